@@ -0,0 +1,230 @@
+// 会话令牌
+//account在玩家登录成功后签发令牌，gate在LouMiaoLoginGate中校验令牌，只信任令牌中的userid，tokenid，world
+//格式：base64url(json(Claims)).base64url(签名)，算法由密钥决定，令牌中不携带算法
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/util"
+)
+
+const (
+	TOKEN_TTL  = 5 * 60 * 1000 //令牌默认有效期，毫秒，只用于登录gate，不需要很长
+	TOKEN_SKEW = 5 * 1000      //允许的节点间时钟误差，毫秒
+)
+
+var (
+	ErrTokenMalformed = errors.New("token malformed")
+	ErrTokenSignature = errors.New("token signature invalid")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrTokenGate      = errors.New("token not for this gate")
+	ErrTokenReplayed  = errors.New("token already used")
+	ErrNoSigner       = errors.New("token signer not configured")
+)
+
+//令牌的内容
+type Claims struct {
+	UserId   int64 `json:"uid"`
+	TokenId  int64 `json:"tid"`
+	GateUid  int   `json:"gate,omitempty"` //允许登录的gate的uid，0不限制
+	WorldUid int   `json:"world"`          //分配的world的uid
+	Expire   int64 `json:"exp"`            //过期时间，unix毫秒
+}
+
+//签发令牌
+type Signer interface {
+	Sign(claims *Claims) (string, error)
+}
+
+//校验令牌，返回令牌的内容，接入自己的sso时实现这个接口
+type Verifier interface {
+	Verify(token string) (*Claims, error)
+}
+
+//签发令牌，Expire为0时使用TOKEN_TTL
+func Issue(signer Signer, claims Claims) (string, error) {
+	if claims.Expire == 0 {
+		claims.Expire = util.TimeStamp() + TOKEN_TTL
+	}
+	return signer.Sign(&claims)
+}
+
+//检查令牌内容是否可以登录本gate
+func (self *Claims) Check(gateUid int) error {
+	if util.TimeStamp() > self.Expire+TOKEN_SKEW {
+		return ErrTokenExpired
+	}
+	if self.GateUid > 0 && self.GateUid != gateUid {
+		return ErrTokenGate
+	}
+	return nil
+}
+
+//记录用过的令牌，令牌在过期前只能使用一次，防止被截获后重放
+//只在本进程内有效，GateUid为0的令牌仍然可以在其他gate上使用一次
+type ReplayGuard struct {
+	lock  sync.Mutex
+	used  map[[2]int64]int64 //[userid, tokenid] -> 过期时间
+	sweep int64              //下一次清理过期记录的时间
+}
+
+func NewReplayGuard() *ReplayGuard {
+	return &ReplayGuard{used: make(map[[2]int64]int64)}
+}
+
+//令牌是否已经用过，不标记
+func (self *ReplayGuard) Used(claims *Claims) bool {
+	key := [2]int64{claims.UserId, claims.TokenId}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	exp, ok := self.used[key]
+	return ok && util.TimeStamp() <= exp+TOKEN_SKEW
+}
+
+//标记令牌已使用，已经用过时返回ErrTokenReplayed，应该在Check通过后调用
+func (self *ReplayGuard) Use(claims *Claims) error {
+	now := util.TimeStamp()
+	key := [2]int64{claims.UserId, claims.TokenId}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if now >= self.sweep {
+		for k, exp := range self.used {
+			if now > exp+TOKEN_SKEW {
+				delete(self.used, k)
+			}
+		}
+		self.sweep = now + TOKEN_SKEW
+	}
+	if exp, ok := self.used[key]; ok && now <= exp+TOKEN_SKEW {
+		return ErrTokenReplayed
+	}
+	self.used[key] = claims.Expire
+	return nil
+}
+
+func encode(claims *Claims, sign func(payload []byte) []byte) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(payload))), nil
+}
+
+func decode(token string, verify func(payload, sig []byte) bool) (*Claims, error) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return nil, ErrTokenMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if !verify([]byte(token[:i]), sig) {
+		return nil, ErrTokenSignature
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	claims := new(Claims)
+	if err = json.Unmarshal(data, claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	return claims, nil
+}
+
+//HMAC-SHA256，account和gate共享同一个密钥
+type HMAC struct {
+	key []byte
+}
+
+func NewHMAC(key []byte) *HMAC {
+	return &HMAC{key: key}
+}
+
+func (self *HMAC) sum(payload []byte) []byte {
+	mac := hmac.New(sha256.New, self.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (self *HMAC) Sign(claims *Claims) (string, error) {
+	return encode(claims, self.sum)
+}
+
+func (self *HMAC) Verify(token string) (*Claims, error) {
+	return decode(token, func(payload, sig []byte) bool {
+		return hmac.Equal(self.sum(payload), sig)
+	})
+}
+
+//Ed25519，account持有私钥，gate只需要公钥
+type Ed25519 struct {
+	priv ed25519.PrivateKey //只校验时为空
+	pub  ed25519.PublicKey
+}
+
+//用私钥创建，可以签发和校验
+func NewEd25519Signer(priv ed25519.PrivateKey) *Ed25519 {
+	return &Ed25519{priv: priv, pub: priv.Public().(ed25519.PublicKey)}
+}
+
+//用公钥创建，只能校验
+func NewEd25519Verifier(pub ed25519.PublicKey) *Ed25519 {
+	return &Ed25519{pub: pub}
+}
+
+func (self *Ed25519) Sign(claims *Claims) (string, error) {
+	if self.priv == nil {
+		return "", errors.New("Ed25519.Sign: no private key")
+	}
+	return encode(claims, func(payload []byte) []byte {
+		return ed25519.Sign(self.priv, payload)
+	})
+}
+
+func (self *Ed25519) Verify(token string) (*Claims, error) {
+	return decode(token, func(payload, sig []byte) bool {
+		return ed25519.Verify(self.pub, payload, sig)
+	})
+}
+
+//按照配置创建签发和校验，没有配置NET_TOKEN_ALG时返回nil
+//ED25519配置公钥时signer为nil，只能校验
+func FromConfig() (Signer, Verifier, error) {
+	switch strings.ToUpper(config.NET_TOKEN_ALG) {
+	case "":
+		return nil, nil, nil
+	case "HMAC":
+		if config.NET_TOKEN_KEY == "" {
+			return nil, nil, errors.New("auth: HMAC key is empty")
+		}
+		h := NewHMAC([]byte(config.NET_TOKEN_KEY))
+		return h, h, nil
+	case "ED25519":
+		key, err := base64.StdEncoding.DecodeString(config.NET_TOKEN_KEY)
+		if err != nil {
+			return nil, nil, fmt.Errorf("auth: ED25519 key: %s", err.Error())
+		}
+		switch len(key) {
+		case ed25519.PrivateKeySize:
+			e := NewEd25519Signer(ed25519.PrivateKey(key))
+			return e, e, nil
+		case ed25519.PublicKeySize:
+			return nil, NewEd25519Verifier(ed25519.PublicKey(key)), nil
+		}
+		return nil, nil, fmt.Errorf("auth: ED25519 key size %d", len(key))
+	}
+	return nil, nil, fmt.Errorf("auth: unknown alg %s", config.NET_TOKEN_ALG)
+}
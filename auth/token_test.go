@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao/util"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (self *fakeClock) Now() time.Time {
+	return self.now
+}
+
+func (self *fakeClock) AfterFunc(d time.Duration, f func()) util.ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+func TestSignVerify(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	h := NewHMAC([]byte("key"))
+	e := NewEd25519Signer(priv)
+	pairs := []struct {
+		s Signer
+		v Verifier
+	}{{h, h}, {e, e}, {e, NewEd25519Verifier(pub)}}
+	for _, p := range pairs {
+		token, err := Issue(p.s, Claims{UserId: 1, TokenId: 2, WorldUid: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := p.v.Verify(token)
+		if err != nil || claims.UserId != 1 || claims.TokenId != 2 || claims.WorldUid != 3 {
+			t.Fatalf("verify: %v %v", claims, err)
+		}
+		if _, err = p.v.Verify(token[:len(token)-2] + "AA"); err != ErrTokenSignature {
+			t.Fatalf("tampered: %v", err)
+		}
+	}
+	token, _ := Issue(h, Claims{UserId: 1})
+	if _, err := NewHMAC([]byte("other")).Verify(token); err != ErrTokenSignature {
+		t.Fatalf("other key: %v", err)
+	}
+	if _, err := h.Verify("abc"); err != ErrTokenMalformed {
+		t.Fatalf("malformed: %v", err)
+	}
+}
+
+func TestReplayGuard(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	defer util.SetClock(util.SetClock(clock))
+
+	guard := NewReplayGuard()
+	a := &Claims{UserId: 1, TokenId: 1, Expire: util.TimeStamp() + TOKEN_TTL}
+	b := &Claims{UserId: 2, TokenId: 1, Expire: a.Expire}
+	if guard.Used(a) || guard.Used(a) { //Used不标记
+		t.Fatal("unused token")
+	}
+	if err := guard.Use(a); err != nil {
+		t.Fatal(err)
+	}
+	if !guard.Used(a) || guard.Used(b) {
+		t.Fatal("used token")
+	}
+	if err := guard.Use(b); err != nil {
+		t.Fatalf("other user: %v", err)
+	}
+	if err := guard.Use(a); err != ErrTokenReplayed {
+		t.Fatalf("replay: %v", err)
+	}
+
+	//过期后Check会拒绝，记录被清理
+	clock.now = clock.now.Add((TOKEN_TTL + TOKEN_SKEW + 1) * time.Millisecond)
+	if err := a.Check(0); err != ErrTokenExpired {
+		t.Fatalf("check: %v", err)
+	}
+	c := &Claims{UserId: 3, TokenId: 1, Expire: util.TimeStamp() + TOKEN_TTL}
+	if err := guard.Use(c); err != nil {
+		t.Fatal(err)
+	}
+	if guard.Used(a) || len(guard.used) != 1 {
+		t.Fatalf("expired tokens not swept: %d", len(guard.used))
+	}
+}
@@ -18,6 +18,7 @@ type ClientServer struct {
 	gorpc.GoRoutineLogic
 
 	pService *network.ClientSocket
+	resume   *resumeState //断线续连，nil代表没有开启
 }
 
 func (self *ClientServer) DoInit() bool {
@@ -29,6 +30,36 @@ func (self *ClientServer) DoInit() bool {
 	self.pService.Init(config.NET_GATE_SADDR)
 	self.pService.SetConnectType(network.SERVER_CONNECT)
 	self.pService.BindPacketFunc(PacketFunc)
+	if config.NET_TLS != nil {
+		loader, err := network.NewTlsLoader(config.NET_TLS)
+		if err != nil {
+			llog.Errorf("ClientServer: %s", err.Error())
+			return false
+		}
+		self.pService.SetTls(loader)
+	}
+	if config.NET_CRYPTO != nil {
+		crypto, err := network.NewCryptoConfig(config.NET_CRYPTO)
+		if err != nil {
+			llog.Errorf("ClientServer: %s", err.Error())
+			return false
+		}
+		self.pService.SetCrypto(crypto)
+	}
+	if config.NET_COMPRESS != nil {
+		comp, err := network.NewCompressConfig(config.NET_COMPRESS)
+		if err != nil {
+			llog.Errorf("ClientServer: %s", err.Error())
+			return false
+		}
+		self.pService.SetCompress(comp)
+	}
+	self.pService.SetSequence(config.NET_SEQUENCE)
+	if config.NET_RESUME_TIME > 0 { //断线后重连，用续连令牌恢复gate上的会话
+		self.resume = new(resumeState)
+		self.pService.SetReconnect(network.NewReconnectConfig(&config.ReconnCfg{}))
+		self.pService.SetLoginFunc(self.resume.login)
+	}
 
 	handler_Map = make(map[string]string)
 
@@ -57,13 +88,16 @@ func PacketFunc(socketid int, buff []byte, nlen int) bool {
 		This.pService.Close()
 		return false
 	}
+	if This.resume != nil && This.resume.onPacket(name, pm) {
+		This.pService.DropPending() //新的会话，之前的请求不能再重发
+	}
 
 	handler, ok := handler_Map[name]
 	if ok {
 		if handler == This.Name {
-			cb, ok := This.NetHandler[name]
+			_, ok := This.NetHandler[name]
 			if ok {
-				cb(This, socketid, pm)
+				This.CallNetFunc(&gorpc.M{Id: socketid, Name: name, Data: pm})
 			} else {
 				llog.Errorf("ClientServer packetFunc[%s] handler is nil: %s", name, This.Name)
 			}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/snowyyj001/loumiao/message"
+	"github.com/snowyyj001/loumiao/msg"
+)
+
+//断线续连，NET_RESUME_TIME>0时开启，参考gate/session.go
+//登录回复带有ResumeToken后给收到的消息包计数，LouMiaoLoginGate的回复不计数
+//重连成功后先发送LouMiaoLoginGate{UserId, ResumeToken, Seq}，gate补发Seq之后的消息包
+//续连失败时gate回复WorldUid=0，和登录失败一样交给LouMiaoLoginGate的处理函数重新登录
+type resumeState struct {
+	lock   sync.Mutex
+	userId int64
+	token  string //gate返回的续连令牌，空代表不能续连
+	seq    int64  //登录回复之后收到的消息包数
+}
+
+//收到消息包，在接收协程中调用，返回true代表是新的登录(不是续连成功)
+func (self *resumeState) onPacket(name string, pm interface{}) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if name == "LouMiaoLoginGate" {
+		m := pm.(*msg.LouMiaoLoginGate)
+		resumed := self.token != "" && m.ResumeToken == self.token
+		if m.WorldUid != 0 && m.ResumeToken != "" {
+			self.userId, self.token, self.seq = m.UserId, m.ResumeToken, m.Seq
+		} else {
+			self.token = ""
+		}
+		return !resumed
+	}
+	if self.token != "" {
+		self.seq++
+	}
+	return false
+}
+
+//重连成功后发送的续连消息包，不能续连时返回nil
+func (self *resumeState) login() []byte {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.token == "" {
+		return nil
+	}
+	buff, _ := message.Encode(0, "LouMiaoLoginGate", &msg.LouMiaoLoginGate{UserId: self.userId, ResumeToken: self.token, Seq: self.seq})
+	return buff
+}
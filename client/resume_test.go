@@ -0,0 +1,55 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/snowyyj001/loumiao/message"
+	"github.com/snowyyj001/loumiao/msg"
+)
+
+func TestResumeLogin(t *testing.T) {
+	message.DoInit()
+	r := new(resumeState)
+	r.onPacket("Test", nil)
+	if r.login() != nil {
+		t.Fatal("resume login before gate login")
+	}
+
+	//登录回复之后的消息包计数，LouMiaoLoginGate的回复不计数
+	if !r.onPacket("LouMiaoLoginGate", &msg.LouMiaoLoginGate{UserId: 7, WorldUid: 1, ResumeToken: "abc"}) {
+		t.Fatal("first login not fresh")
+	}
+	r.onPacket("Test", nil)
+	r.onPacket("Test", nil)
+	buff := r.login()
+	if buff == nil {
+		t.Fatal("no resume login")
+	}
+	err, _, name, pm := message.Decode(0, buff, len(buff))
+	if err != nil || name != "LouMiaoLoginGate" {
+		t.Fatalf("decode %v %s", err, name)
+	}
+	if m := pm.(*msg.LouMiaoLoginGate); m.UserId != 7 || m.ResumeToken != "abc" || m.Seq != 2 {
+		t.Fatalf("resume login %v", m)
+	}
+
+	//续连成功，从回复的Seq继续计数
+	if r.onPacket("LouMiaoLoginGate", &msg.LouMiaoLoginGate{UserId: 7, WorldUid: 1, ResumeToken: "abc", Seq: 2}) {
+		t.Fatal("resume treated as fresh login")
+	}
+	r.onPacket("Test", nil)
+	if r.seq != 3 {
+		t.Fatalf("seq %d", r.seq)
+	}
+
+	//续连失败，需要重新登录，没有确认的消息包不再重发
+	if !r.onPacket("LouMiaoLoginGate", &msg.LouMiaoLoginGate{UserId: 7}) {
+		t.Fatal("refused resume not fresh")
+	}
+	if r.login() != nil {
+		t.Fatal("resume login after refused")
+	}
+	if !r.onPacket("LouMiaoLoginGate", &msg.LouMiaoLoginGate{UserId: 7, WorldUid: 1, ResumeToken: "def"}) {
+		t.Fatal("new login not fresh")
+	}
+}
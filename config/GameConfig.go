@@ -7,4 +7,10 @@ var (
 	GAME_LOG_JSON    = false //log格式是否使用json
 	GAME_LOG_EK      = true  //日志是否发送到Elasticsearch
 	GAME_LOG_LEVEL   = 0     //log输出级别
+
+	GAME_DRAIN_TIME       = 0     //关闭前的排空时间，毫秒，期间不再接受新的登录和rpc，0代表直接关闭
+	GAME_DRAIN_FLUSH_TIME = 10000 //排空结束后，等待actor处理完工作队列的最长时间，毫秒
+
+	GAME_START_TIMEOUT = 30000 //单个服务DoStart/DoOpen的超时时间，毫秒，超时直接退出，0代表不限制
+	GAME_STOP_TIMEOUT  = 5000  //单个服务DoDestory/Close的超时时间，毫秒，超时后继续关闭其他服务，0代表不限制
 )
@@ -42,21 +42,126 @@ var (
 	NET_LISTEN_SADDR = "0.0.0.0:6789" //内网tcp监听地址
 	SERVER_PARAM     = ""             //启动参数
 
+	NET_METRICS_SADDR = "" //prometheus统计的http监听地址，例如"0.0.0.0:9100"，空代表不开启
+
+	NET_RATE_LIMIT       = RateLimit{}                //每个客户端连接所有消息的限制，默认不限制
+	NET_MSG_RATE_LIMIT   = make(map[string]RateLimit) //每个客户端连接单个消息的限制，key是消息名
+	NET_MAX_CONNS_PER_IP = 0                          //每个ip的最大连接数，0不限制
+	NET_FLOOD_STRIKES    = 20                         //NET_FLOOD_WINDOW内超限的消息数达到后踢下线，0不踢
+	NET_FLOOD_WINDOW     = 10000                      //统计超限的时间窗口，毫秒
+	NET_FLOOD_BAN_TIME   = 60000                      //踢下线后封禁ip的时间，毫秒，0不封禁
+
+	NET_TOKEN_ALG = "" //会话令牌的签名算法："HMAC" or "ED25519"，空代表gate不校验令牌
+	NET_TOKEN_KEY = "" //HMAC的密钥，ED25519是base64的私钥(account)或者公钥(gate)
+
+	NET_TLS       *TlsCfg = nil //对外监听(socket，websocket)的tls，nil代表明文
+	NET_INNER_TLS *TlsCfg = nil //集群内部连接的tls，配置ca时是双向认证，nil代表明文
+
+	NET_CRYPTO *CryptoCfg = nil //对外tcp和kcp连接的消息加密，nil代表不加密，websocket请使用NET_TLS
+
+	NET_COMPRESS         *CompressCfg = nil             //对外连接的消息压缩，nil代表不压缩
+	NET_INNER_COMPRESS   *CompressCfg = nil             //集群内部连接的消息压缩，nil代表不压缩
+	NET_MAX_UNPACK_SIZE               = 4 * 1024 * 1024 //开启压缩或者分包后，压缩前(分包前)一个消息包的最大大小4M
+	NET_FRAGMENT                      = false           //超过NET_BUFFER_SIZE的消息包自动分包，集群和客户端都要开启，默认关闭
+	NET_FRAGMENT_TIMEOUT              = 30000           //分包没有收完的超时，毫秒，超时后丢弃
+
+	NET_SEND_QUEUE    = 1024    //每个连接的发送队列长度(消息包数)
+	NET_SLOW_CLIENT   = "BLOCK" //对外连接发送队列满时的处理："BLOCK"等待(未知的值也按BLOCK处理)，"DROP"丢弃消息，"KICK"断开连接；对内连接总是等待，默认等待，和以前一样
+	NET_WRITE_TIMEOUT = 0       //写超时，毫秒，超时后断开连接，<=0不限制，默认不限制，和以前一样
+
+	NET_HEARTBEAT = 0 //心跳间隔，毫秒，连接这么久没有发送消息时发送PING，<=0不发送，默认关闭
+	NET_READ_IDLE = 0 //读超时，毫秒，连接这么久没有收到数据时断开并派发DISCONNECT，<=0不检查，默认关闭
+
+	NET_INNER_RECONNECT *ReconnCfg = nil //集群内部连接(gate到server)断开后自动重连，nil代表不重连
+
+	NET_RESUME_TIME   = 0   //gate在客户端断开后保留会话的时间，毫秒，期间客户端可以续连，world不会收到断开，0不保留
+	NET_RESUME_BUFFER = 256 //每个会话缓存的最近发给客户端的消息包数，续连时补发
+
+	NET_SEQUENCE   = false //对外连接的消息序号和确认，参考network.PacketSeq
+	NET_SEQ_BUFFER = 256   //客户端保留的对方没有确认的消息包数，断线重连后重发
 )
 
+//消息加密配置，参考network.CryptoConfig
+type CryptoCfg struct {
+	Ciphers []string `json:"ciphers"` //"AES-GCM"，"CHACHA20"，客户端按优先级提供，为空时都支持，AES-GCM优先
+	Key     string   `json:"key"`     //base64的ed25519私钥(服务端签名握手)或者公钥(客户端校验签名)，空代表不签名
+}
+
+//消息压缩配置，连接时协商，双方都配置了才会压缩
+type CompressCfg struct {
+	Codecs    []string `json:"codecs"`    //"SNAPPY"，"ZSTD"，"DEFLATE"，按优先级，为空时使用SNAPPY
+	Threshold int      `json:"threshold"` //超过这个大小的消息包才压缩，0使用默认值1k
+}
+
+//断线重连配置，等待时间从MinDelay开始每次失败翻倍，最多MaxDelay，再随机减少最多一半
+type ReconnCfg struct {
+	MinDelay int `json:"mindelay"` //第一次重连前的等待时间，毫秒，0使用默认值500
+	MaxDelay int `json:"maxdelay"` //最长等待时间，毫秒，0使用默认值30000
+	MaxRetry int `json:"maxretry"` //连续失败这么多次后放弃并派发C_DISCONNECT，0不限制
+	Buffer   int `json:"buffer"`   //断线期间最多缓存的消息包数，超过的丢弃，0使用默认值1024
+}
+
+//tls配置，证书文件更新后新的连接自动使用新证书，不需要重启
+type TlsCfg struct {
+	Cert       string `json:"cert"`       //证书文件，pem
+	Key        string `json:"key"`        //私钥文件，pem
+	CA         string `json:"ca"`         //ca证书文件，监听时要求并校验对方的证书，连接时校验对方的证书，连接时为空使用系统ca
+	ServerName string `json:"servername"` //连接时校验的对方证书名字，为空时使用连接地址的host
+}
+
+//令牌桶限流，每秒补充Rate个令牌，最多积累Burst个，每个消息消耗一个
+type RateLimit struct {
+	Rate  float64 `json:"rate"`  //每秒的消息数，<=0不限制
+	Burst int     `json:"burst"` //允许的突发消息数，<=0时取Rate
+}
+
+//客户端的限流配置，参考NET_RATE_LIMIT等，没有配置的项使用默认值
+type FloodCfg struct {
+	Rate     RateLimit            `json:"rate"`
+	Msgs     map[string]RateLimit `json:"msgs"`
+	MaxPerIp int                  `json:"maxperip"`
+	Strikes  int                  `json:"strikes"`
+	Window   int                  `json:"window"`
+	BanTime  int                  `json:"bantime"`
+}
+
 //uid通过etcd自动分配，一般不要手动分配uid，除非清楚知道自己在做什么,参考GetServerUid
 //uid和SAddr是一一对应的,可以通过删除ETCD_LOCKUID来重置uid的分配
 type NetNode struct {
-	Id        int    `json:"id"`
-	Type      int    `json:"type"`
-	SAddr     string `json:"saddr"`
-	Param     string `json:"param"` //可选的启动参数，server根据自己的特殊需求配置具体内容
-	Protocol  string `json:"protocol"`
-	WebSocket int    `json:"websocket"`
-	Uid       int    `json:"uid"`
-	MaxNum    int    `json:"maxnum"`
-	Group     string `json:"group"`
-	LogFile   int    `json:"logfile"` //如果-1，代表输出到控制台
+	Id        int          `json:"id"`
+	Type      int          `json:"type"`
+	SAddr     string       `json:"saddr"`
+	Param     string       `json:"param"` //可选的启动参数，server根据自己的特殊需求配置具体内容
+	Protocol  string       `json:"protocol"`
+	WebSocket int          `json:"websocket"`
+	Uid       int          `json:"uid"`
+	MaxNum    int          `json:"maxnum"`
+	Group     string       `json:"group"`
+	LogFile   int          `json:"logfile"`       //如果-1，代表输出到控制台
+	Weight    int          `json:"weight"`        //rpc加权选择的权重，<=0视为1
+	DrainTime int          `json:"drain"`         //关闭前的排空时间，毫秒，参考GAME_DRAIN_TIME
+	Metrics   string       `json:"metrics"`       //prometheus统计的http监听地址，参考NET_METRICS_SADDR
+	Flood     *FloodCfg    `json:"flood"`         //客户端限流，只对网关和登录网关生效
+	TokenAlg  string       `json:"tokenalg"`      //参考NET_TOKEN_ALG
+	TokenKey  string       `json:"tokenkey"`      //参考NET_TOKEN_KEY
+	Tls       *TlsCfg      `json:"tls"`           //参考NET_TLS
+	InnerTls  *TlsCfg      `json:"innertls"`      //参考NET_INNER_TLS
+	Crypto    *CryptoCfg   `json:"crypto"`        //参考NET_CRYPTO
+	Compress  *CompressCfg `json:"compress"`      //参考NET_COMPRESS
+	InnerComp *CompressCfg `json:"innercompress"` //参考NET_INNER_COMPRESS
+	MaxUnpack int          `json:"maxunpack"`     //参考NET_MAX_UNPACK_SIZE
+	Fragment  int          `json:"fragment"`      //1开启，参考NET_FRAGMENT
+	FragTime  int          `json:"fragtimeout"`   //参考NET_FRAGMENT_TIMEOUT
+	SendQueue int          `json:"sendqueue"`     //参考NET_SEND_QUEUE
+	Slow      string       `json:"slowclient"`    //参考NET_SLOW_CLIENT
+	WriteTime int          `json:"writetimeout"`  //参考NET_WRITE_TIMEOUT，-1不限制
+	Heartbeat int          `json:"heartbeat"`     //参考NET_HEARTBEAT，-1不发送
+	ReadIdle  int          `json:"readidle"`      //参考NET_READ_IDLE，-1不检查
+	Reconnect *ReconnCfg   `json:"reconnect"`     //参考NET_INNER_RECONNECT
+	Resume    int          `json:"resume"`        //参考NET_RESUME_TIME
+	ResumeBuf int          `json:"resumebuf"`     //参考NET_RESUME_BUFFER
+	Sequence  int          `json:"sequence"`      //1开启，参考NET_SEQUENCE
+	SeqBuf    int          `json:"seqbuffer"`     //参考NET_SEQ_BUFFER
 }
 
 type ServerCfg struct {
@@ -92,6 +197,68 @@ func init() {
 	NET_LISTEN_SADDR = NET_GATE_SADDR
 	SERVER_PARAM = Cfg.NetCfg.Param
 	GAME_LOG_CONLOSE = Cfg.NetCfg.LogFile == -1
+	if Cfg.NetCfg.DrainTime > 0 {
+		GAME_DRAIN_TIME = Cfg.NetCfg.DrainTime
+	}
+	NET_METRICS_SADDR = Cfg.NetCfg.Metrics
+	NET_TOKEN_ALG = Cfg.NetCfg.TokenAlg
+	NET_TOKEN_KEY = Cfg.NetCfg.TokenKey
+	NET_TLS = Cfg.NetCfg.Tls
+	NET_INNER_TLS = Cfg.NetCfg.InnerTls
+	NET_CRYPTO = Cfg.NetCfg.Crypto
+	NET_COMPRESS = Cfg.NetCfg.Compress
+	NET_INNER_COMPRESS = Cfg.NetCfg.InnerComp
+	if Cfg.NetCfg.MaxUnpack > 0 {
+		NET_MAX_UNPACK_SIZE = Cfg.NetCfg.MaxUnpack
+	}
+	NET_FRAGMENT = Cfg.NetCfg.Fragment == 1
+	if Cfg.NetCfg.FragTime > 0 {
+		NET_FRAGMENT_TIMEOUT = Cfg.NetCfg.FragTime
+	}
+	if Cfg.NetCfg.SendQueue > 0 {
+		NET_SEND_QUEUE = Cfg.NetCfg.SendQueue
+	}
+	if Cfg.NetCfg.Slow != "" {
+		NET_SLOW_CLIENT = Cfg.NetCfg.Slow
+	}
+	if Cfg.NetCfg.WriteTime != 0 {
+		NET_WRITE_TIMEOUT = Cfg.NetCfg.WriteTime
+	}
+	if Cfg.NetCfg.Heartbeat != 0 {
+		NET_HEARTBEAT = Cfg.NetCfg.Heartbeat
+	}
+	if Cfg.NetCfg.ReadIdle != 0 {
+		NET_READ_IDLE = Cfg.NetCfg.ReadIdle
+	}
+	if Cfg.NetCfg.Reconnect != nil {
+		NET_INNER_RECONNECT = Cfg.NetCfg.Reconnect
+	}
+	if Cfg.NetCfg.Resume > 0 {
+		NET_RESUME_TIME = Cfg.NetCfg.Resume
+	}
+	if Cfg.NetCfg.ResumeBuf > 0 {
+		NET_RESUME_BUFFER = Cfg.NetCfg.ResumeBuf
+	}
+	NET_SEQUENCE = Cfg.NetCfg.Sequence == 1
+	if Cfg.NetCfg.SeqBuf > 0 {
+		NET_SEQ_BUFFER = Cfg.NetCfg.SeqBuf
+	}
+	if flood := Cfg.NetCfg.Flood; flood != nil {
+		NET_RATE_LIMIT = flood.Rate
+		if flood.Msgs != nil {
+			NET_MSG_RATE_LIMIT = flood.Msgs
+		}
+		NET_MAX_CONNS_PER_IP = flood.MaxPerIp
+		if flood.Strikes > 0 {
+			NET_FLOOD_STRIKES = flood.Strikes
+		}
+		if flood.Window > 0 {
+			NET_FLOOD_WINDOW = flood.Window
+		}
+		if flood.BanTime > 0 {
+			NET_FLOOD_BAN_TIME = flood.BanTime
+		}
+	}
 
 	if GAME_LOG_CONLOSE {
 		GAME_LOG_LEVEL = 0
@@ -9,6 +9,12 @@ const ETCD_NODEINFO string = "/nodeinfos/"
 //node状态
 const ETCD_NODESTATUS string = "/nodestatus/"
 
+//node排空状态，排空时写入，和ETCD_NODESTATUS分开，不影响老版本节点解析人数
+const ETCD_NODEDRAIN string = "/nodedrain/"
+
+//leader选举
+const ETCD_ELECTION string = "/election/"
+
 //分布式锁-world position
 const KEY_LOCKWORLD string = "/lockworldpos/"
 
@@ -20,6 +26,12 @@ const (
 	CLIENT_CONNECT    = 0 //客户端建立连接
 	CLIENT_DISCONNECT = 1 //客户端断开连接
 )
+
+const ( //LouMiaoKickOut踢下线原因
+	KICK_REASON_REPLACE = 0 //顶号
+	KICK_REASON_DRAIN   = 1 //服务器排空关闭，需要重新登录到其他服务器
+	KICK_REASON_AUTH    = 2 //登录令牌无效或者过期，需要重新登录account
+)
 const ( //kafka消息topic
 	TOPIC_SERVER_MAIL = "tp:servermail" //server关键信息，发送邮件
 )
@@ -30,3 +42,12 @@ const ( //发送真实邮件类型
 	MAIL_TYPE_STOP  = 2 //服务器关闭
 	MAIL_SYS_WARN   = 3 //系统资源告警
 )
+
+const ( //rpc目标选择策略
+	RPC_BALANCE_NONE         = 0 //未指定，使用注册时的策略，都没有指定则随机
+	RPC_BALANCE_RANDOM       = 1 //随机
+	RPC_BALANCE_ROUND_ROBIN  = 2 //轮询
+	RPC_BALANCE_LEAST_LOADED = 3 //负载最小，即NodeInfo.Number最小
+	RPC_BALANCE_HASH         = 4 //一致性hash，相同的key总是选择相同的节点
+	RPC_BALANCE_WEIGHTED     = 5 //按照节点配置的weight加权随机
+)
@@ -0,0 +1,312 @@
+package etcd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/snowyyj001/loumiao/define"
+	"github.com/snowyyj001/loumiao/llog"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/concurrency"
+)
+
+const (
+	ELECTION_TTL   = 10   //选举租约时间，秒，leader宕机后最多这么久发生切换
+	ELECTION_RETRY = 1000 //etcd出错后重新参选的间隔，毫秒
+)
+
+//选举结果变化时的通知
+type ElectionEvent struct {
+	Name     string //选举名
+	Leader   string //当前leader的value，还没有leader时为空
+	IsLeader bool   //自己是否是leader
+}
+
+//选举通知投递的目标，gorpc.IGoRoutine满足这个接口
+type Actor interface {
+	SendActor(handler_name string, sdata interface{}) error
+}
+
+//基于concurrency.Election的leader选举
+//参选者都用自己的value参选，leader的session失效(宕机，网络断开)后，下一个参选者成为leader
+//成为leader后watch自己参选的key，租约在服务端过期时key被删除，不用等session.Done就放弃leader
+type Election struct {
+	client *clientv3.Client
+	name   string
+	value  string
+	ttl    int
+
+	lock       sync.Mutex
+	notifyLock sync.Mutex //通知依次进行
+	isLeader   bool
+	leader     string
+	onChange   func(ev *ElectionEvent)
+	actor      Actor
+	handler    string
+
+	resign  chan struct{}
+	ctx     context.Context
+	cancel  func()
+	started bool
+	done    chan struct{}
+}
+
+//创建选举，调用Start后开始参选
+//@client: etcd连接，nil时使用This
+//@name: 选举名，同名的参选者竞争同一个leader
+//@value: 自己的标识，一般是节点uid，leader的value可以通过Leader获得
+//@ttl: 租约时间，秒，<=0使用ELECTION_TTL
+func NewElection(client *clientv3.Client, name string, value string, ttl int) *Election {
+	if client == nil {
+		client = This
+	}
+	if ttl <= 0 {
+		ttl = ELECTION_TTL
+	}
+	self := &Election{client: client, name: name, value: value, ttl: ttl}
+	self.resign = make(chan struct{}, 1)
+	self.ctx, self.cancel = context.WithCancel(context.Background())
+	self.done = make(chan struct{})
+	return self
+}
+
+//设置选举结果变化的回调，在选举协程中依次调用，不能阻塞，必须在Start之前设置
+func (self *Election) OnChange(call func(ev *ElectionEvent)) {
+	self.onChange = call
+}
+
+//选举结果变化时投递*ElectionEvent给actor，必须在Start之前设置
+func (self *Election) Notify(actor Actor, handler string) {
+	self.actor = actor
+	self.handler = handler
+}
+
+//开始参选，并观察leader的变化
+func (self *Election) Start() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.started || self.ctx.Err() != nil {
+		return
+	}
+	self.started = true
+	go self.run()
+	go self.observe()
+}
+
+//自己是否是leader
+func (self *Election) IsLeader() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.isLeader
+}
+
+//当前leader的value，还不知道时为空
+func (self *Election) Leader() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.leader
+}
+
+//放弃leader，然后重新排队参选，其他参选者会成为leader
+func (self *Election) Resign() {
+	select {
+	case self.resign <- struct{}{}:
+	default:
+	}
+}
+
+//退出选举，是leader时会立刻放弃
+func (self *Election) Close() {
+	self.lock.Lock()
+	self.cancel()
+	started := self.started
+	self.lock.Unlock()
+	if started {
+		<-self.done
+	}
+}
+
+func (self *Election) key() string {
+	return define.ETCD_ELECTION + self.name
+}
+
+func (self *Election) run() {
+	defer close(self.done)
+	for self.ctx.Err() == nil {
+		session, err := concurrency.NewSession(self.client, concurrency.WithTTL(self.ttl)) //不能用self.ctx，Close时还要撤销租约
+		if err != nil {
+			llog.Warningf("Election[%s]: NewSession %s", self.name, err.Error())
+			self.sleep(ELECTION_RETRY)
+			continue
+		}
+		resigned := self.campaign(session)
+		session.Close() //撤销租约，参选的key随之删除
+		if resigned {
+			self.sleep(ELECTION_RETRY) //让其他参选者先成为leader
+		}
+	}
+}
+
+//参选一次，直到session失效或者放弃，返回是否主动放弃
+func (self *Election) campaign(session *concurrency.Session) bool {
+	ctx, cancel := context.WithCancel(self.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-session.Done(): //租约失效时Campaign不会自己返回
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	election := concurrency.NewElection(session, self.key())
+	if err := election.Campaign(ctx, self.value); err != nil {
+		if self.ctx.Err() == nil {
+			llog.Warningf("Election[%s]: Campaign %s", self.name, err.Error())
+			self.sleep(ELECTION_RETRY)
+		}
+		return false
+	}
+	llog.Infof("Election[%s]: %s is leader now", self.name, self.value)
+	lost := self.watchLeader(ctx, election.Key(), election.Rev())
+	self.update(true)
+	defer self.update(false)
+
+	select {
+	case <-ctx.Done():
+		if self.ctx.Err() == nil {
+			llog.Warningf("Election[%s]: session expired, leadership lost", self.name)
+		}
+		return false
+	case <-lost:
+		if self.ctx.Err() == nil {
+			llog.Warningf("Election[%s]: key deleted, leadership lost", self.name)
+		}
+		return false
+	case <-self.resign:
+		llog.Infof("Election[%s]: %s resign", self.name, self.value)
+		return true
+	}
+}
+
+//watch自己参选的key，被删除或者不再是rev创建的时关闭返回的chan
+//租约在服务端过期后session.Done要等keepalive失败才触发，这期间其他参选者可能已经成为leader
+func (self *Election) watchLeader(ctx context.Context, key string, rev int64) <-chan struct{} {
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		watchRev := rev
+		for ctx.Err() == nil {
+			wch := self.client.Watch(ctx, key, clientv3.WithRev(watchRev+1))
+			for wresp := range wch {
+				if wresp.Err() != nil {
+					break
+				}
+				for _, ev := range wresp.Events {
+					if ev.Type == clientv3.EventTypeDelete {
+						return
+					}
+				}
+			}
+			//watch断开，确认key还在
+			resp, err := self.client.Get(ctx, key)
+			if err != nil {
+				select {
+				case <-time.After(ELECTION_RETRY * time.Millisecond):
+				case <-ctx.Done():
+				}
+				continue
+			}
+			if len(resp.Kvs) == 0 || resp.Kvs[0].CreateRevision != rev {
+				return
+			}
+			watchRev = resp.Header.Revision
+		}
+	}()
+	return lost
+}
+
+//观察leader的变化，参选者的key中最早创建的就是leader
+func (self *Election) observe() {
+	prefix := self.key() + "/"
+	for self.ctx.Err() == nil {
+		resp, err := self.client.Get(self.ctx, prefix, clientv3.WithFirstCreate()...)
+		if err != nil {
+			self.sleep(ELECTION_RETRY)
+			continue
+		}
+		self.observed(resp)
+		wch := self.client.Watch(self.ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+		for wresp := range wch {
+			if wresp.Err() != nil {
+				break
+			}
+			resp, err = self.client.Get(self.ctx, prefix, clientv3.WithFirstCreate()...)
+			if err != nil {
+				break
+			}
+			self.observed(resp)
+		}
+		self.sleep(ELECTION_RETRY)
+	}
+}
+
+func (self *Election) observed(resp *clientv3.GetResponse) {
+	leader := ""
+	if len(resp.Kvs) > 0 {
+		leader = string(resp.Kvs[0].Value)
+	}
+	self.notifyLock.Lock()
+	defer self.notifyLock.Unlock()
+	self.lock.Lock()
+	isLeader := self.isLeader
+	self.lock.Unlock()
+	if leader == self.value && isLeader == false { //自己放弃后key还没删除，或者Campaign刚返回
+		leader = ""
+	}
+	self.change(isLeader, leader)
+}
+
+//自己的leader状态变化
+func (self *Election) update(isLeader bool) {
+	self.notifyLock.Lock()
+	defer self.notifyLock.Unlock()
+	self.lock.Lock()
+	leader := self.leader
+	self.lock.Unlock()
+	if isLeader {
+		leader = self.value
+	} else if leader == self.value {
+		leader = "" //新的leader等待observe通知
+	}
+	self.change(isLeader, leader)
+}
+
+//有变化时通知，调用前需要锁住notifyLock，保证通知的顺序
+func (self *Election) change(isLeader bool, leader string) {
+	self.lock.Lock()
+	if self.isLeader == isLeader && self.leader == leader {
+		self.lock.Unlock()
+		return
+	}
+	self.isLeader, self.leader = isLeader, leader
+	self.lock.Unlock()
+
+	ev := &ElectionEvent{Name: self.name, Leader: leader, IsLeader: isLeader}
+	if self.onChange != nil {
+		self.onChange(ev)
+	}
+	if self.actor != nil {
+		if err := self.actor.SendActor(self.handler, ev); err != nil {
+			llog.Warningf("Election[%s]: notify %s", self.name, err.Error())
+		}
+	}
+}
+
+func (self *Election) sleep(ms int) {
+	select {
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+	case <-self.ctx.Done():
+	}
+}
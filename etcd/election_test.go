@@ -0,0 +1,120 @@
+package etcd
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao/define"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/embed"
+)
+
+func testUrl(t *testing.T) url.URL {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return url.URL{Scheme: "http", Host: ln.Addr().String()}
+}
+
+//启动一个单节点的etcd，返回连接它的client
+func startEtcd(t *testing.T) *clientv3.Client {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	client, peer := testUrl(t), testUrl(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{client}, []url.URL{client}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peer}, []url.URL{peer}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	cfg.LogLevel = "error"
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd not ready")
+	}
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{client.Host}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+func waitEvent(t *testing.T, events chan *ElectionEvent, isLeader bool, leader string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.IsLeader == isLeader && ev.Leader == leader {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no event isLeader=%v leader=%s", isLeader, leader)
+		}
+	}
+}
+
+func newTestElection(cli *clientv3.Client, value string) (*Election, chan *ElectionEvent) {
+	events := make(chan *ElectionEvent, 16)
+	self := NewElection(cli, "test", value, 30)
+	self.OnChange(func(ev *ElectionEvent) { events <- ev })
+	return self, events
+}
+
+func TestElectionCloseWithoutStart(t *testing.T) {
+	self := NewElection(&clientv3.Client{}, "test", "1", 0)
+	done := make(chan struct{})
+	go func() {
+		self.Close()
+		self.Start() //Close之后不再参选
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked without Start")
+	}
+}
+
+func TestElection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("embedded etcd")
+	}
+	cli := startEtcd(t)
+	a, eventsA := newTestElection(cli, "a")
+	a.Start()
+	defer a.Close()
+	waitEvent(t, eventsA, true, "a")
+
+	b, eventsB := newTestElection(cli, "b")
+	b.Start()
+	defer b.Close()
+	waitEvent(t, eventsB, false, "a")
+
+	//租约在服务端失效，key被删除，不用等TTL就放弃leader
+	resp, err := cli.Get(context.Background(), define.ETCD_ELECTION+"test/", clientv3.WithFirstCreate()...)
+	if err != nil || len(resp.Kvs) == 0 || string(resp.Kvs[0].Value) != "a" {
+		t.Fatalf("leader key %v %v", resp, err)
+	}
+	if _, err := cli.Delete(context.Background(), string(resp.Kvs[0].Key)); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, eventsB, true, "b")
+	waitEvent(t, eventsA, false, "b")
+	if !b.IsLeader() || a.IsLeader() {
+		t.Fatalf("leader a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+
+	//放弃后由a接任
+	b.Resign()
+	waitEvent(t, eventsA, true, "a")
+	waitEvent(t, eventsB, false, "a")
+}
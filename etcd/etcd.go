@@ -39,7 +39,6 @@ type EtcdBase struct {
 	keepAliveChan <-chan *clientv3.LeaseKeepAliveResponse
 }
 
-//
 func (self *EtcdBase) GetClient() *clientv3.Client {
 	return self.client
 }
@@ -177,6 +176,7 @@ func UnLock(key string, lockval *concurrency.Mutex) {
 }
 
 //选举leader，所有参与选举的人使用相同的value和prefix，leader负责设置value
+//只适合一次性的选举，leader宕机不会切换，持续的选举请使用Election
 //@prefix: 选举区分标识
 //@value: 本次选举的值，每次发起选举，value应该和上次选举时的value不同
 func AquireLeader(prefix string, value string) (isleader bool) {
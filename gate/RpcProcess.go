@@ -3,6 +3,7 @@ package gate
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 
 	"github.com/snowyyj001/loumiao"
 	"github.com/snowyyj001/loumiao/base"
@@ -35,6 +36,8 @@ func innerDisConnect(igo gorpc.IGoRoutine, socketId int, data interface{}) {
 	if config.NET_NODE_TYPE == config.ServerType_Account {
 		This.OnlineNum--
 	}
+	//登录消息还没有处理就断开了
+	takeLoginClaims(socketId)
 	if This.ServerType == network.SERVER_CONNECT { //lost connect with gate
 		This.rpcGates = util.RemoveSlice(This.rpcGates, socketId)
 		//在这个gate上的user都应该掉线
@@ -54,6 +57,11 @@ func innerDisConnect(igo gorpc.IGoRoutine, socketId int, data interface{}) {
 		token, ok := This.tokens[socketId]
 		if ok {
 			userid := token.UserId
+			if This.suspendSession(socketId, userid) { //等待客户端续连，先不通知world
+				delete(This.tokens, socketId)
+				delete(This.tokens_u, userid)
+				return
+			}
 			worlduid := This.users_u[userid]
 			onClientDisConnected(userid, worlduid)
 
@@ -67,6 +75,12 @@ func innerDisConnect(igo gorpc.IGoRoutine, socketId int, data interface{}) {
 //server connect
 func outerConnect(igo gorpc.IGoRoutine, socketId int, data interface{}) {
 	//llog.Debugf("GateServer outerConnect: %d", socketId)
+	//第一次连接时还没有加入clients，已经在clients中的是断线重连
+	//重连后socket会重新发送LouMiaoLoginGate，server会重新注册rpc，先清除之前注册的
+	if This.GetRpcClient(socketId) != nil {
+		llog.Infof("GateServer outerConnect: server[%d] reconnected", socketId)
+		This.removeRpcHanlder(socketId)
+	}
 }
 
 //server disconnect
@@ -99,6 +113,7 @@ func onClientConnected(uid int, tid int) {
 		req := &msg.LouMiaoRpcRegister{}
 		for key, _ := range This.rpcMap {
 			req.FuncName = append(req.FuncName, key)
+			req.Balance = append(req.Balance, int32(This.rpcBalance[key]))
 		}
 		if len(req.FuncName) > 0 {
 			buff, _ := message.Encode(uid, "LouMiaoRpcRegister", req)
@@ -149,9 +164,7 @@ func leaseCallBack(success bool) {
 	}
 
 	if success { //成功续租
-		//str := fmt.Sprintf("%s%s/%s", define.ETCD_NODESTATUS, config.SERVER_GROUP, config.NET_GATE_SADDR)
-		str := fmt.Sprintf("%s/%s", define.ETCD_NODESTATUS, config.NET_GATE_SADDR)
-		This.clientEtcd.Put(str, util.Itoa(This.OnlineNum), true) //写入人数
+		This.putNodeStatus() //写入人数
 		//llog.Debugf("leaseCallBack %s", str)
 	} else {
 		llog.Errorf("leaseCallBack续租失败")
@@ -175,13 +188,30 @@ func innerLouMiaoLoginGate(igo gorpc.IGoRoutine, socketId int, data interface{})
 	m := data.(*msg.LouMiaoLoginGate)
 	userid := int(m.UserId)
 	llog.Debugf("innerLouMiaoLoginGate: %v, socketId=%d", m, socketId)
+	claims := takeLoginClaims(socketId)
 
 	if This.OnlineNum > config.NET_MAX_NUMBER {
 		llog.Errorf("0.innerLouMiaoLoginGate too many connections: max=%d, now=%d", config.NET_MAX_NUMBER, This.OnlineNum)
 		This.closeClient(socketId)
 		return
 	}
+	if nodemgr.Draining && config.NET_NODE_TYPE == config.ServerType_Gate { //排空中，让客户端登录其他gate
+		llog.Infof("1.innerLouMiaoLoginGate gate is draining: userid=%d", userid)
+		buff, _ := message.Encode(0, "LouMiaoKickOut", &msg.LouMiaoKickOut{Reason: define.KICK_REASON_DRAIN})
+		This.pService.SendById(socketId, buff)
+		This.closeClient(socketId)
+		return
+	}
+	if m.ResumeToken != "" && resumeEnabled() { //断线续连
+		This.resumeSession(socketId, m)
+		return
+	}
+	if loginReplayed(socketId, claims) { //不能顶掉用这个令牌登录的连接
+		This.closeClient(socketId)
+		return
+	}
 
+	This.endSession(userid) //重新登录，丢弃之前的会话
 	old_socketid, ok := This.tokens_u[userid]
 	if ok { //close the old connection
 		req := &msg.LouMiaoKickOut{}
@@ -211,13 +241,14 @@ func innerLouMiaoLoginGate(igo gorpc.IGoRoutine, socketId int, data interface{})
 	worldid := int(m.WorldUid)
 	if config.NET_NODE_TYPE == config.ServerType_Gate {
 		rpcclient := This.GetRpcClient(worldid)
-		if rpcclient == nil { //accout分配的world，在gate这里不存在，有可能是刚好world关闭了，这种情况就让客户端重新登录吧
+		if rpcclient == nil || nodemgr.IsDraining(worldid) { //accout分配的world，在gate这里不存在，有可能是刚好world关闭了，这种情况就让客户端重新登录吧
 			m.WorldUid = 0
 			buff, _ := message.Encode(0, "LouMiaoLoginGate", m)
 			This.pService.SendById(socketId, buff)
 			return
 		}
 		This.users_u[userid] = worldid
+		useLoginToken(claims) //登录成功
 	} else if config.NET_NODE_TYPE == config.ServerType_Account {
 		This.users_u[userid] = socketId
 		worldid = socketId
@@ -229,6 +260,10 @@ func innerLouMiaoLoginGate(igo gorpc.IGoRoutine, socketId int, data interface{})
 	}
 	onClientConnected(userid, worldid)
 	if config.NET_NODE_TYPE == config.ServerType_Gate { //tell the client login success
+		if resumeEnabled() {
+			m.ResumeToken = This.newSession(userid, tokenid, socketId)
+			m.Seq = 0
+		}
 		buff, _ := message.Encode(0, "LouMiaoLoginGate", m)
 		This.pService.SendById(socketId, buff)
 	}
@@ -244,11 +279,14 @@ func innerLouMiaoRpcRegister(igo gorpc.IGoRoutine, socketId int, data interface{
 		llog.Warningf("0.innerLouMiaoRpcRegister server has lost[%d] ", socketId)
 		return
 	}
-	for _, key := range req.FuncName {
+	for i, key := range req.FuncName {
 		if This.rpcMap[key] == nil {
 			This.rpcMap[key] = []int{}
 		}
 		This.rpcMap[key] = append(This.rpcMap[key], socketId)
+		if i < len(req.Balance) {
+			This.setRpcBalance(key, int(req.Balance[i]))
+		}
 		//rpcstr, _ := base64.StdEncoding.DecodeString(key)
 		//llog.Debugf("rpc register: funcname=%s, uid=%d", string(rpcstr), socketId)
 	}
@@ -258,21 +296,56 @@ func innerLouMiaoRpcRegister(igo gorpc.IGoRoutine, socketId int, data interface{
 func innerLouMiaoRpcMsg(igo gorpc.IGoRoutine, socketId int, data interface{}) {
 	req := data.(*msg.LouMiaoRpcMsg)
 	llog.Debugf("innerLouMiaoRpcMsg=%s, socurce=%d, target=%d, ByteBuffer=%d", req.FuncName, req.SourceId, req.TargetId, req.ByteBuffer)
-	if config.NET_NODE_TYPE == config.ServerType_Gate { //server -> gate
+	if config.NET_NODE_TYPE == config.ServerType_Gate && req.Reply > 0 && int(req.TargetId) == This.Id { //gate自己发起的rpc调用返回
+		pm, err := decodeRemoteData(req.Buffer, req.ByteBuffer)
+		if err == nil {
+			err = gorpc.RemoteError(req.Error)
+		}
+		gorpc.DeliverReply(req.Seq, pm, err)
+	} else if config.NET_NODE_TYPE == config.ServerType_Gate { //server -> gate
 		target := int(req.TargetId)
 		var rpcClient *network.ClientSocket
 		if target <= 0 {
-			rpcClient = This.getCluserServer(req.FuncName)
+			rpcClient = This.getCluserServer(req.FuncName, int(req.Balance), req.HashKey)
 		} else {
 			rpcClient = This.GetRpcClient(target)
 		}
 		if rpcClient == nil {
 			llog.Warningf("1.innerLouMiaoRpcMsg rpc client error %s %d", req.FuncName, target)
+			if req.Reply == 0 && req.Seq > 0 {
+				replyRpcMsg(req.SourceId, req.Seq, req.FuncName, nil, gorpc.ErrRemoteUnreachable)
+			}
 			return
 		}
-		outdata := &msg.LouMiaoRpcMsg{TargetId: req.TargetId, FuncName: req.FuncName, Buffer: req.Buffer, SourceId: req.SourceId, ByteBuffer: req.ByteBuffer}
+		outdata := &msg.LouMiaoRpcMsg{TargetId: req.TargetId, FuncName: req.FuncName, Buffer: req.Buffer, SourceId: req.SourceId, ByteBuffer: req.ByteBuffer,
+			Seq: req.Seq, Reply: req.Reply, Error: req.Error}
 		buff, _ := message.Encode(target, "LouMiaoRpcMsg", outdata)
 		rpcClient.Send(buff)
+	} else if req.Reply > 0 { //rpc call return back
+		pm, err := decodeRemoteData(req.Buffer, req.ByteBuffer)
+		if err == nil {
+			err = gorpc.RemoteError(req.Error)
+		}
+		gorpc.DeliverReply(req.Seq, pm, err)
+	} else if req.Seq > 0 { //rpc call, need return
+		sourceId, seq, funcName := req.SourceId, req.Seq, req.FuncName
+		handler, ok := handler_Map[funcName]
+		if ok == false {
+			llog.Errorf("4.InnerLouMiaoRpcMsg no rpc hanlder %s, %d", funcName, socketId)
+			replyRpcMsg(sourceId, seq, funcName, nil, gorpc.ErrHandlerNotFound)
+			return
+		}
+		pm, err := decodeRemoteData(req.Buffer, req.ByteBuffer)
+		if err == nil {
+			m := &gorpc.M{Id: int(sourceId), Name: funcName, Data: pm}
+			err = gorpc.DeliverRemote(gorpc.ActorAddr{Name: handler}, funcName, m, func(ret interface{}, err error) {
+				replyRpcMsg(sourceId, seq, funcName, ret, err)
+			})
+		}
+		if err != nil {
+			llog.Errorf("5.InnerLouMiaoRpcMsg call error: func=%s, error=%s", funcName, err.Error())
+			replyRpcMsg(sourceId, seq, funcName, nil, err)
+		}
 	} else { //gate -> server or gate self
 		handler, ok := handler_Map[req.FuncName]
 		if ok {
@@ -358,8 +431,7 @@ func innerLouMiaoNetMsg(igo gorpc.IGoRoutine, socketId int, data interface{}) {
 	clientid := int(req.ClientId)
 
 	if config.NET_NODE_TYPE == config.ServerType_Gate { //server -> gate
-		socketId, _ := This.tokens_u[clientid]       // get client's socketid by userid
-		This.pService.SendById(socketId, req.Buffer) //send to client
+		This.sendToClient(clientid, req.Buffer) //send to client
 	} else { //gate -> server
 		token, ok := This.tokens[socketId]
 		if ok {
@@ -372,9 +444,9 @@ func innerLouMiaoNetMsg(igo gorpc.IGoRoutine, socketId int, data interface{}) {
 			handler, ok := handler_Map[name]
 			if ok {
 				if name == This.Name {
-					cb, ok := This.NetHandler[name]
+					_, ok := This.NetHandler[name]
 					if ok {
-						cb(This, clientid, pm)
+						This.CallNetFunc(&gorpc.M{Id: clientid, Name: name, Data: pm})
 					} else {
 						llog.Errorf("innerLouMiaoNetMsg[%s] handler is nil: %s", name, This.Name)
 					}
@@ -441,6 +513,7 @@ func registerNet(igo gorpc.IGoRoutine, data interface{}) interface{} {
 	handler_Map[m.Name] = m.Data.(string)
 	if m.Id < 0 { //rpc register
 		This.rpcMap[m.Name] = []int{}
+		This.setRpcBalance(m.Name, m.Param)
 	}
 	return nil
 }
@@ -449,6 +522,19 @@ func unRegisterNet(igo gorpc.IGoRoutine, data interface{}) interface{} {
 	return nil
 }
 
+//gate节点上拦截器直接回复客户端
+func replyGateClient(clientid int, data interface{}) {
+	buff, n := message.Encode(0, "", data)
+	if n == 0 {
+		return
+	}
+	if resumeEnabled() { //需要在gate的协程中计数
+		gorpc.MGR.Send("GateServer", "ReplyClient", &gorpc.M{Id: clientid, Data: buff})
+		return
+	}
+	This.pService.SendById(clientid, buff)
+}
+
 func sendClient(igo gorpc.IGoRoutine, data interface{}) interface{} {
 	m := data.(*gorpc.M)
 	if config.NET_NODE_TYPE == config.ServerType_Gate {
@@ -526,6 +612,7 @@ func newRpc(igo gorpc.IGoRoutine, data interface{}) interface{} {
 	if config.NET_NODE_TYPE == config.ServerType_Gate { //gate才需要向server登录，accoutn目前没有需求
 		req := &msg.LouMiaoLoginGate{TokenId: int64(uid), UserId: int64(This.Id)}
 		buff, _ := message.Encode(uid, "LouMiaoLoginGate", req)
+		client.SetLoginPacket(buff)
 		client.Send(buff)
 	}
 	return nil
@@ -620,6 +707,10 @@ func recvPackMsg(igo gorpc.IGoRoutine, data interface{}) interface{} {
 		message.BackBuffer(rebuff)
 		return nil
 	}
+	if !This.acceptRequest(token.UserId, rebuff) { //重连后重发的请求
+		message.BackBuffer(rebuff)
+		return nil
+	}
 
 	msg := &msg.LouMiaoNetMsg{ClientId: int64(token.UserId), Buffer: rebuff}
 	buff, newlen := message.Encode(target, "LouMiaoNetMsg", msg)
@@ -658,6 +749,34 @@ func closeServer(igo gorpc.IGoRoutine, data interface{}) interface{} {
 	return nil
 }
 
+//begin drain, stop accepting new logins and rpc, tell the connected users to relogin
+func drainNode(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	if nodemgr.Draining {
+		return nil
+	}
+	nodemgr.Draining = true
+	llog.Infof("GateServer drainNode: uid=%d, deadline=%v", This.Id, data)
+	if This.clientEtcd != nil {
+		This.putNodeStatus()
+	}
+
+	buff, _ := message.Encode(0, "LouMiaoKickOut", &msg.LouMiaoKickOut{Reason: define.KICK_REASON_DRAIN})
+	if config.NET_NODE_TYPE == config.ServerType_Gate {
+		This.broadcastClients(buff)
+	} else if This.ServerType == network.SERVER_CONNECT {
+		for userid, _ := range This.users_u {
+			sendClient(igo, &gorpc.M{Id: userid, Data: buff})
+		}
+	}
+
+	handler, ok := handler_Map["ON_DRAIN"]
+	if ok {
+		m := &gorpc.M{Id: This.Id, Name: "ON_DRAIN", Data: data}
+		gorpc.MGR.Send(handler, "ServiceHandler", m)
+	}
+	return nil
+}
+
 func bindGate(igo gorpc.IGoRoutine, data interface{}) interface{} {
 	req := data.(*msg.LouMiaoBindGate)
 	This.users_u[int(req.UserId)] = int(req.Uid)
@@ -669,8 +788,161 @@ func bindGate(igo gorpc.IGoRoutine, data interface{}) interface{} {
 func broadCastClients(buff []byte) {
 	llog.Debugf("GateServer broadCastClients: %d", This.Id)
 	if config.NET_NODE_TYPE == config.ServerType_Gate {
-		This.pService.BroadCast(buff)
+		This.broadcastClients(buff)
 	} else {
 		This.pInnerService.BroadCast(buff)
 	}
 }
+
+//recv remote actor msg
+func innerLouMiaoActorMsg(igo gorpc.IGoRoutine, socketId int, data interface{}) {
+	req := data.(*msg.LouMiaoActorMsg)
+	llog.Debugf("innerLouMiaoActorMsg: actor=%s/%d, handler=%s, source=%d, target=%d, seq=%d, reply=%d", req.ActorName, req.ActorId, req.Handler, req.SourceId, req.TargetId, req.Seq, req.Reply)
+	target := int(req.TargetId)
+	if target != This.Id {
+		if This.ServerType == network.CLIENT_CONNECT { //server -> gate -> server
+			rpcClient := This.GetRpcClient(target)
+			if rpcClient != nil {
+				buff, _ := message.Encode(target, "LouMiaoActorMsg", req)
+				rpcClient.Send(buff)
+				return
+			}
+		}
+		llog.Warningf("0.innerLouMiaoActorMsg target server has lost[%d]", target)
+		if req.Reply == 0 && req.Seq > 0 {
+			replyActorMsg(req.SourceId, req.Seq, nil, gorpc.ErrRemoteUnreachable)
+		}
+		return
+	}
+
+	pm, err := decodeRemoteData(req.Buffer, req.ByteBuffer)
+	if req.Reply > 0 { //remote actor return back
+		if err == nil {
+			err = gorpc.RemoteError(req.Error)
+		}
+		gorpc.DeliverReply(req.Seq, pm, err)
+		return
+	}
+
+	var reply func(ret interface{}, err error)
+	if req.Seq > 0 {
+		sourceId, seq := req.SourceId, req.Seq
+		reply = func(ret interface{}, err error) {
+			replyActorMsg(sourceId, seq, ret, err)
+		}
+	}
+	if err == nil {
+		addr := gorpc.ActorAddr{NodeUid: This.Id, Name: req.ActorName, Id: req.ActorId}
+		err = gorpc.DeliverRemote(addr, req.Handler, pm, reply)
+	}
+	if err != nil {
+		llog.Warningf("1.innerLouMiaoActorMsg deliver error: actor=%s/%d, handler=%s, error=%s", req.ActorName, req.ActorId, req.Handler, err.Error())
+		if reply != nil {
+			reply(nil, err)
+		}
+	}
+}
+
+//remote actor msg sender, goroutine safe
+func sendRemoteActor(rm *gorpc.RemoteMsg) error {
+	buff, byteBuffer, err := encodeRemoteData(rm.Data)
+	if err != nil {
+		return err
+	}
+	outdata := &msg.LouMiaoActorMsg{TargetId: int64(rm.Addr.NodeUid), SourceId: int64(This.Id), ActorName: rm.Addr.Name, ActorId: rm.Addr.Id,
+		Handler: rm.Handler, Buffer: buff, ByteBuffer: byteBuffer, Seq: rm.Seq}
+	return gorpc.MGR.Send("GateServer", "SendActorMsg", &gorpc.M{Data: outdata})
+}
+
+//return the remote actor call result to source server
+func replyActorMsg(sourceId int64, seq int64, ret interface{}, err error) {
+	outdata := &msg.LouMiaoActorMsg{TargetId: sourceId, SourceId: int64(This.Id), Seq: seq, Reply: 1}
+	buff, byteBuffer, eerr := encodeRemoteData(ret)
+	if eerr != nil {
+		err = eerr
+	}
+	if err != nil {
+		outdata.Error = err.Error()
+	} else {
+		outdata.Buffer = buff
+		outdata.ByteBuffer = byteBuffer
+	}
+	gorpc.MGR.Send("GateServer", "SendActorMsg", &gorpc.M{Data: outdata})
+}
+
+//remote data: nil, []byte or registered msg
+func encodeRemoteData(data interface{}) ([]byte, int32, error) {
+	if data == nil {
+		return nil, 0, nil
+	}
+	if buff, ok := data.([]byte); ok { //bitstream
+		return buff, 1, nil
+	}
+	if reflect.TypeOf(data).Kind() != reflect.Ptr || message.Packet_CreateFactorStringMap[message.GetMessageName(data)] == nil {
+		return nil, 0, fmt.Errorf("remote data is not a registered msg: %T", data)
+	}
+	buff, n := message.Encode(0, "", data)
+	if buff == nil {
+		return nil, 0, fmt.Errorf("remote data encode error: %T", data)
+	}
+	return buff[:n], 0, nil
+}
+
+func decodeRemoteData(buff []byte, byteBuffer int32) (interface{}, error) {
+	if byteBuffer > 0 {
+		return buff, nil
+	}
+	if len(buff) == 0 {
+		return nil, nil
+	}
+	err, _, _, pm := message.Decode(This.Id, buff, len(buff))
+	return pm, err
+}
+
+//send remote actor msg to gate or server
+func sendActorMsg(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	m := data.(*gorpc.M)
+	req := m.Data.(*msg.LouMiaoActorMsg)
+	if This.sendInner(int(req.TargetId), "LouMiaoActorMsg", req) == false {
+		llog.Warningf("0.sendActorMsg target server has lost[%d] %s/%s", req.TargetId, req.ActorName, req.Handler)
+		if req.Reply == 0 && req.Seq > 0 {
+			gorpc.DeliverReply(req.Seq, nil, gorpc.ErrRemoteUnreachable)
+		}
+	}
+	return nil
+}
+
+//send rpc call or rpc reply to gate
+func sendRpcMsg(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	m := data.(*gorpc.M)
+	req := m.Data.(*msg.LouMiaoRpcMsg)
+	target := int(req.TargetId)
+	if target <= 0 && This.ServerType == network.CLIENT_CONNECT { //gate直接选择目标server
+		if rpcClient := This.getCluserServer(req.FuncName, int(req.Balance), req.HashKey); rpcClient != nil {
+			target = rpcClient.Uid
+		}
+	}
+	if This.sendInner(target, "LouMiaoRpcMsg", req) == false {
+		llog.Warningf("0.sendRpcMsg target server has lost[%d] %s", req.TargetId, req.FuncName)
+		if req.Reply == 0 && req.Seq > 0 {
+			gorpc.DeliverReply(req.Seq, nil, gorpc.ErrRemoteUnreachable)
+		}
+	}
+	return nil
+}
+
+//return the rpc call result to source server
+func replyRpcMsg(sourceId int64, seq int64, funcName string, ret interface{}, err error) {
+	outdata := &msg.LouMiaoRpcMsg{TargetId: sourceId, SourceId: int64(This.Id), FuncName: funcName, Seq: seq, Reply: 1}
+	buff, byteBuffer, eerr := encodeRemoteData(ret)
+	if eerr != nil {
+		err = eerr
+	}
+	if err != nil {
+		outdata.Error = err.Error()
+	} else {
+		outdata.Buffer = buff
+		outdata.ByteBuffer = byteBuffer
+	}
+	gorpc.MGR.Send("GateServer", "SendRpcMsg", &gorpc.M{Data: outdata})
+}
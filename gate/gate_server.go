@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/snowyyj001/loumiao/message"
+	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/snowyyj001/loumiao/lnats"
 
@@ -15,6 +18,7 @@ import (
 	"github.com/snowyyj001/loumiao/etcd"
 	"github.com/snowyyj001/loumiao/gorpc"
 	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/msg"
 	"github.com/snowyyj001/loumiao/network"
 	"github.com/snowyyj001/loumiao/nodemgr"
 	"github.com/snowyyj001/loumiao/util"
@@ -58,13 +62,24 @@ type GateServer struct {
 	tokens     map[int]*Token
 	tokens_u   map[int]int
 	users_u    map[int]int
+	sessions   map[int]*session //userid -> 续连会话，只在gate上使用
 	OnlineNum  int
 	clientEtcd *etcd.ClientDis
 	rpcMap     map[string][]int
-	rpcGates   []int //space for time
+	rpcGates   []int          //space for time
+	rpcBalance map[string]int //funcname -> define.RPC_BALANCE_*
+	rpcCursor  map[string]int //轮询计数
+
+	GateBalance int //server选择gate的策略define.RPC_BALANCE_*，默认随机
 
 	m_etcdKey string
 
+	flood       *floodGuard              //客户端限流
+	connLimiter *network.ConnLimiter     //客户端连接的准入控制
+	innerTls    *network.TlsLoader       //集群内部连接的tls，nil是明文
+	innerComp   *network.CompressConfig  //集群内部连接的压缩，nil不压缩
+	innerReconn *network.ReconnectConfig //到server的连接断开后自动重连，nil不重连
+
 	lock sync.Mutex
 
 	InitFunc func() //需要额外处理的函数回调
@@ -74,24 +89,78 @@ func (self *GateServer) DoInit() bool {
 	llog.Infof("%s DoInit", self.Name)
 	This = self
 
+	if config.NET_NODE_TYPE == config.ServerType_Gate { //gate节点上的clientid就是socketid
+		gorpc.ClientReplier = replyGateClient
+	}
+
 	if self.ServerType == network.CLIENT_CONNECT { //对外(login,gate)
+		self.flood = newFloodGuard()
+		self.connLimiter = network.NewConnLimiter(config.NET_MAX_CONNS_PER_IP)
 		if config.NET_WEBSOCKET {
 			self.pService = new(network.WebSocket)
 			self.pService.(*network.WebSocket).SetMaxClients(config.NET_MAX_CONNS)
+			self.pService.(*network.WebSocket).SetConnLimiter(self.connLimiter)
 		} else {
 			self.pService = new(network.ServerSocket)
 			self.pService.(*network.ServerSocket).SetMaxClients(config.NET_MAX_CONNS)
+			self.pService.(*network.ServerSocket).SetConnLimiter(self.connLimiter)
+		}
+		if config.NET_TLS != nil {
+			loader := loadTls(config.NET_TLS)
+			if config.NET_WEBSOCKET {
+				self.pService.(*network.WebSocket).SetTls(loader)
+			} else {
+				self.pService.(*network.ServerSocket).SetTls(loader)
+			}
+		}
+		if config.NET_CRYPTO != nil {
+			if config.NET_WEBSOCKET { //websocket请使用wss
+				llog.Warning("GateServer: crypto is not supported on websocket, use tls instead")
+			} else {
+				self.pService.(*network.ServerSocket).SetCrypto(loadCrypto(config.NET_CRYPTO))
+			}
+		}
+		if config.NET_COMPRESS != nil {
+			if config.NET_WEBSOCKET {
+				self.pService.(*network.WebSocket).SetCompress(loadCompress(config.NET_COMPRESS))
+			} else {
+				self.pService.(*network.ServerSocket).SetCompress(loadCompress(config.NET_COMPRESS))
+			}
+		}
+		if config.NET_WEBSOCKET {
+			self.pService.(*network.WebSocket).SetSequence(config.NET_SEQUENCE)
+		} else {
+			self.pService.(*network.ServerSocket).SetSequence(config.NET_SEQUENCE)
 		}
 		self.pService.Init(config.NET_LISTEN_SADDR)
-		self.pService.BindPacketFunc(packetFunc)
+		self.pService.BindPacketFunc(clientPacketFunc)
+		initVerifier()
+		gorpc.RegisterMetrics(func(w io.Writer) {
+			self.flood.writeMetrics(w, self.connLimiter)
+		})
 		self.pService.SetConnectType(network.CLIENT_CONNECT)
 	}
 
+	if config.NET_INNER_TLS != nil {
+		self.innerTls = loadTls(config.NET_INNER_TLS)
+	}
+	if config.NET_INNER_COMPRESS != nil {
+		self.innerComp = loadCompress(config.NET_INNER_COMPRESS)
+	}
+	if config.NET_INNER_RECONNECT != nil {
+		self.innerReconn = network.NewReconnectConfig(config.NET_INNER_RECONNECT)
+	}
 	if self.ServerType == network.CLIENT_CONNECT { //对外(login,gate)
 		self.clients = make(map[int]*network.ClientSocket)
 	} else {
 		self.pInnerService = new(network.ServerSocket)
 		self.pInnerService.(*network.ServerSocket).SetMaxClients(config.NET_MAX_RPC_CONNS)
+		if self.innerTls != nil {
+			self.pInnerService.(*network.ServerSocket).SetTls(self.innerTls)
+		}
+		if self.innerComp != nil {
+			self.pInnerService.(*network.ServerSocket).SetCompress(self.innerComp)
+		}
 		self.pInnerService.Init(config.NET_LISTEN_SADDR)
 		self.pInnerService.BindPacketFunc(packetFunc)
 		self.pInnerService.SetConnectType(network.SERVER_CONNECT)
@@ -101,9 +170,14 @@ func (self *GateServer) DoInit() bool {
 	self.tokens_u = make(map[int]int)
 	self.users_u = make(map[int]int)
 	self.rpcMap = make(map[string][]int) //base64(funcname) -> [uid,uid,...]
+	self.rpcBalance = make(map[string]int)
+	self.rpcCursor = make(map[string]int)
+	self.sessions = make(map[int]*session)
 
 	handler_Map = make(map[string]string)
 
+	gorpc.SetRemoteSender(sendRemoteActor) //其他节点的actor消息经过gate转发
+
 	if self.InitFunc != nil {
 		self.InitFunc()
 	}
@@ -111,12 +185,40 @@ func (self *GateServer) DoInit() bool {
 	return true
 }
 
+//加载tls证书，配置错误时不能启动
+func loadTls(cfg *config.TlsCfg) *network.TlsLoader {
+	loader, err := network.NewTlsLoader(cfg)
+	if err != nil {
+		llog.Fatalf("GateServer: %s", err.Error())
+	}
+	return loader
+}
+
+//加载消息加密配置，配置错误时不能启动
+func loadCrypto(cfg *config.CryptoCfg) *network.CryptoConfig {
+	crypto, err := network.NewCryptoConfig(cfg)
+	if err != nil {
+		llog.Fatalf("GateServer: %s", err.Error())
+	}
+	return crypto
+}
+
+//加载消息压缩配置，配置错误时不能启动
+func loadCompress(cfg *config.CompressCfg) *network.CompressConfig {
+	comp, err := network.NewCompressConfig(cfg)
+	if err != nil {
+		llog.Fatalf("GateServer: %s", err.Error())
+	}
+	return comp
+}
+
 func (self *GateServer) DoRegsiter() {
 	llog.Info("GateServer DoRegsiter")
 
 	self.Register("RegisterNet", registerNet)
 	self.Register("UnRegisterNet", unRegisterNet)
 	self.Register("SendClient", sendClient)
+	self.Register("ReplyClient", replyClient)
 	self.Register("SendMulClient", sendMulClient)
 	self.Register("NewRpc", newRpc)
 	self.Register("SendRpc", sendRpc)
@@ -126,6 +228,9 @@ func (self *GateServer) DoRegsiter() {
 	self.Register("ReportOnLineNum", reportOnLineNum)
 	self.Register("CloseServer", closeServer)
 	self.Register("BindGate", bindGate)
+	self.Register("SendActorMsg", sendActorMsg)
+	self.Register("SendRpcMsg", sendRpcMsg)
+	self.Register("Drain", drainNode)
 
 	//equal to RegisterSelfNet
 	handler_Map["CONNECT"] = "GateServer" //in gate, client connect with gate, in server, gate(as client) connect with server
@@ -163,6 +268,9 @@ func (self *GateServer) DoRegsiter() {
 
 	handler_Map["LouMiaoBindGate"] = "GateServer"
 	self.RegisterGate("LouMiaoBindGate", innerLouMiaoLouMiaoBindGate)
+
+	handler_Map["LouMiaoActorMsg"] = "GateServer"
+	self.RegisterGate("LouMiaoActorMsg", innerLouMiaoActorMsg)
 }
 
 //begin communicate with other nodes
@@ -187,6 +295,10 @@ func (self *GateServer) DoStart() {
 		if err != nil {
 			llog.Fatalf("etcd watch ETCD_NODESTATUS error : %s", err.Error())
 		}
+		_, err = self.clientEtcd.WatchCommon(define.ETCD_NODEDRAIN, nodemgr.NodeDrainUpdate)
+		if err != nil {
+			llog.Fatalf("etcd watch ETCD_NODEDRAIN error : %s", err.Error())
+		}
 		//watch all node, just for account, to gate balance
 		_, err = self.clientEtcd.WatchNodeList(define.ETCD_NODEINFO, self.newServerDiscover)
 		if err != nil {
@@ -323,26 +435,93 @@ func packetFunc(socketid int, buff []byte, nlen int) bool {
 		llog.Errorf("packetFunc Decode error: %s", err.Error())
 		//This.closeClient(socketid)
 	} else {
-		if target == This.Id || target <= 0 { //msg to me
-			handler, ok := handler_Map[name]
-			if ok {
-				nm := &gorpc.M{Id: socketid, Name: name, Data: pm}
-				gorpc.MGR.Send(handler, "ServiceHandler", nm)
-			} else {
-				llog.Errorf("packetFunc handler is nil, drop it[%s]", name)
+		dispatchPacket(socketid, buff, nlen, target, name, pm)
+	}
+	return true
+}
+
+//客户端连接的消息，先经过限流
+//返回false时socket会断开连接
+func clientPacketFunc(socketid int, buff []byte, nlen int) bool {
+	err, target, name, pm := message.Decode(This.Id, buff, nlen)
+	if nil != err {
+		llog.Errorf("clientPacketFunc Decode error: %s", err.Error())
+		return true
+	}
+	switch name {
+	case "CONNECT": //socket自己产生的消息，不限流
+	case "DISCONNECT":
+		This.flood.remove(socketid)
+	default:
+		if floodEnabled() {
+			ok, kick := This.flood.allow(socketid, name)
+			if kick {
+				This.kickFlood(socketid)
+				return false
+			}
+			if !ok {
+				return true
 			}
-		} else { //msg to other server
-			//newbuff := make([]byte, nlen)
-			//copy(newbuff, buff[:nlen])
-			newbuff := message.GetBuffer(nlen)
-			copy(newbuff, buff[:nlen])
-			m := &gorpc.M{Id: socketid, Param: target, Data: newbuff}
-			gorpc.MGR.Send("GateServer", "RecvPackMsg", m)
+		}
+		if name == "LouMiaoLoginGate" && verifier != nil && !verifyLoginGate(socketid, pm.(*msg.LouMiaoLoginGate)) {
+			return false
 		}
 	}
+	dispatchPacket(socketid, buff, nlen, target, name, pm)
 	return true
 }
 
+//持续超限的客户端，踢下线并且封禁ip
+func (self *GateServer) kickFlood(socketid int) {
+	var addr string
+	if config.NET_WEBSOCKET {
+		addr = self.pService.(*network.WebSocket).ClientRemoteAddr(socketid)
+	} else {
+		addr = self.pService.(*network.ServerSocket).ClientRemoteAddr(socketid)
+	}
+	llog.Warningf("GateServer: client %d[%s] flooding, kick it", socketid, addr)
+	if config.NET_FLOOD_BAN_TIME > 0 && addr != "" {
+		self.connLimiter.Ban(addr, time.Duration(config.NET_FLOOD_BAN_TIME)*time.Millisecond)
+		atomic.AddInt64(&self.flood.banned, 1)
+	}
+}
+
+//转发解码后的消息，给自己的交给handler_Map中的服务，其他的转发给目标server
+func dispatchPacket(socketid int, buff []byte, nlen int, target int, name string, pm interface{}) {
+	if target == This.Id || target <= 0 { //msg to me
+		handler, ok := handler_Map[name]
+		if ok {
+			nm := &gorpc.M{Id: socketid, Name: name, Data: pm}
+			gorpc.MGR.Send(handler, "ServiceHandler", nm)
+		} else {
+			llog.Errorf("packetFunc handler is nil, drop it[%s]", name)
+		}
+	} else { //msg to other server
+		//newbuff := make([]byte, nlen)
+		//copy(newbuff, buff[:nlen])
+		newbuff, err := forwardBuffer(buff, nlen)
+		if err != nil {
+			llog.Errorf("packetFunc forward error: %s", err.Error())
+			return
+		}
+		m := &gorpc.M{Id: socketid, Param: target, Data: newbuff}
+		gorpc.MGR.Send("GateServer", "RecvPackMsg", m)
+	}
+}
+
+//转发给其他server的消息包，压缩的先解压，server不一定开启了同样的压缩
+func forwardBuffer(buff []byte, nlen int) ([]byte, error) {
+	if message.IsCompressed(buff) {
+		var err error
+		if buff, nlen, err = message.Decompress(buff, nlen); err != nil {
+			return nil, err
+		}
+	}
+	newbuff := message.GetBuffer(nlen)
+	copy(newbuff, buff[:nlen])
+	return newbuff, nil
+}
+
 func (self *GateServer) buildRpc(uid int, addr string) *network.ClientSocket {
 	client := new(network.ClientSocket)
 	client.SetClientId(uid)
@@ -350,6 +529,15 @@ func (self *GateServer) buildRpc(uid int, addr string) *network.ClientSocket {
 	client.SetConnectType(network.CHILD_CONNECT)
 	client.BindPacketFunc(packetFunc)
 	client.Uid = uid
+	if self.innerTls != nil {
+		client.SetTls(self.innerTls)
+	}
+	if self.innerComp != nil {
+		client.SetCompress(self.innerComp)
+	}
+	if self.innerReconn != nil {
+		client.SetReconnect(self.innerReconn)
+	}
 
 	return client
 }
@@ -401,28 +589,51 @@ func (self *GateServer) getCluserGateClientId() int {
 			llog.Warning("0.getCluserGateClientId no gate server finded ")
 			return 0
 		} else {
-			index := util.Random(sz) //choose a gate by random
-			return self.rpcGates[index]
+			var uids, socketIds []int
+			for _, draining := range []bool{false, true} { //优先选择没有排空的gate
+				for _, socketId := range self.rpcGates {
+					uid := 0
+					if token, ok := self.tokens[socketId]; ok {
+						uid = token.UserId
+					}
+					if draining || nodemgr.IsDraining(uid) == false {
+						uids = append(uids, uid)
+						socketIds = append(socketIds, socketId)
+					}
+				}
+				if len(socketIds) > 0 {
+					break
+				}
+			}
+			index := self.selectIndex("", self.GateBalance, int64(self.Id), uids)
+			return socketIds[index]
 		}
 	}
 	return 0
 }
 
 //rpc调用的目标server选择
-func (self *GateServer) getCluserServer(funcName string) *network.ClientSocket {
+//@balance: 选择策略define.RPC_BALANCE_*，RPC_BALANCE_NONE使用注册时的策略
+//@key: RPC_BALANCE_HASH的key
+func (self *GateServer) getCluserServer(funcName string, balance int, key int64) *network.ClientSocket {
 	arr := self.rpcMap[funcName]
 	sz := len(arr)
 	if sz == 0 {
 		llog.Warningf("0.getCluserServerUid no rpc server hanlder finded %s", funcName)
 		return nil
 	}
-	index := util.Random(sz) //choose a server by random
+	if balance == define.RPC_BALANCE_NONE {
+		balance = self.rpcBalance[funcName]
+	}
+	arr = filterDraining(arr)
+	index := self.selectIndex(funcName, balance, key, arr)
 	uid := arr[index]
 	client, _ := self.clients[uid]
 	return client
 }
 
 func (self *GateServer) StopClient(userId int) {
+	self.endSession(userId)
 	sid := This.tokens_u[userId]
 	if sid > 0 {
 		self.closeClient(sid)
@@ -441,6 +652,37 @@ func (self *GateServer) closeClient(clientid int) {
 	}
 }
 
+//发送内部消息给目标server，gate直接发送，server经过gate转发
+//必须在gateserver的igo中调用
+func (self *GateServer) sendInner(target int, name string, req interface{}) bool {
+	if self.ServerType == network.CLIENT_CONNECT {
+		rpcClient := self.GetRpcClient(target)
+		if rpcClient == nil {
+			return false
+		}
+		buff, _ := message.Encode(target, name, req)
+		rpcClient.Send(buff)
+		return true
+	}
+	clientid := self.getCluserGateClientId()
+	if clientid <= 0 {
+		return false
+	}
+	buff, _ := message.Encode(0, name, req)
+	self.pInnerService.SendById(clientid, buff)
+	return true
+}
+
+//写入节点状态，排空时另外写入排空标记
+func (self *GateServer) putNodeStatus() {
+	//str := fmt.Sprintf("%s%s/%s", define.ETCD_NODESTATUS, config.SERVER_GROUP, config.NET_GATE_SADDR)
+	str := fmt.Sprintf("%s%s", define.ETCD_NODESTATUS, config.NET_GATE_SADDR)
+	self.clientEtcd.Put(str, util.Itoa(self.OnlineNum), true)
+	if nodemgr.Draining {
+		self.clientEtcd.Put(define.ETCD_NODEDRAIN+config.NET_GATE_SADDR, "1", true)
+	}
+}
+
 // 向内部server直接发送buffer消息,专为gate使用，
 // 必须保证线程安全，即需要在gateserver的igo中调用该函数
 func (self *GateServer) SendServer(target int, buff []byte) {
@@ -0,0 +1,43 @@
+package gate
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/snowyyj001/loumiao/message"
+	"github.com/snowyyj001/loumiao/msg"
+)
+
+var messageOnce sync.Once
+
+//只初始化一次，前一个测试的连接协程可能还在编码消息
+func initMessage() {
+	messageOnce.Do(message.DoInit)
+}
+
+func TestForwardBuffer(t *testing.T) {
+	initMessage()
+	req := &msg.LouMiaoNetMsg{ClientId: 1, Buffer: bytes.Repeat([]byte("loumiao"), 512)}
+	buff, nlen := message.Encode(2001, "LouMiaoNetMsg", req)
+	plain := buff[:nlen]
+	compressed := message.Compress(plain, message.COMPRESS_SNAPPY)
+	if !message.IsCompressed(compressed) {
+		t.Fatal("packet not compressed")
+	}
+
+	for _, in := range [][]byte{plain, compressed} {
+		out, err := forwardBuffer(in, len(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, plain) {
+			t.Fatalf("forward %d bytes, got %d bytes", len(in), len(out))
+		}
+	}
+
+	compressed[len(compressed)-1] ^= 0xFF
+	if _, err := forwardBuffer(compressed, len(compressed)-4); err == nil {
+		t.Fatal("corrupted packet forwarded")
+	}
+}
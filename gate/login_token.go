@@ -0,0 +1,105 @@
+package gate
+
+import (
+	"sync"
+
+	"github.com/snowyyj001/loumiao/auth"
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/define"
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/message"
+	"github.com/snowyyj001/loumiao/msg"
+)
+
+var (
+	verifier   auth.Verifier
+	usedTokens = auth.NewReplayGuard() //登录令牌只能成功登录一次，只在本gate内有效，GateUid为0的令牌在其他gate上仍然可以登录一次
+
+	loginLock   sync.Mutex
+	loginClaims = make(map[int]*auth.Claims) //socketid -> 校验通过还没有登录成功的令牌
+)
+
+//设置会话令牌的校验，替换cfg.json中tokenalg，tokenkey的配置，必须在gate启动前调用
+//例如接入自己的sso，Verify在客户端socket的协程中调用，可以阻塞，但会阻塞这个客户端的消息
+func SetVerifier(v auth.Verifier) {
+	verifier = v
+}
+
+//按照配置创建令牌校验
+func initVerifier() {
+	if verifier != nil || config.NET_NODE_TYPE != config.ServerType_Gate {
+		return
+	}
+	_, v, err := auth.FromConfig()
+	if err != nil {
+		llog.Fatalf("GateServer: token config: %s", err.Error())
+		return
+	}
+	if v == nil {
+		llog.Warning("GateServer: no token verifier, LouMiaoLoginGate is trusted as it is")
+		return
+	}
+	verifier = v
+}
+
+//校验客户端的登录令牌，通过后用令牌中的内容替换客户端发来的userid，tokenid，world
+//令牌在登录成功时(useLoginToken)才标记为已使用，登录失败(例如world不存在)后可以再用同一个令牌登录
+//在客户端socket的协程中调用，返回false时断开连接
+func verifyLoginGate(socketid int, m *msg.LouMiaoLoginGate) bool {
+	if m.ResumeToken != "" && resumeEnabled() { //续连由会话令牌校验
+		return true
+	}
+	claims, err := verifier.Verify(m.Token)
+	if err == nil {
+		err = claims.Check(config.SERVER_NODE_UID)
+	}
+	if err == nil && usedTokens.Used(claims) {
+		err = auth.ErrTokenReplayed
+	}
+	if err != nil {
+		llog.Warningf("GateServer: client %d login token refused: userid=%d, %s", socketid, m.UserId, err.Error())
+		refuseLogin(socketid)
+		return false
+	}
+	loginLock.Lock()
+	loginClaims[socketid] = claims
+	loginLock.Unlock()
+	m.UserId = claims.UserId
+	m.TokenId = claims.TokenId
+	m.WorldUid = int32(claims.WorldUid)
+	m.Token = "" //不再回给客户端
+	return true
+}
+
+//取出socket校验通过的令牌，没有开启校验或者续连时返回nil
+func takeLoginClaims(socketid int) *auth.Claims {
+	loginLock.Lock()
+	defer loginLock.Unlock()
+	claims := loginClaims[socketid]
+	delete(loginClaims, socketid)
+	return claims
+}
+
+//令牌是否已经被其他连接登录成功过，是的话通知客户端
+//和useLoginToken都在gate的actor中调用，同一个令牌的多个登录按顺序处理，只有第一个成功
+func loginReplayed(socketid int, claims *auth.Claims) bool {
+	if claims == nil || !usedTokens.Used(claims) {
+		return false
+	}
+	llog.Warningf("GateServer: client %d login token refused: userid=%d, %s", socketid, claims.UserId, auth.ErrTokenReplayed.Error())
+	refuseLogin(socketid)
+	return true
+}
+
+//登录成功，标记令牌已使用
+func useLoginToken(claims *auth.Claims) {
+	if claims != nil {
+		usedTokens.Use(claims)
+	}
+}
+
+//通知客户端令牌校验失败
+func refuseLogin(socketid int) {
+	buff, _ := message.Encode(0, "LouMiaoKickOut", &msg.LouMiaoKickOut{Reason: define.KICK_REASON_AUTH})
+	This.pService.SendById(socketid, buff)
+}
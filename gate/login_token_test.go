@@ -0,0 +1,68 @@
+package gate
+
+import (
+	"testing"
+
+	"github.com/snowyyj001/loumiao/auth"
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/msg"
+)
+
+//按gate actor中的顺序处理登录，登录成功时返回true
+func login(socketid int, success bool) bool {
+	claims := takeLoginClaims(socketid)
+	if loginReplayed(socketid, claims) {
+		return false
+	}
+	if success {
+		useLoginToken(claims)
+	}
+	return success
+}
+
+func TestVerifyLoginGateReplay(t *testing.T) {
+	oldVerifier, oldUsed, oldResume := verifier, usedTokens, config.NET_RESUME_TIME
+	defer func() {
+		verifier, usedTokens, config.NET_RESUME_TIME = oldVerifier, oldUsed, oldResume
+		This = nil
+	}()
+	config.NET_RESUME_TIME = 0
+	h := auth.NewHMAC([]byte("key"))
+	verifier, usedTokens = h, auth.NewReplayGuard()
+	sock := &fakeSocket{sent: make(map[int][][]byte)}
+	This = &GateServer{pService: sock}
+
+	token, _ := auth.Issue(h, auth.Claims{UserId: 10, TokenId: 20, WorldUid: 30})
+	m := &msg.LouMiaoLoginGate{Token: token, UserId: 99}
+	if !verifyLoginGate(1, m) || m.UserId != 10 || m.TokenId != 20 || m.WorldUid != 30 || m.Token != "" {
+		t.Fatalf("first login refused: %v", m)
+	}
+	//登录成功之前同一个令牌可以通过校验，先处理的登录成功
+	if !verifyLoginGate(2, &msg.LouMiaoLoginGate{Token: token}) {
+		t.Fatal("token refused before login succeeded")
+	}
+	if !login(1, true) || login(2, true) {
+		t.Fatal("replayed login succeeded")
+	}
+	if len(sock.sent[1]) != 0 || len(sock.sent[2]) != 1 {
+		t.Fatal("replayed client not kicked")
+	}
+	if verifyLoginGate(3, &msg.LouMiaoLoginGate{Token: token}) {
+		t.Fatal("used token accepted")
+	}
+	if len(sock.sent[3]) != 1 {
+		t.Fatal("refused client not kicked")
+	}
+
+	//登录失败不消耗令牌
+	token, _ = auth.Issue(h, auth.Claims{UserId: 11, TokenId: 21, WorldUid: 30})
+	if !verifyLoginGate(4, &msg.LouMiaoLoginGate{Token: token}) || login(4, false) {
+		t.Fatal("first login")
+	}
+	if !verifyLoginGate(5, &msg.LouMiaoLoginGate{Token: token}) || !login(5, true) {
+		t.Fatal("token refused after failed login")
+	}
+	if len(loginClaims) != 0 {
+		t.Fatalf("claims left: %d", len(loginClaims))
+	}
+}
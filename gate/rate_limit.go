@@ -0,0 +1,161 @@
+package gate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/network"
+	"github.com/snowyyj001/loumiao/util"
+)
+
+//令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+//消耗一个令牌，没有令牌时返回false
+func (self *tokenBucket) take(limit config.RateLimit, now time.Time) bool {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = limit.Rate
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	if self.last.IsZero() {
+		self.tokens = burst
+	} else if dt := now.Sub(self.last).Seconds(); dt > 0 {
+		self.tokens += dt * limit.Rate
+		if self.tokens > burst {
+			self.tokens = burst
+		}
+	}
+	self.last = now
+	if self.tokens < 1 {
+		return false
+	}
+	self.tokens--
+	return true
+}
+
+//一个客户端连接的限流状态，只在该连接的socket协程中访问
+type clientFlood struct {
+	all     tokenBucket
+	msgs    map[string]*tokenBucket
+	strikes int       //窗口内超限的消息数
+	window  time.Time //窗口开始时间
+}
+
+//客户端消息的限流和防刷
+type floodGuard struct {
+	lock    sync.Mutex
+	clients map[int]*clientFlood //socketid -> 限流状态
+
+	dropLock sync.Mutex
+	dropped  map[string]int64 //消息名 -> 超限丢弃的次数
+	kicked   int64
+	banned   int64
+}
+
+func newFloodGuard() *floodGuard {
+	return &floodGuard{clients: make(map[int]*clientFlood), dropped: make(map[string]int64)}
+}
+
+//是否需要限流
+func floodEnabled() bool {
+	return config.NET_RATE_LIMIT.Rate > 0 || len(config.NET_MSG_RATE_LIMIT) > 0
+}
+
+func (self *floodGuard) client(socketid int) *clientFlood {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	cf, ok := self.clients[socketid]
+	if !ok {
+		cf = &clientFlood{msgs: make(map[string]*tokenBucket)}
+		self.clients[socketid] = cf
+	}
+	return cf
+}
+
+//连接断开
+func (self *floodGuard) remove(socketid int) {
+	self.lock.Lock()
+	delete(self.clients, socketid)
+	self.lock.Unlock()
+}
+
+//检查一个消息，返回(是否放行，是否踢下线)
+func (self *floodGuard) allow(socketid int, name string) (bool, bool) {
+	cf := self.client(socketid)
+	now := util.Now()
+	ok := true
+	if limit, has := config.NET_MSG_RATE_LIMIT[name]; has && limit.Rate > 0 {
+		bucket, exist := cf.msgs[name]
+		if !exist {
+			bucket = new(tokenBucket)
+			cf.msgs[name] = bucket
+		}
+		ok = bucket.take(limit, now)
+	}
+	if ok && config.NET_RATE_LIMIT.Rate > 0 {
+		ok = cf.all.take(config.NET_RATE_LIMIT, now)
+	}
+	if ok {
+		return true, false
+	}
+
+	self.dropLock.Lock()
+	self.dropped[name]++
+	self.dropLock.Unlock()
+
+	if config.NET_FLOOD_STRIKES <= 0 {
+		return false, false
+	}
+	if now.Sub(cf.window) >= time.Duration(config.NET_FLOOD_WINDOW)*time.Millisecond {
+		cf.window = now
+		cf.strikes = 0
+	}
+	cf.strikes++
+	if cf.strikes < config.NET_FLOOD_STRIKES {
+		return false, false
+	}
+	atomic.AddInt64(&self.kicked, 1)
+	return false, true
+}
+
+//按照prometheus文本格式输出限流统计
+func (self *floodGuard) writeMetrics(w io.Writer, limiter *network.ConnLimiter) {
+	bw := bufio.NewWriter(w)
+	self.dropLock.Lock()
+	names := make([]string, 0, len(self.dropped))
+	for name := range self.dropped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(bw, "# HELP loumiao_gate_rate_limited_total Client packets dropped by the rate limit.\n# TYPE loumiao_gate_rate_limited_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(bw, "loumiao_gate_rate_limited_total{msg=\"%s\"} %d\n", name, self.dropped[name])
+	}
+	self.dropLock.Unlock()
+
+	fmt.Fprintf(bw, "# HELP loumiao_gate_kicked_total Clients kicked for flooding.\n# TYPE loumiao_gate_kicked_total counter\n")
+	fmt.Fprintf(bw, "loumiao_gate_kicked_total %d\n", atomic.LoadInt64(&self.kicked))
+	fmt.Fprintf(bw, "# HELP loumiao_gate_banned_total Ip bans for flooding.\n# TYPE loumiao_gate_banned_total counter\n")
+	fmt.Fprintf(bw, "loumiao_gate_banned_total %d\n", atomic.LoadInt64(&self.banned))
+	if limiter != nil {
+		fmt.Fprintf(bw, "# HELP loumiao_gate_banned_ips Ips currently banned.\n# TYPE loumiao_gate_banned_ips gauge\n")
+		fmt.Fprintf(bw, "loumiao_gate_banned_ips %d\n", limiter.BanCount())
+		fmt.Fprintf(bw, "# HELP loumiao_gate_conn_rejected_total Client connections refused.\n# TYPE loumiao_gate_conn_rejected_total counter\n")
+		for reason, name := range network.RejectNames {
+			fmt.Fprintf(bw, "loumiao_gate_conn_rejected_total{reason=\"%s\"} %d\n", name, limiter.Rejected(reason))
+		}
+	}
+	bw.Flush()
+}
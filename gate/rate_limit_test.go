@@ -0,0 +1,76 @@
+package gate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limit := config.RateLimit{Rate: 10, Burst: 3}
+	var bucket tokenBucket
+	for i := 0; i < 3; i++ {
+		if !bucket.take(limit, now) {
+			t.Fatalf("burst %d refused", i)
+		}
+	}
+	if bucket.take(limit, now) {
+		t.Fatal("taken over burst")
+	}
+	now = now.Add(100 * time.Millisecond) //补充1个
+	if !bucket.take(limit, now) || bucket.take(limit, now) {
+		t.Fatal("refill")
+	}
+	now = now.Add(time.Hour) //最多补充到burst
+	for i := 0; i < 3; i++ {
+		if !bucket.take(limit, now) {
+			t.Fatalf("refill burst %d refused", i)
+		}
+	}
+	if bucket.take(limit, now) {
+		t.Fatal("refilled over burst")
+	}
+
+	//Burst为0时取Rate，不足1时取1
+	var low tokenBucket
+	if !low.take(config.RateLimit{Rate: 0.5}, now) || low.take(config.RateLimit{Rate: 0.5}, now) {
+		t.Fatal("low rate burst")
+	}
+}
+
+func TestFloodGuard(t *testing.T) {
+	oldRate, oldMsg, oldStrikes := config.NET_RATE_LIMIT, config.NET_MSG_RATE_LIMIT, config.NET_FLOOD_STRIKES
+	defer func() {
+		config.NET_RATE_LIMIT, config.NET_MSG_RATE_LIMIT, config.NET_FLOOD_STRIKES = oldRate, oldMsg, oldStrikes
+	}()
+	config.NET_RATE_LIMIT = config.RateLimit{Rate: 0.001, Burst: 5}
+	config.NET_MSG_RATE_LIMIT = map[string]config.RateLimit{"C_Chat": {Rate: 0.001, Burst: 1}}
+	config.NET_FLOOD_STRIKES = 3
+
+	guard := newFloodGuard()
+	if ok, _ := guard.allow(1, "C_Chat"); !ok {
+		t.Fatal("first chat refused")
+	}
+	if ok, kick := guard.allow(1, "C_Chat"); ok || kick {
+		t.Fatal("second chat allowed")
+	}
+	if ok, _ := guard.allow(2, "C_Chat"); !ok {
+		t.Fatal("other client refused")
+	}
+	for i := 0; i < 4; i++ {
+		if ok, _ := guard.allow(1, "C_Move"); !ok {
+			t.Fatalf("move %d refused", i)
+		}
+	}
+	if ok, kick := guard.allow(1, "C_Move"); ok || kick {
+		t.Fatal("move over total limit")
+	}
+	if _, kick := guard.allow(1, "C_Move"); !kick {
+		t.Fatal("flooding client not kicked")
+	}
+	if guard.dropped["C_Chat"] != 1 || guard.dropped["C_Move"] != 2 {
+		t.Fatalf("dropped: %v", guard.dropped)
+	}
+}
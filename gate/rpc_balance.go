@@ -0,0 +1,104 @@
+package gate
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/snowyyj001/loumiao/define"
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/nodemgr"
+	"github.com/snowyyj001/loumiao/util"
+)
+
+//记录rpc函数的目标选择策略，不同server注册了不同的策略时，后注册的生效
+func (self *GateServer) setRpcBalance(funcName string, balance int) {
+	if balance == define.RPC_BALANCE_NONE {
+		return
+	}
+	old, ok := self.rpcBalance[funcName]
+	if ok && old != balance {
+		llog.Warningf("GateServer setRpcBalance: %s balance changed %d -> %d", funcName, old, balance)
+	}
+	self.rpcBalance[funcName] = balance
+}
+
+//按照策略从uids中选择一个，返回下标
+//@name: 轮询计数的key
+//@balance: 选择策略define.RPC_BALANCE_*
+//@key: RPC_BALANCE_HASH的key，0时按轮询选择
+//@uids: 候选节点的uid
+func (self *GateServer) selectIndex(name string, balance int, key int64, uids []int) int {
+	sz := len(uids)
+	if sz == 1 {
+		return 0
+	}
+	switch balance {
+	case define.RPC_BALANCE_ROUND_ROBIN:
+		index := self.rpcCursor[name] % sz
+		self.rpcCursor[name] = index + 1
+		return index
+	case define.RPC_BALANCE_LEAST_LOADED:
+		index, minNum := util.Random(sz), 0x7fffffff //都没有负载信息时随机
+		for i, uid := range uids {
+			node := nodemgr.GetNode(uid)
+			if node != nil && node.Number >= 0 && node.Number < minNum {
+				index, minNum = i, node.Number
+			}
+		}
+		return index
+	case define.RPC_BALANCE_HASH: //rendezvous hash，节点增减只影响该节点上的key
+		if key == 0 { //没有指定key时轮询，避免都选中同一个节点
+			llog.Debugf("GateServer selectIndex: %s hash key is 0, use round robin", name)
+			return self.selectIndex(name, define.RPC_BALANCE_ROUND_ROBIN, key, uids)
+		}
+		var index int
+		var maxScore uint64
+		buf := make([]byte, 16)
+		binary.BigEndian.PutUint64(buf, uint64(key))
+		for i, uid := range uids {
+			binary.BigEndian.PutUint64(buf[8:], uint64(uid))
+			h := fnv.New64a()
+			h.Write(buf)
+			score := h.Sum64()
+			if i == 0 || score > maxScore {
+				index, maxScore = i, score
+			}
+		}
+		return index
+	case define.RPC_BALANCE_WEIGHTED:
+		total := 0
+		weights := make([]int, sz)
+		for i, uid := range uids {
+			weights[i] = 1
+			node := nodemgr.GetNode(uid)
+			if node != nil && node.Weight > 0 {
+				weights[i] = node.Weight
+			}
+			total += weights[i]
+		}
+		r := util.Random(total)
+		for i, w := range weights {
+			if r < w {
+				return i
+			}
+			r -= w
+		}
+		return sz - 1
+	default:
+		return util.Random(sz)
+	}
+}
+
+//过滤掉正在排空的节点，都在排空时返回原列表
+func filterDraining(uids []int) []int {
+	var alive []int
+	for _, uid := range uids {
+		if nodemgr.IsDraining(uid) == false {
+			alive = append(alive, uid)
+		}
+	}
+	if len(alive) == 0 {
+		return uids
+	}
+	return alive
+}
@@ -0,0 +1,31 @@
+package gate
+
+import (
+	"testing"
+
+	"github.com/snowyyj001/loumiao/define"
+)
+
+func TestSelectIndexHash(t *testing.T) {
+	self := &GateServer{rpcCursor: make(map[string]int)}
+	uids := []int{101, 102, 103}
+
+	//相同的key总是选择相同的节点
+	for _, key := range []int64{1, 10001, 987654321} {
+		index := self.selectIndex("Echo", define.RPC_BALANCE_HASH, key, uids)
+		for i := 0; i < 10; i++ {
+			if self.selectIndex("Echo", define.RPC_BALANCE_HASH, key, uids) != index {
+				t.Fatalf("key %d moved", key)
+			}
+		}
+	}
+
+	//key为0时轮询
+	hits := make(map[int]int)
+	for i := 0; i < 30; i++ {
+		hits[self.selectIndex("Echo", define.RPC_BALANCE_HASH, 0, uids)]++
+	}
+	if len(hits) != len(uids) || hits[0] != 10 || hits[1] != 10 || hits[2] != 10 {
+		t.Fatalf("key 0 hits: %v", hits)
+	}
+}
@@ -0,0 +1,102 @@
+package gate
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/gorpc"
+	"github.com/snowyyj001/loumiao/message"
+	"github.com/snowyyj001/loumiao/msg"
+	"github.com/snowyyj001/loumiao/network"
+)
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+//模拟一个server，收到rpc调用后原样返回
+func startEchoServer(t *testing.T, uid int) (*network.ServerSocket, string) {
+	addr := freeAddr(t)
+	server := new(network.ServerSocket)
+	server.Init(addr)
+	server.SetMaxClients(16)
+	server.SetConnectType(network.SERVER_CONNECT)
+	server.BindPacketFunc(func(socketid int, buff []byte, nlen int) bool {
+		err, _, name, pm := message.Decode(uid, buff, nlen)
+		if err != nil || name != "LouMiaoRpcMsg" {
+			return true
+		}
+		req := pm.(*msg.LouMiaoRpcMsg)
+		resp := &msg.LouMiaoRpcMsg{TargetId: req.SourceId, SourceId: int64(uid), FuncName: req.FuncName, Seq: req.Seq, Reply: 1, Buffer: req.Buffer, ByteBuffer: 1}
+		out, _ := message.Encode(0, "LouMiaoRpcMsg", resp)
+		server.SendById(socketid, out)
+		return true
+	})
+	if !server.Start() {
+		t.Fatal("server start failed")
+	}
+	return server, addr
+}
+
+func TestGateCallRpc(t *testing.T) {
+	initMessage()
+	oldType := config.NET_NODE_TYPE
+	config.NET_NODE_TYPE = config.ServerType_Gate
+	defer func() { config.NET_NODE_TYPE = oldType }()
+
+	const gateId, serverId = 1001, 2001
+	This = &GateServer{Id: gateId, ServerType: network.CLIENT_CONNECT, clients: make(map[int]*network.ClientSocket),
+		rpcMap: make(map[string][]int), rpcBalance: make(map[string]int), rpcCursor: make(map[string]int)}
+	defer func() { This = nil }()
+
+	server, addr := startEchoServer(t, serverId)
+	defer server.Close()
+
+	client := new(network.ClientSocket)
+	client.Init(addr)
+	client.Uid = serverId
+	client.SetConnectType(network.CLIENT_CONNECT)
+	client.BindPacketFunc(func(socketid int, buff []byte, nlen int) bool {
+		err, _, name, pm := message.Decode(gateId, buff, nlen)
+		if err == nil && name == "LouMiaoRpcMsg" {
+			innerLouMiaoRpcMsg(nil, socketid, pm)
+		}
+		return true
+	})
+	if !client.Start() {
+		t.Fatal("client start failed")
+	}
+	defer client.Stop()
+	This.clients[serverId] = client
+	This.rpcMap["Echo"] = []int{serverId}
+
+	for _, target := range []int64{serverId, 0} {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		ret, err := gorpc.CallRemote(ctx, func(seq int64) error {
+			req := &msg.LouMiaoRpcMsg{TargetId: target, SourceId: gateId, FuncName: "Echo", Seq: seq, Buffer: []byte("hello"), ByteBuffer: 1}
+			sendRpcMsg(nil, &gorpc.M{Data: req})
+			return nil
+		})
+		cancel()
+		if err != nil || string(ret.([]byte)) != "hello" {
+			t.Fatalf("target %d: %v %v", target, ret, err)
+		}
+	}
+
+	delete(This.rpcMap, "Echo")
+	_, err := gorpc.CallRemote(context.Background(), func(seq int64) error {
+		sendRpcMsg(nil, &gorpc.M{Data: &msg.LouMiaoRpcMsg{SourceId: gateId, FuncName: "Echo", Seq: seq}})
+		return nil
+	})
+	if err != gorpc.ErrRemoteUnreachable {
+		t.Fatalf("unreachable: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package gate
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/gorpc"
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/message"
+	"github.com/snowyyj001/loumiao/msg"
+	"github.com/snowyyj001/loumiao/nodemgr"
+)
+
+//客户端断线续连，只在gate上使用，NET_RESUME_TIME>0时开启
+//登录成功时回复的LouMiaoLoginGate中带有续连令牌ResumeToken，gate给之后发给客户端的每个消息包计数
+//客户端断开后会话保留NET_RESUME_TIME毫秒，期间不通知world，world发来的消息包缓存在会话中
+//客户端重新连接后发送LouMiaoLoginGate{UserId, ResumeToken, Seq}，Seq是登录回复之后收到的消息包数
+//成功时回复LouMiaoLoginGate{ResumeToken, Seq}，然后补发Seq之后的消息包，计数继续
+//登录后发给客户端的消息包(包括广播)都要经过sendToClient，LouMiaoLoginGate的回复不计数
+//失败时回复WorldUid=0，和world不存在一样，客户端需要重新登录
+//客户端开启了消息序号(network.PacketSeq)时，会话记录转发给world的最大请求序号，重连后客户端重发的请求不会重复转发
+//新的会话从0开始，客户端重新完整登录后要丢弃没有确认的消息包(network.Socket.DropPending)
+
+//一个客户端的会话
+type session struct {
+	token    string
+	tokenId  int
+	socketId int      //0代表断线中
+	seq      int64    //已经发给客户端的消息包数
+	replay   [][]byte //最近发给客户端的消息包，最后一个的序号是seq
+	timer    int      //断线后的超时定时器
+	request  uint32   //已经转发给world的客户端请求的最大序号
+}
+
+func resumeEnabled() bool {
+	return config.NET_RESUME_TIME > 0 && config.NET_NODE_TYPE == config.ServerType_Gate
+}
+
+//登录成功，创建会话，返回续连令牌
+func (self *GateServer) newSession(userid int, tokenId int, socketId int) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		llog.Errorf("GateServer newSession: %s", err.Error())
+		return ""
+	}
+	s := &session{token: hex.EncodeToString(b), tokenId: tokenId, socketId: socketId}
+	self.sessions[userid] = s
+	return s.token
+}
+
+//结束会话，断线中的会话通知world客户端断开，在线的会话由socket的DISCONNECT通知
+func (self *GateServer) endSession(userid int) {
+	s, ok := self.sessions[userid]
+	if !ok {
+		return
+	}
+	delete(self.sessions, userid)
+	if s.socketId == 0 {
+		self.CancelTimer(s.timer)
+		worlduid := self.users_u[userid]
+		onClientDisConnected(userid, worlduid)
+		delete(self.users_u, userid)
+	}
+}
+
+//客户端断开，保留会话等待续连，返回false代表没有会话或者不能续连
+func (self *GateServer) suspendSession(socketId int, userid int) bool {
+	s, ok := self.sessions[userid]
+	if !ok || s.socketId != socketId {
+		return false
+	}
+	if nodemgr.Draining { //排空中，让客户端登录其他gate
+		delete(self.sessions, userid)
+		return false
+	}
+	llog.Debugf("GateServer suspendSession: userid=%d, socketId=%d, seq=%d", userid, socketId, s.seq)
+	s.socketId = 0
+	s.timer = self.AddTimer(config.NET_RESUME_TIME, func(dt int64) {
+		if self.sessions[userid] == s {
+			llog.Debugf("GateServer session expired: userid=%d", userid)
+			self.endSession(userid)
+		}
+	}, false)
+	return true
+}
+
+//客户端续连
+func (self *GateServer) resumeSession(socketId int, m *msg.LouMiaoLoginGate) {
+	userid := int(m.UserId)
+	s, ok := self.sessions[userid]
+	if !ok || subtle.ConstantTimeCompare([]byte(s.token), []byte(m.ResumeToken)) != 1 {
+		llog.Infof("GateServer resumeSession: no session, userid=%d", userid)
+		self.refuseResume(socketId, m)
+		return
+	}
+	if m.Seq > s.seq || m.Seq < s.seq-int64(len(s.replay)) { //缓存的消息包不够补发
+		llog.Infof("GateServer resumeSession: userid=%d, seq=%d out of range [%d, %d]", userid, m.Seq, s.seq-int64(len(s.replay)), s.seq)
+		self.endSession(userid)
+		self.refuseResume(socketId, m)
+		return
+	}
+	if s.socketId != 0 { //老的连接还没有检测到断开
+		delete(self.tokens, s.socketId)
+		self.closeClient(s.socketId)
+	} else {
+		self.CancelTimer(s.timer)
+	}
+	llog.Debugf("GateServer resumeSession: userid=%d, socketId=%d, seq=%d/%d", userid, socketId, m.Seq, s.seq)
+	s.socketId = socketId
+	self.tokens[socketId] = &Token{TokenId: s.tokenId, UserId: userid}
+	self.tokens_u[userid] = socketId
+
+	reply := &msg.LouMiaoLoginGate{TokenId: int64(s.tokenId), UserId: m.UserId, WorldUid: int32(self.users_u[userid]), ResumeToken: s.token, Seq: m.Seq}
+	buff, _ := message.Encode(0, "LouMiaoLoginGate", reply)
+	self.pService.SendById(socketId, buff)
+	for _, buff := range s.replay[len(s.replay)-int(s.seq-m.Seq):] {
+		self.pService.SendById(socketId, buff)
+	}
+}
+
+func (self *GateServer) refuseResume(socketId int, m *msg.LouMiaoLoginGate) {
+	m.WorldUid = 0
+	m.ResumeToken = ""
+	m.Seq = 0
+	buff, _ := message.Encode(0, "LouMiaoLoginGate", m)
+	self.pService.SendById(socketId, buff)
+}
+
+//发送消息包给登录了的客户端，有会话时计数并缓存，断线期间只缓存
+func (self *GateServer) sendToClient(userid int, buff []byte) {
+	s, ok := self.sessions[userid]
+	if !ok {
+		socketId, _ := self.tokens_u[userid]
+		self.pService.SendById(socketId, buff)
+		return
+	}
+	s.seq++
+	if config.NET_RESUME_BUFFER > 0 {
+		if len(s.replay) >= config.NET_RESUME_BUFFER {
+			s.replay = s.replay[1:]
+		}
+		s.replay = append(s.replay, buff)
+	}
+	if s.socketId != 0 {
+		self.pService.SendById(s.socketId, buff)
+	}
+}
+
+//广播给客户端，开启续连时只发给登录了的客户端，经过sendToClient计数，断线中的会话也缓存
+func (self *GateServer) broadcastClients(buff []byte) {
+	if !resumeEnabled() {
+		self.pService.BroadCast(buff)
+		return
+	}
+	for _, token := range self.tokens {
+		self.sendToClient(token.UserId, buff)
+	}
+	for userid, s := range self.sessions {
+		if s.socketId == 0 {
+			self.sendToClient(userid, buff)
+		}
+	}
+}
+
+//拦截器回复客户端，开启续连时需要在gate的协程中计数
+func replyClient(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	m := data.(*gorpc.M)
+	if token, ok := This.tokens[m.Id]; ok {
+		This.sendToClient(token.UserId, m.Data.([]byte))
+	} else {
+		This.pService.SendById(m.Id, m.Data.([]byte))
+	}
+	return nil
+}
+
+//客户端请求去重，序号不大于已经转发过的请求是重连后重发的，丢弃
+//没有会话或者请求不带序号时总是转发
+func (self *GateServer) acceptRequest(userid int, buff []byte) bool {
+	s, ok := self.sessions[userid]
+	if !ok || !message.HasSeq(buff) {
+		return true
+	}
+	seq, _ := message.GetSeq(buff)
+	if seq <= s.request {
+		llog.Debugf("GateServer acceptRequest: userid=%d, duplicate request %d, forwarded %d", userid, seq, s.request)
+		return false
+	}
+	s.request = seq
+	return true
+}
@@ -0,0 +1,59 @@
+package gate
+
+import (
+	"testing"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/network"
+)
+
+//记录发送的消息包，socketId -> 消息包，0是广播
+type fakeSocket struct {
+	network.ISocket
+	sent map[int][][]byte
+}
+
+func (self *fakeSocket) SendById(socketId int, buff []byte) int {
+	self.sent[socketId] = append(self.sent[socketId], buff)
+	return len(buff)
+}
+
+func (self *fakeSocket) BroadCast(buff []byte) {
+	self.sent[0] = append(self.sent[0], buff)
+}
+
+func TestBroadcastClients(t *testing.T) {
+	oldType, oldTime, oldBuffer := config.NET_NODE_TYPE, config.NET_RESUME_TIME, config.NET_RESUME_BUFFER
+	defer func() {
+		config.NET_NODE_TYPE, config.NET_RESUME_TIME, config.NET_RESUME_BUFFER = oldType, oldTime, oldBuffer
+	}()
+	config.NET_NODE_TYPE = config.ServerType_Gate
+	config.NET_RESUME_TIME, config.NET_RESUME_BUFFER = 0, 8
+
+	sock := &fakeSocket{sent: make(map[int][][]byte)}
+	gate := &GateServer{pService: sock, tokens: make(map[int]*Token), tokens_u: make(map[int]int), sessions: make(map[int]*session)}
+	gate.broadcastClients([]byte{1})
+	if len(sock.sent[0]) != 1 {
+		t.Fatal("broadcast without resume not sent")
+	}
+
+	//在线的会话10，断线中的会话20，没有会话的客户端30
+	config.NET_RESUME_TIME = 1000
+	gate.sessions[10] = &session{socketId: 1}
+	gate.sessions[20] = &session{socketId: 0}
+	gate.tokens[1] = &Token{UserId: 10}
+	gate.tokens[3] = &Token{UserId: 30}
+	gate.tokens_u[10], gate.tokens_u[30] = 1, 3
+	gate.broadcastClients([]byte{2})
+	gate.sendToClient(10, []byte{3})
+
+	if len(sock.sent[0]) != 1 || len(sock.sent[1]) != 2 || len(sock.sent[3]) != 1 {
+		t.Fatalf("sent %v", sock.sent)
+	}
+	if s := gate.sessions[10]; s.seq != 2 || len(s.replay) != 2 || s.replay[0][0] != 2 {
+		t.Fatalf("online session seq %d, replay %v", s.seq, s.replay)
+	}
+	if s := gate.sessions[20]; s.seq != 1 || len(s.replay) != 1 {
+		t.Fatalf("suspended session seq %d, replay %v", s.seq, s.replay)
+	}
+}
@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e // indirect
 	github.com/golang/protobuf v1.4.3
+	github.com/golang/snappy v1.0.0
 	github.com/gomodule/redigo v1.8.4
 	github.com/google/go-cmp v0.5.5 // indirect
 	github.com/gorilla/websocket v0.0.0-20170926233335-4201258b820c
@@ -22,6 +23,7 @@ require (
 	go.etcd.io/etcd v0.0.0-20191023171146-3cf2f69b5738
 	go.uber.org/multierr v1.7.0 // indirect
 	go.uber.org/zap v1.16.0
+	golang.org/x/crypto v0.0.0-20201012173705-84dcc777aaee
 	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b // indirect
 	google.golang.org/grpc v1.33.2 // indirect
 	google.golang.org/protobuf v1.23.0
@@ -1,11 +1,14 @@
 // ChannelContext
 package gorpc
 
+import "context"
+
 //"fmt"
 
 // 声明一个函数类型
 type HanlderFunc func(igo IGoRoutine, data interface{}) interface{}
 type HanlderNetFunc func(igo IGoRoutine, clientid int, data interface{})
+type HanlderRpcFunc func(igo IGoRoutine, sourceId int, data interface{}) interface{}
 
 // 声明一个数据类型
 type M struct {
@@ -42,8 +45,12 @@ type MM struct {
 }
 
 type ChannelContext struct {
-	Handler  string              //处理函数名字
-	Data     M                   //传送携带数据(如果使用Data interface{}，Data会escapes to heap)
-	ReadChan chan ChannelContext //读取chan
-	Cb       HanlderFunc         //回调
+	Handler  string                           //处理函数名字
+	Data     M                                //传送携带数据(如果使用Data interface{}，Data会escapes to heap)
+	ReadChan chan ChannelContext              //读取chan
+	Cb       HanlderFunc                      //回调
+	Seq      int64                            //call的关联id，返回时原样带回
+	Ctx      context.Context                  //call的上下文，调用方取消或超时后不再执行
+	Err      error                            //call的返回错误
+	Reply    func(ret interface{}, err error) //远程actor调用的返回，不为nil时代替ReadChan
 }
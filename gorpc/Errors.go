@@ -0,0 +1,53 @@
+package gorpc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//call相关的错误
+var (
+	ErrCallTimeout       = errors.New("gorpc: call timeout")
+	ErrCallCanceled      = errors.New("gorpc: call canceled")
+	ErrTargetNil         = errors.New("gorpc: target is nil")
+	ErrTargetNotRunning  = errors.New("gorpc: target is not running")
+	ErrCallerNotRunning  = errors.New("gorpc: caller is not running")
+	ErrMailboxFull       = errors.New("gorpc: target mailbox is full")
+	ErrHandlerNotFound   = errors.New("gorpc: handler not found")
+	ErrHandlerPanic      = errors.New("gorpc: handler panic")
+	ErrRemoteUnreachable = errors.New("gorpc: remote node unreachable")
+)
+
+//handler panic的具体信息，errors.Is(err, ErrHandlerPanic)为true
+type PanicError struct {
+	Actor   string
+	Handler string
+	Value   interface{}
+	Stack   string
+}
+
+func (self *PanicError) Error() string {
+	return fmt.Sprintf("gorpc: handler panic [%s.%s]: %v", self.Actor, self.Handler, self.Value)
+}
+
+func (self *PanicError) Unwrap() error {
+	return ErrHandlerPanic
+}
+
+//把远程节点返回的错误信息还原成error，已知错误依然可以用errors.Is判断
+func RemoteError(str string) error {
+	if str == "" {
+		return nil
+	}
+	for _, err := range []error{ErrCallTimeout, ErrCallCanceled, ErrTargetNil, ErrTargetNotRunning, ErrCallerNotRunning,
+		ErrMailboxFull, ErrHandlerNotFound, ErrRemoteUnreachable} {
+		if str == err.Error() {
+			return err
+		}
+	}
+	if strings.HasPrefix(str, ErrHandlerPanic.Error()) {
+		return fmt.Errorf("%w%s", ErrHandlerPanic, strings.TrimPrefix(str, ErrHandlerPanic.Error()))
+	}
+	return errors.New(str)
+}
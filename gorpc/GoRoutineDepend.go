@@ -0,0 +1,198 @@
+package gorpc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/llog"
+)
+
+//设置服务依赖，deps中的服务会先于name启动，晚于name关闭
+//和AddRoutine一样，只能在服务启动前调用
+func (self *GoRoutineMgr) SetDepends(name string, deps ...string) {
+	for _, dep := range deps {
+		if dep == name {
+			llog.Fatalf("SetDepends fatal: %s depends on itself", name)
+			return
+		}
+	}
+	self.go_deps[name] = append(self.go_deps[name], deps...)
+}
+
+//按依赖关系排序，依赖的服务在前，没有依赖关系的按注册顺序
+//@set: 参与排序的服务
+func (self *GoRoutineMgr) sortRoutines(set map[string]IGoRoutine) ([]string, error) {
+	var names []string
+	for _, name := range self.go_order {
+		if _, ok := set[name]; ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		for _, dep := range self.go_deps[name] {
+			if self.go_name_Map[dep] == nil && self.go_name_Tmp[dep] == nil {
+				llog.Errorf("GoRoutineMgr: %s depends on unknown service %s", name, dep)
+			}
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	done := make(map[string]bool)
+	for len(sorted) < len(names) {
+		progress := false
+		for _, name := range names {
+			if done[name] {
+				continue
+			}
+			ready := true
+			for _, dep := range self.go_deps[name] {
+				if _, ok := set[dep]; ok && done[dep] == false {
+					ready = false
+					break
+				}
+			}
+			if ready { //每次从头查找，尽量保持注册顺序
+				done[name] = true
+				sorted = append(sorted, name)
+				progress = true
+				break
+			}
+		}
+		if progress == false {
+			return sorted, fmt.Errorf("dependency cycle: %s", self.findCycle(set, done))
+		}
+	}
+	return sorted, nil
+}
+
+//在未排序的服务中找出一个依赖环，格式"a -> b -> a"
+func (self *GoRoutineMgr) findCycle(set map[string]IGoRoutine, done map[string]bool) string {
+	var path []string
+	index := make(map[string]int)
+	for _, name := range self.go_order {
+		if _, ok := set[name]; ok && done[name] == false {
+			path = append(path, name)
+			break
+		}
+	}
+	for len(path) > 0 {
+		cur := path[len(path)-1]
+		index[cur] = len(path) - 1
+		next := ""
+		for _, dep := range self.go_deps[cur] {
+			if _, ok := set[dep]; ok && done[dep] == false {
+				next = dep
+				break
+			}
+		}
+		if next == "" { //不会发生，未排序的服务一定有未排序的依赖
+			break
+		}
+		if i, ok := index[next]; ok {
+			return strings.Join(append(path[i:], next), " -> ")
+		}
+		path = append(path, next)
+	}
+	return strings.Join(path, " -> ")
+}
+
+//执行服务的一个阶段，超时返回false
+//@timeout: 毫秒，<=0不限制
+func runStage(timeout int, f func()) bool {
+	if timeout <= 0 {
+		f()
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Duration(timeout) * time.Millisecond):
+		return false
+	}
+}
+
+//启动服务，超时直接退出
+func startRoutine(igo IGoRoutine) {
+	igo.Run()
+	if runStage(config.GAME_START_TIMEOUT, igo.DoStart) == false {
+		llog.Fatalf("GoRoutineMgr: %s DoStart timeout(%d ms)", igo.GetName(), config.GAME_START_TIMEOUT)
+	}
+}
+
+//销毁并关闭服务，等待其退出，超时继续关闭其他服务
+func closeRoutine(igo IGoRoutine) {
+	if runStage(config.GAME_STOP_TIMEOUT, igo.DoDestory) == false {
+		llog.Errorf("GoRoutineMgr: %s DoDestory timeout(%d ms)", igo.GetName(), config.GAME_STOP_TIMEOUT)
+	}
+	igo.Close()
+	timeout := time.Duration(config.GAME_STOP_TIMEOUT) * time.Millisecond
+	if config.GAME_STOP_TIMEOUT <= 0 {
+		timeout = time.Duration(1<<63 - 1)
+	}
+	if igo.waitStop(timeout) == false {
+		llog.Errorf("GoRoutineMgr: %s Close timeout(%d ms), left job %d", igo.GetName(), config.GAME_STOP_TIMEOUT, igo.LeftJobNumber())
+	}
+}
+
+//排空关闭服务，等待其退出，超时返回false
+func closeCleanly(igo IGoRoutine, deadline time.Time) bool {
+	if igo.IsRunning() {
+		if runStage(config.GAME_STOP_TIMEOUT, igo.DoDestory) == false {
+			llog.Errorf("GoRoutineMgr.CloseAllCleanly: %s DoDestory timeout(%d ms)", igo.GetName(), config.GAME_STOP_TIMEOUT)
+		}
+		igo.CloseCleanly()
+	}
+	if igo.waitStop(time.Until(deadline)) == false {
+		llog.Warningf("GoRoutineMgr.CloseAllCleanly: %s timeout, left job %d", igo.GetName(), igo.LeftJobNumber())
+		return false
+	}
+	return true
+}
+
+//把按关闭顺序排列的服务分层，同一层的服务之间没有依赖，可以同时关闭
+//一个服务在所有依赖它的服务的下一层
+//@names: closeOrder的结果或者其中的一部分
+func (self *GoRoutineMgr) closeLevels(names []string) [][]string {
+	level := make(map[string]int)
+	var levels [][]string
+	for _, name := range names { //依赖它的服务都在前面，层数已经确定
+		n := level[name]
+		if n == len(levels) {
+			levels = append(levels, nil)
+		}
+		levels[n] = append(levels[n], name)
+		for _, dep := range self.go_deps[name] {
+			if level[dep] < n+1 {
+				level[dep] = n + 1
+			}
+		}
+	}
+	return levels
+}
+
+//按依赖关系的逆序排列服务
+func (self *GoRoutineMgr) closeOrder() []string {
+	sorted, err := self.sortRoutines(self.go_name_Map)
+	if err != nil { //启动时已经检查过，这里只会是启动后又设置了依赖
+		llog.Errorf("GoRoutineMgr close: %s", err.Error())
+		has := make(map[string]bool)
+		for _, name := range sorted {
+			has[name] = true
+		}
+		for _, name := range self.go_order {
+			if self.go_name_Map[name] != nil && has[name] == false {
+				sorted = append(sorted, name)
+			}
+		}
+	}
+	for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	}
+	return sorted
+}
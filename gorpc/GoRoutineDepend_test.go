@@ -0,0 +1,96 @@
+package gorpc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDependOrder(t *testing.T) {
+	cases := []struct {
+		name   string
+		order  []string            //注册顺序
+		deps   map[string][]string //服务依赖
+		start  []string
+		close  []string
+		levels [][]string
+	}{
+		{
+			name:   "registration order",
+			order:  []string{"x", "y", "z"},
+			start:  []string{"x", "y", "z"},
+			close:  []string{"z", "y", "x"},
+			levels: [][]string{{"z", "y", "x"}},
+		},
+		{
+			name:   "chain",
+			order:  []string{"a", "b", "c"},
+			deps:   map[string][]string{"a": {"b"}, "b": {"c"}},
+			start:  []string{"c", "b", "a"},
+			close:  []string{"a", "b", "c"},
+			levels: [][]string{{"a"}, {"b"}, {"c"}},
+		},
+		{
+			name:   "diamond",
+			order:  []string{"gate", "a", "b", "db"},
+			deps:   map[string][]string{"gate": {"a", "b"}, "a": {"db"}, "b": {"db"}},
+			start:  []string{"db", "a", "b", "gate"},
+			close:  []string{"gate", "b", "a", "db"},
+			levels: [][]string{{"gate"}, {"b", "a"}, {"db"}},
+		},
+		{
+			name:   "missing dependency",
+			order:  []string{"a", "b"},
+			deps:   map[string][]string{"a": {"ghost"}, "b": {"a", "ghost"}},
+			start:  []string{"a", "b"},
+			close:  []string{"b", "a"},
+			levels: [][]string{{"b"}, {"a"}},
+		},
+	}
+	for _, c := range cases {
+		mgr := NewGoRoutineMgr()
+		for _, name := range c.order {
+			mgr.AddRoutine(&GoRoutineLogic{}, name)
+			mgr.SetDepends(name, c.deps[name]...)
+		}
+		start, err := mgr.sortRoutines(mgr.go_name_Map)
+		if err != nil || !reflect.DeepEqual(start, c.start) {
+			t.Fatalf("%s: start %v %v", c.name, start, err)
+		}
+		closeOrder := mgr.closeOrder()
+		if !reflect.DeepEqual(closeOrder, c.close) {
+			t.Fatalf("%s: close %v", c.name, closeOrder)
+		}
+		if levels := mgr.closeLevels(closeOrder); !reflect.DeepEqual(levels, c.levels) {
+			t.Fatalf("%s: levels %v", c.name, levels)
+		}
+	}
+}
+
+func TestDependCycle(t *testing.T) {
+	cases := []struct {
+		name  string
+		order []string
+		deps  map[string][]string
+		cycle string
+	}{
+		{"two", []string{"a", "b", "c"}, map[string][]string{"a": {"b"}, "b": {"a"}}, "a -> b -> a"},
+		{"three", []string{"d", "a", "b", "c"}, map[string][]string{"d": {"a"}, "a": {"b"}, "b": {"c"}, "c": {"a"}}, "a -> b -> c -> a"},
+		{"after sorted", []string{"db", "a", "b"}, map[string][]string{"a": {"db", "b"}, "b": {"a"}}, "a -> b -> a"},
+	}
+	for _, c := range cases {
+		mgr := NewGoRoutineMgr()
+		for _, name := range c.order {
+			mgr.AddRoutine(&GoRoutineLogic{}, name)
+			mgr.SetDepends(name, c.deps[name]...)
+		}
+		_, err := mgr.sortRoutines(mgr.go_name_Map)
+		if err == nil || !strings.HasSuffix(err.Error(), c.cycle) {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		//关闭时依然关闭所有服务
+		if closeOrder := mgr.closeOrder(); len(closeOrder) != len(c.order) {
+			t.Fatalf("%s: close %v", c.name, closeOrder)
+		}
+	}
+}
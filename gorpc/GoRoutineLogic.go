@@ -2,7 +2,10 @@
 package gorpc
 
 import (
+	"context"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -10,11 +13,13 @@ import (
 
 	"github.com/snowyyj001/loumiao/llog"
 	"github.com/snowyyj001/loumiao/util"
+	"github.com/snowyyj001/loumiao/util/queue"
 	"github.com/snowyyj001/loumiao/util/timer"
 )
 
 const (
-	ACTION_CLOSE = iota //0
+	ACTION_CLOSE         = iota //0
+	ACTION_CLOSE_CLEANLY        //工作队列清空后关闭
 )
 
 const (
@@ -22,6 +27,7 @@ const (
 	CHAN_BUFFER_MAX  = 50000 //channel缓冲最大数量
 	CALL_TIMEOUT     = 3     //call超时时间,秒
 	CHAN_LIMIT_TIMES = 3     //异步协程上限倍数
+	CTRL_CHAN_LEN    = 1024  //控制消息chan缓冲数量
 )
 
 type Cmdtype map[string]HanlderFunc
@@ -42,10 +48,16 @@ type IGoRoutine interface {
 	GetJobChan() chan ChannelContext
 	WriteSync(ct *ChannelContext)
 	ReadSync() interface{}
-	Send(handler_name string, sdata *M)
-	SendActor(handler_name string, sdata interface{})
-	SendBack(target IGoRoutine, handler_name string, sdata *M, Cb HanlderFunc)
+	Send(handler_name string, sdata *M) error
+	SendActor(handler_name string, sdata interface{}) error
+	SendBack(target IGoRoutine, handler_name string, sdata *M, Cb HanlderFunc) error
 	Call(target IGoRoutine, handler_name string, sdata *M) interface{}
+	CallContext(ctx context.Context, target IGoRoutine, handler_name string, sdata *M) (interface{}, error)
+	SendTo(addr ActorAddr, handler_name string, sdata interface{}) error
+	SendBackTo(addr ActorAddr, handler_name string, sdata interface{}, Cb HanlderFunc) error
+	CallTo(ctx context.Context, addr ActorAddr, handler_name string, sdata interface{}) (interface{}, error)
+	SendBackRemote(send func(seq int64) error, timeout int, done func(ret interface{}, err error)) error
+	RegisterPriority(name string, fun HanlderFunc)
 	RegisterGate(name string, call HanlderNetFunc)
 	UnRegisterGate(name string)
 	Register(name string, fun HanlderFunc)
@@ -57,12 +69,28 @@ type IGoRoutine interface {
 	IsInited() bool
 	SetInited(bool)
 	LeftJobNumber() int
+	Metrics() ActorMetrics
+	setCrashFunc(f CrashFunc, crashOnPanic bool)           //设置崩溃通知,由Supervisor调用
+	waitStop(timeout time.Duration) bool                   //等待woker退出
+	reset()                                                //重置运行状态，保留消息队列和处理函数，用于重启
+	pushJob(ct ChannelContext, done <-chan struct{}) error //按照邮箱策略投递任务
 }
 
+//崩溃通知
+//@handler: 崩溃的处理函数，空代表woker本身
+//@reason: panic的值
+//@stack: 调用栈
+//@fatal: actor是否已经退出
+type CrashFunc func(handler string, reason interface{}, stack string, fatal bool)
+
+var callSeq int64 //call关联id
+
 type RoutineTimer struct {
-	timer        *timer.Timer
+	handle       *timer.Handle
 	lastCallTime int64
 	timerCall    func(dt int64)
+	repeat       bool
+	pending      int32 //已经投递还没有执行，这期间的触发会被丢弃，不会堆积
 }
 
 type GoRoutineLogic struct {
@@ -73,18 +101,36 @@ type GoRoutineLogic struct {
 	actionChan   chan int                  //命令控制chan
 	chanNum      int                       //协程数量
 	NetHandler   map[string]HanlderNetFunc //net hanlder
+	interceptors []Interceptor             //actor的拦截器
 	goFun        bool                      //true:使用go执行Cmd,GoRoutineLogic非协程安全;false:协程安全
 	cRoLimitChan chan struct{}             //异步协程上限控制
 	/*timer        *time.Timer
 	timerCall    func(dt int64)
 	timerDuation time.Duration*/
 
-	timerChan  chan int //定时器chan
+	timerChan  chan int //定时器chan，到期的定时器id
 	timerFuncs map[int]*RoutineTimer
+	timerSeq   int
 
 	started  bool //是否已启动
 	inited   bool //是否初始化失败
 	ChanSize int  //job chan size
+
+	MailboxPolicy  int                 //job chan满了之后的处理策略MAILBOX_*
+	MailboxTimeout int                 //MAILBOX_BLOCK策略的等待时间，毫秒，0代表一直等待
+	ctrlChan       chan ChannelContext //优先处理的控制消息chan
+	priorityCmd    map[string]bool     //走ctrlChan的处理函数
+	priorityLock   sync.RWMutex        //priorityCmd在投递任务的协程中读取
+	overflow       *queue.Queue        //MAILBOX_OVERFLOW策略的溢出队列
+	overflowLock   sync.Mutex
+	overflowChan   chan struct{} //溢出队列有数据的通知
+	dropNum        int64         //被丢弃的任务数量
+
+	exitChan     chan struct{} //woker退出后关闭
+	crashFunc    CrashFunc     //崩溃通知
+	crashOnPanic bool          //处理函数panic时woker是否退出(仅同步actor)
+
+	metrics actorMetrics //运行统计
 }
 
 func (self *GoRoutineLogic) DoInit() bool {
@@ -129,25 +175,74 @@ func (self *GoRoutineLogic) SetInited(in bool) {
 }
 
 func (self *GoRoutineLogic) LeftJobNumber() int {
-	return len(self.jobChan)
+	return len(self.jobChan) + len(self.ctrlChan) + self.overflowLen()
 }
 
+//执行网络消息处理函数，经过拦截器链
 func (self *GoRoutineLogic) CallNetFunc(m *M) {
-	self.NetHandler[m.Name](self, m.Id, m.Data)
+	cb, ok := self.NetHandler[m.Name]
+	if !ok {
+		llog.Errorf("GoRoutineLogic[%s].CallNetFunc handler is nil: %s", self.Name, m.Name)
+		return
+	}
+	inv := &Invocation{Actor: self, Kind: INVOKE_NET, Handler: m.Name, ClientId: m.Id, Data: m.Data}
+	_, err := self.invoke(inv, func(inv *Invocation) (interface{}, error) {
+		cb(inv.Actor, inv.ClientId, inv.Data)
+		return nil, nil
+	})
+	if err != nil {
+		llog.Debugf("GoRoutineLogic[%s].CallNetFunc %s: %s", self.Name, m.Name, err.Error())
+	}
 }
 
 //同步定时任务，必须在DoStart中调用，不是协程安全的
 //@delat: 时间间隔，单位毫秒
 //@f: 回调函数，在GoRoutineLogic中调用，协程安全
-//@ticker: 使用ticker方式的timer
+//@ticker: 兼容旧接口，时间轮中的定时器都按固定节拍触发
 func (self *GoRoutineLogic) RunTimer(delat int, f func(int64), ticker bool) {
-	_, ok := self.timerFuncs[delat]
-	if ok {
-		llog.Errorf("GoRoutineLogic.RunTimer[%s]: timer duation[%d] already has one", self.Name, delat)
-		return
+	self.AddTimer(delat, f, true)
+}
+
+//添加定时器，同一个间隔可以有多个定时器，只能在DoStart或者自己的处理函数中调用
+//@delat: 时间间隔，单位毫秒
+//@f: 回调函数，在GoRoutineLogic中调用，协程安全，dt是距离上次触发的时间
+//@repeat: 是否循环触发
+//返回定时器id，用于CancelTimer
+func (self *GoRoutineLogic) AddTimer(delat int, f func(dt int64), repeat bool) int {
+	var sched timer.Schedule
+	if repeat {
+		sched = timer.Every(delat)
 	}
+	return self.addTimer(util.Now().Add(time.Duration(delat)*time.Millisecond), sched, f)
+}
+
+//按照时间表添加定时器，例如timer.Daily(5, 0, 0)，限制同AddTimer
+//时间表没有下一次时返回0
+func (self *GoRoutineLogic) AddSchedule(sched timer.Schedule, f func(dt int64)) int {
+	first := sched.Next(util.Now())
+	if first.IsZero() {
+		return 0
+	}
+	return self.addTimer(first, sched, f)
+}
+
+//取消定时器，限制同AddTimer
+func (self *GoRoutineLogic) CancelTimer(id int) bool {
+	caller, ok := self.timerFuncs[id]
+	if !ok {
+		return false
+	}
+	delete(self.timerFuncs, id)
+	return caller.handle.Cancel()
+}
+
+//定时器到期时把id投递到timerChan，由woker执行回调
+func (self *GoRoutineLogic) addTimer(first time.Time, sched timer.Schedule, f func(dt int64)) int {
+	self.timerSeq++
+	id := self.timerSeq
 	caller := new(RoutineTimer)
 	caller.lastCallTime = util.TimeStamp()
+	caller.repeat = sched != nil
 	caller.timerCall = func(dt int64) { //try catch errors here, do not effect woker
 		defer func() {
 			if r := recover(); r != nil {
@@ -158,71 +253,92 @@ func (self *GoRoutineLogic) RunTimer(delat int, f func(int64), ticker bool) {
 		}()
 		f(dt)
 	}
-	if ticker {
-		caller.timer = timer.NewTicker(delat, func(dt int64) bool {
-			self.timerChan <- delat
-			return true
-		})
-	} else {
-		caller.timer = timer.NewTimer(delat, func(dt int64) bool {
-			self.timerChan <- delat
-			return true
-		}, true)
-	}
-
-	self.timerFuncs[delat] = caller
+	timerChan, exitChan := self.timerChan, self.exitChan
+	caller.handle = timer.DefaultWheel().Add(first, sched, func() { //在时间轮协程中，不能阻塞
+		if !atomic.CompareAndSwapInt32(&caller.pending, 0, 1) {
+			return
+		}
+		select {
+		case timerChan <- id:
+		case <-exitChan: //woker已经退出
+		default: //timerChan满了，很少发生
+			go func() {
+				select {
+				case timerChan <- id:
+				case <-exitChan:
+				}
+			}()
+		}
+	})
+	self.timerFuncs[id] = caller
+	return id
 }
 
 //工作队列
 func (self *GoRoutineLogic) woker() {
 	defer func() {
-		if r := recover(); r != nil {
-			buf := make([]byte, 2048)
-			l := runtime.Stack(buf, false)
-			llog.Errorf("GoRoutineLogic.woker[%s] %v: %s", self.Name, r, buf[:l])
+		r := recover()
+		var handler, stack string
+		if r != nil {
+			if pe, ok := r.(*PanicError); ok { //处理函数panic导致的退出，已经打印过日志
+				handler, r, stack = pe.Handler, pe.Value, pe.Stack
+			} else {
+				buf := make([]byte, 2048)
+				l := runtime.Stack(buf, false)
+				stack = string(buf[:l])
+				llog.Errorf("GoRoutineLogic.woker[%s] %v: %s", self.Name, r, stack)
+			}
+			self.started = false
+			self.stopTimers()
+		}
+		close(self.exitChan)
+		if r != nil {
+			self.reportCrash(handler, r, stack, true)
 		}
 	}()
 	//utm := util.TimeStamp()
 	//utmPre := utm
 
+	draining := false //收到ACTION_CLOSE_CLEANLY，队列清空后退出
 	for {
 		//llog.Debugf("woker run: %s", self.Name)
+		if draining && self.LeftJobNumber() == 0 {
+			goto LabelEnd
+		}
+		//优先处理控制消息，避免被大量的普通消息饿死
+		select {
+		case action := <-self.actionChan:
+			if action == ACTION_CLOSE {
+				goto LabelEnd
+			}
+			draining = draining || action == ACTION_CLOSE_CLEANLY
+			continue
+		case index := <-self.timerChan:
+			self.onTimer(index)
+			continue
+		case ct := <-self.ctrlChan:
+			self.dispatch(ct)
+			continue
+		default:
+		}
+
 		select {
 		case ct := <-self.jobChan:
 			//llog.Debugf("jobchan single: %s %s", self.Name, ct.Handler)
-			if ct.Cb != nil && ct.ReadChan == nil { //callback, for remote actor return back, remote actor should set ReadChan = nil, look line:172
-				self.CallFunc(ct.Cb, &ct.Data)
-			} else { //
-				var hd = self.Cmd[ct.Handler]
-				if hd == nil {
-					llog.Errorf("GoRoutineLogic[%s] handler is nil: %s", self.Name, ct.Handler)
-				} else {
-					if self.goFun {
-						self.cRoLimitChan <- struct{}{}
-						go self.CallGoFunc(hd, &ct)
-					} else {
-						ret := self.CallFunc(hd, &ct.Data)
-						if ct.ReadChan != nil {
-							retctx := ChannelContext{Cb: ct.Cb}
-							retctx.Data.Flag = true
-							retctx.Data.Data = ret
-							ct.ReadChan <- retctx
-						}
-					}
-				}
-			}
+			self.dispatch(ct)
+			self.refillOverflow()
 			//llog.Debugf("jobchan single done: %s %s", self.Name, ct.Handler)
+		case ct := <-self.ctrlChan:
+			self.dispatch(ct)
+		case <-self.overflowChan:
+			self.refillOverflow()
 		case action := <-self.actionChan:
 			if action == ACTION_CLOSE {
 				goto LabelEnd
 			}
+			draining = draining || action == ACTION_CLOSE_CLEANLY
 		case index := <-self.timerChan:
-			caller, ok := self.timerFuncs[index]
-			if ok {
-				nt := util.TimeStamp()
-				caller.timerCall(nt - caller.lastCallTime)
-				caller.lastCallTime = nt
-			}
+			self.onTimer(index)
 		}
 	}
 
@@ -230,9 +346,60 @@ LabelEnd:
 	self.stop()
 }
 
+//处理一个任务
+func (self *GoRoutineLogic) dispatch(ct ChannelContext) {
+	self.metrics.markDepth(len(self.jobChan) + len(self.ctrlChan) + 1)
+	if ct.Cb != nil && ct.ReadChan == nil { //callback, for remote actor return back, remote actor should set ReadChan = nil, look replyCall
+		self.CallFunc(ct.Cb, &ct.Data)
+		return
+	}
+	if ct.Ctx != nil && ct.Ctx.Err() != nil { //调用方已经超时或取消，不再执行
+		llog.Debugf("GoRoutineLogic[%s] call has been abandoned: %s, seq=%d", self.Name, ct.Handler, ct.Seq)
+		return
+	}
+	var hd = self.Cmd[ct.Handler]
+	if hd == nil {
+		llog.Errorf("GoRoutineLogic[%s] handler is nil: %s", self.Name, ct.Handler)
+		self.replyCall(&ct, nil, ErrHandlerNotFound)
+		return
+	}
+	if self.goFun {
+		self.cRoLimitChan <- struct{}{}
+		go self.CallGoFunc(hd, &ct)
+		return
+	}
+	ret, err := self.callFunc(ct.Handler, hd, &ct.Data)
+	self.replyCall(&ct, ret, err)
+	if pe, ok := err.(*PanicError); ok {
+		if self.crashOnPanic {
+			panic(pe)
+		}
+		self.reportCrash(pe.Handler, pe.Value, pe.Stack, false)
+	}
+}
+
+func (self *GoRoutineLogic) onTimer(id int) {
+	caller, ok := self.timerFuncs[id]
+	if ok {
+		atomic.StoreInt32(&caller.pending, 0)
+		if !caller.repeat {
+			delete(self.timerFuncs, id)
+		}
+		lag := util.Now().Sub(caller.handle.Fired())
+		if lag < 0 {
+			lag = 0
+		}
+		self.metrics.timerLag.record(lag, false)
+		nt := util.TimeStamp()
+		caller.timerCall(nt - caller.lastCallTime)
+		caller.lastCallTime = nt
+	}
+}
+
 //处理任务
 func (self *GoRoutineLogic) Run() {
 	self.started = true
+	self.exitChan = make(chan struct{})
 	go self.woker()
 }
 
@@ -243,15 +410,60 @@ func (self *GoRoutineLogic) stop() {
 		self.timer.Stop()
 		self.timer = nil
 	}*/
-	for _, caller := range self.timerFuncs {
-		caller.timer.Stop()
-	}
+	self.stopTimers()
 	//当一个通道不再被任何协程所使用后，它将逐渐被垃圾回收掉，无论它是否已经被关闭
 	//这里不关闭jobChan和readChan，让gc处理他们
 	close(self.actionChan)
 
 }
 
+func (self *GoRoutineLogic) stopTimers() {
+	for _, caller := range self.timerFuncs {
+		caller.handle.Cancel()
+	}
+}
+
+//等待woker退出
+func (self *GoRoutineLogic) waitStop(timeout time.Duration) bool {
+	if self.exitChan == nil { //从未运行
+		return true
+	}
+	select {
+	case <-self.exitChan:
+		return true
+	default:
+	}
+	if timeout <= 0 {
+		return false
+	}
+	select {
+	case <-self.exitChan:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+//重置运行状态，保留jobChan和已注册的处理函数，重启后发送方持有的引用依然有效
+func (self *GoRoutineLogic) reset() {
+	self.started = false
+	self.inited = false
+	self.actionChan = make(chan int, 1)
+	self.timerChan = make(chan int, CTRL_CHAN_LEN)
+	self.timerFuncs = make(map[int]*RoutineTimer)
+}
+
+func (self *GoRoutineLogic) setCrashFunc(f CrashFunc, crashOnPanic bool) {
+	self.crashFunc = f
+	self.crashOnPanic = crashOnPanic
+}
+
+func (self *GoRoutineLogic) reportCrash(handler string, reason interface{}, stack string, fatal bool) {
+	if self.crashFunc != nil {
+		self.crashFunc(handler, reason, stack, fatal)
+	}
+}
+
 //关闭任务
 func (self *GoRoutineLogic) Close() {
 	self.started = false //先标记关闭
@@ -259,121 +471,185 @@ func (self *GoRoutineLogic) Close() {
 	llog.Debugf("GoRoutineLogic.Close: %s", self.Name)
 }
 
-//延迟关闭任务，等待工作队列清空
+//延迟关闭任务，woker处理完工作队列中的任务后立即退出
 func (self *GoRoutineLogic) CloseCleanly() {
 	self.started = false //先标记关闭
-	timer.NewTicker(1000, func(dt int64) bool {
-		if self.LeftJobNumber() == 0 {
-			timer.DelayJob(1000, func() {
-				self.actionChan <- ACTION_CLOSE
-			}, true)
-			return false
-		}
-		//llog.Debugf("CloseCleanly: %d", self.LeftJobNumber())
-		return true
-	})
+	select {
+	case self.actionChan <- ACTION_CLOSE_CLEANLY:
+	default: //已经有关闭命令在等待处理
+	}
 }
 
 //投递任务，给自己
-func (self *GoRoutineLogic) Send(handler_name string, sdata *M) {
+func (self *GoRoutineLogic) Send(handler_name string, sdata *M) error {
 	if self.started == false {
 		llog.Warningf("GoRoutineLogic.Send has not started: %s, %s, %v", self.Name, handler_name, sdata)
-		return
-	}
-	if len(self.GetJobChan()) > self.GetChanLen()*2 {
-		llog.Infof("GoRoutineLogic[%s].Send too many job chan: %s", self.Name, handler_name)
-		return
+		return ErrTargetNotRunning
 	}
 	job := ChannelContext{Handler: handler_name}
 	if sdata != nil {
 		job.Data = *sdata
 	}
-	self.GetJobChan() <- job
+	err := self.pushJob(job, nil)
+	if err != nil {
+		llog.Infof("GoRoutineLogic[%s].Send %s: %s", self.Name, handler_name, err.Error())
+	}
+	return err
 }
 
 //投递任务，给自己
-func (self *GoRoutineLogic) SendActor(handler_name string, sdata interface{}) {
+func (self *GoRoutineLogic) SendActor(handler_name string, sdata interface{}) error {
 	if self.started == false {
 		llog.Warningf("GoRoutineLogic.SendActor has not started: %s, %s, %v", self.Name, handler_name, sdata)
-		return
-	}
-	if len(self.GetJobChan()) > self.GetChanLen()*2 {
-		llog.Infof("GoRoutineLogic[%s].SendActor too many job chan: %s", self.Name, handler_name)
-		return
+		return ErrTargetNotRunning
 	}
 	m := M{Data: sdata, Flag: true}
-	job := ChannelContext{handler_name, m, nil, nil}
-	self.GetJobChan() <- job
+	job := ChannelContext{Handler: handler_name, Data: m}
+	err := self.pushJob(job, nil)
+	if err != nil {
+		llog.Infof("GoRoutineLogic[%s].SendActor %s: %s", self.Name, handler_name, err.Error())
+	}
+	return err
 }
 
 //投递任务,拥有回调
-func (self *GoRoutineLogic) SendBack(server IGoRoutine, handler_name string, sdata *M, Cb HanlderFunc) {
+//目标邮箱满了任务被丢弃时(MAILBOX_DROP_*)回调不会执行
+func (self *GoRoutineLogic) SendBack(server IGoRoutine, handler_name string, sdata *M, Cb HanlderFunc) error {
 	if self.started == false {
 		llog.Warningf("GoRoutineLogic.SendBack has not started: %s, %s, %v", self.Name, handler_name, sdata)
-		return
+		return ErrCallerNotRunning
 	}
 	if server == nil {
-		llog.Infof("GoRoutineLogic[%s].SendBack target[%s] is nil: %s", self.Name, server.GetName(), handler_name)
-		return
+		llog.Infof("GoRoutineLogic[%s].SendBack target is nil: %s", self.Name, handler_name)
+		return ErrTargetNil
 	}
-	if len(server.GetJobChan()) > server.GetChanLen()*2 {
-		llog.Infof("GoRoutineLogic[%s].SendBack[%s] too many job chan: %s", self.Name, server.GetName(), handler_name)
-		return
+	job := ChannelContext{Handler: handler_name, Data: *sdata, ReadChan: self.jobChan, Cb: Cb}
+	err := server.pushJob(job, nil)
+	if err != nil {
+		llog.Infof("GoRoutineLogic[%s].SendBack[%s] %s: %s", self.Name, server.GetName(), handler_name, err.Error())
 	}
-	job := ChannelContext{handler_name, *sdata, self.jobChan, Cb}
-	server.GetJobChan() <- job
+	return err
 }
 
 //阻塞读取数据式投递任务，一直等待（超过三秒属于异常）
+//出错时返回nil，需要区分错误的请使用CallContext
 func (self *GoRoutineLogic) Call(server IGoRoutine, handler_name string, sdata *M) interface{} {
+	ctx, cancel := context.WithTimeout(context.Background(), CALL_TIMEOUT*time.Second)
+	defer cancel()
+	ret, err := self.CallContext(ctx, server, handler_name, sdata)
+	if err != nil {
+		llog.Infof("GoRoutineLogic[%s] Call %s error: %s", self.Name, handler_name, err.Error())
+	}
+	return ret
+}
+
+//阻塞式调用，由ctx控制超时和取消
+//每次调用使用独立的返回chan，异步协程(goFun)中可以并发调用
+//@ctx: 调用上下文，nil等同于context.Background()
+//@server: 目标actor
+//@handler_name: 目标actor的处理函数
+//@sdata: 函数参数
+func (self *GoRoutineLogic) CallContext(ctx context.Context, server IGoRoutine, handler_name string, sdata *M) (interface{}, error) {
 	if self.started == false {
-		llog.Warningf("GoRoutineLogic.Call has not started: %s, %s, %v", self.Name, handler_name, sdata)
-		return nil
+		llog.Warningf("GoRoutineLogic.CallContext has not started: %s, %s, %v", self.Name, handler_name, sdata)
+		return nil, ErrCallerNotRunning
 	}
+	return callContext(ctx, server, handler_name, sdata)
+}
+
+func callContext(ctx context.Context, server IGoRoutine, handler_name string, sdata *M) (interface{}, error) {
 	if server == nil {
-		llog.Infof("GoRoutineLogic[%s].Call target[%s] is nil: %s", self.Name, server.GetName(), handler_name)
-		return nil
+		return nil, ErrTargetNil
 	}
-	if len(server.GetJobChan()) > server.GetChanLen()*2 {
-		llog.Infof("GoRoutineLogic[%s].Call target[%s] too many jon chan: %s", self.Name, server.GetName(), handler_name)
-		return nil
+	if server.IsRunning() == false {
+		return nil, ErrTargetNotRunning
 	}
-	job := ChannelContext{Handler: handler_name, ReadChan: self.readChan}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	readChan := make(chan ChannelContext, 1) //带缓冲，调用方放弃后，目标actor的返回也不会阻塞
+	job := ChannelContext{Handler: handler_name, ReadChan: readChan, Seq: atomic.AddInt64(&callSeq, 1), Ctx: ctx}
 	if sdata != nil {
 		job.Data = *sdata
 	}
+	if err := server.pushJob(job, ctx.Done()); err != nil { //阻塞策略下，队列已满会等待到ctx结束
+		return nil, err
+	}
 	select {
-	case server.GetJobChan() <- job:
-		rdata := <-self.readChan
-		return rdata.Data.Data
-	case <-time.After(CALL_TIMEOUT * time.Second):
-		llog.Infof("GoRoutineLogic[%s] Call timeout: %s", self.Name, handler_name)
-		return nil
+	case rdata := <-readChan:
+		return rdata.Data.Data, rdata.Err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrCallTimeout
+		}
+		return nil, ErrCallCanceled
 	}
 }
 
 func (self *GoRoutineLogic) CallFunc(cb HanlderFunc, data *M) interface{} {
+	ret, _ := self.callFunc("", cb, data)
+	return ret
+}
+
+//执行处理函数，panic会被转换成PanicError返回
+func (self *GoRoutineLogic) callFunc(handler_name string, cb HanlderFunc, data *M) (ret interface{}, err error) {
+	begin := time.Now()
+	atomic.AddInt64(&self.metrics.running, 1)
+	defer atomic.AddInt64(&self.metrics.running, -1)
 	defer func() {
-		if r := recover(); r != nil {
+		r := recover()
+		if r != nil {
 			buf := make([]byte, 2048)
 			l := runtime.Stack(buf, false)
 			llog.Errorf("GoRoutineLogic.CallFunc[%s] %v: %s", self.Name, r, buf[:l])
+			err = &PanicError{Actor: self.Name, Handler: handler_name, Value: r, Stack: string(buf[:l])}
+		}
+		name := handler_name
+		if name == "" {
+			name = METRICS_CALLBACK
+		} else if name == "ServiceHandler" && data.Flag == false && data.Name != "" {
+			name = data.Name //网络消息按消息名统计
 		}
+		self.metrics.handler(name).record(time.Since(begin), r != nil)
 	}()
+	var arg interface{} = data
 	if data.Flag {
-		return cb(self, data.Data)
-	} else {
-		return cb(self, data)
+		arg = data.Data
+	}
+	if handler_name == "" || handler_name == "ServiceHandler" { //回调不拦截，网络消息在CallNetFunc中拦截
+		ret = cb(self, arg)
+		return
 	}
+	inv := &Invocation{Actor: self, Kind: INVOKE_CMD, Handler: handler_name, Data: arg}
+	if data.Flag == false {
+		inv.ClientId = data.Id
+	}
+	ret, err = self.invoke(inv, func(inv *Invocation) (interface{}, error) {
+		return cb(inv.Actor, inv.Data), nil
+	})
+	return
+}
+
+//把call的结果返回给调用方
+func (self *GoRoutineLogic) replyCall(ct *ChannelContext, ret interface{}, err error) {
+	if ct.Reply != nil { //远程调用
+		ct.Reply(ret, err)
+		return
+	}
+	if ct.ReadChan == nil {
+		return
+	}
+	retctx := ChannelContext{Cb: ct.Cb, Seq: ct.Seq, Err: err}
+	retctx.Data.Flag = true
+	retctx.Data.Data = ret
+	ct.ReadChan <- retctx
 }
 
 func (self *GoRoutineLogic) CallGoFunc(hd HanlderFunc, ct *ChannelContext) {
-	ret := self.CallFunc(hd, &ct.Data)
-	if ct.ReadChan != nil {
-		retctx := ChannelContext{Cb: ct.Cb}
-		retctx.Data.Flag = true
-		retctx.Data.Data = ret
-		ct.ReadChan <- retctx
+	ret, err := self.callFunc(ct.Handler, hd, &ct.Data)
+	self.replyCall(ct, ret, err)
+	if pe, ok := err.(*PanicError); ok {
+		self.reportCrash(pe.Handler, pe.Value, pe.Stack, false)
 	}
 	<-self.cRoLimitChan
 }
@@ -397,8 +673,21 @@ func (self *GoRoutineLogic) Register(name string, fun HanlderFunc) {
 	self.Cmd[name] = fun
 }
 
+//注册优先处理的函数，投递给它的任务走控制通道，不会被普通任务阻塞，也不受邮箱策略影响
+//控制通道满了(CTRL_CHAN_LEN)时投递返回ErrMailboxFull
+//只能在actor启动之前调用
+func (self *GoRoutineLogic) RegisterPriority(name string, fun HanlderFunc) {
+	self.Cmd[name] = fun
+	self.priorityLock.Lock()
+	self.priorityCmd[name] = true
+	self.priorityLock.Unlock()
+}
+
 func (self *GoRoutineLogic) UnRegister(name string) {
 	delete(self.Cmd, name)
+	self.priorityLock.Lock()
+	delete(self.priorityCmd, name)
+	self.priorityLock.Unlock()
 }
 
 func (self *GoRoutineLogic) RegisterGate(name string, call HanlderNetFunc) {
@@ -417,6 +706,12 @@ func (self *GoRoutineLogic) init(name string) {
 	}
 	self.ChanSize = n
 	self.jobChan = make(chan ChannelContext, n)
+	self.ctrlChan = make(chan ChannelContext, CTRL_CHAN_LEN)
+	self.overflowChan = make(chan struct{}, 1)
+	if self.MailboxPolicy == MAILBOX_OVERFLOW {
+		self.overflow = queue.New()
+	}
+	self.priorityCmd = make(map[string]bool)
 	self.readChan = make(chan ChannelContext)
 	self.actionChan = make(chan int, 1)
 	self.cRoLimitChan = make(chan struct{}, n*CHAN_LIMIT_TIMES)
@@ -425,7 +720,7 @@ func (self *GoRoutineLogic) init(name string) {
 	//self.timer = time.NewTimer(1<<63 - 1) //默认没有定时器
 	//self.timerCall = nil
 
-	self.timerChan = make(chan int)
+	self.timerChan = make(chan int, CTRL_CHAN_LEN)
 	self.timerFuncs = make(map[int]*RoutineTimer)
 }
 
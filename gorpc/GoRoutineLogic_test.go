@@ -0,0 +1,106 @@
+package gorpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+//启动的测试actor，注册了echo，sleep，panic
+func newCallTarget(goFun bool) *GoRoutineLogic {
+	self := newTestLogic(MAILBOX_BLOCK, 16)
+	self.SetSync(goFun)
+	self.Register("echo", func(igo IGoRoutine, data interface{}) interface{} {
+		if n, ok := data.(int); ok {
+			time.Sleep(time.Duration(10-n%10) * time.Millisecond) //后调用的先返回
+		}
+		return data
+	})
+	self.Register("sleep", func(igo IGoRoutine, data interface{}) interface{} {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+	self.Register("panic", func(igo IGoRoutine, data interface{}) interface{} { panic("call panic") })
+	self.Run()
+	return self
+}
+
+func TestCallContextErrors(t *testing.T) {
+	caller, target := newCallTarget(false), newCallTarget(false)
+	defer caller.Close()
+
+	ret, err := caller.CallContext(nil, target, "echo", &M{Data: "hello", Flag: true})
+	if err != nil || ret != "hello" {
+		t.Fatalf("echo %v %v", ret, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err = caller.CallContext(ctx, target, "sleep", &M{}); err != ErrCallTimeout {
+		t.Fatalf("deadline: %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err = caller.CallContext(ctx, target, "sleep", &M{}); err != ErrCallCanceled {
+		t.Fatalf("cancel: %v", err)
+	}
+
+	//panic作为错误返回，actor继续运行
+	_, err = caller.CallContext(nil, target, "panic", &M{})
+	var pe *PanicError
+	if !errors.Is(err, ErrHandlerPanic) || !errors.As(err, &pe) || pe.Actor != "test" || pe.Handler != "panic" || pe.Value != "call panic" {
+		t.Fatalf("panic: %v", err)
+	}
+	if ret, err = caller.CallContext(nil, target, "echo", &M{Data: 1, Flag: true}); err != nil || ret != 1 {
+		t.Fatalf("echo after panic %v %v", ret, err)
+	}
+
+	if _, err = caller.CallContext(nil, target, "missing", &M{}); err != ErrHandlerNotFound {
+		t.Fatalf("missing handler: %v", err)
+	}
+	if _, err = caller.CallContext(nil, nil, "echo", &M{}); err != ErrTargetNil {
+		t.Fatalf("nil target: %v", err)
+	}
+	target.Close()
+	target.waitStop(time.Second)
+	if _, err = caller.CallContext(nil, target, "echo", &M{}); err != ErrTargetNotRunning {
+		t.Fatalf("stopped target: %v", err)
+	}
+	if _, err = newTestLogic(MAILBOX_BLOCK, 1).CallContext(nil, caller, "echo", &M{}); err != ErrCallerNotRunning {
+		t.Fatalf("stopped caller: %v", err)
+	}
+}
+
+//异步actor的多个协程同时调用，每个调用收到自己的返回
+func TestCallContextConcurrent(t *testing.T) {
+	caller, target := newCallTarget(true), newCallTarget(true)
+	defer caller.Close()
+	defer target.Close()
+	type result struct {
+		want, got interface{}
+		err       error
+	}
+	results := make(chan result, 20)
+	caller.Register("ask", func(igo IGoRoutine, data interface{}) interface{} {
+		ret, err := igo.(*GoRoutineLogic).CallContext(nil, target, "echo", &M{Data: data, Flag: true})
+		results <- result{data, ret, err}
+		return nil
+	})
+	for i := 0; i < 20; i++ {
+		if err := caller.SendActor("ask", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		select {
+		case r := <-results:
+			if r.err != nil || r.got != r.want {
+				t.Fatalf("call %v got %v: %v", r.want, r.got, r.err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d calls returned", i)
+		}
+	}
+}
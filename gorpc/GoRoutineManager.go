@@ -1,14 +1,24 @@
 package gorpc
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
 	"github.com/snowyyj001/loumiao/llog"
 )
 
 //服务启动后，不允许再开新的service
 //这样就不用考虑go_name_Map的全局调用问题了，保证线程安全
 type GoRoutineMgr struct {
-	go_name_Map map[string]IGoRoutine //持久化actor
-	go_name_Tmp map[string]IGoRoutine //临时actor
+	go_name_Map map[string]IGoRoutine     //持久化actor
+	go_name_Tmp map[string]IGoRoutine     //临时actor
+	go_pool_Map map[string]*GoRoutinePool //可以按地址访问的协程池
+	go_deps     map[string][]string       //服务依赖，依赖的服务先启动后关闭
+	go_order    []string                  //注册顺序，没有依赖关系的服务按注册顺序启动
+	supervisor  *Supervisor               //监督Start创建的服务，nil代表不监督
 	is_starting bool
 	has_started bool
 }
@@ -18,9 +28,17 @@ var (
 )
 
 func init() {
-	MGR = &GoRoutineMgr{}
-	MGR.go_name_Map = make(map[string]IGoRoutine)
-	MGR.go_name_Tmp = make(map[string]IGoRoutine)
+	MGR = NewGoRoutineMgr()
+}
+
+//创建服务管理器，一般使用全局的MGR，测试时可以替换MGR
+func NewGoRoutineMgr() *GoRoutineMgr {
+	mgr := &GoRoutineMgr{}
+	mgr.go_name_Map = make(map[string]IGoRoutine)
+	mgr.go_name_Tmp = make(map[string]IGoRoutine)
+	mgr.go_pool_Map = make(map[string]*GoRoutinePool)
+	mgr.go_deps = make(map[string][]string)
+	return mgr
 }
 
 func (self *GoRoutineMgr) AddRoutine(rou IGoRoutine, name string) {
@@ -34,6 +52,7 @@ func (self *GoRoutineMgr) AddRoutine(rou IGoRoutine, name string) {
 	} else {
 		self.go_name_Map[name] = rou
 	}
+	self.go_order = append(self.go_order, name)
 }
 
 //只会获得永久存在的actor
@@ -45,27 +64,102 @@ func (self *GoRoutineMgr) GetRoutine(name string) IGoRoutine {
 	return nil
 }
 
+//注册协程池，注册后池中的actor可以通过"nodeUid/poolName/actorId"访问
+//和AddRoutine一样，只能在服务启动前调用
+func (self *GoRoutineMgr) RegisterPool(name string, pool *GoRoutinePool) {
+	if self.go_pool_Map[name] != nil {
+		llog.Fatalf("RegisterPool fatal: %s has already been registered", name)
+		return
+	}
+	self.go_pool_Map[name] = pool
+}
+
+func (self *GoRoutineMgr) GetPool(name string) *GoRoutinePool {
+	return self.go_pool_Map[name]
+}
+
+//设置监督者，之后Start创建的服务崩溃后由sup按照重启策略重启，需要在Start之前调用
+func (self *GoRoutineMgr) SetSupervisor(sup *Supervisor) {
+	self.supervisor = sup
+}
+
+//关闭所有服务前停止监督者，关闭过程中崩溃不再重启
+func (self *GoRoutineMgr) stopSupervisor() {
+	if self.supervisor != nil {
+		self.supervisor.Stop()
+	}
+}
+
+//主动关闭的服务解除监督，关闭过程中崩溃不再重启
+func (self *GoRoutineMgr) unsupervise(name string) {
+	if self.supervisor != nil {
+		self.supervisor.Unsupervise(name)
+	}
+}
+
 //关闭单个服务
 func (self *GoRoutineMgr) Close(name string) {
 	igo := self.GetRoutine(name)
 	if igo != nil {
+		self.unsupervise(name)
 		igo.DoDestory()
 		igo.Close()
 	}
 }
 
 //关闭所有服务
+//按依赖关系的逆序关闭，依赖其他服务的先关闭
 func (self *GoRoutineMgr) CloseAll() {
-	for _, igo := range self.go_name_Map {
-		igo.DoDestory()
+	self.stopSupervisor()
+	for _, name := range self.closeOrder() {
+		self.unsupervise(name)
+		closeRoutine(self.go_name_Map[name])
 	}
-	for _, igo := range self.go_name_Map {
-		igo.Close()
+}
+
+//排空关闭所有服务，等待工作队列清空后再关闭
+//按依赖关系的逆序分层关闭，同一层的服务同时排空，一层完全退出后才关闭下一层
+//@timeout: 最长等待时间，毫秒，超时后不再等待
+//@last: 最后关闭的服务，例如GateServer，保证其他服务排空时的消息还能发送出去
+//返回是否所有服务都在超时前关闭
+func (self *GoRoutineMgr) CloseAllCleanly(timeout int, last ...string) bool {
+	deadline := time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	self.stopSupervisor()
+	isLast := make(map[string]bool)
+	for _, name := range last {
+		isLast[name] = true
+	}
+	var first, second []string
+	for _, name := range self.closeOrder() {
+		self.unsupervise(name)
+		if isLast[name] {
+			second = append(second, name)
+		} else {
+			first = append(first, name)
+		}
+	}
+	done := true
+	for _, level := range append(self.closeLevels(first), self.closeLevels(second)...) {
+		var wg sync.WaitGroup
+		var timeouts int32
+		for _, name := range level {
+			wg.Add(1)
+			go func(igo IGoRoutine) {
+				defer wg.Done()
+				if closeCleanly(igo, deadline) == false {
+					atomic.AddInt32(&timeouts, 1)
+				}
+			}(self.go_name_Map[name])
+		}
+		wg.Wait()
+		done = done && timeouts == 0
 	}
+	return done
 }
 
 //创建初始化服务
-func (self *GoRoutineMgr) Start(igo IGoRoutine, name string) {
+//@deps: 依赖的服务，参考SetDepends
+func (self *GoRoutineMgr) Start(igo IGoRoutine, name string, deps ...string) {
 
 	//GoRoutineLogic
 	igo.init(name)
@@ -80,23 +174,37 @@ func (self *GoRoutineMgr) Start(igo IGoRoutine, name string) {
 	igo.DoRegsiter()
 
 	self.AddRoutine(igo, name)
+	self.SetDepends(name, deps...)
+	if self.supervisor != nil {
+		self.supervisor.Supervise(igo, name)
+	}
 }
 
 //开启服务
-//开启所有服务
+//按依赖关系开启所有服务，有依赖环时直接退出
 func (self *GoRoutineMgr) DoStart() {
 	self.is_starting = true
-	for _, igo := range self.go_name_Map {
+	sorted, err := self.sortRoutines(self.go_name_Map)
+	if err != nil {
+		llog.Fatalf("GoRoutineMgr.DoStart: %s", err.Error())
+		return
+	}
+	for _, name := range sorted {
+		igo := self.go_name_Map[name]
 		if igo.IsRunning() == false && igo.IsInited() == true {
-			igo.Run()
-			igo.DoStart()
+			startRoutine(igo)
 		}
-
 	}
-	for name, igo := range self.go_name_Tmp {
+	//启动过程中创建的服务
+	sorted, err = self.sortRoutines(self.go_name_Tmp)
+	if err != nil {
+		llog.Fatalf("GoRoutineMgr.DoStart: %s", err.Error())
+		return
+	}
+	for _, name := range sorted {
+		igo := self.go_name_Tmp[name]
 		if igo.IsRunning() == false && igo.IsInited() == true {
-			igo.Run()
-			igo.DoStart()
+			startRoutine(igo)
 		}
 		self.go_name_Map[name] = igo
 	}
@@ -112,13 +220,17 @@ func (self *GoRoutineMgr) DoStart() {
 }
 
 //开启服务
-//启动单个服务
+//启动单个服务，依赖的服务应该已经启动
 func (self *GoRoutineMgr) DoSingleStart(name string) {
 	igo, has := self.go_name_Map[name]
 	if has {
+		for _, dep := range self.go_deps[name] {
+			if rou := self.GetRoutine(dep); rou == nil || rou.IsRunning() == false {
+				llog.Warningf("GoRoutineMgr.DoSingleStart: %s depends on %s, which has not started", name, dep)
+			}
+		}
 		if igo.IsRunning() == false && igo.IsInited() == true {
-			igo.Run()
-			igo.DoStart()
+			startRoutine(igo)
 		}
 		if igo.IsRunning() == false || igo.IsInited() == false {
 			delete(self.go_name_Map, name)
@@ -126,20 +238,44 @@ func (self *GoRoutineMgr) DoSingleStart(name string) {
 	}
 }
 
+//开始服务
+//按依赖关系调用所有服务的DoOpen，例如GateServer开始监听
+func (self *GoRoutineMgr) DoOpen() {
+	sorted, _ := self.sortRoutines(self.go_name_Map) //DoStart已经检查过依赖环
+	for _, name := range sorted {
+		igo := self.go_name_Map[name]
+		if igo.IsRunning() == false {
+			continue
+		}
+		if runStage(config.GAME_START_TIMEOUT, igo.DoOpen) == false {
+			llog.Fatalf("GoRoutineMgr.DoOpen: %s timeout(%d ms)", name, config.GAME_START_TIMEOUT)
+		}
+	}
+}
+
 //内部rpc调用
 //@target: 目标actor
 //@funcName: rpc函数
 //@data: 函数参数
-func (self *GoRoutineMgr) Send(target string, funcName string, data *M) {
+func (self *GoRoutineMgr) Send(target string, funcName string, data *M) error {
 	igo := self.GetRoutine(target)
 	if igo == nil {
 		llog.Errorf("GoRoutineMgr.Send target[%s] is nil: %s", target, funcName)
-		return
+		return ErrTargetNil
 	}
-	if len(igo.GetJobChan()) > igo.GetChanLen()*2 {
-		llog.Errorf("GoRoutineMgr.Send:[%s (chan overlow[%d, %d])] %s", target, igo.GetJobChan(), igo.GetChanLen(), funcName)
-		return
+	job := ChannelContext{Handler: funcName, Data: *data}
+	err := igo.pushJob(job, nil)
+	if err != nil {
+		llog.Errorf("GoRoutineMgr.Send:[%s (chan len[%d, %d])] %s: %s", target, igo.LeftJobNumber(), igo.GetChanLen(), funcName, err.Error())
 	}
-	job := ChannelContext{funcName, *data, nil, nil}
-	igo.GetJobChan() <- job
+	return err
+}
+
+//内部rpc阻塞调用，调用方不是actor时使用
+//@ctx: 调用上下文，控制超时和取消
+//@target: 目标actor
+//@funcName: rpc函数
+//@data: 函数参数
+func (self *GoRoutineMgr) CallContext(ctx context.Context, target string, funcName string, data *M) (interface{}, error) {
+	return callContext(ctx, self.GetRoutine(target), funcName, data)
 }
@@ -0,0 +1,112 @@
+package gorpc
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//每个work任务耗时10ms，DoDestory时调用destroy
+type drainActor struct {
+	GoRoutineLogic
+	jobs    int32
+	destroy func()
+}
+
+func (self *drainActor) DoRegsiter() {
+	self.Register("work", func(IGoRoutine, interface{}) interface{} {
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&self.jobs, 1)
+		return nil
+	})
+}
+
+func (self *drainActor) DoDestory() {
+	if self.destroy != nil {
+		self.destroy()
+	}
+}
+
+func TestCloseAllCleanly(t *testing.T) {
+	mgr := NewGoRoutineMgr()
+	actors := make(map[string]*drainActor)
+	for _, name := range []string{"gate", "db", "a", "b"} {
+		actors[name] = &drainActor{}
+	}
+	mgr.Start(actors["gate"], "gate")
+	mgr.Start(actors["db"], "db")
+	mgr.Start(actors["a"], "a", "db")
+	mgr.Start(actors["b"], "b", "db")
+	mgr.DoStart()
+
+	//a和b在同一层，同时DoDestory，互相等待对方
+	aIn, bIn := make(chan struct{}), make(chan struct{})
+	var parallel int32
+	rendezvous := func(in chan struct{}, other chan struct{}) func() {
+		return func() {
+			close(in)
+			select {
+			case <-other:
+				atomic.AddInt32(&parallel, 1)
+			case <-time.After(time.Second):
+			}
+		}
+	}
+	actors["a"].destroy = rendezvous(aIn, bIn)
+	actors["b"].destroy = rendezvous(bIn, aIn)
+	//依赖的服务在依赖它的服务退出后才关闭
+	var dbAfter, gateAfter bool
+	actors["db"].destroy = func() {
+		dbAfter = actors["a"].waitStop(0) && actors["b"].waitStop(0) &&
+			atomic.LoadInt32(&actors["a"].jobs) == 5 && atomic.LoadInt32(&actors["b"].jobs) == 5
+	}
+	actors["gate"].destroy = func() { gateAfter = actors["db"].waitStop(0) }
+
+	for name := range actors {
+		for i := 0; i < 5; i++ {
+			if err := mgr.Send(name, "work", &M{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	begin := time.Now()
+	if !mgr.CloseAllCleanly(2000, "gate") {
+		t.Fatal("CloseAllCleanly timeout")
+	}
+	//队列清空后立即退出，不再每个服务等待固定的时间
+	if d := time.Since(begin); d > time.Second {
+		t.Fatalf("CloseAllCleanly took %v", d)
+	}
+	if atomic.LoadInt32(&parallel) != 2 || !dbAfter || !gateAfter {
+		t.Fatalf("parallel %d, db after %v, gate after %v", parallel, dbAfter, gateAfter)
+	}
+	for name, actor := range actors {
+		if actor.jobs != 5 || !actor.waitStop(0) {
+			t.Fatalf("%s jobs %d", name, actor.jobs)
+		}
+	}
+}
+
+func TestCloseAllCleanlyTimeout(t *testing.T) {
+	mgr := NewGoRoutineMgr()
+	slow, last := &drainActor{}, &drainActor{}
+	mgr.Start(slow, "slow")
+	mgr.Start(last, "last")
+	mgr.DoStart()
+	block := make(chan struct{})
+	slow.Register("block", func(IGoRoutine, interface{}) interface{} {
+		<-block
+		return nil
+	})
+	mgr.Send("slow", "block", &M{})
+	if mgr.CloseAllCleanly(100, "last") {
+		t.Fatal("blocked actor closed")
+	}
+	if !last.waitStop(time.Second) { //超时后依然通知关闭
+		t.Fatal("last actor not closed after timeout")
+	}
+	close(block)
+	if !slow.waitStop(time.Second) {
+		t.Fatal("slow actor not closed after drained")
+	}
+}
@@ -11,7 +11,8 @@ import (
 type GoRoutinePool struct {
 	go_name_Tmp map[int64]IGoRoutine
 
-	actorLock *sync.RWMutex
+	actorLock  *sync.RWMutex
+	supervisor *Supervisor //监督Start创建的actor，nil代表不监督
 }
 
 func (self *GoRoutinePool) Init() {
@@ -19,6 +20,11 @@ func (self *GoRoutinePool) Init() {
 	self.actorLock = &sync.RWMutex{}
 }
 
+//设置监督者，之后Start创建的actor崩溃后由sup按照重启策略重启，被关闭的actor自动解除监督
+func (self *GoRoutinePool) SetSupervisor(sup *Supervisor) {
+	self.supervisor = sup
+}
+
 //添加一个actor
 func (self *GoRoutinePool) AddRoutine(rou IGoRoutine, name int64) bool {
 	self.actorLock.Lock()
@@ -127,6 +133,9 @@ func (self *GoRoutinePool) Start(igo IGoRoutine, name int64) bool {
 	//register handler msg
 	igo.DoRegsiter()
 
+	if self.supervisor != nil {
+		self.supervisor.SupervisePool(self, igo, name)
+	}
 	return true
 }
 
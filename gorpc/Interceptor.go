@@ -0,0 +1,94 @@
+package gorpc
+
+import (
+	"fmt"
+
+	"github.com/snowyyj001/loumiao/llog"
+)
+
+const (
+	INVOKE_CMD = iota //Register注册的处理函数，actor之间的消息，rpc call
+	INVOKE_NET        //RegisterGate注册的网络消息处理函数，客户端消息，rpc消息
+)
+
+//一次处理函数的调用，拦截器可以读取和修改
+type Invocation struct {
+	Actor    IGoRoutine
+	Kind     int         //INVOKE_CMD, INVOKE_NET
+	Handler  string      //处理函数名，网络消息是消息名
+	ClientId int         //网络消息的clientid，rpc消息和rpc call是源节点uid
+	Data     interface{} //处理函数的参数
+}
+
+//拦截器链中的下一环，最后一环是处理函数本身
+type Invoker func(inv *Invocation) (interface{}, error)
+
+//拦截器，调用next继续执行，不调用就是拦截
+//返回值和error会作为call的结果返回给调用方，网络消息的返回值被忽略，error只记录日志
+type Interceptor func(inv *Invocation, next Invoker) (interface{}, error)
+
+var interceptors []Interceptor
+
+//回复客户端，由loumiao设置，gate节点上直接发给socket
+var ClientReplier func(clientid int, data interface{})
+
+//注册全局拦截器，对所有actor生效，先注册的在外层
+//必须在服务启动前调用
+func Use(ics ...Interceptor) {
+	interceptors = append(interceptors, ics...)
+}
+
+//注册actor的拦截器，在全局拦截器的内层，先注册的在外层
+//必须在actor启动前调用
+func (self *GoRoutineLogic) Use(ics ...Interceptor) {
+	self.interceptors = append(self.interceptors, ics...)
+}
+
+//直接回复发消息的客户端，用于拦截网络消息后返回错误码
+func (self *Invocation) Reply(data interface{}) {
+	if ClientReplier == nil {
+		llog.Errorf("Invocation.Reply[%s]: no ClientReplier", self.Handler)
+		return
+	}
+	ClientReplier(self.ClientId, data)
+}
+
+//按照拦截器链执行
+func (self *GoRoutineLogic) invoke(inv *Invocation, final Invoker) (interface{}, error) {
+	if len(interceptors) == 0 && len(self.interceptors) == 0 {
+		return final(inv)
+	}
+	next := final
+	for i := len(self.interceptors) - 1; i >= 0; i-- {
+		next = chainInvoker(self.interceptors[i], next)
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		next = chainInvoker(interceptors[i], next)
+	}
+	return next(inv)
+}
+
+func chainInvoker(ic Interceptor, next Invoker) Invoker {
+	return func(inv *Invocation) (interface{}, error) {
+		return ic(inv, next)
+	}
+}
+
+//把处理函数的panic转换成返回值，例如给客户端回复错误码
+//被转换的panic不再作为PanicError上报，actor不会因此退出
+//@translate: nil时返回ErrHandlerPanic，errors.Is(err, ErrHandlerPanic)为true
+func Recover(translate func(inv *Invocation, reason interface{}) (interface{}, error)) Interceptor {
+	return func(inv *Invocation, next Invoker) (ret interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				llog.Errorf("Recover[%s.%s]: %v", inv.Actor.GetName(), inv.Handler, r)
+				if translate == nil {
+					ret, err = nil, fmt.Errorf("%w [%s.%s]: %v", ErrHandlerPanic, inv.Actor.GetName(), inv.Handler, r)
+					return
+				}
+				ret, err = translate(inv, r)
+			}
+		}()
+		return next(inv)
+	}
+}
@@ -0,0 +1,117 @@
+package gorpc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+//替换全局拦截器和ClientReplier，返回恢复函数
+func withInterceptors(ics ...Interceptor) func() {
+	oldIcs, oldReplier := interceptors, ClientReplier
+	interceptors = nil
+	Use(ics...)
+	return func() { interceptors, ClientReplier = oldIcs, oldReplier }
+}
+
+func TestInterceptorOrder(t *testing.T) {
+	var order []string
+	trace := func(name string) Interceptor {
+		return func(inv *Invocation, next Invoker) (interface{}, error) {
+			order = append(order, name)
+			ret, err := next(inv)
+			order = append(order, "/"+name)
+			return ret, err
+		}
+	}
+	defer withInterceptors(trace("g1"), trace("g2"))()
+	self := newTestLogic(MAILBOX_BLOCK, 4)
+	self.Use(trace("a1"), trace("a2"))
+	self.RegisterGate("C_Move", func(igo IGoRoutine, clientid int, data interface{}) { order = append(order, "handler") })
+
+	self.CallNetFunc(&M{Id: 1, Name: "C_Move"})
+	want := "[g1 g2 a1 a2 handler /a2 /a1 /g2 /g1]"
+	if fmt.Sprint(order) != want {
+		t.Fatalf("order %v", order)
+	}
+
+	//回调不经过拦截器
+	order = nil
+	self.callFunc("", func(IGoRoutine, interface{}) interface{} { order = append(order, "cb"); return nil }, &M{})
+	if fmt.Sprint(order) != "[cb]" {
+		t.Fatalf("callback %v", order)
+	}
+}
+
+func TestInterceptorShortCircuit(t *testing.T) {
+	var replied []interface{}
+	deny := func(inv *Invocation, next Invoker) (interface{}, error) {
+		if inv.Handler == "C_Buy" || inv.Handler == "buy" {
+			inv.Reply(fmt.Sprintf("denied %d", inv.ClientId))
+			return "denied", nil
+		}
+		return next(inv)
+	}
+	defer withInterceptors(deny)()
+	ClientReplier = func(clientid int, data interface{}) { replied = append(replied, data) }
+
+	caller, target := newCallTarget(false), newCallTarget(false)
+	defer caller.Close()
+	defer target.Close()
+	handled := 0
+	target.RegisterGate("C_Buy", func(IGoRoutine, int, interface{}) { handled++ })
+	target.Register("buy", func(IGoRoutine, interface{}) interface{} { handled++; return "bought" })
+
+	target.CallNetFunc(&M{Id: 9, Name: "C_Buy"})
+	ret, err := caller.CallContext(nil, target, "buy", &M{Id: 5})
+	if ret != "denied" || err != nil || handled != 0 {
+		t.Fatalf("call %v %v, handled %d", ret, err, handled)
+	}
+	if fmt.Sprint(replied) != "[denied 9 denied 5]" {
+		t.Fatalf("replied %v", replied)
+	}
+	if ret, _ = caller.CallContext(nil, target, "echo", &M{Data: "ok", Flag: true}); ret != "ok" {
+		t.Fatalf("not intercepted %v", ret)
+	}
+}
+
+func TestInterceptorRecover(t *testing.T) {
+	defer withInterceptors()()
+	caller, target := newCallTarget(false), newTestLogic(MAILBOX_BLOCK, 4)
+	defer caller.Close()
+	crashed := make(chan string, 2)
+	target.setCrashFunc(func(handler string, reason interface{}, stack string, fatal bool) { crashed <- handler }, true) //没有拦截的panic会让woker退出
+	target.Use(Recover(nil))
+	target.Register("panic", func(IGoRoutine, interface{}) interface{} { panic("boom") })
+	target.Register("echo", func(igo IGoRoutine, data interface{}) interface{} { return data })
+	target.Run()
+	defer target.Close()
+
+	_, err := caller.CallContext(nil, target, "panic", &M{})
+	var pe *PanicError
+	if !errors.Is(err, ErrHandlerPanic) || errors.As(err, &pe) {
+		t.Fatalf("recover: %v", err)
+	}
+	if !errors.Is(RemoteError(err.Error()), ErrHandlerPanic) {
+		t.Fatalf("remote: %v", err)
+	}
+	if ret, err := caller.CallContext(nil, target, "echo", &M{Data: 1, Flag: true}); err != nil || ret != 1 {
+		t.Fatalf("actor killed: %v %v", ret, err)
+	}
+
+	//自定义转换
+	other := newTestLogic(MAILBOX_BLOCK, 4)
+	other.Use(Recover(func(inv *Invocation, reason interface{}) (interface{}, error) { return reason, nil }))
+	other.Register("code", func(IGoRoutine, interface{}) interface{} { panic(7) })
+	other.Run()
+	defer other.Close()
+	if ret, err := caller.CallContext(nil, other, "code", &M{}); err != nil || ret != 7 {
+		t.Fatalf("translate: %v %v", ret, err)
+	}
+	select {
+	case handler := <-crashed:
+		t.Fatalf("recovered panic reported: %s", handler)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -0,0 +1,171 @@
+package gorpc
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/snowyyj001/loumiao/llog"
+)
+
+//job chan满了之后的处理策略
+const (
+	MAILBOX_BLOCK       = iota //阻塞等待，超过MailboxTimeout丢弃并返回ErrMailboxFull
+	MAILBOX_DROP_NEWEST        //丢弃新任务
+	MAILBOX_DROP_OLDEST        //丢弃最早的任务，再投递新任务
+	MAILBOX_REJECT             //拒绝新任务并返回ErrMailboxFull
+	MAILBOX_OVERFLOW           //放入无界的溢出队列，job chan有空位后按顺序转移回去
+)
+
+//设置邮箱策略，必须在actor init之前调用
+//@policy: MAILBOX_*
+//@timeout: MAILBOX_BLOCK策略的等待时间，毫秒，0代表一直等待
+func (self *GoRoutineLogic) SetMailboxPolicy(policy int, timeout int) {
+	self.MailboxPolicy = policy
+	self.MailboxTimeout = timeout
+}
+
+//被丢弃的任务数量
+func (self *GoRoutineLogic) DroppedJobNumber() int64 {
+	return atomic.LoadInt64(&self.dropNum)
+}
+
+//按照邮箱策略投递任务
+//@done: 调用方的结束信号，MAILBOX_BLOCK策略和控制通道满时等待会一并监听，可以为nil
+func (self *GoRoutineLogic) pushJob(ct ChannelContext, done <-chan struct{}) error {
+	if self.isPriority(ct.Handler) {
+		select {
+		case self.ctrlChan <- ct:
+			return nil
+		default:
+		}
+		if done != nil { //控制通道满了，调用方有结束信号时等到结束，否则直接返回，不能一直阻塞
+			select {
+			case self.ctrlChan <- ct:
+				return nil
+			case <-done:
+			}
+		}
+		atomic.AddInt64(&self.dropNum, 1)
+		llog.Warningf("GoRoutineLogic[%s] ctrl chan full, drop %s", self.Name, ct.Handler)
+		return ErrMailboxFull
+	}
+
+	if self.MailboxPolicy == MAILBOX_OVERFLOW {
+		self.overflowLock.Lock()
+		if self.overflow.Length() == 0 { //溢出队列有数据时，新任务也要进溢出队列，保证顺序
+			select {
+			case self.jobChan <- ct:
+				self.overflowLock.Unlock()
+				return nil
+			default:
+			}
+		}
+		self.overflow.Add(ct)
+		self.overflowLock.Unlock()
+		select {
+		case self.overflowChan <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+
+	select {
+	case self.jobChan <- ct:
+		return nil
+	default:
+	}
+
+	switch self.MailboxPolicy {
+	case MAILBOX_DROP_NEWEST:
+		atomic.AddInt64(&self.dropNum, 1)
+		return nil
+	case MAILBOX_REJECT:
+		atomic.AddInt64(&self.dropNum, 1)
+		return ErrMailboxFull
+	case MAILBOX_DROP_OLDEST:
+		for {
+			select {
+			case old := <-self.jobChan:
+				atomic.AddInt64(&self.dropNum, 1)
+				llog.Debugf("GoRoutineLogic[%s] mailbox full, drop oldest: %s", self.Name, old.Handler)
+				self.rejectJob(&old)
+			default:
+			}
+			select {
+			case self.jobChan <- ct:
+				return nil
+			default:
+			}
+		}
+	default:
+		var timeout <-chan time.Time
+		if self.MailboxTimeout > 0 {
+			tm := time.NewTimer(time.Duration(self.MailboxTimeout) * time.Millisecond)
+			defer tm.Stop()
+			timeout = tm.C
+		}
+		select {
+		case self.jobChan <- ct:
+			return nil
+		case <-timeout:
+		case <-done:
+		}
+		atomic.AddInt64(&self.dropNum, 1)
+		return ErrMailboxFull
+	}
+}
+
+func (self *GoRoutineLogic) isPriority(handler string) bool {
+	self.priorityLock.RLock()
+	defer self.priorityLock.RUnlock()
+	return self.priorityCmd[handler]
+}
+
+//被丢弃的call马上返回ErrMailboxFull，调用方不用等到超时
+//SendBack的回调只能收到数据，收不到错误，被丢弃时不执行回调
+func (self *GoRoutineLogic) rejectJob(ct *ChannelContext) {
+	if ct.Reply != nil {
+		ct.Reply(nil, ErrMailboxFull)
+		return
+	}
+	if ct.ReadChan == nil {
+		return
+	}
+	if ct.Cb != nil {
+		llog.Warningf("GoRoutineLogic[%s] drop %s, callback of SendBack is skipped", self.Name, ct.Handler)
+		return
+	}
+	retctx := ChannelContext{Seq: ct.Seq, Err: ErrMailboxFull}
+	retctx.Data.Flag = true
+	select {
+	case ct.ReadChan <- retctx:
+	default:
+		llog.Warningf("GoRoutineLogic[%s] drop %s, caller is busy too", self.Name, ct.Handler)
+	}
+}
+
+//把溢出队列中的任务按顺序转移到job chan，只在woker中调用
+func (self *GoRoutineLogic) refillOverflow() {
+	if self.overflow == nil {
+		return
+	}
+	self.overflowLock.Lock()
+	defer self.overflowLock.Unlock()
+	for self.overflow.Length() > 0 {
+		select {
+		case self.jobChan <- self.overflow.Peek().(ChannelContext):
+			self.overflow.Remove()
+		default:
+			return
+		}
+	}
+}
+
+func (self *GoRoutineLogic) overflowLen() int {
+	if self.overflow == nil {
+		return 0
+	}
+	self.overflowLock.Lock()
+	defer self.overflowLock.Unlock()
+	return self.overflow.Length()
+}
@@ -0,0 +1,143 @@
+package gorpc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLogic(policy int, size int) *GoRoutineLogic {
+	self := &GoRoutineLogic{ChanSize: size}
+	self.SetMailboxPolicy(policy, 0)
+	self.init("test")
+	return self
+}
+
+func TestMailboxDropOldestRejectsCall(t *testing.T) {
+	self := newTestLogic(MAILBOX_DROP_OLDEST, 1)
+	readChan := make(chan ChannelContext, 1)
+	if err := self.pushJob(ChannelContext{Handler: "call", ReadChan: readChan, Seq: 7}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var remoteErr error
+	if err := self.pushJob(ChannelContext{Handler: "remote", Reply: func(ret interface{}, err error) { remoteErr = err }}, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ret := <-readChan:
+		if ret.Err != ErrMailboxFull || ret.Seq != 7 {
+			t.Fatalf("call reply: %v", ret)
+		}
+	default:
+		t.Fatal("dropped call got no reply")
+	}
+
+	if err := self.pushJob(ChannelContext{Handler: "send"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if remoteErr != ErrMailboxFull {
+		t.Fatalf("remote reply: %v", remoteErr)
+	}
+	if ct := <-self.jobChan; ct.Handler != "send" || self.DroppedJobNumber() != 2 {
+		t.Fatalf("job %s, dropped %d", ct.Handler, self.DroppedJobNumber())
+	}
+}
+
+func TestMailboxPolicy(t *testing.T) {
+	for _, policy := range []int{MAILBOX_DROP_NEWEST, MAILBOX_REJECT, MAILBOX_OVERFLOW} {
+		self := newTestLogic(policy, 1)
+		self.pushJob(ChannelContext{Handler: "a"}, nil)
+		err := self.pushJob(ChannelContext{Handler: "b"}, nil)
+		switch policy {
+		case MAILBOX_DROP_NEWEST:
+			if err != nil || self.DroppedJobNumber() != 1 {
+				t.Fatalf("drop newest: %v %d", err, self.DroppedJobNumber())
+			}
+		case MAILBOX_REJECT:
+			if err != ErrMailboxFull {
+				t.Fatalf("reject: %v", err)
+			}
+		case MAILBOX_OVERFLOW:
+			if err != nil || self.overflowLen() != 1 {
+				t.Fatalf("overflow: %v %d", err, self.overflowLen())
+			}
+			<-self.jobChan
+			self.refillOverflow()
+			if ct := <-self.jobChan; ct.Handler != "b" {
+				t.Fatalf("overflow order: %s", ct.Handler)
+			}
+		}
+	}
+}
+
+func TestMailboxPriority(t *testing.T) {
+	self := newTestLogic(MAILBOX_REJECT, 1)
+	self.RegisterPriority("ctrl", func(IGoRoutine, interface{}) interface{} { return nil })
+	self.pushJob(ChannelContext{Handler: "a"}, nil)
+	if err := self.pushJob(ChannelContext{Handler: "ctrl"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ct := <-self.ctrlChan; ct.Handler != "ctrl" {
+		t.Fatalf("ctrl: %s", ct.Handler)
+	}
+
+	//注册和投递并发进行，配合-race检查
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			self.RegisterPriority("other", nil)
+			self.UnRegister("other")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		self.isPriority("other")
+	}
+	wg.Wait()
+}
+
+//被挤掉的SendBack不执行回调，回调收不到错误
+func TestMailboxDropOldestSendBack(t *testing.T) {
+	caller, target := newTestLogic(MAILBOX_BLOCK, 4), newTestLogic(MAILBOX_DROP_OLDEST, 1)
+	caller.started = true //不启动woker，检查caller的job chan
+	called := false
+	if err := caller.SendBack(target, "back", &M{}, func(IGoRoutine, interface{}) interface{} { called = true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.pushJob(ChannelContext{Handler: "send"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(caller.jobChan) != 0 || called || target.DroppedJobNumber() != 1 {
+		t.Fatalf("caller jobs %d, dropped %d", len(caller.jobChan), target.DroppedJobNumber())
+	}
+}
+
+//控制通道满了不会一直阻塞
+func TestMailboxPriorityFull(t *testing.T) {
+	self := newTestLogic(MAILBOX_BLOCK, 1)
+	self.RegisterPriority("ctrl", func(IGoRoutine, interface{}) interface{} { return nil })
+	for i := 0; i < CTRL_CHAN_LEN; i++ {
+		if err := self.pushJob(ChannelContext{Handler: "ctrl"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := self.pushJob(ChannelContext{Handler: "ctrl"}, nil); err != ErrMailboxFull {
+		t.Fatalf("full without done: %v", err)
+	}
+
+	//有结束信号时等到结束
+	done := make(chan struct{})
+	time.AfterFunc(20*time.Millisecond, func() { close(done) })
+	begin := time.Now()
+	if err := self.pushJob(ChannelContext{Handler: "ctrl"}, done); err != ErrMailboxFull || time.Since(begin) < 10*time.Millisecond {
+		t.Fatalf("full with done: %v", err)
+	}
+	<-self.ctrlChan
+	if err := self.pushJob(ChannelContext{Handler: "ctrl"}, done); err != nil {
+		t.Fatalf("ctrl chan has room: %v", err)
+	}
+	if self.DroppedJobNumber() != 2 {
+		t.Fatalf("dropped %d", self.DroppedJobNumber())
+	}
+}
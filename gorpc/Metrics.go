@@ -0,0 +1,253 @@
+package gorpc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//延迟直方图的上边界
+var MetricsBuckets = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+const (
+	METRICS_CALLBACK = "callback" //SendBack等回调的统计名
+)
+
+//延迟统计，字段都用原子操作访问
+type latencyStat struct {
+	calls   int64
+	panics  int64
+	total   int64                          //纳秒
+	max     int64                          //纳秒
+	buckets [len(MetricsBuckets) + 1]int64 //最后一个是+Inf
+}
+
+func (self *latencyStat) record(d time.Duration, panicked bool) {
+	ns := int64(d)
+	atomic.AddInt64(&self.calls, 1)
+	atomic.AddInt64(&self.total, ns)
+	if panicked {
+		atomic.AddInt64(&self.panics, 1)
+	}
+	for {
+		old := atomic.LoadInt64(&self.max)
+		if ns <= old || atomic.CompareAndSwapInt64(&self.max, old, ns) {
+			break
+		}
+	}
+	i := 0
+	for i < len(MetricsBuckets) && ns > int64(MetricsBuckets[i]) {
+		i++
+	}
+	atomic.AddInt64(&self.buckets[i], 1)
+}
+
+func (self *latencyStat) snapshot(name string) HandlerMetrics {
+	hm := HandlerMetrics{
+		Handler: name,
+		Calls:   atomic.LoadInt64(&self.calls),
+		Panics:  atomic.LoadInt64(&self.panics),
+		Total:   time.Duration(atomic.LoadInt64(&self.total)),
+		Max:     time.Duration(atomic.LoadInt64(&self.max)),
+		Buckets: make([]int64, len(self.buckets)),
+	}
+	for i := range self.buckets {
+		hm.Buckets[i] = atomic.LoadInt64(&self.buckets[i])
+	}
+	return hm
+}
+
+//actor的运行统计
+type actorMetrics struct {
+	lock     sync.RWMutex
+	handlers map[string]*latencyStat
+	timerLag latencyStat
+	depthMax int64
+	running  int64 //正在执行的处理函数数量
+}
+
+func (self *actorMetrics) handler(name string) *latencyStat {
+	self.lock.RLock()
+	stat := self.handlers[name]
+	self.lock.RUnlock()
+	if stat != nil {
+		return stat
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.handlers == nil {
+		self.handlers = make(map[string]*latencyStat)
+	}
+	stat = self.handlers[name]
+	if stat == nil {
+		stat = new(latencyStat)
+		self.handlers[name] = stat
+	}
+	return stat
+}
+
+//记录队列深度的高水位
+func (self *actorMetrics) markDepth(depth int) {
+	for {
+		old := atomic.LoadInt64(&self.depthMax)
+		if int64(depth) <= old || atomic.CompareAndSwapInt64(&self.depthMax, old, int64(depth)) {
+			return
+		}
+	}
+}
+
+//处理函数的统计
+type HandlerMetrics struct {
+	Handler string        //处理函数名，网络消息是消息名
+	Calls   int64         //调用次数
+	Panics  int64         //panic次数
+	Total   time.Duration //总耗时
+	Max     time.Duration //最大耗时
+	Buckets []int64       //耗时分布，与MetricsBuckets对应，最后一个是超过所有边界的次数
+}
+
+//平均耗时
+func (self *HandlerMetrics) Avg() time.Duration {
+	if self.Calls == 0 {
+		return 0
+	}
+	return self.Total / time.Duration(self.Calls)
+}
+
+//actor的统计
+type ActorMetrics struct {
+	Name          string
+	QueueDepth    int              //当前队列深度
+	QueueDepthMax int64            //队列深度高水位
+	Running       int64            //正在执行的处理函数数量，异步actor可能大于1
+	Dropped       int64            //被邮箱策略丢弃的任务数量
+	TimerLag      HandlerMetrics   //定时器的延迟，实际间隔超出设定间隔的部分
+	Handlers      []HandlerMetrics //按处理函数名排序
+}
+
+//获得actor的统计，协程安全
+func (self *GoRoutineLogic) Metrics() ActorMetrics {
+	am := ActorMetrics{
+		Name:          self.Name,
+		QueueDepth:    self.LeftJobNumber(),
+		QueueDepthMax: atomic.LoadInt64(&self.metrics.depthMax),
+		Running:       atomic.LoadInt64(&self.metrics.running),
+		Dropped:       self.DroppedJobNumber(),
+		TimerLag:      self.metrics.timerLag.snapshot("timer"),
+	}
+	self.metrics.lock.RLock()
+	for name, stat := range self.metrics.handlers {
+		am.Handlers = append(am.Handlers, stat.snapshot(name))
+	}
+	self.metrics.lock.RUnlock()
+	sort.Slice(am.Handlers, func(i, j int) bool {
+		return am.Handlers[i].Handler < am.Handlers[j].Handler
+	})
+	return am
+}
+
+//获得所有服务的统计，按注册顺序
+//协程池中的actor数量不固定，不在其中，需要时使用igo.Metrics()
+func (self *GoRoutineMgr) Metrics() []ActorMetrics {
+	var arr []ActorMetrics
+	for _, name := range self.go_order {
+		if igo := self.go_name_Map[name]; igo != nil {
+			arr = append(arr, igo.Metrics())
+		}
+	}
+	return arr
+}
+
+//按照prometheus文本格式输出所有服务的统计
+func (self *GoRoutineMgr) WriteMetrics(w io.Writer) error {
+	return WriteMetrics(w, self.Metrics())
+}
+
+//按照prometheus文本格式输出统计
+func WriteMetrics(w io.Writer, arr []ActorMetrics) error {
+	bw := bufio.NewWriter(w)
+	gauge := func(name, help string, value func(am *ActorMetrics) int64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for i := range arr {
+			fmt.Fprintf(bw, "%s{actor=\"%s\"} %d\n", name, escapeLabel(arr[i].Name), value(&arr[i]))
+		}
+	}
+	gauge("loumiao_actor_queue_depth", "Jobs waiting in the actor mailbox.", func(am *ActorMetrics) int64 { return int64(am.QueueDepth) })
+	gauge("loumiao_actor_queue_depth_max", "High-water mark of the actor mailbox.", func(am *ActorMetrics) int64 { return am.QueueDepthMax })
+	gauge("loumiao_actor_running", "Handlers currently executing.", func(am *ActorMetrics) int64 { return am.Running })
+
+	fmt.Fprintf(bw, "# HELP loumiao_actor_dropped_total Jobs dropped by the mailbox policy.\n# TYPE loumiao_actor_dropped_total counter\n")
+	for i := range arr {
+		fmt.Fprintf(bw, "loumiao_actor_dropped_total{actor=\"%s\"} %d\n", escapeLabel(arr[i].Name), arr[i].Dropped)
+	}
+
+	fmt.Fprintf(bw, "# HELP loumiao_actor_handler_panics_total Handler panics.\n# TYPE loumiao_actor_handler_panics_total counter\n")
+	for i := range arr {
+		for _, hm := range arr[i].Handlers {
+			fmt.Fprintf(bw, "loumiao_actor_handler_panics_total{actor=\"%s\",handler=\"%s\"} %d\n", escapeLabel(arr[i].Name), escapeLabel(hm.Handler), hm.Panics)
+		}
+	}
+
+	fmt.Fprintf(bw, "# HELP loumiao_actor_handler_seconds Handler latency.\n# TYPE loumiao_actor_handler_seconds histogram\n")
+	for i := range arr {
+		for j := range arr[i].Handlers {
+			labels := fmt.Sprintf("actor=\"%s\",handler=\"%s\"", escapeLabel(arr[i].Name), escapeLabel(arr[i].Handlers[j].Handler))
+			writeHistogram(bw, "loumiao_actor_handler_seconds", labels, &arr[i].Handlers[j])
+		}
+	}
+
+	fmt.Fprintf(bw, "# HELP loumiao_actor_timer_lag_seconds Delay of actor timers beyond their interval.\n# TYPE loumiao_actor_timer_lag_seconds histogram\n")
+	for i := range arr {
+		writeHistogram(bw, "loumiao_actor_timer_lag_seconds", fmt.Sprintf("actor=\"%s\"", escapeLabel(arr[i].Name)), &arr[i].TimerLag)
+	}
+	return bw.Flush()
+}
+
+func writeHistogram(w io.Writer, name, labels string, hm *HandlerMetrics) {
+	var count int64
+	for i, b := range MetricsBuckets {
+		count += hm.Buckets[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, b.Seconds(), count)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, hm.Calls)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, hm.Total.Seconds())
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, hm.Calls)
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(str string) string {
+	return labelReplacer.Replace(str)
+}
+
+var metricsWriters []func(w io.Writer)
+
+//注册额外的统计输出，在actor统计之后按注册顺序输出，例如gate的限流统计
+//必须在服务启动前调用
+func RegisterMetrics(f func(w io.Writer)) {
+	metricsWriters = append(metricsWriters, f)
+}
+
+//prometheus的http接口
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	MGR.WriteMetrics(w)
+	for _, f := range metricsWriters {
+		f(w)
+	}
+}
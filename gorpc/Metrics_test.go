@@ -0,0 +1,56 @@
+package gorpc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetricsByMessage(t *testing.T) {
+	self := newTestLogic(MAILBOX_BLOCK, 4)
+	self.NetHandler["C_Login"] = func(igo IGoRoutine, clientid int, data interface{}) {}
+	self.NetHandler["C_Move"] = func(igo IGoRoutine, clientid int, data interface{}) { panic("move") }
+	self.callFunc("ServiceHandler", ServiceHandler, &M{Id: 1, Name: "C_Login"})
+	self.callFunc("ServiceHandler", ServiceHandler, &M{Id: 1, Name: "C_Login"})
+	self.callFunc("ServiceHandler", ServiceHandler, &M{Id: 1, Name: "C_Move"})
+	self.callFunc("Login", func(igo IGoRoutine, data interface{}) interface{} { return nil }, &M{Name: "C_Login"})
+	self.callFunc("", func(igo IGoRoutine, data interface{}) interface{} { return nil }, &M{})
+
+	calls := map[string]int64{}
+	for _, hm := range self.Metrics().Handlers {
+		calls[hm.Handler] = hm.Calls
+	}
+	if len(calls) != 4 || calls["C_Login"] != 2 || calls["C_Move"] != 1 || calls["Login"] != 1 || calls[METRICS_CALLBACK] != 1 {
+		t.Fatalf("handlers: %v", calls)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf, []ActorMetrics{self.Metrics()}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "ServiceHandler") || !strings.Contains(buf.String(), `handler="C_Login"`) {
+		t.Fatalf("metrics:\n%s", buf.String())
+	}
+}
+
+func TestMetricsRunning(t *testing.T) {
+	self := newTestLogic(MAILBOX_BLOCK, 4)
+	var running int64
+	self.callFunc("Check", func(igo IGoRoutine, data interface{}) interface{} {
+		running = igo.(*GoRoutineLogic).Metrics().Running
+		return nil
+	}, &M{})
+	if running != 1 || self.Metrics().Running != 0 {
+		t.Fatalf("running %d, after %d", running, self.Metrics().Running)
+	}
+
+	mgr := NewGoRoutineMgr()
+	if len(mgr.Metrics()) != 0 {
+		t.Fatal("new manager has metrics")
+	}
+	var buf bytes.Buffer
+	WriteMetrics(&buf, []ActorMetrics{self.Metrics()})
+	if !strings.Contains(buf.String(), `loumiao_actor_running{actor="test"} 0`) {
+		t.Fatalf("metrics:\n%s", buf.String())
+	}
+}
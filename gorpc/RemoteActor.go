@@ -0,0 +1,301 @@
+package gorpc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/llog"
+)
+
+//集群actor地址
+//字符串格式: "nodeUid/actorName"，协程池中的actor是"nodeUid/poolName/actorId"
+type ActorAddr struct {
+	NodeUid int    //actor所在节点的uid，0代表本节点
+	Name    string //actor名，协程池actor时是协程池名
+	Id      int64  //协程池actor的id，0代表不是协程池actor
+}
+
+//远程actor消息
+type RemoteMsg struct {
+	Addr    ActorAddr
+	Handler string
+	Data    interface{} //[]byte或者message注册过的pb/json结构体
+	Seq     int64       //>0需要返回结果
+}
+
+//远程actor消息的发送接口，由GateServer设置
+type RemoteSender func(rm *RemoteMsg) error
+
+const (
+	REMOTE_CALLBACK_WAIT = 1000 //远程调用的结果投递到调用方邮箱的最长等待，毫秒，调用方邮箱一直满时丢弃结果
+)
+
+//等待远程返回的调用
+type remoteCall struct {
+	caller   *GoRoutineLogic                  //回调方式的调用方
+	done     func(ret interface{}, err error) //回调，在调用方的woker中执行
+	readChan chan ChannelContext              //阻塞方式的返回chan
+	timer    *time.Timer                      //回调方式的超时
+}
+
+var (
+	remoteSender RemoteSender
+	remoteLock   sync.Mutex
+	remoteCalls  = make(map[int64]*remoteCall)
+	remoteWait   = REMOTE_CALLBACK_WAIT //测试时修改
+)
+
+//本节点actor地址
+func NewActorAddr(name string) ActorAddr {
+	return ActorAddr{NodeUid: config.SERVER_NODE_UID, Name: name}
+}
+
+//本节点协程池actor地址
+func NewPoolActorAddr(pool string, id int64) ActorAddr {
+	return ActorAddr{NodeUid: config.SERVER_NODE_UID, Name: pool, Id: id}
+}
+
+//解析"nodeUid/actorName"或"nodeUid/poolName/actorId"
+func ParseActorAddr(str string) (ActorAddr, error) {
+	var addr ActorAddr
+	arr := strings.Split(str, "/")
+	if len(arr) != 2 && len(arr) != 3 {
+		return addr, fmt.Errorf("ParseActorAddr: wrong format: %s", str)
+	}
+	uid, err := strconv.Atoi(arr[0])
+	if err != nil || uid < 0 {
+		return addr, fmt.Errorf("ParseActorAddr: wrong node uid: %s", str)
+	}
+	addr.NodeUid = uid
+	addr.Name = arr[1]
+	if len(arr) == 3 {
+		addr.Id, err = strconv.ParseInt(arr[2], 10, 64)
+		if err != nil || addr.Id <= 0 {
+			return addr, fmt.Errorf("ParseActorAddr: wrong actor id: %s", str)
+		}
+	}
+	if addr.Name == "" {
+		return addr, fmt.Errorf("ParseActorAddr: empty actor name: %s", str)
+	}
+	return addr, nil
+}
+
+func (self ActorAddr) String() string {
+	if self.Id > 0 {
+		return fmt.Sprintf("%d/%s/%d", self.NodeUid, self.Name, self.Id)
+	}
+	return fmt.Sprintf("%d/%s", self.NodeUid, self.Name)
+}
+
+//是否是本节点的actor
+func (self ActorAddr) IsLocal() bool {
+	return self.NodeUid == 0 || self.NodeUid == config.SERVER_NODE_UID
+}
+
+//设置远程actor消息的发送接口
+func SetRemoteSender(f RemoteSender) {
+	remoteSender = f
+}
+
+func sendRemote(rm *RemoteMsg) error {
+	if remoteSender == nil {
+		return ErrRemoteUnreachable
+	}
+	return remoteSender(rm)
+}
+
+func addRemoteCall(seq int64, rc *remoteCall) {
+	remoteLock.Lock()
+	remoteCalls[seq] = rc
+	remoteLock.Unlock()
+}
+
+func takeRemoteCall(seq int64) *remoteCall {
+	remoteLock.Lock()
+	rc := remoteCalls[seq]
+	delete(remoteCalls, seq)
+	remoteLock.Unlock()
+	return rc
+}
+
+//根据地址获得本节点的actor
+func (self *GoRoutineMgr) GetActor(addr ActorAddr) IGoRoutine {
+	if addr.Id > 0 {
+		pool := self.GetPool(addr.Name)
+		if pool == nil {
+			return nil
+		}
+		return pool.GetRunRoutine(addr.Id)
+	}
+	return self.GetRoutine(addr.Name)
+}
+
+//按地址投递任务，本节点直接进入目标actor的邮箱，其他节点经过gate转发
+//@addr: 目标actor地址
+//@funcName: 目标actor的处理函数
+//@data: 函数参数，发往其他节点时必须是[]byte或者message注册过的结构体
+func (self *GoRoutineMgr) SendTo(addr ActorAddr, funcName string, data interface{}) error {
+	if addr.IsLocal() == false {
+		return sendRemote(&RemoteMsg{Addr: addr, Handler: funcName, Data: data})
+	}
+	igo := self.GetActor(addr)
+	if igo == nil {
+		llog.Errorf("GoRoutineMgr.SendTo target[%s] is nil: %s", addr.String(), funcName)
+		return ErrTargetNil
+	}
+	return igo.pushJob(ChannelContext{Handler: funcName, Data: M{Data: data, Flag: true}}, nil)
+}
+
+//按地址投递任务，给其他actor
+func (self *GoRoutineLogic) SendTo(addr ActorAddr, handler_name string, sdata interface{}) error {
+	return MGR.SendTo(addr, handler_name, sdata)
+}
+
+//按地址投递任务,拥有回调，回调在自己的woker中执行
+//远程调用CALL_TIMEOUT内没有返回，回调不会再执行
+func (self *GoRoutineLogic) SendBackTo(addr ActorAddr, handler_name string, sdata interface{}, Cb HanlderFunc) error {
+	if addr.IsLocal() {
+		return self.SendBack(MGR.GetActor(addr), handler_name, &M{Data: sdata, Flag: true}, Cb)
+	}
+	err := self.SendBackRemote(func(seq int64) error {
+		return sendRemote(&RemoteMsg{Addr: addr, Handler: handler_name, Data: sdata, Seq: seq})
+	}, CALL_TIMEOUT*1000, func(ret interface{}, err error) {
+		if err == ErrCallTimeout {
+			llog.Warningf("GoRoutineLogic[%s].SendBackTo[%s] %s: no reply", self.Name, addr.String(), handler_name)
+			return
+		}
+		Cb(self, ret)
+	})
+	if err != nil {
+		llog.Infof("GoRoutineLogic[%s].SendBackTo[%s] %s: %s", self.Name, addr.String(), handler_name, err.Error())
+	}
+	return err
+}
+
+//按地址阻塞式调用，由ctx控制超时和取消
+func (self *GoRoutineLogic) CallTo(ctx context.Context, addr ActorAddr, handler_name string, sdata interface{}) (interface{}, error) {
+	if addr.IsLocal() {
+		return self.CallContext(ctx, MGR.GetActor(addr), handler_name, &M{Data: sdata, Flag: true})
+	}
+	if self.started == false {
+		llog.Warningf("GoRoutineLogic.CallTo has not started: %s, %s, %s", self.Name, addr.String(), handler_name)
+		return nil, ErrCallerNotRunning
+	}
+	return MGR.CallTo(ctx, addr, handler_name, sdata)
+}
+
+//按地址阻塞式调用，调用方不是actor时使用
+func (self *GoRoutineMgr) CallTo(ctx context.Context, addr ActorAddr, funcName string, data interface{}) (interface{}, error) {
+	if addr.IsLocal() {
+		return callContext(ctx, self.GetActor(addr), funcName, &M{Data: data, Flag: true})
+	}
+	return CallRemote(ctx, func(seq int64) error {
+		return sendRemote(&RemoteMsg{Addr: addr, Handler: funcName, Data: data, Seq: seq})
+	})
+}
+
+//需要其他节点返回结果的调用，阻塞等待
+//@ctx: 调用上下文，nil等同于context.Background()
+//@send: 发送请求，seq要随请求带到目标节点，目标节点原样带回后调用DeliverReply
+func CallRemote(ctx context.Context, send func(seq int64) error) (interface{}, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	seq := atomic.AddInt64(&callSeq, 1)
+	readChan := make(chan ChannelContext, 1)
+	addRemoteCall(seq, &remoteCall{readChan: readChan})
+	if err := send(seq); err != nil {
+		takeRemoteCall(seq)
+		return nil, err
+	}
+	select {
+	case rdata := <-readChan:
+		return rdata.Data.Data, rdata.Err
+	case <-ctx.Done():
+		takeRemoteCall(seq)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrCallTimeout
+		}
+		return nil, ErrCallCanceled
+	}
+}
+
+//需要其他节点返回结果的调用，结果在自己的woker中回调
+//@send: 同CallRemote
+//@timeout: 超时时间，毫秒，超时后done收到ErrCallTimeout
+//@done: 回调，调用方邮箱满了超过REMOTE_CALLBACK_WAIT时丢弃，不会执行
+func (self *GoRoutineLogic) SendBackRemote(send func(seq int64) error, timeout int, done func(ret interface{}, err error)) error {
+	if self.started == false {
+		llog.Warningf("GoRoutineLogic.SendBackRemote has not started: %s", self.Name)
+		return ErrCallerNotRunning
+	}
+	seq := atomic.AddInt64(&callSeq, 1)
+	rc := &remoteCall{caller: self, done: done}
+	remoteLock.Lock() //先加入再启动定时器，定时器回调会等待锁
+	remoteCalls[seq] = rc
+	rc.timer = time.AfterFunc(time.Duration(timeout)*time.Millisecond, func() {
+		if takeRemoteCall(seq) != nil {
+			rc.callback(seq, nil, ErrCallTimeout)
+		}
+	})
+	remoteLock.Unlock()
+	err := send(seq)
+	if err != nil {
+		takeRemoteCall(seq)
+		rc.timer.Stop()
+	}
+	return err
+}
+
+//把结果投递到调用方的woker中执行回调
+//在定时器协程或者GateServer的协程中调用，调用方邮箱满时最多等待remoteWait，不能一直阻塞
+func (self *remoteCall) callback(seq int64, ret interface{}, err error) {
+	cb := func(igo IGoRoutine, data interface{}) interface{} {
+		self.done(ret, err)
+		return nil
+	}
+	done := make(chan struct{})
+	tm := time.AfterFunc(time.Duration(remoteWait)*time.Millisecond, func() { close(done) })
+	defer tm.Stop()
+	if e := self.caller.pushJob(ChannelContext{Cb: cb, Seq: seq}, done); e != nil {
+		llog.Warningf("remoteCall callback: caller[%s] %s, seq=%d", self.caller.Name, e.Error(), seq)
+	}
+}
+
+//收到其他节点发来的actor消息，由GateServer调用
+//@reply: 需要返回结果时不为nil，在目标actor处理完成后调用
+func DeliverRemote(addr ActorAddr, handler string, data interface{}, reply func(ret interface{}, err error)) error {
+	igo := MGR.GetActor(addr)
+	if igo == nil {
+		return ErrTargetNil
+	}
+	if igo.IsRunning() == false {
+		return ErrTargetNotRunning
+	}
+	ct := ChannelContext{Handler: handler, Data: M{Data: data, Flag: true}, Reply: reply}
+	return igo.pushJob(ct, nil)
+}
+
+//收到其他节点的返回结果，由GateServer调用
+func DeliverReply(seq int64, data interface{}, err error) {
+	rc := takeRemoteCall(seq)
+	if rc == nil { //已经超时或取消
+		llog.Debugf("DeliverReply: call has been abandoned, seq=%d", seq)
+		return
+	}
+	if rc.readChan != nil {
+		retctx := ChannelContext{Seq: seq, Err: err}
+		retctx.Data.Flag = true
+		retctx.Data.Data = data
+		rc.readChan <- retctx
+		return
+	}
+	rc.timer.Stop()
+	rc.callback(seq, data, err)
+}
@@ -0,0 +1,149 @@
+package gorpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseActorAddr(t *testing.T) {
+	valid := []struct {
+		str  string
+		addr ActorAddr
+	}{
+		{"3/Lobby", ActorAddr{NodeUid: 3, Name: "Lobby"}},
+		{"0/GateServer", ActorAddr{Name: "GateServer"}},
+		{"12/room/7", ActorAddr{NodeUid: 12, Name: "room", Id: 7}},
+	}
+	for _, c := range valid {
+		addr, err := ParseActorAddr(c.str)
+		if err != nil || addr != c.addr || addr.String() != c.str {
+			t.Fatalf("%s: %v %v", c.str, addr, err)
+		}
+	}
+	for _, str := range []string{"", "Lobby", "a/Lobby", "-1/Lobby", "3/", "3/room/0", "3/room/x", "3/room/-2", "1/2/3/4", "3//7"} {
+		if _, err := ParseActorAddr(str); err == nil {
+			t.Fatalf("%q parsed", str)
+		}
+	}
+}
+
+func TestRemoteError(t *testing.T) {
+	if RemoteError("") != nil {
+		t.Fatal("empty error")
+	}
+	for _, err := range []error{ErrCallTimeout, ErrMailboxFull, ErrTargetNil, ErrRemoteUnreachable} {
+		if RemoteError(err.Error()) != err {
+			t.Fatalf("%s not mapped", err.Error())
+		}
+	}
+	pe := &PanicError{Actor: "Lobby", Handler: "Login", Value: "boom"}
+	err := RemoteError(pe.Error())
+	if !errors.Is(err, ErrHandlerPanic) || err.Error() != pe.Error() {
+		t.Fatalf("panic: %v", err)
+	}
+	if err = RemoteError("db error"); err == nil || err.Error() != "db error" {
+		t.Fatalf("unknown: %v", err)
+	}
+}
+
+func TestCallRemote(t *testing.T) {
+	reply := func(data interface{}, err error) func(seq int64) error {
+		return func(seq int64) error {
+			go DeliverReply(seq, data, err)
+			return nil
+		}
+	}
+	if ret, err := CallRemote(nil, reply("pong", nil)); err != nil || ret != "pong" {
+		t.Fatalf("reply %v %v", ret, err)
+	}
+	if _, err := CallRemote(nil, reply(nil, RemoteError(ErrTargetNil.Error()))); err != ErrTargetNil {
+		t.Fatalf("remote error %v", err)
+	}
+	if _, err := CallRemote(nil, func(int64) error { return ErrRemoteUnreachable }); err != ErrRemoteUnreachable {
+		t.Fatalf("send error %v", err)
+	}
+
+	//超时后的返回被忽略
+	var seq int64
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := CallRemote(ctx, func(s int64) error { seq = s; return nil }); err != ErrCallTimeout {
+		t.Fatalf("timeout %v", err)
+	}
+	DeliverReply(seq, "late", nil)
+	if takeRemoteCall(seq) != nil {
+		t.Fatal("timeout call not removed")
+	}
+}
+
+func TestSendBackRemote(t *testing.T) {
+	caller := newTestLogic(MAILBOX_BLOCK, 4)
+	caller.Run()
+	defer caller.Close()
+	type result struct {
+		ret interface{}
+		err error
+	}
+	results := make(chan result, 2)
+	done := func(ret interface{}, err error) { results <- result{ret, err} }
+
+	var seq int64
+	if err := caller.SendBackRemote(func(s int64) error { seq = s; return nil }, 1000, done); err != nil {
+		t.Fatal(err)
+	}
+	DeliverReply(seq, "pong", nil)
+	if r := <-results; r.ret != "pong" || r.err != nil {
+		t.Fatalf("reply %v", r)
+	}
+
+	if err := caller.SendBackRemote(func(s int64) error { seq = s; return nil }, 20, done); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-results:
+		if r.err != ErrCallTimeout {
+			t.Fatalf("timeout %v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no timeout callback")
+	}
+	DeliverReply(seq, "late", nil)
+	select {
+	case r := <-results:
+		t.Fatalf("late reply %v", r)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := caller.SendBackRemote(func(int64) error { return ErrRemoteUnreachable }, 20, done); err != ErrRemoteUnreachable {
+		t.Fatalf("send error %v", err)
+	}
+}
+
+//调用方邮箱一直满时丢弃结果，不阻塞返回结果的协程
+func TestSendBackRemoteMailboxFull(t *testing.T) {
+	old := remoteWait
+	remoteWait = 20
+	defer func() { remoteWait = old }()
+	caller := newTestLogic(MAILBOX_BLOCK, 1)
+	caller.started = true //不启动woker，邮箱不会被处理
+	var seq int64
+	if err := caller.SendBackRemote(func(s int64) error { seq = s; return nil }, 1000, func(interface{}, error) {}); err != nil {
+		t.Fatal(err)
+	}
+	caller.pushJob(ChannelContext{Handler: "fill"}, nil)
+	delivered := make(chan struct{})
+	go func() {
+		DeliverReply(seq, "pong", nil)
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("DeliverReply blocked by full mailbox")
+	}
+	if caller.DroppedJobNumber() != 1 {
+		t.Fatalf("dropped %d", caller.DroppedJobNumber())
+	}
+}
@@ -0,0 +1,380 @@
+package gorpc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/util"
+)
+
+//重启策略
+const (
+	STRATEGY_ONE_FOR_ONE  = iota //只重启崩溃的actor
+	STRATEGY_ONE_FOR_ALL         //重启所有actor
+	STRATEGY_REST_FOR_ONE        //重启崩溃的actor和在它之后加入的actor
+)
+
+const (
+	SUPERVISOR_MAX_RESTARTS = 3    //默认重启强度，SUPERVISOR_PERIOD内最多重启次数
+	SUPERVISOR_PERIOD       = 5000 //默认重启强度统计窗口，毫秒
+	SUPERVISOR_STOP_TIMEOUT = 3000 //等待actor退出的超时时间，毫秒
+	SUPERVISOR_CHAN_LEN     = 128  //崩溃通知chan缓冲数量
+)
+
+//崩溃报告
+type CrashReport struct {
+	Supervisor string      //监督者名字
+	Actor      string      //崩溃的actor
+	Handler    string      //崩溃的处理函数，空代表woker本身
+	Reason     interface{} //panic的值
+	Stack      string      //调用栈
+	Time       int64       //崩溃时间，毫秒
+	Fatal      bool        //actor是否已经退出，false代表只是处理函数panic，actor还在运行
+	Restarted  []string    //被重启的actor
+	GiveUp     bool        //超过重启强度，放弃重启，交给上级监督者处理
+}
+
+func (self *CrashReport) String() string {
+	return fmt.Sprintf("supervisor=%s,actor=%s,handler=%s,reason=%v,fatal=%t,restarted=%v,giveup=%t",
+		self.Supervisor, self.Actor, self.Handler, self.Reason, self.Fatal, self.Restarted, self.GiveUp)
+}
+
+//被监督的对象，actor或者下级监督者
+type supervisedChild struct {
+	name string
+	igo  IGoRoutine
+	pool *GoRoutinePool //协程池中的actor
+	id   int64
+	sup  *Supervisor //下级监督者
+	gen  int         //每次重启加1，丢弃重启前的崩溃通知
+}
+
+type crashEvent struct {
+	child   *supervisedChild
+	gen     int
+	handler string
+	reason  interface{}
+	stack   string
+	fatal   bool
+}
+
+//监督者，actor的woker崩溃后按照重启策略重新执行DoInit/DoRegsiter/DoStart
+//监督者可以嵌套，超过重启强度后会停止所有actor并交给上级监督者重启
+//GoRoutineMgr.SetSupervisor和GoRoutinePool.SetSupervisor之后Start创建的actor自动加入监督
+type Supervisor struct {
+	Name                string
+	Strategy            int                       //重启策略STRATEGY_*
+	MaxRestarts         int                       //Period时间内最多重启次数
+	Period              int                       //重启强度统计窗口，毫秒
+	CrashOnHandlerPanic bool                      //处理函数panic是否视为actor崩溃，只对同步actor有效，需要在Supervise之前设置
+	OnCrash             func(report *CrashReport) //崩溃报告回调，在监督者协程中调用
+
+	children  []*supervisedChild
+	restarts  []int64
+	parent    *Supervisor
+	selfChild *supervisedChild //自己在上级监督者中的记录
+	crashChan chan *crashEvent
+	stopChan  chan struct{} //Stop后关闭
+	stopOnce  sync.Once
+	lock      sync.Mutex //保护children等数据，不在等待actor退出和启动actor时持有
+	restart   sync.Mutex //重启过程互斥，等待actor退出时只持有restart，不阻塞Supervise
+}
+
+//创建监督者
+//@name: 监督者名字
+//@strategy: 重启策略STRATEGY_*
+func NewSupervisor(name string, strategy int) *Supervisor {
+	sup := &Supervisor{Name: name, Strategy: strategy, MaxRestarts: SUPERVISOR_MAX_RESTARTS, Period: SUPERVISOR_PERIOD}
+	sup.crashChan = make(chan *crashEvent, SUPERVISOR_CHAN_LEN)
+	sup.stopChan = make(chan struct{})
+	go sup.run()
+	return sup
+}
+
+//监督通过GoRoutineMgr.Start创建的actor，重启顺序就是Supervise的调用顺序
+func (self *Supervisor) Supervise(igo IGoRoutine, name string) {
+	self.addChild(&supervisedChild{name: name, igo: igo})
+}
+
+//监督通过GoRoutinePool.Start创建的actor，actor被协程池关闭后自动解除监督
+func (self *Supervisor) SupervisePool(pool *GoRoutinePool, igo IGoRoutine, id int64) {
+	self.addChild(&supervisedChild{name: util.Itoa64(id), igo: igo, pool: pool, id: id})
+}
+
+//监督下级监督者
+func (self *Supervisor) SuperviseChild(sup *Supervisor) {
+	child := &supervisedChild{name: sup.Name, sup: sup}
+	sup.lock.Lock()
+	sup.parent = self
+	sup.selfChild = child
+	sup.lock.Unlock()
+	self.addChild(child)
+}
+
+//解除监督
+func (self *Supervisor) Unsupervise(name string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for i, child := range self.children {
+		if child.name == name {
+			self.detach(child)
+			self.children = append(self.children[:i], self.children[i+1:]...)
+			return
+		}
+	}
+}
+
+func (self *Supervisor) addChild(child *supervisedChild) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	//清理已经被协程池关闭的actor
+	alive := self.children[:0]
+	for _, old := range self.children {
+		if self.isAlive(old) {
+			alive = append(alive, old)
+		}
+	}
+	self.children = append(alive, child)
+	self.attach(child)
+}
+
+func (self *Supervisor) attach(child *supervisedChild) {
+	child.gen++
+	if child.sup != nil {
+		return
+	}
+	gen := child.gen
+	child.igo.setCrashFunc(func(handler string, reason interface{}, stack string, fatal bool) {
+		ev := &crashEvent{child: child, gen: gen, handler: handler, reason: reason, stack: stack, fatal: fatal}
+		if fatal {
+			select {
+			case self.crashChan <- ev:
+			case <-self.stopChan: //已经停止，不再重启
+			}
+			return
+		}
+		select {
+		case self.crashChan <- ev:
+		default:
+			llog.Warningf("Supervisor[%s] crash chan is full, drop report: %s.%s %v", self.Name, child.name, handler, reason)
+		}
+	}, self.CrashOnHandlerPanic)
+}
+
+func (self *Supervisor) detach(child *supervisedChild) {
+	child.gen++
+	if child.sup != nil {
+		child.sup.lock.Lock()
+		child.sup.parent = nil
+		child.sup.selfChild = nil
+		child.sup.lock.Unlock()
+	} else {
+		child.igo.setCrashFunc(nil, false)
+	}
+}
+
+func (self *Supervisor) isAlive(child *supervisedChild) bool {
+	if child.pool != nil {
+		return child.pool.GetRoutine(child.id) == child.igo
+	}
+	return true
+}
+
+func (self *Supervisor) run() {
+	for {
+		select {
+		case ev := <-self.crashChan:
+			self.handleCrash(ev)
+		case <-self.stopChan:
+			return
+		}
+	}
+}
+
+//停止监督者和下级监督者，之后崩溃的actor不再重启，正在进行的重启会先完成
+//不关闭actor，服务关闭时由GoRoutineMgr.CloseAll/CloseAllCleanly先调用
+func (self *Supervisor) Stop() {
+	self.stopOnce.Do(func() { close(self.stopChan) })
+	self.restart.Lock()
+	self.lock.Lock()
+	children := self.children
+	self.children = nil
+	for _, child := range children {
+		self.detach(child)
+	}
+	self.lock.Unlock()
+	self.restart.Unlock()
+	for _, child := range children {
+		if child.sup != nil {
+			child.sup.Stop()
+		}
+	}
+}
+
+func (self *Supervisor) handleCrash(ev *crashEvent) {
+	report := &CrashReport{Supervisor: self.Name, Actor: ev.child.name, Handler: ev.handler, Reason: ev.reason, Stack: ev.stack, Time: util.TimeStamp(), Fatal: ev.fatal}
+	if ev.fatal == false {
+		self.report(report)
+		return
+	}
+
+	self.restart.Lock()
+	self.lock.Lock()
+	if ev.gen != ev.child.gen { //重启之前的通知
+		self.lock.Unlock()
+		self.restart.Unlock()
+		return
+	}
+	index := self.indexOf(ev.child)
+	if index < 0 || self.isAlive(ev.child) == false { //已经解除监督
+		self.lock.Unlock()
+		self.restart.Unlock()
+		self.report(report)
+		return
+	}
+
+	if self.exceeded() {
+		report.GiveUp = true
+		children := append([]*supervisedChild(nil), self.children...)
+		parent, selfChild := self.parent, self.selfChild
+		self.lock.Unlock()
+		self.stopChildren(children)
+		self.restart.Unlock()
+		self.report(report)
+		if parent != nil && selfChild != nil {
+			select {
+			case parent.crashChan <- &crashEvent{child: selfChild, gen: selfChild.gen, reason: fmt.Sprintf("supervisor[%s] give up: %v", self.Name, ev.reason), fatal: true}:
+			case <-parent.stopChan:
+			}
+		}
+		return
+	}
+
+	var targets []*supervisedChild
+	switch self.Strategy {
+	case STRATEGY_ONE_FOR_ALL:
+		targets = self.children
+	case STRATEGY_REST_FOR_ONE:
+		targets = self.children[index:]
+	default:
+		targets = self.children[index : index+1]
+	}
+	targets = append([]*supervisedChild(nil), targets...)
+	self.lock.Unlock()
+	self.stopChildren(targets)
+	report.Restarted = self.startChildren(targets)
+	self.restart.Unlock()
+	self.report(report)
+}
+
+func (self *Supervisor) indexOf(child *supervisedChild) int {
+	for i, c := range self.children {
+		if c == child {
+			return i
+		}
+	}
+	return -1
+}
+
+//是否超过重启强度，没有超过则记录本次重启
+func (self *Supervisor) exceeded() bool {
+	now := util.TimeStamp()
+	valid := self.restarts[:0]
+	for _, tm := range self.restarts {
+		if now-tm < int64(self.Period) {
+			valid = append(valid, tm)
+		}
+	}
+	self.restarts = valid
+	if len(self.restarts) >= self.MaxRestarts {
+		return true
+	}
+	self.restarts = append(self.restarts, now)
+	return false
+}
+
+//逆序停止，持有restart，不持有lock
+func (self *Supervisor) stopChildren(children []*supervisedChild) {
+	for i := len(children) - 1; i >= 0; i-- {
+		child := children[i]
+		self.lock.Lock()
+		child.gen++
+		self.lock.Unlock()
+		if child.sup != nil {
+			child.sup.stopAll()
+			continue
+		}
+		igo := child.igo
+		if igo.IsRunning() {
+			igo.DoDestory()
+			igo.Close()
+		}
+		if igo.waitStop(SUPERVISOR_STOP_TIMEOUT*time.Millisecond) == false {
+			llog.Warningf("Supervisor[%s] wait actor[%s] stop timeout", self.Name, child.name)
+		}
+	}
+}
+
+//顺序启动，返回启动成功的actor，持有restart，不持有lock
+func (self *Supervisor) startChildren(children []*supervisedChild) (started []string) {
+	for _, child := range children {
+		self.lock.Lock()
+		if self.indexOf(child) < 0 { //停止期间解除了监督
+			self.lock.Unlock()
+			continue
+		}
+		if child.sup == nil {
+			child.igo.reset()
+		}
+		self.attach(child)
+		self.lock.Unlock()
+		if child.sup != nil {
+			child.sup.startAll()
+			started = append(started, child.name)
+			continue
+		}
+		igo := child.igo
+		if igo.DoInit() == false {
+			llog.Errorf("Supervisor[%s] restart actor[%s] DoInit failed", self.Name, child.name)
+			continue
+		}
+		igo.SetInited(true)
+		igo.DoRegsiter()
+		igo.Run()
+		igo.DoStart()
+		started = append(started, child.name)
+	}
+	return
+}
+
+func (self *Supervisor) stopAll() {
+	self.restart.Lock()
+	self.lock.Lock()
+	children := append([]*supervisedChild(nil), self.children...)
+	self.lock.Unlock()
+	self.stopChildren(children)
+	self.restart.Unlock()
+}
+
+func (self *Supervisor) startAll() {
+	self.restart.Lock()
+	self.lock.Lock()
+	self.restarts = self.restarts[:0]
+	children := append([]*supervisedChild(nil), self.children...)
+	self.lock.Unlock()
+	self.startChildren(children)
+	self.restart.Unlock()
+}
+
+func (self *Supervisor) report(report *CrashReport) {
+	if report.Fatal {
+		llog.Errorf("Supervisor crash report: %s", report.String())
+	} else {
+		llog.Warningf("Supervisor crash report: %s", report.String())
+	}
+	if self.OnCrash != nil {
+		self.OnCrash(report)
+	}
+}
@@ -0,0 +1,267 @@
+package gorpc
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//处理函数crash会panic，设置了destroy时DoDestory等待destroy关闭
+type crashActor struct {
+	GoRoutineLogic
+	inits      int32
+	started    chan string
+	destroying chan struct{} //开始DoDestory
+	destroy    chan struct{}
+	once       sync.Once
+}
+
+func newCrashActor(started chan string) *crashActor {
+	return &crashActor{started: started}
+}
+
+func (self *crashActor) DoInit() bool {
+	atomic.AddInt32(&self.inits, 1)
+	return true
+}
+
+func (self *crashActor) DoRegsiter() {
+	self.Register("crash", func(IGoRoutine, interface{}) interface{} { panic("crash") })
+}
+
+func (self *crashActor) DoStart() {
+	self.started <- self.Name
+}
+
+func (self *crashActor) DoDestory() {
+	if self.destroy != nil {
+		self.once.Do(func() { close(self.destroying) })
+		<-self.destroy
+	}
+}
+
+func newTestSupervisor(strategy int) (*Supervisor, chan *CrashReport) {
+	reports := make(chan *CrashReport, 8)
+	sup := NewSupervisor("test", strategy)
+	sup.CrashOnHandlerPanic = true
+	sup.OnCrash = func(report *CrashReport) { reports <- report }
+	return sup, reports
+}
+
+func waitReport(t *testing.T, reports chan *CrashReport) *CrashReport {
+	select {
+	case report := <-reports:
+		return report
+	case <-time.After(time.Second):
+		t.Fatal("no crash report")
+	}
+	return nil
+}
+
+func drain(started chan string) (names []string) {
+	for {
+		select {
+		case name := <-started:
+			names = append(names, name)
+		default:
+			return
+		}
+	}
+}
+
+func TestSupervisorStrategy(t *testing.T) {
+	for strategy, want := range map[int][]string{
+		STRATEGY_ONE_FOR_ONE:  {"b"},
+		STRATEGY_ONE_FOR_ALL:  {"a", "b", "c"},
+		STRATEGY_REST_FOR_ONE: {"b", "c"},
+	} {
+		sup, reports := newTestSupervisor(strategy)
+		mgr := NewGoRoutineMgr()
+		mgr.SetSupervisor(sup)
+		started := make(chan string, 16)
+		actors := map[string]*crashActor{}
+		for _, name := range []string{"a", "b", "c"} {
+			actors[name] = newCrashActor(started)
+			mgr.Start(actors[name], name)
+			if name != "a" {
+				mgr.SetDepends(name, "a")
+			}
+		}
+		mgr.DoStart()
+		drain(started)
+
+		actors["b"].Send("crash", nil)
+		report := waitReport(t, reports)
+		if !report.Fatal || report.GiveUp || report.Actor != "b" || report.Handler != "crash" || !reflect.DeepEqual(report.Restarted, want) {
+			t.Fatalf("strategy %d: %s", strategy, report.String())
+		}
+		if names := drain(started); !reflect.DeepEqual(names, want) {
+			t.Fatalf("strategy %d: restart order %v", strategy, names)
+		}
+		for name, actor := range actors {
+			if !actor.IsRunning() {
+				t.Fatalf("strategy %d: %s not running", strategy, name)
+			}
+		}
+		mgr.CloseAll()
+	}
+}
+
+func TestSupervisorGiveUp(t *testing.T) {
+	sup, reports := newTestSupervisor(STRATEGY_ONE_FOR_ONE)
+	sup.MaxRestarts = 1
+	mgr := NewGoRoutineMgr()
+	mgr.SetSupervisor(sup)
+	started := make(chan string, 16)
+	a, b := newCrashActor(started), newCrashActor(started)
+	mgr.Start(a, "a")
+	mgr.Start(b, "b")
+	mgr.DoStart()
+
+	a.Send("crash", nil)
+	if report := waitReport(t, reports); report.GiveUp {
+		t.Fatalf("first crash: %s", report.String())
+	}
+	a.Send("crash", nil)
+	if report := waitReport(t, reports); !report.GiveUp || len(report.Restarted) != 0 {
+		t.Fatalf("second crash: %s", report.String())
+	}
+	if a.IsRunning() || b.IsRunning() || atomic.LoadInt32(&a.inits) != 2 {
+		t.Fatalf("running %v %v, inits %d", a.IsRunning(), b.IsRunning(), a.inits)
+	}
+}
+
+func TestSupervisorPool(t *testing.T) {
+	sup, reports := newTestSupervisor(STRATEGY_ONE_FOR_ONE)
+	pool := &GoRoutinePool{}
+	pool.Init()
+	pool.SetSupervisor(sup)
+	started := make(chan string, 16)
+	actor := newCrashActor(started)
+	pool.DoSingleStart(actor, 7, true)
+	actor.Send("crash", nil)
+	if report := waitReport(t, reports); !reflect.DeepEqual(report.Restarted, []string{"7"}) {
+		t.Fatalf("pool crash: %s", report.String())
+	}
+
+	//被协程池关闭后解除监督
+	pool.CloseRoutine(7)
+	other := newCrashActor(started)
+	pool.DoSingleStart(other, 8, true)
+	sup.lock.Lock()
+	n := len(sup.children)
+	sup.lock.Unlock()
+	if n != 1 {
+		t.Fatalf("children %d", n)
+	}
+	pool.CloseAll()
+}
+
+//等待actor退出时不持有lock，Supervise和Unsupervise不会被阻塞
+func TestSupervisorStopUnlocked(t *testing.T) {
+	sup, reports := newTestSupervisor(STRATEGY_ONE_FOR_ALL)
+	mgr := NewGoRoutineMgr()
+	mgr.SetSupervisor(sup)
+	started := make(chan string, 16)
+	a, b := newCrashActor(started), newCrashActor(started)
+	mgr.Start(a, "a")
+	mgr.Start(b, "b")
+	mgr.DoStart()
+	b.destroying, b.destroy = make(chan struct{}), make(chan struct{})
+
+	a.Send("crash", nil)
+	select {
+	case <-b.destroying:
+	case <-time.After(time.Second):
+		t.Fatal("b not stopped")
+	}
+	done := make(chan struct{})
+	go func() {
+		c := newCrashActor(started)
+		c.init("c")
+		sup.Supervise(c, "c")
+		sup.Unsupervise("c")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Supervise blocked by restart")
+	}
+	close(b.destroy)
+	if report := waitReport(t, reports); !reflect.DeepEqual(report.Restarted, []string{"a", "b"}) {
+		t.Fatalf("restart: %s", report.String())
+	}
+}
+
+func TestSupervisorStop(t *testing.T) {
+	sup, reports := newTestSupervisor(STRATEGY_ONE_FOR_ONE)
+	child, _ := newTestSupervisor(STRATEGY_ONE_FOR_ONE)
+	sup.SuperviseChild(child)
+	mgr := NewGoRoutineMgr()
+	mgr.SetSupervisor(sup)
+	started := make(chan string, 16)
+	a, b := newCrashActor(started), newCrashActor(started)
+	mgr.Start(a, "a")
+	mgr.DoStart()
+	b.init("b")
+	child.Supervise(b, "b")
+	b.Run()
+	drain(started)
+
+	//停止后解除监督，panic和没有监督时一样被恢复，不再上报和重启，下级监督者一起停止
+	sup.Stop()
+	sup.Stop()
+	a.Send("crash", nil)
+	b.Send("crash", nil)
+	select {
+	case report := <-reports:
+		t.Fatalf("report after stop: %s", report.String())
+	case <-time.After(50 * time.Millisecond):
+	}
+	if names := drain(started); len(names) != 0 || !a.IsRunning() || !b.IsRunning() {
+		t.Fatalf("restarted after stop: %v", names)
+	}
+	select {
+	case <-child.stopChan:
+	default:
+		t.Fatal("child supervisor not stopped")
+	}
+}
+
+//关闭所有服务时先停止监督者，等待正在进行的重启完成
+func TestSupervisorStopOnClose(t *testing.T) {
+	sup, reports := newTestSupervisor(STRATEGY_ONE_FOR_ALL)
+	mgr := NewGoRoutineMgr()
+	mgr.SetSupervisor(sup)
+	started := make(chan string, 16)
+	a, b := newCrashActor(started), newCrashActor(started)
+	mgr.Start(a, "a")
+	mgr.Start(b, "b")
+	mgr.DoStart()
+	b.destroying, b.destroy = make(chan struct{}), make(chan struct{})
+
+	a.Send("crash", nil)
+	<-b.destroying
+	closed := make(chan bool)
+	go func() { closed <- mgr.CloseAllCleanly(1000) }()
+	select {
+	case <-closed:
+		t.Fatal("closed during restart")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(b.destroy)
+	if report := waitReport(t, reports); len(report.Restarted) != 2 {
+		t.Fatalf("restart: %s", report.String())
+	}
+	if !<-closed || a.IsRunning() || b.IsRunning() {
+		t.Fatal("not closed after restart")
+	}
+	select {
+	case <-sup.stopChan:
+	default:
+		t.Fatal("supervisor not stopped")
+	}
+}
@@ -44,9 +44,9 @@ func recvPackMsg(igo gorpc.IGoRoutine, data interface{}) interface{} {
 	handler, ok := handler_Map[name]
 	if ok {
 		if handler == This.Name {
-			cb, ok := This.NetHandler[name]
+			_, ok := This.NetHandler[name]
 			if ok {
-				cb(This, socketid, pm)
+				This.CallNetFunc(&gorpc.M{Id: socketid, Name: name, Data: pm})
 			} else {
 				llog.Errorf("KcpGateServer recvPackMsg[%s] handler is nil: %s", name, This.Name)
 			}
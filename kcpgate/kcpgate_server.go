@@ -32,6 +32,23 @@ func (self *KcpGateServer) DoInit() bool {
 	self.pService.BindPacketFunc(packetFunc)
 	self.pService.SetConnectType(network.CLIENT_CONNECT)
 	self.pService.SetMaxClients(config.NET_MAX_CONNS)
+	if config.NET_CRYPTO != nil {
+		crypto, err := network.NewCryptoConfig(config.NET_CRYPTO)
+		if err != nil {
+			llog.Errorf("KcpGateServer: %s", err.Error())
+			return false
+		}
+		self.pService.SetCrypto(crypto)
+	}
+	if config.NET_COMPRESS != nil {
+		comp, err := network.NewCompressConfig(config.NET_COMPRESS)
+		if err != nil {
+			llog.Errorf("KcpGateServer: %s", err.Error())
+			return false
+		}
+		self.pService.SetCompress(comp)
+	}
+	self.pService.SetSequence(config.NET_SEQUENCE)
 
 	if self.InitFunc != nil {
 		self.InitFunc()
@@ -92,9 +109,9 @@ func packetFunc(socketid int, buff []byte, nlen int) bool {
 	handler, ok := handler_Map[name]
 	if ok {
 		if handler == This.Name {
-			cb, ok := This.NetHandler[name]
+			_, ok := This.NetHandler[name]
 			if ok {
-				cb(This, socketid, pm)
+				This.CallNetFunc(&gorpc.M{Id: socketid, Name: name, Data: pm})
 			} else {
 				llog.Errorf("KcpGateServer packetFunc[%s] handler is nil: %s", name, This.Name)
 			}
@@ -1,17 +1,24 @@
 package loumiao
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"reflect"
 	"runtime"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/define"
 	"github.com/snowyyj001/loumiao/gorpc"
 	"github.com/snowyyj001/loumiao/llog"
 	"github.com/snowyyj001/loumiao/message"
 	"github.com/snowyyj001/loumiao/msg"
+	"github.com/snowyyj001/loumiao/util"
 	"github.com/snowyyj001/loumiao/util/timer"
 )
 
@@ -24,22 +31,25 @@ func DoInit() {
 
 func init() {
 	DoInit()
+	gorpc.ClientReplier = SendClient
 }
 
 //创建一个服务，稍后开启
 //@name: actor名，唯一
 //@sync: 是否异步协程，无状态服务可以是异步协程，有状态服务应该使用同步协程，可以保证协程安全
-func Prepare(igo gorpc.IGoRoutine, name string, sync bool) {
+//@deps: 依赖的服务，依赖的服务先启动后关闭
+func Prepare(igo gorpc.IGoRoutine, name string, sync bool, deps ...string) {
 	igo.SetSync(sync)
-	gorpc.MGR.Start(igo, name)
+	gorpc.MGR.Start(igo, name, deps...)
 	igo.Register("ServiceHandler", gorpc.ServiceHandler)
 }
 
 //创建一个服务,立即开启
 //@name: actor名，唯一
 //@sync: 是否异步协程，无状态服务可以是异步协程，有状态服务应该使用同步协程，可以保证协程安全
-func Start(igo gorpc.IGoRoutine, name string, sync bool) {
-	Prepare(igo, name, sync)
+//@deps: 依赖的服务，必须已经启动
+func Start(igo gorpc.IGoRoutine, name string, sync bool, deps ...string) {
+	Prepare(igo, name, sync, deps...)
 	gorpc.MGR.DoSingleStart(name)
 }
 
@@ -48,30 +58,73 @@ func Run() {
 
 	gorpc.MGR.DoStart()
 
+	if config.NET_METRICS_SADDR != "" {
+		serveMetrics(config.NET_METRICS_SADDR)
+	}
+
 	timer.DelayJob(1000, func() {
-		igo := gorpc.MGR.GetRoutine("GateServer")
-		if igo != nil {
-			igo.DoOpen()
-			llog.Infof("loumiao start success: %s", config.SERVER_NAME)
-		}
+		startSingletons() //GateServer启动后etcd才连接
+		gorpc.MGR.DoOpen()
+		llog.Infof("loumiao start success: %s", config.SERVER_NAME)
 	}, true)
 
 	c = make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill, syscall.SIGTERM)
 	sig := <-c
 	llog.Infof("loumiao closing down (signal: %v)", sig)
+	stopSingletons() //尽早让其他节点接替
 
-	gorpc.MGR.CloseAll()
+	if config.GAME_DRAIN_TIME > 0 && drain() {
+		gorpc.MGR.CloseAllCleanly(config.GAME_DRAIN_FLUSH_TIME, "GateServer")
+	} else {
+		gorpc.MGR.CloseAll()
+	}
 
 	llog.Infof("loumiao done !")
 }
 
+//开启prometheus统计的http接口
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", gorpc.MetricsHandler)
+	go func() {
+		llog.Infof("loumiao metrics listen: %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			llog.Errorf("loumiao metrics ListenAndServe: %v", err)
+		}
+	}()
+}
+
+//排空节点，在GAME_DRAIN_TIME内不再接受新的登录和rpc，通知已连接的用户重新登录
+//排空期间再次收到信号会直接关闭，返回false
+func drain() bool {
+	if gorpc.MGR.GetRoutine("GateServer") == nil {
+		return true
+	}
+	deadline := util.TimeStamp() + int64(config.GAME_DRAIN_TIME)
+	llog.Infof("loumiao draining: %d ms", config.GAME_DRAIN_TIME)
+	gorpc.MGR.Send("GateServer", "Drain", &gorpc.M{Data: deadline, Flag: true})
+	select {
+	case <-time.After(time.Duration(config.GAME_DRAIN_TIME) * time.Millisecond):
+		return true
+	case sig := <-c:
+		llog.Infof("loumiao drain interrupted (signal: %v)", sig)
+		return false
+	}
+}
+
 //关闭游戏
 func Stop() {
 	llog.Info("loumiao stop the server !")
 	c <- os.Kill
 }
 
+//注册全局拦截器，包装所有actor的处理函数和网络消息处理函数，必须在Run之前调用
+//用于鉴权，限流，日志，统计等，单个actor的拦截器使用igo.Use
+func Use(ics ...gorpc.Interceptor) {
+	gorpc.Use(ics...)
+}
+
 //注册网络消息,对于内部server节点来说HanlderNetFunc的第二个参数clientid就是userid
 func RegisterNetHandler(igo gorpc.IGoRoutine, name string, call gorpc.HanlderNetFunc) {
 	gorpc.MGR.Send("GateServer", "RegisterNet", &gorpc.M{Id: 0, Name: name, Data: igo.GetName()})
@@ -135,13 +188,36 @@ func RpcFuncName(call gorpc.HanlderNetFunc) string {
 }
 
 //注册rpc消息
-func RegisterRpcHandler(igo gorpc.IGoRoutine, call gorpc.HanlderNetFunc) {
+//@balance: 可选，gate选择目标server的策略define.RPC_BALANCE_*，默认随机
+func RegisterRpcHandler(igo gorpc.IGoRoutine, call gorpc.HanlderNetFunc, balance ...int) {
 	funcName := RpcFuncName(call)
 	//base64str := base64.StdEncoding.EncodeToString([]byte(funcName))
-	gorpc.MGR.Send("GateServer", "RegisterNet", &gorpc.M{Id: -1, Name: funcName, Data: igo.GetName()})
+	gorpc.MGR.Send("GateServer", "RegisterNet", &gorpc.M{Id: -1, Name: funcName, Data: igo.GetName(), Param: rpcBalance(balance)})
 	igo.RegisterGate(funcName, call)
 }
 
+//注册可以返回结果的rpc消息，返回值会作为CallRpc/SendRpcBack的结果，SendRpc也可以调用
+//返回值应该是nil、[]byte或者message注册的pb或json结构体
+//@balance: 同RegisterRpcHandler
+func RegisterRpcCallHandler(igo gorpc.IGoRoutine, call gorpc.HanlderRpcFunc, balance ...int) {
+	funcName := runtime.FuncForPC(reflect.ValueOf(call).Pointer()).Name()
+	gorpc.MGR.Send("GateServer", "RegisterNet", &gorpc.M{Id: -1, Name: funcName, Data: igo.GetName(), Param: rpcBalance(balance)})
+	igo.RegisterGate(funcName, func(igo gorpc.IGoRoutine, sourceId int, data interface{}) {
+		call(igo, sourceId, data)
+	})
+	igo.Register(funcName, func(igo gorpc.IGoRoutine, data interface{}) interface{} {
+		m := data.(*gorpc.M)
+		return call(igo, m.Id, m.Data)
+	})
+}
+
+func rpcBalance(balance []int) int {
+	if len(balance) > 0 {
+		return balance[0]
+	}
+	return define.RPC_BALANCE_NONE
+}
+
 func UnRegisterRpcHandler(igo gorpc.IGoRoutine, call gorpc.HanlderNetFunc) {
 	funcName := RpcFuncName(call)
 	gorpc.MGR.Send("GateServer", "UnRegisterNet", &gorpc.M{Id: -1, Name: funcName})
@@ -166,6 +242,71 @@ func SendRpc(funcName string, data interface{}, target int) {
 	gorpc.MGR.Send("GateServer", "SendRpc", m)
 }
 
+//远程rpc阻塞调用，等待目标server的返回结果
+//@funcName: rpc函数，目标server需要用RegisterRpcCallHandler注册
+//@data: 同SendRpc
+//@target: 同SendRpc
+//@timeout: 超时时间，毫秒，0代表使用gorpc.CALL_TIMEOUT
+func CallRpc(funcName string, data interface{}, target int, timeout int) (interface{}, error) {
+	if timeout <= 0 {
+		timeout = gorpc.CALL_TIMEOUT * 1000
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+	return gorpc.CallRemote(ctx, func(seq int64) error {
+		return sendRpcCall(funcName, data, target, seq)
+	})
+}
+
+//远程rpc调用，返回结果在igo的woker中回调
+//@igo: 调用方actor
+//@timeout: 超时时间，毫秒，0代表使用gorpc.CALL_TIMEOUT，超时后cb收到gorpc.ErrCallTimeout
+//@cb: 回调
+func SendRpcBack(igo gorpc.IGoRoutine, funcName string, data interface{}, target int, timeout int, cb func(resp interface{}, err error)) error {
+	if timeout <= 0 {
+		timeout = gorpc.CALL_TIMEOUT * 1000
+	}
+	return igo.SendBackRemote(func(seq int64) error {
+		return sendRpcCall(funcName, data, target, seq)
+	}, timeout, cb)
+}
+
+func sendRpcCall(funcName string, data interface{}, target int, seq int64) error {
+	llog.Debugf("CallRpc: %s, %d, seq=%d", funcName, target, seq)
+	return sendRpcMsg(&msg.LouMiaoRpcMsg{TargetId: int64(target), FuncName: funcName, Seq: seq}, data)
+}
+
+//远程rpc调用，指定本次调用的目标选择策略
+//@funcName: rpc函数
+//@data: 同SendRpc
+//@balance: 目标选择策略define.RPC_BALANCE_*
+//@key: define.RPC_BALANCE_HASH的key，例如userid，相同的key总是发给同一个server，0时按轮询选择
+func SendRpcBalance(funcName string, data interface{}, balance int, key int64) {
+	llog.Debugf("SendRpcBalance: %s, %d, %d", funcName, balance, key)
+	err := sendRpcMsg(&msg.LouMiaoRpcMsg{FuncName: funcName, Balance: int32(balance), HashKey: key}, data)
+	if err != nil {
+		llog.Errorf("SendRpcBalance: %s", err.Error())
+	}
+}
+
+func sendRpcMsg(req *msg.LouMiaoRpcMsg, data interface{}) error {
+	if data == nil {
+		return fmt.Errorf("sendRpcMsg data is nil: %s", req.FuncName)
+	}
+	req.SourceId = int64(config.SERVER_NODE_UID)
+	if buff, ok := data.([]byte); ok { //bitstream
+		req.Buffer = buff
+		req.ByteBuffer = 1
+	} else {
+		buff, n := message.Encode(int(req.TargetId), "", data)
+		if buff == nil {
+			return fmt.Errorf("sendRpcMsg encode error: %s", req.FuncName)
+		}
+		req.Buffer = buff[:n]
+	}
+	return gorpc.MGR.Send("GateServer", "SendRpcMsg", &gorpc.M{Data: req})
+}
+
 //远程rpc消息广播调用-*********还没测试
 //@funcName: rpc函数
 //@data: 函数参数,如果data是[]byte类型，则代表使用bitstream或自定义二进制内容，否则data应该是一个messgae注册的pb或json结构体
@@ -194,14 +335,36 @@ func SendGate(clientid int, data interface{}) {
 }
 
 //全局通用发送actor消息
-//@actorName: 目标actor的名字
+//@actorName: 目标actor的名字，或者集群actor地址"nodeUid/actorName","nodeUid/poolName/actorId"
 //@actorHandler: 目标actor的处理函数
-//@data: 函数参数
+//@data: 函数参数，发往其他节点时必须是[]byte或者message注册过的结构体
 func SendAcotr(actorName string, actorHandler string, data interface{}) {
+	if strings.Contains(actorName, "/") {
+		addr, err := gorpc.ParseActorAddr(actorName)
+		if err != nil {
+			llog.Errorf("SendAcotr: %s", err.Error())
+			return
+		}
+		gorpc.MGR.SendTo(addr, actorHandler, data)
+		return
+	}
 	m := &gorpc.M{Data: data, Flag: true}
 	gorpc.MGR.Send(actorName, actorHandler, m)
 }
 
+//全局通用actor阻塞调用，本节点或者其他节点
+//@ctx: 调用上下文，控制超时和取消
+//@actorAddr: 集群actor地址"nodeUid/actorName","nodeUid/poolName/actorId"
+//@actorHandler: 目标actor的处理函数
+//@data: 函数参数，发往其他节点时必须是[]byte或者message注册过的结构体
+func CallActor(ctx context.Context, actorAddr string, actorHandler string, data interface{}) (interface{}, error) {
+	addr, err := gorpc.ParseActorAddr(actorAddr)
+	if err != nil {
+		return nil, err
+	}
+	return gorpc.MGR.CallTo(ctx, addr, actorHandler, data)
+}
+
 //主动绑定关于client的gate信息，目前server在收到client的消息包后会自动绑定，并不需要手动绑定，
 //除非需要在收到client消息之前就要发消息给client，这种情况目前没有。
 //world通知其他server关于client的gate信息,其他server只有知道了client属于哪个gate才能发送消息给client
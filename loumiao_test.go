@@ -0,0 +1,22 @@
+package loumiao
+
+import (
+	"testing"
+
+	"github.com/snowyyj001/loumiao/define"
+	"github.com/snowyyj001/loumiao/gorpc"
+)
+
+//nil参数返回错误，不会panic
+func TestSendRpcNil(t *testing.T) {
+	_, err := CallRpc("Login", nil, 1, 100)
+	if err == nil || err == gorpc.ErrCallTimeout {
+		t.Fatalf("nil data: %v", err)
+	}
+	SendRpcBalance("Login", nil, define.RPC_BALANCE_HASH, 1)
+
+	//没有GateServer时[]byte参数在投递时才失败
+	if _, err = CallRpc("Login", []byte{1, 2, 3}, 1, 100); err != gorpc.ErrTargetNil {
+		t.Fatalf("bitstream: %v", err)
+	}
+}
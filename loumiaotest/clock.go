@@ -0,0 +1,121 @@
+package loumiaotest
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/snowyyj001/loumiao/util"
+)
+
+//虚拟时钟，时间只在Advance/Step时前进，定时器在调用方协程中按触发时间顺序同步执行
+type VirtualClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	seq    int64
+	timers timerHeap
+}
+
+type virtualTimer struct {
+	clock *VirtualClock
+	when  time.Time
+	seq   int64 //同一时间触发的定时器按创建顺序执行
+	f     func()
+	index int //在堆中的下标，-1代表已经触发或停止
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (self *VirtualClock) Now() time.Time {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.now
+}
+
+func (self *VirtualClock) AfterFunc(d time.Duration, f func()) util.ClockTimer {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if d < 0 {
+		d = 0
+	}
+	self.seq++
+	t := &virtualTimer{clock: self, when: self.now.Add(d), seq: self.seq, f: f}
+	heap.Push(&self.timers, t)
+	return t
+}
+
+func (self *virtualTimer) Stop() bool {
+	self.clock.lock.Lock()
+	defer self.clock.lock.Unlock()
+	if self.index < 0 {
+		return false
+	}
+	heap.Remove(&self.clock.timers, self.index)
+	return true
+}
+
+//执行until之前最早的一个定时器，时间前进到它的触发时间，没有可执行的定时器返回false
+func (self *VirtualClock) Step(until time.Time) bool {
+	self.lock.Lock()
+	if len(self.timers) == 0 || self.timers[0].when.After(until) {
+		self.lock.Unlock()
+		return false
+	}
+	t := heap.Pop(&self.timers).(*virtualTimer)
+	if t.when.After(self.now) {
+		self.now = t.when
+	}
+	self.lock.Unlock()
+	t.f() //不持有锁，回调中可以创建新的定时器
+	return true
+}
+
+//时间前进d，期间到期的定时器按顺序执行
+func (self *VirtualClock) Advance(d time.Duration) {
+	until := self.Now().Add(d)
+	for self.Step(until) {
+	}
+	self.lock.Lock()
+	if until.After(self.now) {
+		self.now = until
+	}
+	self.lock.Unlock()
+}
+
+//等待中的定时器数量
+func (self *VirtualClock) Pending() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return len(self.timers)
+}
+
+type timerHeap []*virtualTimer
+
+func (self timerHeap) Len() int { return len(self) }
+func (self timerHeap) Less(i, j int) bool {
+	if self[i].when.Equal(self[j].when) {
+		return self[i].seq < self[j].seq
+	}
+	return self[i].when.Before(self[j].when)
+}
+func (self timerHeap) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+	self[i].index = i
+	self[j].index = j
+}
+func (self *timerHeap) Push(x interface{}) {
+	t := x.(*virtualTimer)
+	t.index = len(*self)
+	*self = append(*self, t)
+}
+func (self *timerHeap) Pop() interface{} {
+	old := *self
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*self = old[:n-1]
+	return t
+}
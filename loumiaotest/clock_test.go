@@ -0,0 +1,49 @@
+package loumiaotest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtualClock(t *testing.T) {
+	clock := NewVirtualClock(DefaultStartTime)
+	var order []string
+	at := func(name string) func() {
+		return func() {
+			order = append(order, name+"@"+clock.Now().Sub(DefaultStartTime).String())
+		}
+	}
+	clock.AfterFunc(2*time.Second, at("b"))
+	clock.AfterFunc(time.Second, at("a1"))
+	clock.AfterFunc(time.Second, at("a2")) //同一时间按创建顺序
+	stopped := clock.AfterFunc(time.Second, at("x"))
+	clock.AfterFunc(1500*time.Millisecond, func() {
+		at("c")()
+		clock.AfterFunc(0, at("d")) //回调中创建的定时器在同一次Advance中执行
+	})
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Stop")
+	}
+	if clock.Pending() != 4 {
+		t.Fatalf("pending %d", clock.Pending())
+	}
+
+	clock.Advance(1500 * time.Millisecond)
+	want := []string{"a1@1s", "a2@1s", "c@1.5s", "d@1.5s"}
+	if len(order) != len(want) {
+		t.Fatalf("order %v", order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order %v", order)
+		}
+	}
+	if clock.Now().Sub(DefaultStartTime) != 1500*time.Millisecond || clock.Pending() != 1 {
+		t.Fatalf("now %v, pending %d", clock.Now(), clock.Pending())
+	}
+
+	clock.Advance(time.Hour)
+	if order[len(order)-1] != "b@2s" || clock.Now().Sub(DefaultStartTime) != time.Hour+1500*time.Millisecond {
+		t.Fatalf("order %v, now %v", order, clock.Now())
+	}
+}
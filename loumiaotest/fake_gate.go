@@ -0,0 +1,174 @@
+package loumiaotest
+
+import (
+	"sync"
+
+	"github.com/snowyyj001/loumiao/gorpc"
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/message"
+	"github.com/snowyyj001/loumiao/msg"
+)
+
+//发往gate的一条消息
+type Outgoing struct {
+	Handler   string      //GateServer的处理函数，例如"SendClient","SendRpc","SendRpcMsg"
+	ClientIds []int       //SendClient/SendMulClient的目标userid，SendMulClient为空代表全服
+	Target    int         //SendRpc/SendGate的目标uid，BroadCastRpc的目标类型
+	FuncName  string      //rpc函数
+	Seq       int64       //CallRpc/SendRpcBack的关联id
+	Data      interface{} //解码后的消息结构体，二进制rpc是[]byte
+}
+
+//rpc调用的模拟返回
+type RpcResponder func(sourceId int, data interface{}) (interface{}, error)
+
+//代替GateServer的actor，记录发出的消息，按handler_Map把模拟的网络消息交给注册的actor
+type fakeGate struct {
+	gorpc.GoRoutineLogic
+
+	lock       sync.Mutex
+	handlerMap map[string]string //消息名或rpc函数 -> actor名
+	sent       []Outgoing
+	responders map[string]RpcResponder
+}
+
+func (self *fakeGate) DoInit() bool {
+	self.handlerMap = make(map[string]string)
+	self.responders = make(map[string]RpcResponder)
+	return true
+}
+
+func (self *fakeGate) DoRegsiter() {
+	self.Register("RegisterNet", self.registerNet)
+	self.Register("UnRegisterNet", self.unRegisterNet)
+	self.Register("SendClient", self.sendClient)
+	self.Register("SendMulClient", self.sendMulClient)
+	self.Register("SendRpc", self.sendRpc)
+	self.Register("BroadCastRpc", self.broadCastRpc)
+	self.Register("SendGate", self.sendGate)
+	self.Register("BindGate", self.bindGate)
+	self.Register("SendRpcMsg", self.sendRpcMsg)
+}
+
+func (self *fakeGate) record(out Outgoing) {
+	self.lock.Lock()
+	self.sent = append(self.sent, out)
+	self.lock.Unlock()
+}
+
+func (self *fakeGate) handler(name string) (string, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	actor, ok := self.handlerMap[name]
+	return actor, ok
+}
+
+func (self *fakeGate) responder(funcName string) RpcResponder {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.responders[funcName]
+}
+
+//解码loumiao.SendClient等编码过的消息
+func decodePacket(buff interface{}) interface{} {
+	data, ok := buff.([]byte)
+	if !ok {
+		return buff
+	}
+	err, _, _, pm := message.Decode(0, data, len(data))
+	if err != nil {
+		llog.Errorf("fakeGate decode error: %s", err.Error())
+		return data
+	}
+	return pm
+}
+
+func (self *fakeGate) registerNet(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	m := data.(*gorpc.M)
+	self.lock.Lock()
+	self.handlerMap[m.Name] = m.Data.(string)
+	self.lock.Unlock()
+	return nil
+}
+
+func (self *fakeGate) unRegisterNet(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	m := data.(*gorpc.M)
+	self.lock.Lock()
+	delete(self.handlerMap, m.Name)
+	self.lock.Unlock()
+	return nil
+}
+
+func (self *fakeGate) sendClient(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	m := data.(*gorpc.M)
+	self.record(Outgoing{Handler: "SendClient", ClientIds: []int{m.Id}, Data: decodePacket(m.Data)})
+	return nil
+}
+
+func (self *fakeGate) sendMulClient(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	ms := data.(*gorpc.M).Data.(*gorpc.MS)
+	self.record(Outgoing{Handler: "SendMulClient", ClientIds: ms.Ids, Data: decodePacket(ms.Data)})
+	return nil
+}
+
+func (self *fakeGate) sendRpc(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	self.record(rpcOutgoing("SendRpc", data.(*gorpc.M)))
+	return nil
+}
+
+func (self *fakeGate) broadCastRpc(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	self.record(rpcOutgoing("BroadCastRpc", data.(*gorpc.M)))
+	return nil
+}
+
+func rpcOutgoing(handler string, m *gorpc.M) Outgoing {
+	out := Outgoing{Handler: handler, Target: m.Id, FuncName: m.Name, Data: m.Data}
+	if m.Param == 0 { //不是二进制
+		out.Data = decodePacket(m.Data)
+	}
+	return out
+}
+
+func (self *fakeGate) sendGate(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	m := data.(*gorpc.M)
+	self.record(Outgoing{Handler: "SendGate", Target: m.Id, Data: decodePacket(m.Data)})
+	return nil
+}
+
+func (self *fakeGate) bindGate(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	self.record(Outgoing{Handler: "BindGate", Data: data})
+	return nil
+}
+
+//SendRpc/SendRpcBalance/CallRpc/SendRpcBack
+//需要返回结果时，依次使用SetRpcResponder设置的模拟返回、本节点注册的rpc函数，都没有时返回gorpc.ErrRemoteUnreachable
+func (self *fakeGate) sendRpcMsg(igo gorpc.IGoRoutine, data interface{}) interface{} {
+	req := data.(*gorpc.M).Data.(*msg.LouMiaoRpcMsg)
+	var pm interface{} = req.Buffer
+	if req.ByteBuffer == 0 {
+		pm = decodePacket(req.Buffer)
+	}
+	self.record(Outgoing{Handler: "SendRpcMsg", Target: int(req.TargetId), FuncName: req.FuncName, Seq: req.Seq, Data: pm})
+	if req.Seq <= 0 {
+		return nil
+	}
+	if responder := self.responder(req.FuncName); responder != nil {
+		ret, err := responder(int(req.SourceId), pm)
+		gorpc.DeliverReply(req.Seq, ret, err)
+		return nil
+	}
+	handler, ok := self.handler(req.FuncName)
+	if !ok {
+		gorpc.DeliverReply(req.Seq, nil, gorpc.ErrRemoteUnreachable)
+		return nil
+	}
+	seq := req.Seq
+	m := &gorpc.M{Id: int(req.SourceId), Name: req.FuncName, Data: pm}
+	err := gorpc.DeliverRemote(gorpc.ActorAddr{Name: handler}, req.FuncName, m, func(ret interface{}, err error) {
+		gorpc.DeliverReply(seq, ret, err)
+	})
+	if err != nil {
+		gorpc.DeliverReply(seq, nil, err)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+//actor测试环境，在虚拟时钟上运行注册的actor，不需要socket和etcd
+//
+//	h := loumiaotest.New()
+//	defer h.Close()
+//	h.Prepare(new(MyActor), "MyActor", true)
+//	h.Start()
+//	h.Recv(10001, &msg.ReqLogin{})
+//	h.Advance(time.Second)
+//	msgs := h.ClientMsgs(10001)
+package loumiaotest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/snowyyj001/loumiao"
+	"github.com/snowyyj001/loumiao/gorpc"
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/message"
+	"github.com/snowyyj001/loumiao/util"
+	"github.com/snowyyj001/loumiao/util/timer"
+)
+
+const (
+	HARNESS_BARRIER = "LouMiaoTestBarrier" //等待actor处理完之前消息的处理函数
+	SETTLE_TIMEOUT  = 5 * time.Second      //等待actor空闲的最长时间，真实时间
+)
+
+//虚拟时钟的默认起始时间
+var DefaultStartTime = time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local)
+
+type Harness struct {
+	Clock *VirtualClock
+
+	gate     *fakeGate
+	names    []string //可以用HARNESS_BARRIER同步的actor
+	oldMgr   *gorpc.GoRoutineMgr
+	oldClock util.Clock
+	oldWheel *timer.Wheel
+}
+
+//创建测试环境，替换gorpc.MGR和util的时钟，并用一个记录消息的actor代替GateServer
+//测试结束后必须调用Close恢复
+func New() *Harness {
+	return NewAt(DefaultStartTime)
+}
+
+//创建测试环境，虚拟时钟从start开始
+func NewAt(start time.Time) *Harness {
+	self := &Harness{Clock: NewVirtualClock(start)}
+	self.oldClock = util.SetClock(self.Clock)
+	self.oldWheel = timer.SetWheel(timer.NewWheel()) //时间轮要使用虚拟时钟
+	self.oldMgr = gorpc.MGR
+	gorpc.MGR = gorpc.NewGoRoutineMgr()
+	self.gate = new(fakeGate)
+	self.Prepare(self.gate, "GateServer", false)
+	return self
+}
+
+//创建一个服务，参考loumiao.Prepare，在Start时开启
+func (self *Harness) Prepare(igo gorpc.IGoRoutine, name string, sync bool, deps ...string) {
+	loumiao.Prepare(igo, name, sync, deps...)
+	if gorpc.MGR.GetRoutine(name) == nil { //DoInit失败
+		return
+	}
+	igo.Register(HARNESS_BARRIER, func(igo gorpc.IGoRoutine, data interface{}) interface{} {
+		return nil
+	})
+	self.names = append(self.names, name)
+}
+
+//开启所有服务，并等待DoStart/DoOpen中投递的消息处理完
+func (self *Harness) Start() {
+	gorpc.MGR.DoStart()
+	gorpc.MGR.DoOpen()
+	self.Settle()
+}
+
+//关闭所有服务，恢复gorpc.MGR、时钟和时间轮
+func (self *Harness) Close() {
+	gorpc.MGR.CloseAll()
+	gorpc.MGR = self.oldMgr
+	timer.SetWheel(self.oldWheel).Stop()
+	util.SetClock(self.oldClock)
+}
+
+//虚拟时间前进d，到期的定时器按顺序触发，每次触发后等待actor处理完
+func (self *Harness) Advance(d time.Duration) {
+	until := self.Clock.Now().Add(d)
+	for self.Clock.Step(until) {
+		self.Settle()
+	}
+	self.Clock.Advance(until.Sub(self.Clock.Now()))
+}
+
+//等待所有actor处理完队列中的消息，包括处理过程中新产生的消息
+func (self *Harness) Settle() {
+	deadline := time.Now().Add(SETTLE_TIMEOUT)
+	for time.Now().Before(deadline) {
+		before := self.work()
+		for _, name := range self.names {
+			self.barrier(name, deadline)
+		}
+		if self.work() == before && self.idle() {
+			return
+		}
+	}
+	llog.Errorf("Harness.Settle: actors are still busy after %v", SETTLE_TIMEOUT)
+}
+
+//同步actor的队列是有序的，barrier返回时之前投递的消息都已经处理完
+func (self *Harness) barrier(name string, deadline time.Time) {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	_, err := gorpc.MGR.CallContext(ctx, name, HARNESS_BARRIER, nil)
+	if err != nil && err != gorpc.ErrTargetNotRunning {
+		llog.Warningf("Harness.barrier[%s]: %s", name, err.Error())
+	}
+}
+
+//已经处理的消息和定时器数量
+func (self *Harness) work() int64 {
+	var n int64
+	for _, am := range gorpc.MGR.Metrics() {
+		n += am.TimerLag.Calls
+		for _, hm := range am.Handlers {
+			if hm.Handler != HARNESS_BARRIER {
+				n += hm.Calls
+			}
+		}
+	}
+	return n
+}
+
+func (self *Harness) idle() bool {
+	for _, am := range gorpc.MGR.Metrics() {
+		if am.QueueDepth > 0 || am.Running > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//投递消息给actor，并等待处理完
+func (self *Harness) Send(actor string, handler string, m *gorpc.M) error {
+	err := gorpc.MGR.Send(actor, handler, m)
+	self.Settle()
+	return err
+}
+
+//投递消息给actor，参考loumiao.SendAcotr
+func (self *Harness) SendActor(actor string, handler string, data interface{}) error {
+	return self.Send(actor, handler, &gorpc.M{Data: data, Flag: true})
+}
+
+//阻塞调用actor，并等待处理完
+func (self *Harness) Call(actor string, handler string, m *gorpc.M) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), SETTLE_TIMEOUT)
+	defer cancel()
+	ret, err := gorpc.MGR.CallContext(ctx, actor, handler, m)
+	self.Settle()
+	return ret, err
+}
+
+//模拟收到客户端的网络消息，和gate一样按注册的消息名交给actor的ServiceHandler
+//@clientid: 客户端userid
+//@packet: message注册过的消息结构体，会经过一次编码解码
+func (self *Harness) Recv(clientid int, packet interface{}) error {
+	buff, n := message.Encode(0, "", packet)
+	if n == 0 {
+		return fmt.Errorf("Harness.Recv: encode error: %v", reflect.TypeOf(packet))
+	}
+	err, _, name, pm := message.Decode(0, buff, n)
+	if err != nil {
+		return err
+	}
+	actor, ok := self.gate.handler(name)
+	if !ok {
+		return fmt.Errorf("Harness.Recv: no handler for %s", name)
+	}
+	err = gorpc.MGR.Send(actor, "ServiceHandler", &gorpc.M{Id: clientid, Name: name, Data: pm})
+	self.Settle()
+	return err
+}
+
+//模拟收到其他server的rpc消息
+//@sourceId: 源服务器uid
+//@funcName: rpc函数，参考loumiao.RpcFuncName
+//@data: 同loumiao.SendRpc
+func (self *Harness) RecvRpc(sourceId int, funcName string, data interface{}) error {
+	actor, ok := self.gate.handler(funcName)
+	if !ok {
+		return fmt.Errorf("Harness.RecvRpc: no rpc handler for %s", funcName)
+	}
+	if _, isBytes := data.([]byte); !isBytes {
+		buff, n := message.Encode(0, "", data)
+		if n == 0 {
+			return fmt.Errorf("Harness.RecvRpc: encode error: %v", reflect.TypeOf(data))
+		}
+		var err error
+		err, _, _, data = message.Decode(0, buff, n)
+		if err != nil {
+			return err
+		}
+	}
+	err := gorpc.MGR.Send(actor, "ServiceHandler", &gorpc.M{Id: sourceId, Name: funcName, Data: data})
+	self.Settle()
+	return err
+}
+
+//设置CallRpc/SendRpcBack的模拟返回，没有设置时会调用本节点注册的rpc函数
+func (self *Harness) SetRpcResponder(funcName string, responder RpcResponder) {
+	self.gate.lock.Lock()
+	self.gate.responders[funcName] = responder
+	self.gate.lock.Unlock()
+}
+
+//处理消息或rpc函数的actor，没有注册返回false
+func (self *Harness) HandlerOf(name string) (string, bool) {
+	return self.gate.handler(name)
+}
+
+//发往gate的所有消息
+func (self *Harness) Sent() []Outgoing {
+	self.gate.lock.Lock()
+	defer self.gate.lock.Unlock()
+	return append([]Outgoing(nil), self.gate.sent...)
+}
+
+//取出发往gate的所有消息，之后Sent不再包含它们
+func (self *Harness) Take() []Outgoing {
+	self.gate.lock.Lock()
+	defer self.gate.lock.Unlock()
+	sent := self.gate.sent
+	self.gate.sent = nil
+	return sent
+}
+
+//发给客户端的消息，包括广播
+func (self *Harness) ClientMsgs(clientid int) []interface{} {
+	var arr []interface{}
+	for _, out := range self.Sent() {
+		if out.Handler != "SendClient" && out.Handler != "SendMulClient" {
+			continue
+		}
+		if out.Handler == "SendMulClient" && len(out.ClientIds) == 0 {
+			arr = append(arr, out.Data)
+			continue
+		}
+		for _, id := range out.ClientIds {
+			if id == clientid {
+				arr = append(arr, out.Data)
+				break
+			}
+		}
+	}
+	return arr
+}
+
+//发出的rpc消息
+func (self *Harness) RpcMsgs(funcName string) []Outgoing {
+	var arr []Outgoing
+	for _, out := range self.Sent() {
+		if out.FuncName == funcName {
+			arr = append(arr, out)
+		}
+	}
+	return arr
+}
@@ -0,0 +1,60 @@
+package loumiaotest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao"
+	"github.com/snowyyj001/loumiao/gorpc"
+	"github.com/snowyyj001/loumiao/msg"
+)
+
+type testActor struct {
+	gorpc.GoRoutineLogic
+	ticks int
+}
+
+func (self *testActor) DoRegsiter() {
+	loumiao.RegisterNetHandler(self, "LouMiaoLoginGate", func(igo gorpc.IGoRoutine, clientid int, data interface{}) {
+		req := data.(*msg.LouMiaoLoginGate)
+		loumiao.SendClient(clientid, &msg.LouMiaoKickOut{Reason: int32(req.WorldUid)})
+	})
+	self.Register("Ticks", func(igo gorpc.IGoRoutine, data interface{}) interface{} {
+		return self.ticks
+	})
+}
+
+func (self *testActor) DoStart() {
+	self.RunTimer(1000, func(dt int64) {
+		self.ticks++
+		loumiao.SendRpc("Tick", &msg.LouMiaoKickOut{Reason: int32(dt)}, 0)
+	}, true)
+}
+
+func TestHarness(t *testing.T) {
+	h := New()
+	defer h.Close()
+	h.Prepare(new(testActor), "testActor", false)
+	h.Start()
+
+	if err := h.Recv(10001, &msg.LouMiaoLoginGate{WorldUid: 7}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := h.ClientMsgs(10001)
+	if len(msgs) != 1 || msgs[0].(*msg.LouMiaoKickOut).Reason != 7 {
+		t.Fatalf("client msgs: %v", msgs)
+	}
+
+	h.Advance(3500 * time.Millisecond)
+	ticks, err := h.Call("testActor", "Ticks", nil)
+	if err != nil || ticks.(int) != 3 {
+		t.Fatalf("ticks: %v %v", ticks, err)
+	}
+	rpcs := h.RpcMsgs("Tick")
+	if len(rpcs) != 3 || rpcs[2].Data.(*msg.LouMiaoKickOut).Reason != 1000 {
+		t.Fatalf("rpc msgs: %v", rpcs)
+	}
+	if h.Clock.Now().Sub(DefaultStartTime) != 3500*time.Millisecond {
+		t.Fatalf("clock: %v", h.Clock.Now())
+	}
+}
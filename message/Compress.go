@@ -0,0 +1,180 @@
+package message
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+//消息压缩
+//压缩的消息包：消息名长度(7，8字节)的高4位是压缩算法，消息名不压缩，消息体压缩
+//消息名最长MSGNAME_SIZE，这4位不会被占用，不压缩的消息包格式不变
+//带序号的消息包(参考Sequence.go)序号也不压缩
+//Decode会自动解压，发送时由network按照连接协商的算法压缩
+
+const (
+	COMPRESS_NONE    = 0
+	COMPRESS_SNAPPY  = 1 //速度快，压缩率一般
+	COMPRESS_ZSTD    = 2 //需要先调用RegisterCodec注册实现，例如github.com/klauspost/compress/zstd
+	COMPRESS_DEFLATE = 3 //压缩率高，速度慢
+
+	COMPRESS_SHIFT     = 12
+	COMPRESS_MASK      = 0xF000
+	COMPRESS_THRESHOLD = 1024 //默认超过1k的消息包才压缩
+)
+
+var CodecNames = map[string]int{"SNAPPY": COMPRESS_SNAPPY, "ZSTD": COMPRESS_ZSTD, "DEFLATE": COMPRESS_DEFLATE}
+
+//压缩算法
+type Codec interface {
+	Encode(src []byte) ([]byte, error)
+	Decode(src []byte, maxSize int) ([]byte, error) //解压后超过maxSize时返回错误
+}
+
+var (
+	codecs        = make(map[int]Codec)
+	codecLock     sync.RWMutex
+	MaxUnpackSize int //解压后(分包组装后)一个消息包的最大大小，防止压缩炸弹
+)
+
+func init() {
+	codecs[COMPRESS_SNAPPY] = snappyCodec{}
+	codecs[COMPRESS_DEFLATE] = new(deflateCodec)
+}
+
+//注册压缩算法，id是COMPRESS_*，可以替换内置的实现，必须在建立连接前调用
+func RegisterCodec(id int, codec Codec) {
+	if id <= COMPRESS_NONE || id > COMPRESS_MASK>>COMPRESS_SHIFT {
+		panic(fmt.Sprintf("RegisterCodec: bad codec id %d", id))
+	}
+	codecLock.Lock()
+	codecs[id] = codec
+	codecLock.Unlock()
+}
+
+func getCodec(id int) Codec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return codecs[id]
+}
+
+//是否支持这个压缩算法
+func HasCodec(id int) bool {
+	return getCodec(id) != nil
+}
+
+//算法名转换为id，例如"SNAPPY"
+func CodecId(name string) (int, error) {
+	id, ok := CodecNames[strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("compress: unknown codec %s", name)
+	}
+	return id, nil
+}
+
+//消息包是否是压缩的
+func IsCompressed(buff []byte) bool {
+	return binary.BigEndian.Uint16(buff[6:])&COMPRESS_MASK != 0
+}
+
+//压缩一个完整的消息包，返回新的消息包，压缩后没有变小时返回原来的消息包
+func Compress(buff []byte, id int) []byte {
+	codec := getCodec(id)
+	if codec == nil || IsCompressed(buff) {
+		return buff
+	}
+	head := nameOffset(buff) + msgNameLen(buff)
+	body, err := codec.Encode(buff[head:])
+	if err != nil || head+len(body) >= len(buff) {
+		return buff
+	}
+	out := make([]byte, head+len(body))
+	copy(out, buff[:head])
+	copy(out[head:], body)
+	binary.BigEndian.PutUint32(out, uint32(len(out)))
+	binary.BigEndian.PutUint16(out[6:], binary.BigEndian.Uint16(buff[6:])|uint16(id<<COMPRESS_SHIFT))
+	return out
+}
+
+//解压一个完整的消息包，返回新的消息包和长度
+func Decompress(buff []byte, length int) ([]byte, int, error) {
+	flag := binary.BigEndian.Uint16(buff[6:])
+	id := int(flag&COMPRESS_MASK) >> COMPRESS_SHIFT
+	codec := getCodec(id)
+	if codec == nil {
+		return nil, 0, fmt.Errorf("Decompress: unknown codec %d", id)
+	}
+	head := nameOffset(buff) + msgNameLen(buff)
+	if head > length {
+		return nil, 0, fmt.Errorf("Decompress: msgname len is illegal: %d", msgNameLen(buff))
+	}
+	body, err := codec.Decode(buff[head:length], MaxUnpackSize-head)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Decompress: %s", err.Error())
+	}
+	out := make([]byte, head+len(body))
+	copy(out, buff[:head])
+	copy(out[head:], body)
+	binary.BigEndian.PutUint32(out, uint32(len(out)))
+	binary.BigEndian.PutUint16(out[6:], flag&^COMPRESS_MASK)
+	return out, len(out), nil
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Encode(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCodec) Decode(src []byte, maxSize int) ([]byte, error) {
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSize {
+		return nil, fmt.Errorf("snappy: too big packet size: %d", n)
+	}
+	return snappy.Decode(nil, src)
+}
+
+type deflateCodec struct {
+	writers sync.Pool
+}
+
+func (self *deflateCodec) Encode(src []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, _ := self.writers.Get().(*flate.Writer)
+	if w == nil {
+		w, _ = flate.NewWriter(&b, flate.DefaultCompression)
+	} else {
+		w.Reset(&b)
+	}
+	defer self.writers.Put(w)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (self *deflateCodec) Decode(src []byte, maxSize int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxSize {
+		return nil, fmt.Errorf("deflate: too big packet size: >%d", maxSize)
+	}
+	return out, nil
+}
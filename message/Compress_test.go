@@ -0,0 +1,95 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+//不经过Encode构造消息包
+func rawPacket(name string, body []byte) []byte {
+	buff := make([]byte, HEAD_SIZE, HEAD_SIZE+len(name)+len(body))
+	buff = append(append(buff, name...), body...)
+	binary.BigEndian.PutUint32(buff, uint32(len(buff)))
+	binary.BigEndian.PutUint16(buff[4:], 3)
+	binary.BigEndian.PutUint16(buff[6:], uint16(len(name)))
+	return buff
+}
+
+func withMaxUnpack(size int) func() {
+	old := MaxUnpackSize
+	MaxUnpackSize = size
+	return func() { MaxUnpackSize = old }
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	defer withMaxUnpack(1 << 20)()
+	body := bytes.Repeat([]byte("loumiao compress "), 256)
+	for _, id := range []int{COMPRESS_SNAPPY, COMPRESS_DEFLATE} {
+		for _, buff := range [][]byte{rawPacket("C_Chat", body), SetSeq(rawPacket("C_Chat", body), 7, 3)} {
+			out := Compress(buff, id)
+			if !IsCompressed(out) || len(out) >= len(buff) || int(binary.BigEndian.Uint32(out)) != len(out) {
+				t.Fatalf("codec %d: compressed %d -> %d", id, len(buff), len(out))
+			}
+			if seq, ack := GetSeq(out); seq != 0 && (seq != 7 || ack != 3) {
+				t.Fatalf("codec %d: seq %d ack %d", id, seq, ack)
+			}
+			if Compress(out, id)[0] != out[0] || len(Compress(out, id)) != len(out) {
+				t.Fatalf("codec %d: compressed twice", id)
+			}
+			plain, n, err := Decompress(out, len(out))
+			if err != nil || n != len(buff) || !bytes.Equal(plain, buff) {
+				t.Fatalf("codec %d: decompress %v", id, err)
+			}
+		}
+
+		//压缩后没有变小时不压缩
+		small := rawPacket("C_Move", []byte{1, 2, 3})
+		if out := Compress(small, id); !bytes.Equal(out, small) || IsCompressed(out) {
+			t.Fatalf("codec %d: small packet compressed", id)
+		}
+	}
+	if buff := rawPacket("C_Chat", body); !bytes.Equal(Compress(buff, COMPRESS_ZSTD), buff) {
+		t.Fatal("compressed by unregistered codec")
+	}
+}
+
+func TestDecompressBomb(t *testing.T) {
+	body := make([]byte, 1<<20)
+	for _, id := range []int{COMPRESS_SNAPPY, COMPRESS_DEFLATE} {
+		out := Compress(rawPacket("C_Bomb", body), id)
+		restore := withMaxUnpack(64 * 1024)
+		if plain, _, err := Decompress(out, len(out)); err == nil || plain != nil {
+			t.Fatalf("codec %d: bomb of %d bytes decompressed", id, len(out))
+		}
+		restore()
+		if _, n, err := Decompress(out, len(out)); err == nil || n != 0 { //没有开启压缩时默认为MaxPacketSize
+			t.Fatalf("codec %d: decompressed over default max size", id)
+		}
+	}
+
+	//未知的算法
+	buff := rawPacket("C_Chat", []byte{1, 2, 3})
+	binary.BigEndian.PutUint16(buff[6:], binary.BigEndian.Uint16(buff[6:])|5<<COMPRESS_SHIFT)
+	if _, _, err := Decompress(buff, len(buff)); err == nil {
+		t.Fatal("unknown codec decompressed")
+	}
+}
+
+func TestCodecId(t *testing.T) {
+	if id, err := CodecId("deflate"); err != nil || id != COMPRESS_DEFLATE {
+		t.Fatalf("deflate: %d %v", id, err)
+	}
+	if _, err := CodecId("lz4"); err == nil {
+		t.Fatal("unknown codec name")
+	}
+	if !HasCodec(COMPRESS_SNAPPY) || HasCodec(COMPRESS_ZSTD) {
+		t.Fatal("registered codecs")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("bad codec id registered")
+		}
+	}()
+	RegisterCodec(16, snappyCodec{})
+}
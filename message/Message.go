@@ -23,7 +23,7 @@ type MsgPool struct {
 var (
 	Packet_CreateFactorStringMap map[string]*MsgPool
 	filterWarning                map[string]bool
-	MaxPacketSize                int //一个消息包的最大大小,如果一个消息超过该阀值，那么就需要分包
+	MaxPacketSize                int //一个消息包的最大大小,如果一个消息超过该阀值，那么就需要分包，参考network.PacketFragment
 )
 
 func init() {
@@ -35,6 +35,10 @@ func init() {
 	filterWarning["C_CONNECT"] = true
 	filterWarning["C_DISCONNECT"] = true
 	MaxPacketSize = config.NET_BUFFER_SIZE - MSGNAME_SIZE - MSGNAME_SIZE
+	MaxUnpackSize = MaxPacketSize
+	if config.NET_FRAGMENT || config.NET_COMPRESS != nil || config.NET_INNER_COMPRESS != nil { //超过MaxPacketSize的由network压缩或者分包
+		MaxUnpackSize = config.NET_MAX_UNPACK_SIZE
+	}
 }
 
 //注册网络消息
@@ -21,6 +21,7 @@ import (
 //1，2，3，4字节代表消息报总长度
 //5，6字节代表目标服务器id
 //7，8字节代表消息名长度
+//带序号的消息包包头后面还有4字节序号和4字节确认，参考Sequence.go
 
 //target: 目标服务器id
 //name: 消息名
@@ -60,7 +61,7 @@ func EncodeProBuff(target int, name string, packet interface{}) ([]byte, int) {
 	if buff != nil {
 		binary.Write(bytesBuffer, binary.BigEndian, buff)
 	}
-	if nLen > MaxPacketSize {
+	if nLen > MaxUnpackSize {
 		llog.Errorf("EncodeProBuff: too big packet size: %d", nLen)
 		return nil, 0
 	}
@@ -76,13 +77,19 @@ func DecodeProBuff(uid int, buff []byte, length int) (error, int, string, interf
 		return nil, target, "", nil
 	}
 
-	mbuff1 = buff[6:8]
-	nameLen := base.BytesToUInt16(mbuff1, binary.BigEndian)
+	if IsCompressed(buff) {
+		var err error
+		if buff, length, err = Decompress(buff, length); err != nil {
+			return err, 0, "", nil
+		}
+	}
+	nameLen := msgNameLen(buff)
 	if nameLen <= 0 {
 		return fmt.Errorf("DecodeProBuff: msgname len is illegal: %d", nameLen), 0, "", nil
 	}
-	msgName := string(buff[8 : 8+nameLen])
-	if length == 8+int(nameLen) { //just for on CONNECT/DISCONNECT or []byte{}
+	head := nameOffset(buff) + nameLen
+	msgName := string(buff[head-nameLen : head])
+	if length == head { //just for on CONNECT/DISCONNECT or []byte{}
 		if filterWarning[msgName] {
 			return nil, target, msgName, nil
 		} else {
@@ -99,7 +106,7 @@ func DecodeProBuff(uid int, buff []byte, length int) (error, int, string, interf
 	if packet == nil {
 		return fmt.Errorf("DecodeProBuff: packet[%s] may not registered, uid=%d,target=%d", msgName, uid, target), 0, "", nil
 	}
-	err := proto.Unmarshal(buff[8+nameLen:length], packet.(proto.Message))
+	err := proto.Unmarshal(buff[head:length], packet.(proto.Message))
 	if util.CheckErr(err) {
 		llog.Errorf("DecodeProBuff: Unmarshal[%s] error", msgName)
 		return err, target, "", nil
@@ -143,13 +150,19 @@ func DecodeJson(uid int, buff []byte, length int) (error, int, string, interface
 		return nil, target, "", nil
 	}
 
-	mbuff1 = buff[6:8]
-	nameLen := base.BytesToUInt16(mbuff1, binary.BigEndian)
+	if IsCompressed(buff) {
+		var err error
+		if buff, length, err = Decompress(buff, length); err != nil {
+			return err, 0, "", nil
+		}
+	}
+	nameLen := msgNameLen(buff)
 	if nameLen <= 0 {
 		return fmt.Errorf("DecodeJson: msgname len is illegal: %d", nameLen), 0, "", nil
 	}
-	msgName := string(buff[8 : 8+nameLen])
-	if length == 8+int(nameLen) { //just for on CONNECT/DISCONNECT or []byte{}
+	head := nameOffset(buff) + nameLen
+	msgName := string(buff[head-nameLen : head])
+	if length == head { //just for on CONNECT/DISCONNECT or []byte{}
 		if filterWarning[msgName] {
 			return nil, target, msgName, nil
 		} else {
@@ -161,7 +174,7 @@ func DecodeJson(uid int, buff []byte, length int) (error, int, string, interface
 	if packet == nil {
 		return fmt.Errorf("DecodeJson: packet[%s] may not registered, uid=%d,target=%d", msgName, uid, target), 0, "", nil
 	}
-	err := json.Unmarshal(buff[8+nameLen:length], packet)
+	err := json.Unmarshal(buff[head:length], packet)
 	if util.CheckErr(err) {
 		llog.Errorf("DecodeJson: Unmarshal[%s] error", msgName)
 		return err, target, "", nil
@@ -0,0 +1,62 @@
+package message
+
+import (
+	"encoding/binary"
+)
+
+//消息序号和确认
+//带序号的消息包：消息名长度(7，8字节)的SEQ_FLAG位置1，包头后面是4字节序号+4字节累计确认，然后是消息名和消息体
+//4+2+2+4+4+name+msg
+//序号是发送方这个连接上的消息包计数，从1开始，确认是已经收到的对方的最大序号，0代表还没有收到
+//序号不压缩，Decode会跳过，不带序号的消息包格式不变，由network按照连接的设置加上序号
+
+const (
+	SEQ_FLAG  = 0x0800 //消息名长度中的序号标记
+	SEQ_SIZE  = 8      //序号+确认
+	NAME_MASK = 0x07FF //消息名长度中的长度部分
+)
+
+//消息包是否带序号
+func HasSeq(buff []byte) bool {
+	return binary.BigEndian.Uint16(buff[6:])&SEQ_FLAG != 0
+}
+
+//消息名在消息包中的开始位置
+func nameOffset(buff []byte) int {
+	if HasSeq(buff) {
+		return HEAD_SIZE + SEQ_SIZE
+	}
+	return HEAD_SIZE
+}
+
+//消息名长度
+func msgNameLen(buff []byte) int {
+	return int(binary.BigEndian.Uint16(buff[6:]) & NAME_MASK)
+}
+
+//读取消息包的序号和确认，不带序号时返回0
+func GetSeq(buff []byte) (uint32, uint32) {
+	if !HasSeq(buff) {
+		return 0, 0
+	}
+	return binary.BigEndian.Uint32(buff[HEAD_SIZE:]), binary.BigEndian.Uint32(buff[HEAD_SIZE+4:])
+}
+
+//给一个完整的消息包加上序号和确认，返回新的消息包，原来的消息包可能还要发给其他连接，不修改
+//已经带序号的消息包替换原来的序号
+func SetSeq(buff []byte, seq uint32, ack uint32) []byte {
+	var out []byte
+	if HasSeq(buff) {
+		out = make([]byte, len(buff))
+		copy(out, buff)
+	} else {
+		out = make([]byte, len(buff)+SEQ_SIZE)
+		copy(out, buff[:HEAD_SIZE])
+		copy(out[HEAD_SIZE+SEQ_SIZE:], buff[HEAD_SIZE:])
+		binary.BigEndian.PutUint32(out, uint32(len(out)))
+		binary.BigEndian.PutUint16(out[6:], binary.BigEndian.Uint16(buff[6:])|SEQ_FLAG)
+	}
+	binary.BigEndian.PutUint32(out[HEAD_SIZE:], seq)
+	binary.BigEndian.PutUint32(out[HEAD_SIZE+4:], ack)
+	return out
+}
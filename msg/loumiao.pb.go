@@ -30,9 +30,12 @@ type LouMiaoLoginGate struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	TokenId  int64 `protobuf:"varint,1,opt,name=TokenId,proto3" json:"TokenId,omitempty"`
-	UserId   int64 `protobuf:"varint,2,opt,name=UserId,proto3" json:"UserId,omitempty"`
-	WorldUid int32 `protobuf:"varint,3,opt,name=WorldUid,proto3" json:"WorldUid,omitempty"`
+	TokenId     int64  `protobuf:"varint,1,opt,name=TokenId,proto3" json:"TokenId,omitempty"`
+	UserId      int64  `protobuf:"varint,2,opt,name=UserId,proto3" json:"UserId,omitempty"`
+	WorldUid    int32  `protobuf:"varint,3,opt,name=WorldUid,proto3" json:"WorldUid,omitempty"`
+	Token       string `protobuf:"bytes,4,opt,name=Token,proto3" json:"Token,omitempty"`             //account签发的会话令牌，配置了令牌校验的gate只信任令牌中的内容
+	ResumeToken string `protobuf:"bytes,5,opt,name=ResumeToken,proto3" json:"ResumeToken,omitempty"` //gate登录成功时返回的续连令牌，断线后用它恢复会话
+	Seq         int64  `protobuf:"varint,6,opt,name=Seq,proto3" json:"Seq,omitempty"`                //续连时客户端已经收到的消息包数
 }
 
 func (x *LouMiaoLoginGate) Reset() {
@@ -88,12 +91,34 @@ func (x *LouMiaoLoginGate) GetWorldUid() int32 {
 	return 0
 }
 
+func (x *LouMiaoLoginGate) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *LouMiaoLoginGate) GetResumeToken() string {
+	if x != nil {
+		return x.ResumeToken
+	}
+	return ""
+}
+
+func (x *LouMiaoLoginGate) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
 type LouMiaoRpcRegister struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
 	FuncName []string `protobuf:"bytes,1,rep,name=FuncName,proto3" json:"FuncName,omitempty"`
+	Balance  []int32  `protobuf:"varint,2,rep,packed,name=Balance,proto3" json:"Balance,omitempty"` //与FuncName一一对应的目标选择策略
 }
 
 func (x *LouMiaoRpcRegister) Reset() {
@@ -135,10 +160,19 @@ func (x *LouMiaoRpcRegister) GetFuncName() []string {
 	return nil
 }
 
+func (x *LouMiaoRpcRegister) GetBalance() []int32 {
+	if x != nil {
+		return x.Balance
+	}
+	return nil
+}
+
 type LouMiaoKickOut struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
+
+	Reason int32 `protobuf:"varint,1,opt,name=Reason,proto3" json:"Reason,omitempty"` //踢下线原因define.KICK_REASON_*
 }
 
 func (x *LouMiaoKickOut) Reset() {
@@ -173,6 +207,13 @@ func (*LouMiaoKickOut) Descriptor() ([]byte, []int) {
 	return file_pbmsg_loumiao_proto_rawDescGZIP(), []int{2}
 }
 
+func (x *LouMiaoKickOut) GetReason() int32 {
+	if x != nil {
+		return x.Reason
+	}
+	return 0
+}
+
 type LouMiaoClientConnect struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -246,6 +287,11 @@ type LouMiaoRpcMsg struct {
 	Buffer     []byte `protobuf:"bytes,3,opt,name=Buffer,proto3" json:"Buffer,omitempty"`
 	SourceId   int64  `protobuf:"varint,4,opt,name=SourceId,proto3" json:"SourceId,omitempty"`     //>0指定源服务器uid
 	ByteBuffer int32  `protobuf:"varint,5,opt,name=ByteBuffer,proto3" json:"ByteBuffer,omitempty"` //消息内容是否为二进制格式
+	Seq        int64  `protobuf:"varint,6,opt,name=Seq,proto3" json:"Seq,omitempty"`               //>0需要返回结果
+	Reply      int32  `protobuf:"varint,7,opt,name=Reply,proto3" json:"Reply,omitempty"`           //1:这是一个返回消息
+	Error      string `protobuf:"bytes,8,opt,name=Error,proto3" json:"Error,omitempty"`            //返回的错误
+	Balance    int32  `protobuf:"varint,9,opt,name=Balance,proto3" json:"Balance,omitempty"`       //目标选择策略，0使用注册时的策略
+	HashKey    int64  `protobuf:"varint,10,opt,name=HashKey,proto3" json:"HashKey,omitempty"`      //一致性hash选择的key
 }
 
 func (x *LouMiaoRpcMsg) Reset() {
@@ -315,6 +361,41 @@ func (x *LouMiaoRpcMsg) GetByteBuffer() int32 {
 	return 0
 }
 
+func (x *LouMiaoRpcMsg) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *LouMiaoRpcMsg) GetReply() int32 {
+	if x != nil {
+		return x.Reply
+	}
+	return 0
+}
+
+func (x *LouMiaoRpcMsg) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *LouMiaoRpcMsg) GetBalance() int32 {
+	if x != nil {
+		return x.Balance
+	}
+	return 0
+}
+
+func (x *LouMiaoRpcMsg) GetHashKey() int64 {
+	if x != nil {
+		return x.HashKey
+	}
+	return 0
+}
+
 type LouMiaoNetMsg struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -496,56 +577,208 @@ func (x *LouMiaoBroadCastMsg) GetByteBuffer() int32 {
 	return 0
 }
 
+type LouMiaoActorMsg struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	TargetId   int64  `protobuf:"varint,1,opt,name=TargetId,proto3" json:"TargetId,omitempty"`  //目标服务器uid
+	SourceId   int64  `protobuf:"varint,2,opt,name=SourceId,proto3" json:"SourceId,omitempty"`  //源服务器uid
+	ActorName  string `protobuf:"bytes,3,opt,name=ActorName,proto3" json:"ActorName,omitempty"` //目标actor名，协程池actor时是协程池名
+	ActorId    int64  `protobuf:"varint,4,opt,name=ActorId,proto3" json:"ActorId,omitempty"`    //目标协程池actor id
+	Handler    string `protobuf:"bytes,5,opt,name=Handler,proto3" json:"Handler,omitempty"`     //目标actor的处理函数
+	Buffer     []byte `protobuf:"bytes,6,opt,name=Buffer,proto3" json:"Buffer,omitempty"`
+	ByteBuffer int32  `protobuf:"varint,7,opt,name=ByteBuffer,proto3" json:"ByteBuffer,omitempty"` //消息内容是否为二进制格式
+	Seq        int64  `protobuf:"varint,8,opt,name=Seq,proto3" json:"Seq,omitempty"`               //>0需要返回结果
+	Reply      int32  `protobuf:"varint,9,opt,name=Reply,proto3" json:"Reply,omitempty"`           //1:这是一个返回消息
+	Error      string `protobuf:"bytes,10,opt,name=Error,proto3" json:"Error,omitempty"`           //返回的错误
+}
+
+func (x *LouMiaoActorMsg) Reset() {
+	*x = LouMiaoActorMsg{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pbmsg_loumiao_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LouMiaoActorMsg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LouMiaoActorMsg) ProtoMessage() {}
+
+func (x *LouMiaoActorMsg) ProtoReflect() protoreflect.Message {
+	mi := &file_pbmsg_loumiao_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LouMiaoActorMsg.ProtoReflect.Descriptor instead.
+func (*LouMiaoActorMsg) Descriptor() ([]byte, []int) {
+	return file_pbmsg_loumiao_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *LouMiaoActorMsg) GetTargetId() int64 {
+	if x != nil {
+		return x.TargetId
+	}
+	return 0
+}
+
+func (x *LouMiaoActorMsg) GetSourceId() int64 {
+	if x != nil {
+		return x.SourceId
+	}
+	return 0
+}
+
+func (x *LouMiaoActorMsg) GetActorName() string {
+	if x != nil {
+		return x.ActorName
+	}
+	return ""
+}
+
+func (x *LouMiaoActorMsg) GetActorId() int64 {
+	if x != nil {
+		return x.ActorId
+	}
+	return 0
+}
+
+func (x *LouMiaoActorMsg) GetHandler() string {
+	if x != nil {
+		return x.Handler
+	}
+	return ""
+}
+
+func (x *LouMiaoActorMsg) GetBuffer() []byte {
+	if x != nil {
+		return x.Buffer
+	}
+	return nil
+}
+
+func (x *LouMiaoActorMsg) GetByteBuffer() int32 {
+	if x != nil {
+		return x.ByteBuffer
+	}
+	return 0
+}
+
+func (x *LouMiaoActorMsg) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *LouMiaoActorMsg) GetReply() int32 {
+	if x != nil {
+		return x.Reply
+	}
+	return 0
+}
+
+func (x *LouMiaoActorMsg) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
 var File_pbmsg_loumiao_proto protoreflect.FileDescriptor
 
 var file_pbmsg_loumiao_proto_rawDesc = []byte{
 	0x0a, 0x13, 0x70, 0x62, 0x6d, 0x73, 0x67, 0x2f, 0x6c, 0x6f, 0x75, 0x6d, 0x69, 0x61, 0x6f, 0x2e,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x03, 0x6d, 0x73, 0x67, 0x22, 0x60, 0x0a, 0x10, 0x4c, 0x6f,
-	0x75, 0x4d, 0x69, 0x61, 0x6f, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x47, 0x61, 0x74, 0x65, 0x12, 0x18,
-	0x0a, 0x07, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52,
-	0x07, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x55, 0x73, 0x65, 0x72,
-	0x49, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x55, 0x73, 0x65, 0x72, 0x49, 0x64,
-	0x12, 0x1a, 0x0a, 0x08, 0x57, 0x6f, 0x72, 0x6c, 0x64, 0x55, 0x69, 0x64, 0x18, 0x03, 0x20, 0x01,
-	0x28, 0x05, 0x52, 0x08, 0x57, 0x6f, 0x72, 0x6c, 0x64, 0x55, 0x69, 0x64, 0x22, 0x30, 0x0a, 0x12,
-	0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x52, 0x70, 0x63, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74,
-	0x65, 0x72, 0x12, 0x1a, 0x0a, 0x08, 0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x01,
-	0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x22, 0x10,
-	0x0a, 0x0e, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x4b, 0x69, 0x63, 0x6b, 0x4f, 0x75, 0x74,
-	0x22, 0x60, 0x0a, 0x14, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x43, 0x6c, 0x69, 0x65, 0x6e,
-	0x74, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65,
-	0x6e, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65,
-	0x6e, 0x74, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x47, 0x61, 0x74, 0x65, 0x49, 0x64, 0x18, 0x02,
-	0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x47, 0x61, 0x74, 0x65, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05,
-	0x53, 0x74, 0x61, 0x74, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x53, 0x74, 0x61,
-	0x74, 0x65, 0x22, 0x9b, 0x01, 0x0a, 0x0d, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x52, 0x70,
-	0x63, 0x4d, 0x73, 0x67, 0x12, 0x1a, 0x0a, 0x08, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x49, 0x64,
-	0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x49, 0x64,
-	0x12, 0x1a, 0x0a, 0x08, 0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x08, 0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x16, 0x0a, 0x06,
-	0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x42, 0x75,
-	0x66, 0x66, 0x65, 0x72, 0x12, 0x1a, 0x0a, 0x08, 0x53, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x49, 0x64,
-	0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x53, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x49, 0x64,
-	0x12, 0x1e, 0x0a, 0x0a, 0x42, 0x79, 0x74, 0x65, 0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x18, 0x05,
-	0x20, 0x01, 0x28, 0x05, 0x52, 0x0a, 0x42, 0x79, 0x74, 0x65, 0x42, 0x75, 0x66, 0x66, 0x65, 0x72,
-	0x22, 0x43, 0x0a, 0x0d, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x4e, 0x65, 0x74, 0x4d, 0x73,
-	0x67, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20,
-	0x01, 0x28, 0x03, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x16, 0x0a,
-	0x06, 0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x42,
-	0x75, 0x66, 0x66, 0x65, 0x72, 0x22, 0x3b, 0x0a, 0x0f, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f,
-	0x42, 0x69, 0x6e, 0x64, 0x47, 0x61, 0x74, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x55, 0x69, 0x64, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x55, 0x69, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x55, 0x73,
-	0x65, 0x72, 0x49, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x55, 0x73, 0x65, 0x72,
-	0x49, 0x64, 0x22, 0x7d, 0x0a, 0x13, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x42, 0x72, 0x6f,
-	0x61, 0x64, 0x43, 0x61, 0x73, 0x74, 0x4d, 0x73, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x54, 0x79, 0x70,
-	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1a, 0x0a,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x03, 0x6d, 0x73, 0x67, 0x22, 0xaa, 0x01, 0x0a, 0x10, 0x4c,
+	0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x47, 0x61, 0x74, 0x65, 0x12,
+	0x18, 0x0a, 0x07, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x07, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x55, 0x73, 0x65,
+	0x72, 0x49, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x55, 0x73, 0x65, 0x72, 0x49,
+	0x64, 0x12, 0x1a, 0x0a, 0x08, 0x57, 0x6f, 0x72, 0x6c, 0x64, 0x55, 0x69, 0x64, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x08, 0x57, 0x6f, 0x72, 0x6c, 0x64, 0x55, 0x69, 0x64, 0x12, 0x14, 0x0a,
+	0x05, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x54, 0x6f,
+	0x6b, 0x65, 0x6e, 0x12, 0x20, 0x0a, 0x0b, 0x52, 0x65, 0x73, 0x75, 0x6d, 0x65, 0x54, 0x6f, 0x6b,
+	0x65, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x52, 0x65, 0x73, 0x75, 0x6d, 0x65,
+	0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x10, 0x0a, 0x03, 0x53, 0x65, 0x71, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x03, 0x53, 0x65, 0x71, 0x22, 0x4a, 0x0a, 0x12, 0x4c, 0x6f, 0x75, 0x4d, 0x69,
+	0x61, 0x6f, 0x52, 0x70, 0x63, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x12, 0x1a, 0x0a,
+	0x08, 0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x08, 0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x42, 0x61, 0x6c,
+	0x61, 0x6e, 0x63, 0x65, 0x18, 0x02, 0x20, 0x03, 0x28, 0x05, 0x52, 0x07, 0x42, 0x61, 0x6c, 0x61,
+	0x6e, 0x63, 0x65, 0x22, 0x28, 0x0a, 0x0e, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x4b, 0x69,
+	0x63, 0x6b, 0x4f, 0x75, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x22, 0x60, 0x0a,
+	0x14, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x43, 0x6f,
+	0x6e, 0x6e, 0x65, 0x63, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49,
+	0x64, 0x12, 0x16, 0x0a, 0x06, 0x47, 0x61, 0x74, 0x65, 0x49, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x06, 0x47, 0x61, 0x74, 0x65, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x53, 0x74, 0x61,
+	0x74, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x53, 0x74, 0x61, 0x74, 0x65, 0x22,
+	0x8d, 0x02, 0x0a, 0x0d, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x52, 0x70, 0x63, 0x4d, 0x73,
+	0x67, 0x12, 0x1a, 0x0a, 0x08, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x08, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x49, 0x64, 0x12, 0x1a, 0x0a,
 	0x08, 0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
 	0x08, 0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x42, 0x75, 0x66,
 	0x66, 0x65, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x42, 0x75, 0x66, 0x66, 0x65,
-	0x72, 0x12, 0x1e, 0x0a, 0x0a, 0x42, 0x79, 0x74, 0x65, 0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x18,
-	0x04, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0a, 0x42, 0x79, 0x74, 0x65, 0x42, 0x75, 0x66, 0x66, 0x65,
-	0x72, 0x42, 0x23, 0x5a, 0x21, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
-	0x73, 0x6e, 0x6f, 0x77, 0x79, 0x79, 0x6a, 0x30, 0x30, 0x31, 0x2f, 0x6c, 0x6f, 0x75, 0x6d, 0x69,
-	0x61, 0x6f, 0x2f, 0x6d, 0x73, 0x67, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x72, 0x12, 0x1a, 0x0a, 0x08, 0x53, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x49, 0x64, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x08, 0x53, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x49, 0x64, 0x12, 0x1e, 0x0a,
+	0x0a, 0x42, 0x79, 0x74, 0x65, 0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x0a, 0x42, 0x79, 0x74, 0x65, 0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x12, 0x10, 0x0a,
+	0x03, 0x53, 0x65, 0x71, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x53, 0x65, 0x71, 0x12,
+	0x14, 0x0a, 0x05, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x18, 0x07, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05,
+	0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x08,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x42,
+	0x61, 0x6c, 0x61, 0x6e, 0x63, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x42, 0x61,
+	0x6c, 0x61, 0x6e, 0x63, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x48, 0x61, 0x73, 0x68, 0x4b, 0x65, 0x79,
+	0x18, 0x0a, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x48, 0x61, 0x73, 0x68, 0x4b, 0x65, 0x79, 0x22,
+	0x43, 0x0a, 0x0d, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x4e, 0x65, 0x74, 0x4d, 0x73, 0x67,
+	0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06,
+	0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x42, 0x75,
+	0x66, 0x66, 0x65, 0x72, 0x22, 0x3b, 0x0a, 0x0f, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x42,
+	0x69, 0x6e, 0x64, 0x47, 0x61, 0x74, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x55, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x55, 0x69, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x55, 0x73, 0x65,
+	0x72, 0x49, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x55, 0x73, 0x65, 0x72, 0x49,
+	0x64, 0x22, 0x7d, 0x0a, 0x13, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x42, 0x72, 0x6f, 0x61,
+	0x64, 0x43, 0x61, 0x73, 0x74, 0x4d, 0x73, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1a, 0x0a, 0x08,
+	0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x46, 0x75, 0x6e, 0x63, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x42, 0x75, 0x66, 0x66,
+	0x65, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x42, 0x75, 0x66, 0x66, 0x65, 0x72,
+	0x12, 0x1e, 0x0a, 0x0a, 0x42, 0x79, 0x74, 0x65, 0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x0a, 0x42, 0x79, 0x74, 0x65, 0x42, 0x75, 0x66, 0x66, 0x65, 0x72,
+	0x22, 0x91, 0x02, 0x0a, 0x0f, 0x4c, 0x6f, 0x75, 0x4d, 0x69, 0x61, 0x6f, 0x41, 0x63, 0x74, 0x6f,
+	0x72, 0x4d, 0x73, 0x67, 0x12, 0x1a, 0x0a, 0x08, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x49, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x49, 0x64,
+	0x12, 0x1a, 0x0a, 0x08, 0x53, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x49, 0x64, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x08, 0x53, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x49, 0x64, 0x12, 0x1c, 0x0a, 0x09,
+	0x41, 0x63, 0x74, 0x6f, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x09, 0x41, 0x63, 0x74, 0x6f, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x41, 0x63,
+	0x74, 0x6f, 0x72, 0x49, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x41, 0x63, 0x74,
+	0x6f, 0x72, 0x49, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x48, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x72, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x48, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x72, 0x12, 0x16,
+	0x0a, 0x06, 0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06,
+	0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x12, 0x1e, 0x0a, 0x0a, 0x42, 0x79, 0x74, 0x65, 0x42, 0x75,
+	0x66, 0x66, 0x65, 0x72, 0x18, 0x07, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0a, 0x42, 0x79, 0x74, 0x65,
+	0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x12, 0x10, 0x0a, 0x03, 0x53, 0x65, 0x71, 0x18, 0x08, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x03, 0x53, 0x65, 0x71, 0x12, 0x14, 0x0a, 0x05, 0x52, 0x65, 0x70, 0x6c,
+	0x79, 0x18, 0x09, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x14,
+	0x0a, 0x05, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x45,
+	0x72, 0x72, 0x6f, 0x72, 0x42, 0x23, 0x5a, 0x21, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63,
+	0x6f, 0x6d, 0x2f, 0x73, 0x6e, 0x6f, 0x77, 0x79, 0x79, 0x6a, 0x30, 0x30, 0x31, 0x2f, 0x6c, 0x6f,
+	0x75, 0x6d, 0x69, 0x61, 0x6f, 0x2f, 0x6d, 0x73, 0x67, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x33,
 }
 
 var (
@@ -560,7 +793,7 @@ func file_pbmsg_loumiao_proto_rawDescGZIP() []byte {
 	return file_pbmsg_loumiao_proto_rawDescData
 }
 
-var file_pbmsg_loumiao_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_pbmsg_loumiao_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_pbmsg_loumiao_proto_goTypes = []interface{}{
 	(*LouMiaoLoginGate)(nil),     // 0: msg.LouMiaoLoginGate
 	(*LouMiaoRpcRegister)(nil),   // 1: msg.LouMiaoRpcRegister
@@ -570,6 +803,7 @@ var file_pbmsg_loumiao_proto_goTypes = []interface{}{
 	(*LouMiaoNetMsg)(nil),        // 5: msg.LouMiaoNetMsg
 	(*LouMiaoBindGate)(nil),      // 6: msg.LouMiaoBindGate
 	(*LouMiaoBroadCastMsg)(nil),  // 7: msg.LouMiaoBroadCastMsg
+	(*LouMiaoActorMsg)(nil),      // 8: msg.LouMiaoActorMsg
 }
 var file_pbmsg_loumiao_proto_depIdxs = []int32{
 	0, // [0:0] is the sub-list for method output_type
@@ -681,6 +915,18 @@ func file_pbmsg_loumiao_proto_init() {
 				return nil
 			}
 		}
+		file_pbmsg_loumiao_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*LouMiaoActorMsg); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -688,7 +934,7 @@ func file_pbmsg_loumiao_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_pbmsg_loumiao_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   8,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
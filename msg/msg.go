@@ -12,4 +12,5 @@ func init() {
 	message.RegisterPacket(&LouMiaoRpcMsg{})
 	message.RegisterPacket(&LouMiaoNetMsg{})
 	message.RegisterPacket(&LouMiaoBindGate{})
+	message.RegisterPacket(&LouMiaoActorMsg{})
 }
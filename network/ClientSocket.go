@@ -20,6 +20,11 @@ type ClientSocket struct {
 	m_nMinClients int
 	Uid           int
 	SendTimes     int
+	m_Tls         *TlsLoader
+	m_CryptoCfg   *CryptoConfig
+	m_CompressCfg *CompressConfig
+	m_Reconnect   *reconnector
+	m_Sequence    bool
 }
 
 func (self *ClientSocket) Init(saddr string) bool {
@@ -34,13 +39,16 @@ func (self *ClientSocket) Start() bool {
 		llog.Error("ClientSocket.Start error : unkonwen socket type")
 		return false
 	}
-	self.m_bShuttingDown = false
+	self.setShuttingDown(false)
 	if self.m_sAddr == "" {
 		return false
 	}
+	if self.m_Reconnect != nil {
+		self.m_Reconnect.reset()
+	}
+	self.m_Seq = nil //重新开始计数
 
 	if self.Connect() {
-		self.m_Conn.(*net.TCPConn).SetNoDelay(true)
 		go clientRoutine(self)
 		return true
 	}
@@ -48,26 +56,87 @@ func (self *ClientSocket) Start() bool {
 }
 
 func (self *ClientSocket) Stop() bool {
-	if self.m_bShuttingDown {
-		return true
+	if self.m_Reconnect != nil {
+		self.m_Reconnect.stop()
 	}
-	self.m_bShuttingDown = true
+	self.setShuttingDown(true)
 	self.Close()
 	return true
 }
 
 func (self *ClientSocket) Send(buff []byte) int {
-	if self.m_Conn == nil {
+	if r := self.m_Reconnect; r != nil {
+		if n, ok := r.hold(buff); ok {
+			return n
+		}
+	}
+	return self.send(buff)
+}
+
+func (self *ClientSocket) send(buff []byte) int {
+	return self.sequence(buff, self.output)
+}
+
+//发送一个消息包，不加序号
+func (self *ClientSocket) output(buff []byte) int {
+	if !self.admit() {
+		return 0
+	}
+	n := 0
+	for _, frame := range self.pack(buff) {
+		if self.m_Crypto != nil {
+			n += self.m_Crypto.Send(frame)
+		} else {
+			n += self.write(frame)
+		}
+	}
+	return n
+}
+
+func (self *ClientSocket) write(buff []byte) int {
+	q := self.getSendQueue()
+	if q == nil {
 		return 0
 	}
 	//llog.Debugf("发送消息 %v", buff)
-	n, err := self.m_Conn.Write(buff)
-	handleError(err)
-	if n > 0 {
-		return n
+	return q.push(buff)
+}
+
+//开启tls，每次连接时使用最新的证书
+func (self *ClientSocket) SetTls(loader *TlsLoader) {
+	self.m_Tls = loader
+}
+
+//开启消息加密，连接建立后发起握手
+func (self *ClientSocket) SetCrypto(cfg *CryptoConfig) {
+	self.m_CryptoCfg = cfg
+}
+
+//开启消息压缩，连接建立后发起协商
+func (self *ClientSocket) SetCompress(cfg *CompressConfig) {
+	self.m_CompressCfg = cfg
+}
+
+//开启消息序号和确认，重连时保留序号，重发对方没有确认的消息包
+func (self *ClientSocket) SetSequence(on bool) {
+	self.m_Sequence = on
+}
+
+//开启断线重连
+func (self *ClientSocket) SetReconnect(cfg *ReconnectConfig) {
+	self.m_Reconnect = newReconnector(cfg)
+}
+
+//重连成功后第一个发送的消息包，例如LouMiaoLoginGate，需要先调用SetReconnect
+func (self *ClientSocket) SetLoginPacket(buff []byte) {
+	self.SetLoginFunc(func() []byte { return buff })
+}
+
+//重连成功后调用login生成第一个发送的消息包，例如带续连序号的LouMiaoLoginGate，返回nil不发送，需要先调用SetReconnect
+func (self *ClientSocket) SetLoginFunc(login func() []byte) {
+	if r := self.m_Reconnect; r != nil {
+		r.setLogin(login)
 	}
-	//self.m_Writer.Flush()
-	return 0
 }
 
 func (self *ClientSocket) Restart() bool {
@@ -75,7 +144,7 @@ func (self *ClientSocket) Restart() bool {
 }
 
 func (self *ClientSocket) Connect() bool {
-	if self.m_nState == SSF_CONNECT {
+	if self.GetState() == SSF_CONNECT {
 		return false
 	}
 
@@ -90,9 +159,31 @@ func (self *ClientSocket) Connect() bool {
 		llog.Errorf("ClientSocket DialTCP  %v", err1)
 		return false
 	}
+	ln.SetNoDelay(true)
+	var conn net.Conn = ln
+	if self.m_Tls != nil {
+		conn, err1 = self.m_Tls.Client(ln, self.m_sAddr)
+		if err1 != nil {
+			llog.Errorf("ClientSocket tls handshake %s: %v", self.m_sAddr, err1)
+			return false
+		}
+	}
 
-	self.m_nState = SSF_CONNECT
-	self.SetTcpConn(ln)
+	self.SetState(SSF_CONNECT)
+	self.SetTcpConn(conn)
+	self.startTcpQueue(conn)
+	if self.m_CryptoCfg != nil { //每次连接都重新握手
+		self.EnableCrypto(self.m_CryptoCfg, false, self.write, nil)
+		self.m_Crypto.handshake()
+	}
+	if self.m_CompressCfg != nil {
+		self.EnableCompress(self.m_CompressCfg, false, self.output)
+		self.m_Compress.handshake()
+	}
+	if self.m_Sequence {
+		self.EnableSequence(true, self.output)
+	}
+	self.startHeartbeat(self.output)
 	self.OnNetConn()
 
 	return true
@@ -107,6 +198,10 @@ func (self *ClientSocket) OnNetConn() {
 }
 
 func (self *ClientSocket) OnNetFail(int) {
+	if self.m_Reconnect != nil && self.m_Reconnect.begin(self.m_nConnectType) { //重连，不派发C_DISCONNECT
+		self.Close()
+		return
+	}
 	self.Stop()
 	buff, nLen := message.Encode(0, "C_DISCONNECT", nil)
 	self.HandlePacket(self.m_ClientId, buff, nLen)
@@ -120,35 +215,63 @@ func clientRoutine(pClient *ClientSocket) bool {
 			llog.Errorf("ClientSocket.clientRoutine %v: %s", r, buf[:l])
 		}
 	}()
-	if pClient.m_Conn == nil {
+	conn := pClient.conn()
+	if conn == nil {
 		return false
 	}
 	var buff = make([]byte, pClient.m_MaxReceiveBufferSize)
 	for {
-		if pClient.m_bShuttingDown {
+		if pClient.isShuttingDown() {
 			break
 		}
-		n, err := pClient.m_Conn.Read(buff)
+		n, err := conn.Read(buff)
 		if err == io.EOF {
-			llog.Debugf("0.远程链接：%s已经关闭: %s", pClient.m_Conn.RemoteAddr().String(), err.Error())
+			llog.Debugf("0.远程链接：%s已经关闭: %s", conn.RemoteAddr().String(), err.Error())
 			pClient.OnNetFail(0)
 			break
 		}
 		if err != nil {
-			llog.Debugf("1.远程链接：%s已经关闭: %s", pClient.m_Conn.RemoteAddr().String(), err.Error())
+			llog.Debugf("1.远程链接：%s已经关闭: %s", conn.RemoteAddr().String(), err.Error())
 			pClient.OnNetFail(1)
 			break
 		}
 		if n > 0 {
 			ok := pClient.ReceivePacket(pClient.m_ClientId, buff[:n])
 			if !ok {
-				llog.Debugf("2.远程链接：%s已经关闭: %d", pClient.m_Conn.RemoteAddr().String(), n)
+				llog.Debugf("2.远程链接：%s已经关闭: %d", conn.RemoteAddr().String(), n)
 				pClient.OnNetFail(2)
 				break
 			}
 		}
 	}
 
+	if pClient.m_Reconnect != nil && pClient.m_Reconnect.isDown() {
+		pClient.reconnect()
+		return true
+	}
 	pClient.Stop()
 	return true
 }
+
+//断线重连，在接收协程中调用，成功后启动新的接收协程
+func (self *ClientSocket) reconnect() {
+	r := self.m_Reconnect
+	ok := r.run(self.m_sAddr, func() bool {
+		self.SetConnectType(r.connectType)
+		return self.Connect()
+	}, func(buff []byte) int {
+		return self.relogin(buff, self.output)
+	}, self.send)
+	if ok {
+		self.setShuttingDown(false)
+		go clientRoutine(self)
+		return
+	}
+	stopped := r.isStopped()
+	self.Stop()
+	if stopped { //调用了Stop，不再派发C_DISCONNECT，避免删除新加入的同一个连接
+		return
+	}
+	buff, nLen := message.Encode(0, "C_DISCONNECT", nil)
+	self.HandlePacket(self.m_ClientId, buff, nLen)
+}
@@ -0,0 +1,115 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/snowyyj001/loumiao/util"
+)
+
+//拒绝连接的原因
+const (
+	REJECT_MAX_CONNS = iota //超过最大连接数
+	REJECT_PER_IP           //超过每个ip的最大连接数
+	REJECT_BANNED           //ip被封禁
+	REJECT_NUM
+)
+
+var RejectNames = [REJECT_NUM]string{"maxconns", "perip", "banned"}
+
+//客户端连接的准入控制，限制每个ip的连接数，封禁ip，ServerSocket和WebSocket共用
+type ConnLimiter struct {
+	maxPerIp int
+	lock     sync.Mutex
+	conns    map[string]int       //ip -> 连接数
+	bans     map[string]time.Time //ip -> 解封时间
+	rejected [REJECT_NUM]int64
+}
+
+//@maxPerIp: 每个ip的最大连接数，<=0不限制
+func NewConnLimiter(maxPerIp int) *ConnLimiter {
+	return &ConnLimiter{maxPerIp: maxPerIp, conns: make(map[string]int), bans: make(map[string]time.Time)}
+}
+
+//地址中的ip，"1.2.3.4:5678" -> "1.2.3.4"
+func RemoteIp(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+//是否允许新连接，允许时占用一个ip名额，连接断开时需要Release
+//@count: 当前连接数
+//@max: 最大连接数
+func (self *ConnLimiter) Accept(addr string, count, max int) bool {
+	if count >= max {
+		atomic.AddInt64(&self.rejected[REJECT_MAX_CONNS], 1)
+		return false
+	}
+	ip := RemoteIp(addr)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if until, ok := self.bans[ip]; ok {
+		if util.Now().Before(until) {
+			atomic.AddInt64(&self.rejected[REJECT_BANNED], 1)
+			return false
+		}
+		delete(self.bans, ip)
+	}
+	if self.maxPerIp > 0 && self.conns[ip] >= self.maxPerIp {
+		atomic.AddInt64(&self.rejected[REJECT_PER_IP], 1)
+		return false
+	}
+	self.conns[ip]++
+	return true
+}
+
+//连接断开，释放ip名额
+func (self *ConnLimiter) Release(addr string) {
+	ip := RemoteIp(addr)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if n := self.conns[ip]; n > 1 {
+		self.conns[ip] = n - 1
+	} else {
+		delete(self.conns, ip)
+	}
+}
+
+//封禁ip，期间拒绝新连接，已有的连接不受影响
+func (self *ConnLimiter) Ban(addr string, d time.Duration) {
+	ip := RemoteIp(addr)
+	now := util.Now()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.bans[ip] = now.Add(d)
+	for k, until := range self.bans { //顺便清理过期的
+		if !now.Before(until) && k != ip {
+			delete(self.bans, k)
+		}
+	}
+}
+
+//当前被封禁的ip数
+func (self *ConnLimiter) BanCount() int {
+	now := util.Now()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	count := 0
+	for _, until := range self.bans {
+		if now.Before(until) {
+			count++
+		}
+	}
+	return count
+}
+
+//累计拒绝的连接数
+//@reason: REJECT_*
+func (self *ConnLimiter) Rejected(reason int) int64 {
+	return atomic.LoadInt64(&self.rejected[reason])
+}
@@ -0,0 +1,71 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao/util"
+)
+
+type limiterClock struct {
+	now time.Time
+}
+
+func (self *limiterClock) Now() time.Time {
+	return self.now
+}
+
+func (self *limiterClock) AfterFunc(d time.Duration, f func()) util.ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+func TestConnLimiterPerIp(t *testing.T) {
+	limiter := NewConnLimiter(2)
+	if !limiter.Accept("1.1.1.1:1", 0, 10) || !limiter.Accept("1.1.1.1:2", 1, 10) {
+		t.Fatal("refused under limit")
+	}
+	if limiter.Accept("1.1.1.1:3", 2, 10) {
+		t.Fatal("accepted over per ip limit")
+	}
+	if !limiter.Accept("2.2.2.2:1", 2, 10) {
+		t.Fatal("other ip refused")
+	}
+	if limiter.Accept("3.3.3.3:1", 10, 10) {
+		t.Fatal("accepted over max conns")
+	}
+	limiter.Release("1.1.1.1:1")
+	if !limiter.Accept("1.1.1.1:4", 2, 10) {
+		t.Fatal("released slot not reusable")
+	}
+	if limiter.Rejected(REJECT_PER_IP) != 1 || limiter.Rejected(REJECT_MAX_CONNS) != 1 {
+		t.Fatalf("rejected: %d %d", limiter.Rejected(REJECT_PER_IP), limiter.Rejected(REJECT_MAX_CONNS))
+	}
+
+	//多余的Release不会让名额变成负数
+	limiter.Release("2.2.2.2:1")
+	limiter.Release("2.2.2.2:1")
+	if _, ok := limiter.conns["2.2.2.2"]; ok {
+		t.Fatal("released ip still counted")
+	}
+}
+
+func TestConnLimiterBan(t *testing.T) {
+	clock := &limiterClock{now: time.Unix(1000, 0)}
+	defer util.SetClock(util.SetClock(clock))
+
+	limiter := NewConnLimiter(0)
+	limiter.Ban("1.1.1.1:1", time.Minute)
+	if limiter.Accept("1.1.1.1:2", 0, 10) || limiter.BanCount() != 1 {
+		t.Fatal("banned ip accepted")
+	}
+	if !limiter.Accept("2.2.2.2:1", 0, 10) {
+		t.Fatal("other ip refused")
+	}
+	clock.now = clock.now.Add(time.Minute)
+	if limiter.BanCount() != 0 || !limiter.Accept("1.1.1.1:3", 1, 10) {
+		t.Fatal("ban not lifted")
+	}
+	if limiter.Rejected(REJECT_BANNED) != 1 {
+		t.Fatalf("rejected: %d", limiter.Rejected(REJECT_BANNED))
+	}
+}
@@ -0,0 +1,141 @@
+package network
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/util"
+	"github.com/snowyyj001/loumiao/util/timer"
+)
+
+//应用层心跳
+//连接NET_HEARTBEAT毫秒没有发送消息时发送PING，对方回复PONG，收到PONG时计算往返时间
+//连接NET_READ_IDLE毫秒没有收到任何数据时断开连接，和对方断开一样派发DISCONNECT
+//PING/PONG和CONNECT/DISCONNECT一样是内置消息：4字节长度+2字节0+2字节4+"PING"/"PONG"+8字节发送时间(纳秒)
+//PING/PONG由socket自己处理，不交给m_PacketFunc
+//默认关闭，检查使用共用的时间轮，不为每个连接启动协程
+
+const (
+	HEART_PING = "PING"
+	HEART_PONG = "PONG"
+	HEART_SIZE = 8 + 4 + 8 //PING/PONG的长度
+)
+
+//一个连接的心跳状态
+type heartbeat struct {
+	addr     string
+	send     func([]byte) int
+	queue    *sendQueue
+	seq      *packetSeq
+	interval int64 //NET_HEARTBEAT，开启时的配置
+	readIdle int64 //NET_READ_IDLE，开启时的配置
+	tick     int   //检查间隔，毫秒
+	lastRecv int64 //最后收到数据的时间，毫秒
+	rtt      int64 //最近一次的往返时间，纳秒
+}
+
+func heartPacket(name string, stamp int64) []byte {
+	buff := make([]byte, HEART_SIZE)
+	binary.BigEndian.PutUint32(buff, HEART_SIZE)
+	binary.BigEndian.PutUint16(buff[6:], uint16(len(name)))
+	copy(buff[8:], name)
+	binary.BigEndian.PutUint64(buff[12:], uint64(stamp))
+	return buff
+}
+
+func isHeartbeat(buff []byte) bool {
+	if len(buff) != HEART_SIZE || binary.BigEndian.Uint16(buff[6:]) != 4 {
+		return false
+	}
+	name := string(buff[8:12])
+	return name == HEART_PING || name == HEART_PONG
+}
+
+//连接建立后开启心跳，必须在创建发送队列和开启消息序号之后调用，连接关闭时自动停止
+//开启了消息序号时，心跳检查顺便发送没有确认的ACK
+//@send: 发送PING/PONG，需要经过加密和压缩
+func (self *Socket) startHeartbeat(send func([]byte) int) {
+	hb := &heartbeat{addr: self.m_sAddr, send: send, queue: self.getSendQueue(), seq: self.m_Seq, lastRecv: util.TimeStamp(),
+		interval: int64(config.NET_HEARTBEAT), readIdle: int64(config.NET_READ_IDLE)}
+	self.m_ConnLock.Lock()
+	self.m_Heartbeat = hb //重连时替换，GetRtt可能在其他协程中
+	self.m_ConnLock.Unlock()
+	if hb.queue != nil && (hb.interval > 0 || hb.readIdle > 0) {
+		//检查间隔取两个超时中较小的一半
+		tick := hb.interval
+		if tick <= 0 || (hb.readIdle > 0 && hb.readIdle < tick) {
+			tick = hb.readIdle
+		}
+		hb.tick = int(tick/2 + 1)
+		timer.AfterFunc(hb.tick, hb.check)
+	}
+}
+
+func (self *Socket) getHeartbeat() *heartbeat {
+	self.m_ConnLock.Lock()
+	defer self.m_ConnLock.Unlock()
+	return self.m_Heartbeat
+}
+
+//最近一次心跳的往返时间，还没有收到PONG时返回0
+func (self *Socket) GetRtt() time.Duration {
+	if hb := self.getHeartbeat(); hb != nil {
+		return time.Duration(atomic.LoadInt64(&hb.rtt))
+	}
+	return 0
+}
+
+//收到数据，在接收协程中调用
+func (self *Socket) onRecv() {
+	if hb := self.getHeartbeat(); hb != nil {
+		atomic.StoreInt64(&hb.lastRecv, util.TimeStamp())
+	}
+}
+
+//收到PING/PONG
+func (self *Socket) onHeartbeat(buff []byte) bool {
+	hb := self.getHeartbeat()
+	if hb == nil {
+		return true
+	}
+	stamp := int64(binary.BigEndian.Uint64(buff[12:]))
+	if string(buff[8:12]) == HEART_PING {
+		hb.send(heartPacket(HEART_PONG, stamp))
+	} else if rtt := time.Now().UnixNano() - stamp; rtt >= 0 {
+		atomic.StoreInt64(&hb.rtt, rtt)
+	}
+	return true
+}
+
+//在时间轮协程中执行，不能阻塞，连接关闭后不再继续检查
+func (self *heartbeat) check() {
+	select {
+	case <-self.queue.done:
+		return
+	default:
+	}
+	now := util.TimeStamp()
+	if self.readIdle > 0 && now-atomic.LoadInt64(&self.lastRecv) > self.readIdle {
+		llog.Infof("heartbeat: %s read idle timeout", self.addr)
+		self.queue.kill() //接收协程会返回错误，派发DISCONNECT
+		return
+	}
+	ping := self.interval > 0 && now-atomic.LoadInt64(&self.queue.last) >= self.interval
+	ack := self.seq != nil && self.seq.unacked()
+	if ping || ack { //发送可能等待发送队列或者加密锁
+		go self.beat(ping)
+	}
+	timer.AfterFunc(self.tick, self.check)
+}
+
+func (self *heartbeat) beat(ping bool) {
+	if ping {
+		self.send(heartPacket(HEART_PING, time.Now().UnixNano()))
+	}
+	if self.seq != nil {
+		self.seq.sendAck()
+	}
+}
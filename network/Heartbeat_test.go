@@ -0,0 +1,132 @@
+package network
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/message"
+	"github.com/snowyyj001/loumiao/util"
+)
+
+func withHeartbeat(heartbeat int, readIdle int) func() {
+	oldHeart, oldIdle := config.NET_HEARTBEAT, config.NET_READ_IDLE
+	config.NET_HEARTBEAT, config.NET_READ_IDLE = heartbeat, readIdle
+	return func() { config.NET_HEARTBEAT, config.NET_READ_IDLE = oldHeart, oldIdle }
+}
+
+func TestHeartbeatDefaultOff(t *testing.T) {
+	if config.NET_HEARTBEAT > 0 || config.NET_READ_IDLE > 0 {
+		t.Fatalf("heartbeat on by default: %d %d", config.NET_HEARTBEAT, config.NET_READ_IDLE)
+	}
+	conn := newFakeConn()
+	sock := &Socket{}
+	sock.m_SendQueue = newSendQueue("off", conn, conn.writev, CLIENT_CONNECT)
+	sock.startHeartbeat(func([]byte) int { return 0 })
+	if sock.m_Heartbeat.tick != 0 {
+		t.Fatalf("heartbeat check started: %d", sock.m_Heartbeat.tick)
+	}
+}
+
+func TestHeartbeatCheck(t *testing.T) {
+	defer withHeartbeat(20, 60)()
+	conn := newFakeConn()
+	close(conn.gate)
+	var pings int32
+	sock := &Socket{}
+	sock.m_SendQueue = newSendQueue("check", conn, conn.writev, CLIENT_CONNECT)
+	sock.startHeartbeat(func(buff []byte) int {
+		if isHeartbeat(buff) && string(buff[8:12]) == HEART_PING {
+			atomic.AddInt32(&pings, 1)
+		}
+		return len(buff)
+	})
+	hb := sock.m_Heartbeat
+	if hb.tick != 11 {
+		t.Fatalf("tick %d", hb.tick)
+	}
+
+	//一直收到数据，没有发送时发送PING
+	for i := 0; i < 10; i++ {
+		sock.onRecv()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, closed := conn.result(); closed || atomic.LoadInt32(&pings) == 0 {
+		t.Fatalf("closed %v, pings %d", closed, pings)
+	}
+
+	//收不到数据，读超时断开
+	atomic.StoreInt64(&hb.lastRecv, util.TimeStamp()-100)
+	waitFor(t, func() bool { _, closed := conn.result(); return closed })
+}
+
+func TestHeartbeatRtt(t *testing.T) {
+	defer withHeartbeat(20, 0)()
+	initMessage()
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	server := new(ServerSocket)
+	server.Init(addr)
+	server.SetMaxClients(4)
+	server.SetConnectType(SERVER_CONNECT)
+	server.BindPacketFunc(func(int, []byte, int) bool { return true })
+	if !server.Start() {
+		t.Fatal("server start failed")
+	}
+	defer server.Close()
+
+	client := new(ClientSocket)
+	client.Init(addr)
+	client.SetConnectType(CHILD_CONNECT)
+	disconnect := make(chan struct{}, 1)
+	client.BindPacketFunc(func(id int, buff []byte, nlen int) bool {
+		if _, _, name, _ := message.Decode(0, buff, nlen); name == "C_DISCONNECT" {
+			disconnect <- struct{}{}
+		}
+		return true
+	})
+	if !client.Start() {
+		t.Fatal("client start failed")
+	}
+	defer func() {
+		client.Stop()
+		waitDisconnect(disconnect)
+	}()
+	waitFor(t, func() bool { return client.GetRtt() > 0 })
+}
+
+func TestHeartbeatSwap(t *testing.T) {
+	restore := withHeartbeat(20, 60)
+	conn := newFakeConn()
+	close(conn.gate)
+	sock := &Socket{}
+	sock.setSendQueue(newSendQueue("swap", conn, conn.writev, CLIENT_CONNECT))
+	sock.SetState(SSF_CONNECT)
+	sock.startHeartbeat(func(buff []byte) int { return len(buff) })
+	restore() //开启后修改配置不影响已经开启的心跳
+	if hb := sock.getHeartbeat(); hb.interval != 20 || hb.readIdle != 60 {
+		t.Fatalf("config %d %d", hb.interval, hb.readIdle)
+	}
+
+	//重连时替换心跳，其他协程同时读取
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			sock.GetRtt()
+			sock.onRecv()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		sock.startHeartbeat(func(buff []byte) int { return len(buff) })
+	}
+	<-done
+	sock.Close()
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"net"
 	"runtime"
+	"sync"
+	"sync/atomic"
 
 	"github.com/snowyyj001/loumiao/base"
 	"github.com/xtaci/kcp-go"
@@ -27,7 +29,7 @@ const (
 )
 
 const (
-	MAX_WRITE_CHAN = 32
+	MAX_WRITE_CHAN = 32 //发送队列一次最多合并写入的消息包数
 )
 
 func handleError(err error) {
@@ -43,8 +45,9 @@ type (
 		m_Conn                 net.Conn
 		m_WsConn               *websocket.Conn
 		m_KcpConn              *kcp.UDPSession
+		m_ConnLock             sync.Mutex //保护连接、发送队列和心跳的替换，重连和关闭可能在不同的协程中
 		m_sAddr                string
-		m_nState               int
+		m_nState               int32 //SSF_*，原子操作访问
 		m_nConnectType         int
 		m_MaxReceiveBufferSize int
 		m_MaxSendBufferSize    int
@@ -57,11 +60,21 @@ type (
 
 		m_SendTimes     int
 		m_ReceiveTimes  int
-		m_bShuttingDown bool
+		m_bShuttingDown int32 //1代表已经关闭，原子操作访问
 		m_PacketFunc    HandleFunc
 
 		m_pInBufferLen int
 		m_pInBuffer    []byte
+
+		m_Crypto   *packetCrypto   //消息加密，nil代表不加密
+		m_Compress *packetCompress //消息压缩，nil代表不压缩
+
+		m_SendQueue *sendQueue //异步发送队列，连接建立时创建，在m_ConnLock中访问
+		m_Heartbeat *heartbeat //心跳，连接建立时创建，在m_ConnLock中访问
+		m_Seq       *packetSeq //消息序号，nil代表不带序号
+
+		m_FragmentSeq uint32               //发送的分包消息序号
+		m_Fragments   map[uint32]*fragment //正在组装的分包消息，只在接收协程中访问
 	}
 
 	ISocket interface {
@@ -118,11 +131,43 @@ func (self *Socket) OnNetFail(int) {
 }
 
 func (self *Socket) GetState() int {
-	return self.m_nState
+	return int(atomic.LoadInt32(&self.m_nState))
 }
 
 func (self *Socket) SetState(state int) {
-	self.m_nState = state
+	atomic.StoreInt32(&self.m_nState, int32(state))
+}
+
+//接收协程每次读取前检查
+func (self *Socket) isShuttingDown() bool {
+	return atomic.LoadInt32(&self.m_bShuttingDown) == 1
+}
+
+func (self *Socket) setShuttingDown(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&self.m_bShuttingDown, v)
+}
+
+//当前的tcp连接，重连时会被替换
+func (self *Socket) conn() net.Conn {
+	self.m_ConnLock.Lock()
+	defer self.m_ConnLock.Unlock()
+	return self.m_Conn
+}
+
+func (self *Socket) wsConn() *websocket.Conn {
+	self.m_ConnLock.Lock()
+	defer self.m_ConnLock.Unlock()
+	return self.m_WsConn
+}
+
+func (self *Socket) kcpConn() *kcp.UDPSession {
+	self.m_ConnLock.Lock()
+	defer self.m_ConnLock.Unlock()
+	return self.m_KcpConn
 }
 
 func (self *Socket) Send([]byte) int {
@@ -144,26 +189,37 @@ func (self *Socket) GetSAddr() string {
 }
 
 func (self *Socket) Clear() {
-	self.m_nState = SSF_SHUT_DOWN
+	self.SetState(SSF_SHUT_DOWN)
+	self.m_ConnLock.Lock()
 	self.m_Conn = nil
 	self.m_WsConn = nil
 	self.m_KcpConn = nil
-	self.m_bShuttingDown = true
+	self.m_ConnLock.Unlock()
+	self.setShuttingDown(true)
 	self.m_nConnectType = -1
 }
 
+//可以在多个协程中同时调用，只有第一次生效
 func (self *Socket) Close() {
-	if self.m_nState == SSF_SHUT_DOWN {
+	if atomic.SwapInt32(&self.m_nState, SSF_SHUT_DOWN) == SSF_SHUT_DOWN {
 		return
 	}
-	if self.m_Conn != nil {
-		self.m_Conn.Close()
-	}
-	if self.m_WsConn != nil {
-		self.m_WsConn.Close()
-	}
-	if self.m_KcpConn != nil {
-		self.m_KcpConn.Close()
+	self.m_ConnLock.Lock()
+	q := self.m_SendQueue
+	self.m_SendQueue = nil
+	self.m_ConnLock.Unlock()
+	if q != nil { //队列中剩余的消息包发送完后关闭连接
+		q.close()
+	} else {
+		if conn := self.conn(); conn != nil {
+			conn.Close()
+		}
+		if conn := self.wsConn(); conn != nil {
+			conn.Close()
+		}
+		if conn := self.kcpConn(); conn != nil {
+			conn.Close()
+		}
 	}
 	self.Clear()
 
@@ -186,29 +242,39 @@ func (self *Socket) SetConnectType(nType int) {
 		self.m_MaxSendBufferSize = config.NET_BUFFER_SIZE
 		self.m_MaxReceiveBufferSize = config.NET_BUFFER_SIZE
 	}
+	self.m_Fragments = nil
+	self.m_pInBufferLen = 0
 	self.m_pInBuffer = make([]byte, self.m_MaxReceiveBufferSize) //预先申请一份内存来换取临时申请，减少gc但每个socket会申请2倍的m_MaxReceiveBufferSize内存大小
 }
 
 func (self *Socket) SetUdpConn(conn net.Conn) {
+	self.m_ConnLock.Lock()
 	self.m_Conn = conn
+	self.m_ConnLock.Unlock()
 	//self.m_Reader = bufio.NewReader(conn)
 	//self.m_Writer = bufio.NewWriter(conn)
 }
 
 func (self *Socket) SetTcpConn(conn net.Conn) {
+	self.m_ConnLock.Lock()
 	self.m_Conn = conn
+	self.m_ConnLock.Unlock()
 	//self.m_Reader = bufio.NewReader(conn)
 	//self.m_Writer = bufio.NewWriter(conn)
 }
 
 func (self *Socket) SetWsConn(conn *websocket.Conn) {
+	self.m_ConnLock.Lock()
 	self.m_WsConn = conn
+	self.m_ConnLock.Unlock()
 	//self.m_Reader = bufio.NewReader(conn)
 	//self.m_Writer = bufio.NewWriter(conn)
 }
 
 func (self *Socket) SetKcpConn(conn *kcp.UDPSession) {
+	self.m_ConnLock.Lock()
 	self.m_KcpConn = conn
+	self.m_ConnLock.Unlock()
 }
 
 func (self *Socket) BindPacketFunc(callfunc HandleFunc) {
@@ -222,6 +288,21 @@ func (self *Socket) HandlePacket(Id int, buff []byte, nlen int) bool {
 	return self.m_PacketFunc(Id, buff, nlen)
 }
 
+//压缩的握手包、分包、心跳和ACK自己处理，其他交给m_PacketFunc
+func (self *Socket) handlePacket(Id int, buff []byte, nLen int) bool {
+	switch binary.BigEndian.Uint16(buff[4:]) {
+	case COMPRESS_MARK:
+		return self.onCompressHello(buff[:nLen])
+	case FRAGMENT_MARK:
+		return self.onFragment(Id, buff[:nLen])
+	case 0:
+		if isHeartbeat(buff[:nLen]) {
+			return self.onHeartbeat(buff[:nLen])
+		}
+	}
+	return self.dispatch(Id, buff, nLen)
+}
+
 func (self *Socket) ReceivePacket(Id int, dat []byte) bool {
 	defer func() {
 		if r := recover(); r != nil {
@@ -231,31 +312,42 @@ func (self *Socket) ReceivePacket(Id int, dat []byte) bool {
 		}
 	}()
 	//llog.Debugf("收到消息包 %v %d", dat, len(dat))
-	copy(self.m_pInBuffer[self.m_pInBufferLen:], dat)
-	self.m_pInBufferLen += len(dat)
-	for {
-		if self.m_pInBufferLen < 8 {
-			break
-		}
-		mbuff1 := self.m_pInBuffer[0:4]
-		nLen := int(base.BytesToUInt32(mbuff1, binary.BigEndian)) //消息总长度
-		//llog.Debugf("当前消息包长度 %d", nLen1)
-		if nLen > self.m_pInBufferLen {
-			break
-		}
-		if nLen > self.m_MaxReceiveBufferSize {
-			llog.Errorf("ReceivePacket: 包长度越界[%d][%d]", nLen, self.m_MaxReceiveBufferSize) // 接受包错误
-			self.Close()
-			return false
-		}
-
-		ok := self.HandlePacket(Id, self.m_pInBuffer, nLen)
-		if ok == false {
-			llog.Error("ReceivePacket HandlePacket error")
-			return false
+	self.onRecv()
+	for len(dat) > 0 { //dat可能比剩余的缓存大，分多次放入
+		n := copy(self.m_pInBuffer[self.m_pInBufferLen:], dat)
+		dat = dat[n:]
+		self.m_pInBufferLen += n
+		for {
+			if self.m_pInBufferLen < 8 {
+				break
+			}
+			mbuff1 := self.m_pInBuffer[0:4]
+			nLen := int(base.BytesToUInt32(mbuff1, binary.BigEndian)) //消息总长度
+			//llog.Debugf("当前消息包长度 %d", nLen1)
+			if nLen > len(self.m_pInBuffer) || nLen < 8 {
+				llog.Errorf("ReceivePacket: 包长度越界[%d][%d]", nLen, self.m_MaxReceiveBufferSize) // 接受包错误
+				self.Close()
+				return false
+			}
+			if nLen > self.m_pInBufferLen {
+				break
+			}
+
+			var ok bool
+			if self.m_Crypto != nil {
+				ok = self.m_Crypto.receive(self.m_pInBuffer[:nLen], func(buff []byte) bool {
+					return self.handlePacket(Id, buff, len(buff))
+				})
+			} else {
+				ok = self.handlePacket(Id, self.m_pInBuffer, nLen)
+			}
+			if ok == false {
+				llog.Error("ReceivePacket HandlePacket error")
+				return false
+			}
+			copy(self.m_pInBuffer, self.m_pInBuffer[nLen:self.m_pInBufferLen])
+			self.m_pInBufferLen -= nLen
 		}
-		copy(self.m_pInBuffer, self.m_pInBuffer[nLen:self.m_pInBufferLen])
-		self.m_pInBufferLen -= nLen
 	}
 	return true
 }
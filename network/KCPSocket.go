@@ -38,15 +38,17 @@ const (
 
 type KcpSocket struct {
 	Socket
-	m_nClientCount  int
-	m_nMaxClients   int
-	m_nMinClients   int
-	m_nIdSeed       int32
-	m_bShuttingDown bool
-	m_ClientList    map[int]*KCPSocketClient
-	m_ClientLocker  *sync.RWMutex
-	m_Lock          sync.Mutex
-	m_Listen        *kcp.Listener
+	m_nClientCount int32 //原子操作访问
+	m_nMaxClients  int
+	m_nMinClients  int
+	m_nIdSeed      int32
+	m_ClientList   map[int]*KCPSocketClient
+	m_ClientLocker *sync.RWMutex
+	m_Lock         sync.Mutex
+	m_Listen       *kcp.Listener
+	m_CryptoCfg    *CryptoConfig
+	m_CompressCfg  *CompressConfig
+	m_Sequence     bool
 }
 
 func (self *KcpSocket) Init(saddr string) bool {
@@ -60,7 +62,7 @@ func (self *KcpSocket) Start() bool {
 		llog.Error("KcpSocket.Start error : unkonwen socket type")
 		return false
 	}
-	self.m_bShuttingDown = false
+	self.setShuttingDown(false)
 
 	if self.m_sAddr == "" {
 		llog.Error("KcpSocket Start error, saddr is null")
@@ -77,7 +79,7 @@ func (self *KcpSocket) Start() bool {
 	ln.SetDSCP(KCPDSCP)
 	llog.Infof("KcpSocket 启动监听，等待链接！%s", self.m_sAddr)
 	self.m_Listen = ln
-	self.m_nState = SSF_ACCEPT
+	self.SetState(SSF_ACCEPT)
 	go kcpRoutine(self)
 	return true
 }
@@ -100,7 +102,7 @@ func (self *KcpSocket) GetClientById(id int) *KCPSocketClient {
 func (self *KcpSocket) ClientRemoteAddr(clientid int) string {
 	pClinet := self.GetClientById(clientid)
 	if pClinet != nil {
-		return pClinet.m_KcpConn.RemoteAddr().String()
+		return pClinet.m_sAddr
 	}
 	return ""
 }
@@ -108,9 +110,9 @@ func (self *KcpSocket) ClientRemoteAddr(clientid int) string {
 func (self *KcpSocket) DelClinet(pClient *KCPSocketClient) bool {
 	self.m_ClientLocker.Lock()
 	delete(self.m_ClientList, pClient.m_ClientId)
-	llog.Debugf("KcpSocket 客户端：%s已断开连接[%d]", pClient.m_KcpConn.RemoteAddr().String(), pClient.m_ClientId)
+	llog.Debugf("KcpSocket 客户端：%s已断开连接[%d]", pClient.m_sAddr, pClient.m_ClientId)
 	self.m_ClientLocker.Unlock()
-	self.m_nClientCount--
+	atomic.AddInt32(&self.m_nClientCount, -1)
 	return true
 }
 
@@ -140,6 +142,21 @@ func (self *KcpSocket) SetMaxClients(maxnum int) {
 	self.m_nMaxClients = maxnum
 }
 
+//开启消息加密，客户端连接后需要先握手，必须在Start之前调用
+func (self *KcpSocket) SetCrypto(cfg *CryptoConfig) {
+	self.m_CryptoCfg = cfg
+}
+
+//开启消息压缩，客户端发起协商，必须在Start之前调用
+func (self *KcpSocket) SetCompress(cfg *CompressConfig) {
+	self.m_CompressCfg = cfg
+}
+
+//开启消息序号和确认，客户端发来带序号的消息包后回复的也带序号，必须在Start之前调用
+func (self *KcpSocket) SetSequence(on bool) {
+	self.m_Sequence = on
+}
+
 func (self *KcpSocket) SendById(id int, buff []byte) int {
 	pClient := self.GetClientById(id)
 	if pClient != nil {
@@ -164,15 +181,17 @@ func (self *KcpSocket) Disconnect(bool) bool {
 }
 
 func (self *KcpSocket) Stop() bool {
-	if self.m_bShuttingDown {
+	if !atomic.CompareAndSwapInt32(&self.m_bShuttingDown, 0, 1) {
 		return true
 	}
-	self.m_bShuttingDown = true
 	self.Close()
 	return true
 }
 
 func (self *KcpSocket) Close() {
+	if atomic.SwapInt32(&self.m_nState, SSF_SHUT_DOWN) == SSF_SHUT_DOWN { //监听协程出错时也会调用
+		return
+	}
 	self.m_Listen.Close()
 	self.Clear()
 }
@@ -185,12 +204,23 @@ func (self *KcpSocket) AddClinet(kcpConn *kcp.UDPSession, addr string, connectTy
 		pClient.m_ClientId = self.AssignClientId()
 		pClient.SetConnectType(connectType)
 		pClient.SetKcpConn(kcpConn)
+		pClient.startKcpQueue(kcpConn)
 		pClient.BindPacketFunc(self.m_PacketFunc)
+		if self.m_CryptoCfg != nil {
+			pClient.EnableCrypto(self.m_CryptoCfg, true, pClient.write, func() { kcpConn.Close() })
+		}
+		if self.m_CompressCfg != nil {
+			pClient.EnableCompress(self.m_CompressCfg, true, pClient.output)
+		}
+		if self.m_Sequence {
+			pClient.EnableSequence(false, pClient.output)
+		}
+		pClient.startHeartbeat(pClient.output)
 		self.m_ClientLocker.Lock()
 		self.m_ClientList[pClient.m_ClientId] = pClient
 		self.m_ClientLocker.Unlock()
 		pClient.Start()
-		self.m_nClientCount++
+		atomic.AddInt32(&self.m_nClientCount, 1)
 		llog.Debugf("KcpSocket 客户端：%s已连接[%d]", kcpConn.RemoteAddr().String(), pClient.m_ClientId)
 		return pClient
 	} else {
@@ -208,7 +238,7 @@ func kcpRoutine(server *KcpSocket) {
 			continue
 		}
 
-		if server.m_nClientCount >= server.m_nMaxClients {
+		if int(atomic.LoadInt32(&server.m_nClientCount)) >= server.m_nMaxClients {
 			kcpConn.Close()
 			llog.Warning("kcpRoutine: too many conns")
 			continue
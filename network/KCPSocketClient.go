@@ -2,7 +2,6 @@ package network
 
 import (
 	"runtime"
-	"time"
 
 	"github.com/snowyyj001/loumiao/llog"
 
@@ -11,10 +10,7 @@ import (
 
 type KCPSocketClient struct {
 	Socket
-	m_pServer   *KcpSocket
-	mHeartTimer *time.Timer
-	mMsgRecved  bool
-	mHeartDone  chan bool
+	m_pServer *KcpSocket
 }
 
 func (self *KCPSocketClient) Start() bool {
@@ -22,15 +18,15 @@ func (self *KCPSocketClient) Start() bool {
 		llog.Error("KCPSocketClient.Start error : unkonwen socket type")
 		return false
 	}
-	if self.m_nState != SSF_SHUT_DOWN {
+	if self.GetState() != SSF_SHUT_DOWN {
 		return false
 	}
 
 	if self.m_pServer == nil {
 		return false
 	}
-	self.m_bShuttingDown = false
-	self.m_nState = SSF_CONNECT
+	self.setShuttingDown(false)
+	self.SetState(SSF_CONNECT)
 
 	self.OnNetConn()
 	go kcpclientRoutine(self)
@@ -39,43 +35,36 @@ func (self *KCPSocketClient) Start() bool {
 }
 
 func (self *KCPSocketClient) Send(buff []byte) int {
-	n, err := self.m_KcpConn.Write(buff)
-	if err != nil {
-		llog.Errorf("KCPSocketClient.Send error: %s", err.Error())
+	return self.sequence(buff, self.output)
+}
+
+//发送一个消息包，不加序号
+func (self *KCPSocketClient) output(buff []byte) int {
+	if !self.admit() {
 		return 0
 	}
-	if n > 0 {
-		return n
+	n := 0
+	for _, frame := range self.pack(buff) {
+		if self.m_Crypto != nil {
+			n += self.m_Crypto.Send(frame)
+		} else {
+			n += self.write(frame)
+		}
 	}
-	return 0
+	return n
+}
+
+func (self *KCPSocketClient) write(buff []byte) int {
+	q := self.getSendQueue()
+	if q == nil {
+		return 0
+	}
+	return q.push(buff)
 }
 
 func (self *KCPSocketClient) OnNetConn() {
 	buff, nLen := message.Encode(0, "CONNECT", nil)
 	self.HandlePacket(self.m_ClientId, buff, nLen)
-
-	delat := time.Duration(KCPTIMEOUT) * time.Second
-	self.mHeartTimer = time.NewTimer(delat)
-	self.mHeartDone = make(chan bool)
-
-	go func() {
-		for {
-			select {
-			case <-self.mHeartTimer.C:
-				if self.mMsgRecved {
-					self.mHeartTimer.Reset(delat)
-					self.mMsgRecved = false
-				} else {
-					if self.m_KcpConn != nil {
-						self.m_KcpConn.Close()
-					}
-					return
-				}
-			case <-self.mHeartDone:
-				return
-			}
-		}
-	}()
 }
 
 func (self *KCPSocketClient) OnNetFail(errcode int) {
@@ -90,7 +79,6 @@ func (self *KCPSocketClient) Close() {
 		self.m_pServer = nil
 	}
 	self.Socket.Close()
-	self.mHeartDone <- true
 }
 
 func kcpclientRoutine(pClient *KCPSocketClient) bool {
@@ -101,18 +89,19 @@ func kcpclientRoutine(pClient *KCPSocketClient) bool {
 			llog.Errorf("KCPSocketClient.kcpclientRoutine %v: %s", r, buf[:l])
 		}
 	}()
-	if pClient.m_KcpConn == nil {
+	conn := pClient.kcpConn()
+	if conn == nil {
 		return false
 	}
 	var buff = make([]byte, pClient.m_MaxReceiveBufferSize)
 	for {
-		if pClient.m_bShuttingDown {
+		if pClient.isShuttingDown() {
 			llog.Infof("KCPSocketClient远程链接：%s已经被关闭！", pClient.GetSAddr())
 			pClient.OnNetFail(0)
 			break
 		}
 
-		n, err := pClient.m_KcpConn.Read(buff)
+		n, err := conn.Read(buff)
 		if err != nil {
 			llog.Infof("KCPSocketClient远程read错误: %s！ %s", pClient.GetSAddr(), err.Error())
 			pClient.OnNetFail(2)
@@ -127,7 +116,6 @@ func kcpclientRoutine(pClient *KCPSocketClient) bool {
 				break
 			}
 		}
-		pClient.mMsgRecved = true
 	}
 
 	pClient.Close()
@@ -6,15 +6,15 @@ import (
 	"github.com/xtaci/kcp-go"
 	"io"
 	"runtime"
-	"time"
 )
 
 type KcpClient struct {
 	Socket
 
-	mHeartTimer *time.Timer
-	mMsgRecved  bool
-	mHeartDone  chan bool
+	m_CryptoCfg   *CryptoConfig
+	m_CompressCfg *CompressConfig
+	m_Reconnect   *reconnector
+	m_Sequence    bool
 }
 
 func (self *KcpClient) Init(saddr string) bool {
@@ -29,10 +29,14 @@ func (self *KcpClient) Start() bool {
 		llog.Error("KcpClient.Start error : unkonwen socket type")
 		return false
 	}
-	self.m_bShuttingDown = false
+	self.setShuttingDown(false)
 	if self.m_sAddr == "" {
 		return false
 	}
+	if self.m_Reconnect != nil {
+		self.m_Reconnect.reset()
+	}
+	self.m_Seq = nil //重新开始计数
 
 	if self.Connect() {
 		go clientKcpRoutine(self)
@@ -42,25 +46,91 @@ func (self *KcpClient) Start() bool {
 }
 
 func (self *KcpClient) Send(buff []byte) int {
-	if self.m_KcpConn == nil {
-		return 0
+	if r := self.m_Reconnect; r != nil {
+		if n, ok := r.hold(buff); ok {
+			return n
+		}
 	}
-	//llog.Debugf("发送消息 %v", buff)
-	n, err := self.m_KcpConn.Write(buff)
-	if err != nil {
-		llog.Errorf("KcpClient.Send error : %s", err.Error())
+	return self.send(buff)
+}
+
+func (self *KcpClient) send(buff []byte) int {
+	return self.sequence(buff, self.output)
+}
+
+//发送一个消息包，不加序号
+func (self *KcpClient) output(buff []byte) int {
+	if !self.admit() {
 		return 0
 	}
-	//self.m_Writer.Flush()
+	n := 0
+	for _, frame := range self.pack(buff) {
+		if self.m_Crypto != nil {
+			n += self.m_Crypto.Send(frame)
+		} else {
+			n += self.write(frame)
+		}
+	}
 	return n
 }
 
+func (self *KcpClient) write(buff []byte) int {
+	q := self.getSendQueue()
+	if q == nil {
+		return 0
+	}
+	//llog.Debugf("发送消息 %v", buff)
+	return q.push(buff)
+}
+
+//开启消息加密，连接建立后发起握手
+func (self *KcpClient) SetCrypto(cfg *CryptoConfig) {
+	self.m_CryptoCfg = cfg
+}
+
+//开启消息压缩，连接建立后发起协商
+func (self *KcpClient) SetCompress(cfg *CompressConfig) {
+	self.m_CompressCfg = cfg
+}
+
+//开启消息序号和确认，重连时保留序号，重发对方没有确认的消息包
+func (self *KcpClient) SetSequence(on bool) {
+	self.m_Sequence = on
+}
+
+//开启断线重连
+func (self *KcpClient) SetReconnect(cfg *ReconnectConfig) {
+	self.m_Reconnect = newReconnector(cfg)
+}
+
+//重连成功后第一个发送的消息包，需要先调用SetReconnect
+func (self *KcpClient) SetLoginPacket(buff []byte) {
+	self.SetLoginFunc(func() []byte { return buff })
+}
+
+//重连成功后调用login生成第一个发送的消息包，例如带续连序号的LouMiaoLoginGate，返回nil不发送，需要先调用SetReconnect
+func (self *KcpClient) SetLoginFunc(login func() []byte) {
+	if r := self.m_Reconnect; r != nil {
+		r.setLogin(login)
+	}
+}
+
+//关闭连接，不再重连
+func (self *KcpClient) Stop() bool {
+	if self.m_Reconnect != nil {
+		self.m_Reconnect.stop()
+	}
+	self.setShuttingDown(true)
+	self.Close()
+	return true
+}
+
 func (self *KcpClient) Restart() bool {
 	return true
 }
 
 func (self *KcpClient) Connect() bool {
-	if self.m_nState == SSF_CONNECT {
+	if self.GetState() == SSF_CONNECT {
 		return false
 	}
 	kcpConn, err := kcp.DialWithOptions(self.m_sAddr, nil, 0, 0)
@@ -92,8 +162,21 @@ func (self *KcpClient) Connect() bool {
 		llog.Errorf("KcpClient.SetDSCP: error %s", err.Error())
 		return false
 	}
-	self.m_nState = SSF_CONNECT
+	self.SetState(SSF_CONNECT)
 	self.SetKcpConn(kcpConn)
+	self.startKcpQueue(kcpConn)
+	if self.m_CryptoCfg != nil { //每次连接都重新握手
+		self.EnableCrypto(self.m_CryptoCfg, false, self.write, nil)
+		self.m_Crypto.handshake()
+	}
+	if self.m_CompressCfg != nil {
+		self.EnableCompress(self.m_CompressCfg, false, self.output)
+		self.m_Compress.handshake()
+	}
+	if self.m_Sequence {
+		self.EnableSequence(true, self.output)
+	}
+	self.startHeartbeat(self.output)
 	self.OnNetConn()
 
 	return true
@@ -105,33 +188,16 @@ func (self *KcpClient) OnDisconnect() {
 func (self *KcpClient) OnNetConn() {
 	buff, nLen := message.Encode(0, "C_CONNECT", nil)
 	self.HandlePacket(self.m_ClientId, buff, nLen)
-
-	delat := time.Duration(KCPTIMEOUT) * time.Second
-	go func() {
-		for {
-			select {
-			case <-self.mHeartTimer.C:
-				if self.mMsgRecved {
-					self.mHeartTimer.Reset(delat)
-					self.mMsgRecved = false
-				} else {
-					if self.m_KcpConn != nil {
-						self.m_KcpConn.Close()
-					}
-					return
-				}
-			case <-self.mHeartDone:
-				return
-			}
-		}
-	}()
 }
 
 func (self *KcpClient) OnNetFail(int) {
+	if self.m_Reconnect != nil && self.m_Reconnect.begin(self.m_nConnectType) { //重连，不派发C_DISCONNECT
+		self.Close()
+		return
+	}
 	buff, nLen := message.Encode(0, "C_DISCONNECT", nil)
 	self.HandlePacket(self.m_ClientId, buff, nLen)
 	self.Close()
-	self.mHeartDone <- true
 }
 
 func clientKcpRoutine(pClient *KcpClient) bool {
@@ -142,36 +208,62 @@ func clientKcpRoutine(pClient *KcpClient) bool {
 			llog.Errorf("KcpClient.clientRoutine %v: %s", r, buf[:l])
 		}
 	}()
-	if pClient.m_KcpConn == nil {
+	conn := pClient.kcpConn()
+	if conn == nil {
 		return false
 	}
 	var buff = make([]byte, pClient.m_MaxReceiveBufferSize)
 	for {
-		if pClient.m_bShuttingDown {
+		if pClient.isShuttingDown() {
 			break
 		}
-		n, err := pClient.m_KcpConn.Read(buff)
+		n, err := conn.Read(buff)
 		if err == io.EOF {
-			llog.Debugf("0.KcpClient远程链接：%s已经关闭: %s", pClient.m_KcpConn.RemoteAddr().String(), err.Error())
+			llog.Debugf("0.KcpClient远程链接：%s已经关闭: %s", conn.RemoteAddr().String(), err.Error())
 			pClient.OnNetFail(0)
 			break
 		}
 		if err != nil {
-			llog.Debugf("1.KcpClient远程链接：%s已经关闭: %s", pClient.m_KcpConn.RemoteAddr().String(), err.Error())
+			llog.Debugf("1.KcpClient远程链接：%s已经关闭: %s", conn.RemoteAddr().String(), err.Error())
 			pClient.OnNetFail(1)
 			break
 		}
 		if n > 0 {
 			ok := pClient.ReceivePacket(pClient.m_ClientId, buff[:n])
 			if !ok {
-				llog.Debugf("2.KcpClient远程链接：%s已经关闭: %d", pClient.m_KcpConn.RemoteAddr().String(), n)
+				llog.Debugf("2.KcpClient远程链接：%s已经关闭: %d", conn.RemoteAddr().String(), n)
 				pClient.OnNetFail(2)
 				break
 			}
 		}
-		pClient.mMsgRecved = true
 	}
 
+	if pClient.m_Reconnect != nil && pClient.m_Reconnect.isDown() {
+		pClient.reconnect()
+		return true
+	}
 	pClient.Close()
 	return true
 }
+
+//断线重连，在接收协程中调用，成功后启动新的接收协程
+func (self *KcpClient) reconnect() {
+	r := self.m_Reconnect
+	ok := r.run(self.m_sAddr, func() bool {
+		self.SetConnectType(r.connectType)
+		return self.Connect()
+	}, func(buff []byte) int {
+		return self.relogin(buff, self.output)
+	}, self.send)
+	if ok {
+		self.setShuttingDown(false)
+		go clientKcpRoutine(self)
+		return
+	}
+	self.Close()
+	if r.isStopped() { //调用了Stop，不再派发C_DISCONNECT
+		return
+	}
+	buff, nLen := message.Encode(0, "C_DISCONNECT", nil)
+	self.HandlePacket(self.m_ClientId, buff, nLen)
+}
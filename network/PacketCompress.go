@@ -0,0 +1,133 @@
+package network
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/message"
+)
+
+//消息压缩的协商
+//连接建立后客户端发送握手包，列出自己的算法，服务端选择第一个自己也配置了的算法，回复握手包
+//双方收到握手包后开始压缩发送的消息包，之前的消息包不压缩，解压由message.Decode完成
+//握手包：4字节长度+2字节0xFFFE+2字节0+1字节版本+1字节算法数量n+n字节算法
+//没有配置压缩的一方忽略握手包，双方都不压缩
+
+const (
+	COMPRESS_VERSION = 1
+	COMPRESS_MARK    = 0xFFFE //握手包的target
+)
+
+//压缩配置，服务端和客户端使用同一个结构
+type CompressConfig struct {
+	Codecs    []int //客户端按优先级提供，服务端按客户端的顺序选择第一个自己支持的
+	Threshold int   //超过这个大小的消息包才压缩
+}
+
+//按照配置创建
+func NewCompressConfig(cfg *config.CompressCfg) (*CompressConfig, error) {
+	self := &CompressConfig{Threshold: cfg.Threshold}
+	for _, name := range cfg.Codecs {
+		id, err := message.CodecId(name)
+		if err != nil {
+			return nil, err
+		}
+		if !message.HasCodec(id) {
+			llog.Warningf("compress: codec %s not registered, see message.RegisterCodec", name)
+			continue
+		}
+		self.Codecs = append(self.Codecs, id)
+	}
+	if len(self.Codecs) == 0 {
+		self.Codecs = []int{message.COMPRESS_SNAPPY}
+	}
+	if self.Threshold <= 0 {
+		self.Threshold = message.COMPRESS_THRESHOLD
+	}
+	return self, nil
+}
+
+func (self *CompressConfig) supports(id int) bool {
+	for _, c := range self.Codecs {
+		if c == id {
+			return true
+		}
+	}
+	return false
+}
+
+//一个连接的压缩状态
+type packetCompress struct {
+	cfg    *CompressConfig
+	server bool
+	send   func([]byte) int
+	codec  int32 //协商好的算法，0不压缩
+}
+
+//开启压缩，必须在连接开始读写之前调用
+//@server: 服务端等待客户端的握手，客户端需要调用handshake发起握手
+//@send: 发送握手包
+func (self *Socket) EnableCompress(cfg *CompressConfig, server bool, send func([]byte) int) {
+	self.m_Compress = &packetCompress{cfg: cfg, server: server, send: send}
+}
+
+func compressHello(codecs []int) []byte {
+	n := 8 + 2 + len(codecs)
+	buff := make([]byte, 8, n)
+	binary.BigEndian.PutUint32(buff, uint32(n))
+	binary.BigEndian.PutUint16(buff[4:], COMPRESS_MARK)
+	buff = append(buff, COMPRESS_VERSION, byte(len(codecs)))
+	for _, c := range codecs {
+		buff = append(buff, byte(c))
+	}
+	return buff
+}
+
+//客户端发起握手
+func (self *packetCompress) handshake() {
+	atomic.StoreInt32(&self.codec, 0)
+	self.send(compressHello(self.cfg.Codecs))
+}
+
+//收到对方的握手包，格式错误时返回false
+func (self *packetCompress) onHello(frame []byte) bool {
+	if len(frame) < 10 || frame[8] != COMPRESS_VERSION || len(frame) < 10+int(frame[9]) {
+		llog.Warning("compress: bad handshake")
+		return false
+	}
+	codecs := frame[10 : 10+int(frame[9])]
+	id := 0
+	if self.server {
+		for _, c := range codecs {
+			if self.cfg.supports(int(c)) {
+				id = int(c)
+				break
+			}
+		}
+		self.send(compressHello([]int{id})) //0代表没有共同的算法，都不压缩
+	} else if len(codecs) == 1 && self.cfg.supports(int(codecs[0])) {
+		id = int(codecs[0])
+	}
+	atomic.StoreInt32(&self.codec, int32(id))
+	return true
+}
+
+//处理压缩的握手包，没有开启压缩时忽略
+func (self *Socket) onCompressHello(frame []byte) bool {
+	if self.m_Compress == nil {
+		return true
+	}
+	return self.m_Compress.onHello(frame)
+}
+
+//发送前压缩消息包
+func (self *Socket) compress(buff []byte) []byte {
+	if pc := self.m_Compress; pc != nil && len(buff) > pc.cfg.Threshold {
+		if id := atomic.LoadInt32(&pc.codec); id != 0 {
+			buff = message.Compress(buff, int(id))
+		}
+	}
+	return buff
+}
@@ -0,0 +1,109 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/message"
+)
+
+//开启压缩的一端，记录发送的握手包
+func newCompressPeer(cfg *CompressConfig, server bool) (*Socket, *[][]byte) {
+	sent := new([][]byte)
+	sock := &Socket{}
+	if cfg != nil {
+		sock.EnableCompress(cfg, server, func(buff []byte) int {
+			*sent = append(*sent, append([]byte{}, buff...))
+			return len(buff)
+		})
+	}
+	return sock, sent
+}
+
+//客户端发起握手，返回双方协商的算法
+func negotiate(t *testing.T, serverCfg, clientCfg *CompressConfig) (*Socket, *Socket) {
+	server, serverSent := newCompressPeer(serverCfg, true)
+	client, clientSent := newCompressPeer(clientCfg, false)
+	client.m_Compress.handshake()
+	for _, frame := range *clientSent {
+		if !server.onCompressHello(frame) {
+			t.Fatal("server refused hello")
+		}
+	}
+	for _, frame := range *serverSent {
+		if !client.onCompressHello(frame) {
+			t.Fatal("client refused hello")
+		}
+	}
+	return server, client
+}
+
+func compressPacket(n int) []byte {
+	buff := make([]byte, n)
+	binary.BigEndian.PutUint32(buff, uint32(n))
+	binary.BigEndian.PutUint16(buff[6:], 6)
+	copy(buff[8:], "C_Chat")
+	return buff
+}
+
+func TestCompressNegotiate(t *testing.T) {
+	server, client := negotiate(t, &CompressConfig{Codecs: []int{message.COMPRESS_DEFLATE}, Threshold: 100},
+		&CompressConfig{Codecs: []int{message.COMPRESS_SNAPPY, message.COMPRESS_DEFLATE}, Threshold: 100})
+	if server.m_Compress.codec != message.COMPRESS_DEFLATE || client.m_Compress.codec != message.COMPRESS_DEFLATE {
+		t.Fatalf("codec %d %d", server.m_Compress.codec, client.m_Compress.codec)
+	}
+	big := compressPacket(4096)
+	for _, sock := range []*Socket{server, client} {
+		out := sock.compress(big)
+		if !message.IsCompressed(out) || binary.BigEndian.Uint16(out[6:])>>message.COMPRESS_SHIFT != message.COMPRESS_DEFLATE {
+			t.Fatal("big packet not compressed by deflate")
+		}
+		if small := compressPacket(100); !bytes.Equal(sock.compress(small), small) {
+			t.Fatal("packet under threshold compressed")
+		}
+	}
+}
+
+func TestCompressFallback(t *testing.T) {
+	big := compressPacket(4096)
+
+	//没有共同的算法，服务端回复0，双方都不压缩
+	server, client := negotiate(t, &CompressConfig{Codecs: []int{message.COMPRESS_SNAPPY}},
+		&CompressConfig{Codecs: []int{message.COMPRESS_DEFLATE}})
+	if server.m_Compress.codec != 0 || client.m_Compress.codec != 0 {
+		t.Fatalf("codec %d %d", server.m_Compress.codec, client.m_Compress.codec)
+	}
+	if !bytes.Equal(server.compress(big), big) || !bytes.Equal(client.compress(big), big) {
+		t.Fatal("compressed without common codec")
+	}
+
+	//服务端没有开启压缩，忽略握手包
+	_, client = negotiate(t, nil, &CompressConfig{Codecs: []int{message.COMPRESS_SNAPPY}})
+	if client.m_Compress.codec != 0 || !bytes.Equal(client.compress(big), big) {
+		t.Fatal("compressed without server support")
+	}
+
+	//服务端回复了客户端没有提供的算法
+	client, _ = newCompressPeer(&CompressConfig{Codecs: []int{message.COMPRESS_SNAPPY}}, false)
+	if !client.onCompressHello(compressHello([]int{message.COMPRESS_DEFLATE})) || client.m_Compress.codec != 0 {
+		t.Fatal("accepted codec not offered")
+	}
+	if client.onCompressHello(compressHello(nil)[:9]) {
+		t.Fatal("bad hello accepted")
+	}
+}
+
+func TestNewCompressConfig(t *testing.T) {
+	cfg, err := NewCompressConfig(&config.CompressCfg{Codecs: []string{"zstd", "deflate"}})
+	if err != nil || len(cfg.Codecs) != 1 || cfg.Codecs[0] != message.COMPRESS_DEFLATE || cfg.Threshold != message.COMPRESS_THRESHOLD {
+		t.Fatalf("config %v %v", cfg, err) //没有注册的zstd被忽略
+	}
+	if cfg, _ = NewCompressConfig(&config.CompressCfg{}); len(cfg.Codecs) != 1 || cfg.Codecs[0] != message.COMPRESS_SNAPPY {
+		t.Fatalf("default codecs %v", cfg.Codecs)
+	}
+	if _, err = NewCompressConfig(&config.CompressCfg{Codecs: []string{"lz4"}}); err == nil {
+		t.Fatal("unknown codec accepted")
+	}
+}
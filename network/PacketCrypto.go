@@ -0,0 +1,341 @@
+package network
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/llog"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+//tcp和kcp连接的消息加密
+//连接建立后客户端发送握手包，服务端回复握手包，双方用x25519交换密钥，之后每个消息包都用AEAD加密
+//握手包：4字节长度+2字节0xFFFF+2字节0+1字节版本+1字节算法数量n+n字节算法+32字节公钥[+64字节服务端签名]
+//加密包：4字节长度+8字节序号+密文，密文是原来完整的消息包，长度和序号是附加数据
+//序号从0开始每个包加1，收到的序号不连续时断开连接，防止重放和调换顺序
+
+const (
+	CRYPTO_AES_GCM  = 1 //AES-256-GCM，有硬件加速时使用
+	CRYPTO_CHACHA20 = 2 //ChaCha20-Poly1305，没有AES硬件加速的手机上更快
+)
+
+const (
+	CRYPTO_VERSION   = 1
+	CRYPTO_MARK      = 0xFFFF                        //握手包的target
+	CRYPTO_HEADER    = 12                            //加密包的头，4字节长度+8字节序号
+	CRYPTO_OVERHEAD  = CRYPTO_HEADER + 16            //加密后增加的长度
+	CRYPTO_HANDSHAKE = 10000                         //服务端等待握手的超时，毫秒
+	CRYPTO_PENDING   = 256                           //握手完成前最多缓存的发送包
+	cryptoSignLabel  = "loumiao crypto handshake v1" //签名内容的前缀
+)
+
+var CipherNames = map[string]int{"AES-GCM": CRYPTO_AES_GCM, "CHACHA20": CRYPTO_CHACHA20}
+
+var (
+	errCryptoHandshake = errors.New("crypto handshake failed")
+	errCryptoSeq       = errors.New("crypto packet out of sequence")
+	cryptoHandshake    = CRYPTO_HANDSHAKE //握手超时，测试时修改
+)
+
+//加密配置，服务端和客户端使用同一个结构
+type CryptoConfig struct {
+	Ciphers []int              //客户端按优先级提供，服务端按客户端的顺序选择第一个自己支持的
+	SignKey ed25519.PrivateKey //服务端用来签名握手，nil不签名
+	PeerKey ed25519.PublicKey  //客户端用来校验服务端的签名，nil不校验，不校验时无法防止中间人
+}
+
+//按照配置创建，cfg.Key是服务端的私钥或者客户端的公钥
+func NewCryptoConfig(cfg *config.CryptoCfg) (*CryptoConfig, error) {
+	self := new(CryptoConfig)
+	for _, name := range cfg.Ciphers {
+		id, ok := CipherNames[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("crypto: unknown cipher %s", name)
+		}
+		self.Ciphers = append(self.Ciphers, id)
+	}
+	if len(self.Ciphers) == 0 {
+		self.Ciphers = []int{CRYPTO_AES_GCM, CRYPTO_CHACHA20}
+	}
+	if cfg.Key != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("crypto: key: %s", err.Error())
+		}
+		switch len(key) {
+		case ed25519.PrivateKeySize:
+			self.SignKey = ed25519.PrivateKey(key)
+		case ed25519.PublicKeySize:
+			self.PeerKey = ed25519.PublicKey(key)
+		default:
+			return nil, fmt.Errorf("crypto: key size %d", len(key))
+		}
+	}
+	return self, nil
+}
+
+func (self *CryptoConfig) supports(id int) bool {
+	for _, c := range self.Ciphers {
+		if c == id {
+			return true
+		}
+	}
+	return false
+}
+
+//一个连接的加密状态
+type packetCrypto struct {
+	cfg    *CryptoConfig
+	server bool
+	write  func([]byte) int //不加密直接写入连接
+	priv   []byte
+	pub    []byte
+	timer  *time.Timer
+
+	lock    sync.Mutex //加密和写入一起进行，保证发送的序号顺序
+	ready   bool
+	send    cipher.AEAD
+	sendSeq uint64
+	pending [][]byte //握手完成前要发送的包
+
+	recv    cipher.AEAD //只在接收协程中访问
+	recvSeq uint64
+}
+
+//开启加密，必须在连接开始读写之前调用
+//@server: 服务端等待客户端的握手，客户端需要调用handshake发起握手
+//@write: 不加密直接写入连接
+//@kill: 服务端超时没有完成握手时关闭连接
+func (self *Socket) EnableCrypto(cfg *CryptoConfig, server bool, write func([]byte) int, kill func()) {
+	pc := &packetCrypto{cfg: cfg, server: server, write: write}
+	pc.priv = make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, pc.priv); err != nil {
+		panic(err)
+	}
+	pc.pub, _ = curve25519.X25519(pc.priv, curve25519.Basepoint)
+	if server && kill != nil {
+		pc.timer = time.AfterFunc(time.Duration(cryptoHandshake)*time.Millisecond, func() {
+			pc.lock.Lock()
+			ready := pc.ready
+			pc.lock.Unlock()
+			if !ready {
+				llog.Warningf("crypto: handshake timeout %s", self.m_sAddr)
+				kill()
+			}
+		})
+	}
+	self.m_Crypto = pc
+	self.m_pInBuffer = make([]byte, self.m_MaxReceiveBufferSize+CRYPTO_OVERHEAD)
+	self.m_pInBufferLen = 0
+}
+
+//握手包
+func (self *packetCrypto) hello(ciphers []int, sig []byte) []byte {
+	n := 8 + 2 + len(ciphers) + len(self.pub) + len(sig)
+	buff := make([]byte, 8, n)
+	binary.BigEndian.PutUint32(buff, uint32(n))
+	binary.BigEndian.PutUint16(buff[4:], CRYPTO_MARK)
+	buff = append(buff, CRYPTO_VERSION, byte(len(ciphers)))
+	for _, c := range ciphers {
+		buff = append(buff, byte(c))
+	}
+	buff = append(buff, self.pub...)
+	return append(buff, sig...)
+}
+
+//客户端发起握手
+func (self *packetCrypto) handshake() {
+	self.write(self.hello(self.cfg.Ciphers, nil))
+}
+
+//解析握手包，返回算法列表，对方公钥，签名
+func parseHello(frame []byte) ([]int, []byte, []byte, error) {
+	if len(frame) < 10 || binary.BigEndian.Uint16(frame[4:]) != CRYPTO_MARK || frame[8] != CRYPTO_VERSION {
+		return nil, nil, nil, errCryptoHandshake
+	}
+	n := int(frame[9])
+	body := frame[10:]
+	if len(body) < n+curve25519.PointSize {
+		return nil, nil, nil, errCryptoHandshake
+	}
+	ciphers := make([]int, n)
+	for i := 0; i < n; i++ {
+		ciphers[i] = int(body[i])
+	}
+	body = body[n:]
+	return ciphers, body[:curve25519.PointSize], body[curve25519.PointSize:], nil
+}
+
+func signContent(clientPub, serverPub []byte, id int) []byte {
+	var b bytes.Buffer
+	b.WriteString(cryptoSignLabel)
+	b.Write(clientPub)
+	b.Write(serverPub)
+	b.WriteByte(byte(id))
+	return b.Bytes()
+}
+
+//收到对方的握手包
+func (self *packetCrypto) onHello(frame []byte) error {
+	ciphers, peer, sig, err := parseHello(frame)
+	if err != nil {
+		return err
+	}
+	var id int
+	var clientPub, serverPub []byte
+	if self.server {
+		for _, c := range ciphers {
+			if self.cfg.supports(c) {
+				id = c
+				break
+			}
+		}
+		if id == 0 {
+			return fmt.Errorf("crypto: no common cipher in %v", ciphers)
+		}
+		clientPub, serverPub = peer, self.pub
+		if self.cfg.SignKey != nil {
+			sig = ed25519.Sign(self.cfg.SignKey, signContent(clientPub, serverPub, id))
+		} else {
+			sig = nil
+		}
+	} else {
+		if len(ciphers) != 1 || !self.cfg.supports(ciphers[0]) {
+			return errCryptoHandshake
+		}
+		id = ciphers[0]
+		clientPub, serverPub = self.pub, peer
+		if self.cfg.PeerKey != nil && !ed25519.Verify(self.cfg.PeerKey, signContent(clientPub, serverPub, id), sig) {
+			return errors.New("crypto: server signature invalid")
+		}
+	}
+
+	secret, err := curve25519.X25519(self.priv, peer) //对方公钥是低阶点时返回错误
+	if err != nil {
+		return err
+	}
+	c2s, err := newAEAD(id, secret, clientPub, serverPub, "loumiao c2s")
+	if err != nil {
+		return err
+	}
+	s2c, err := newAEAD(id, secret, clientPub, serverPub, "loumiao s2c")
+	if err != nil {
+		return err
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.server {
+		self.recv, self.send = c2s, s2c
+		self.write(self.hello([]int{id}, sig)) //先回复握手，再发送缓存的包
+	} else {
+		self.recv, self.send = s2c, c2s
+	}
+	self.ready = true
+	self.priv = nil
+	if self.timer != nil {
+		self.timer.Stop()
+	}
+	for _, buff := range self.pending {
+		self.seal(buff)
+	}
+	self.pending = nil
+	return nil
+}
+
+func newAEAD(id int, secret, clientPub, serverPub []byte, info string) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, clientPub...), serverPub...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	switch id {
+	case CRYPTO_AES_GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CRYPTO_CHACHA20:
+		return chacha20poly1305.New(key)
+	}
+	return nil, fmt.Errorf("crypto: unknown cipher %d", id)
+}
+
+func cryptoNonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+//加密并发送，需要锁住lock
+func (self *packetCrypto) seal(buff []byte) int {
+	out := make([]byte, CRYPTO_HEADER, CRYPTO_HEADER+len(buff)+self.send.Overhead())
+	binary.BigEndian.PutUint32(out, uint32(cap(out)))
+	binary.BigEndian.PutUint64(out[4:], self.sendSeq)
+	out = self.send.Seal(out, cryptoNonce(self.sendSeq), buff, out[:CRYPTO_HEADER])
+	self.sendSeq++
+	return self.write(out)
+}
+
+//发送一个完整的消息包，握手完成前先缓存
+func (self *packetCrypto) Send(buff []byte) int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if !self.ready {
+		if len(self.pending) >= CRYPTO_PENDING {
+			llog.Warning("crypto: too many packets before handshake, drop it")
+			return 0
+		}
+		self.pending = append(self.pending, append([]byte{}, buff...))
+		return len(buff)
+	}
+	return self.seal(buff)
+}
+
+//处理收到的一个包，握手包自己处理，加密包解密后交给handle
+//在原来的buff上解密
+func (self *packetCrypto) receive(frame []byte, handle func(buff []byte) bool) bool {
+	if self.recv == nil {
+		if err := self.onHello(frame); err != nil {
+			llog.Warningf("crypto: handshake: %s", err.Error())
+			return false
+		}
+		return true
+	}
+	if len(frame) < CRYPTO_HEADER+self.recv.Overhead() {
+		llog.Warning("crypto: packet too short")
+		return false
+	}
+	seq := binary.BigEndian.Uint64(frame[4:])
+	if seq != self.recvSeq {
+		llog.Warningf("crypto: %s, expect %d got %d", errCryptoSeq.Error(), self.recvSeq, seq)
+		return false
+	}
+	plain, err := self.recv.Open(frame[CRYPTO_HEADER:CRYPTO_HEADER], cryptoNonce(seq), frame[CRYPTO_HEADER:], frame[:CRYPTO_HEADER])
+	if err != nil {
+		llog.Warningf("crypto: open packet %d: %s", seq, err.Error())
+		return false
+	}
+	self.recvSeq++
+	if len(plain) < 8 || int(binary.BigEndian.Uint32(plain)) != len(plain) {
+		llog.Warning("crypto: bad inner packet")
+		return false
+	}
+	return handle(plain)
+}
@@ -0,0 +1,231 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+)
+
+//一端的加密状态，记录写入连接的包
+type cryptoPeer struct {
+	sock   *Socket
+	lock   sync.Mutex
+	frames [][]byte
+}
+
+func newCryptoPeer(cfg *CryptoConfig, server bool, kill func()) *cryptoPeer {
+	peer := &cryptoPeer{sock: &Socket{}}
+	peer.sock.EnableCrypto(cfg, server, func(buff []byte) int {
+		peer.lock.Lock()
+		peer.frames = append(peer.frames, append([]byte{}, buff...))
+		peer.lock.Unlock()
+		return len(buff)
+	}, kill)
+	return peer
+}
+
+func (self *cryptoPeer) take() [][]byte {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	frames := self.frames
+	self.frames = nil
+	return frames
+}
+
+//收到一个包，返回是否成功和解密后的消息包
+func (self *cryptoPeer) receive(frame []byte) (bool, [][]byte) {
+	var got [][]byte
+	ok := self.sock.m_Crypto.receive(frame, func(buff []byte) bool {
+		got = append(got, append([]byte{}, buff...))
+		return true
+	})
+	return ok, got
+}
+
+//把from写入的包全部交给to
+func deliver(t *testing.T, from, to *cryptoPeer) [][]byte {
+	var all [][]byte
+	for _, frame := range from.take() {
+		ok, got := to.receive(frame)
+		if !ok {
+			t.Fatalf("receive frame %d bytes failed", len(frame))
+		}
+		all = append(all, got...)
+	}
+	return all
+}
+
+//长度为n的消息包
+func cryptoPacket(n int, fill byte) []byte {
+	buff := bytes.Repeat([]byte{fill}, n)
+	binary.BigEndian.PutUint32(buff, uint32(n))
+	return buff
+}
+
+//完成握手的一对连接
+func cryptoPair(t *testing.T, serverCfg, clientCfg *CryptoConfig) (*cryptoPeer, *cryptoPeer) {
+	server := newCryptoPeer(serverCfg, true, nil)
+	client := newCryptoPeer(clientCfg, false, nil)
+	client.sock.m_Crypto.handshake()
+	deliver(t, client, server)
+	deliver(t, server, client)
+	return server, client
+}
+
+func TestCryptoHandshake(t *testing.T) {
+	for _, id := range []int{CRYPTO_AES_GCM, CRYPTO_CHACHA20} {
+		server := newCryptoPeer(&CryptoConfig{Ciphers: []int{CRYPTO_AES_GCM, CRYPTO_CHACHA20}}, true, nil)
+		client := newCryptoPeer(&CryptoConfig{Ciphers: []int{id}}, false, nil)
+
+		//握手完成前发送的包先缓存，握手完成后按顺序发送
+		if n := client.sock.m_Crypto.Send(cryptoPacket(16, 1)); n != 16 {
+			t.Fatalf("pending send %d", n)
+		}
+		server.sock.m_Crypto.Send(cryptoPacket(20, 2))
+		if len(client.take()) != 0 || len(server.take()) != 0 {
+			t.Fatal("sent before handshake")
+		}
+
+		client.sock.m_Crypto.handshake()
+		if got := deliver(t, client, server); len(got) != 0 {
+			t.Fatalf("hello delivered %d packets", len(got))
+		}
+		got := deliver(t, server, client)
+		if len(got) != 1 || !bytes.Equal(got[0], cryptoPacket(20, 2)) {
+			t.Fatalf("cipher %d: server pending %v", id, got)
+		}
+		got = deliver(t, client, server)
+		if len(got) != 1 || !bytes.Equal(got[0], cryptoPacket(16, 1)) {
+			t.Fatalf("cipher %d: client pending %v", id, got)
+		}
+
+		client.sock.m_Crypto.Send(cryptoPacket(100, 3))
+		client.sock.m_Crypto.Send(cryptoPacket(8, 4))
+		got = deliver(t, client, server)
+		if len(got) != 2 || !bytes.Equal(got[0], cryptoPacket(100, 3)) || !bytes.Equal(got[1], cryptoPacket(8, 4)) {
+			t.Fatalf("cipher %d: round trip %v", id, got)
+		}
+	}
+}
+
+func TestCryptoNoCommonCipher(t *testing.T) {
+	server := newCryptoPeer(&CryptoConfig{Ciphers: []int{CRYPTO_AES_GCM}}, true, nil)
+	client := newCryptoPeer(&CryptoConfig{Ciphers: []int{CRYPTO_CHACHA20}}, false, nil)
+	client.sock.m_Crypto.handshake()
+	if ok, _ := server.receive(client.take()[0]); ok {
+		t.Fatal("handshake without common cipher")
+	}
+}
+
+func TestCryptoSignedKey(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	//配置中的私钥给服务端签名，公钥给客户端校验
+	serverCfg, err := NewCryptoConfig(&config.CryptoCfg{Key: base64.StdEncoding.EncodeToString(priv)})
+	if err != nil || serverCfg.SignKey == nil {
+		t.Fatalf("server config: %v", err)
+	}
+	clientCfg, err := NewCryptoConfig(&config.CryptoCfg{Ciphers: []string{"chacha20"}, Key: base64.StdEncoding.EncodeToString(pub)})
+	if err != nil || clientCfg.PeerKey == nil || clientCfg.Ciphers[0] != CRYPTO_CHACHA20 {
+		t.Fatalf("client config: %v", err)
+	}
+	server, client := cryptoPair(t, serverCfg, clientCfg)
+	server.sock.m_Crypto.Send(cryptoPacket(12, 5))
+	if got := deliver(t, server, client); len(got) != 1 {
+		t.Fatalf("signed round trip %v", got)
+	}
+
+	//签名不是期望的服务端
+	server = newCryptoPeer(serverCfg, true, nil)
+	client = newCryptoPeer(&CryptoConfig{Ciphers: []int{CRYPTO_AES_GCM}, PeerKey: other}, false, nil)
+	client.sock.m_Crypto.handshake()
+	deliver(t, client, server)
+	if ok, _ := client.receive(server.take()[0]); ok {
+		t.Fatal("bad signature accepted")
+	}
+
+	//服务端没有签名
+	server = newCryptoPeer(&CryptoConfig{Ciphers: []int{CRYPTO_AES_GCM}}, true, nil)
+	client = newCryptoPeer(&CryptoConfig{Ciphers: []int{CRYPTO_AES_GCM}, PeerKey: pub}, false, nil)
+	client.sock.m_Crypto.handshake()
+	deliver(t, client, server)
+	if ok, _ := client.receive(server.take()[0]); ok {
+		t.Fatal("unsigned hello accepted")
+	}
+
+	if _, err := NewCryptoConfig(&config.CryptoCfg{Ciphers: []string{"rc4"}}); err == nil {
+		t.Fatal("unknown cipher accepted")
+	}
+}
+
+func TestCryptoSeq(t *testing.T) {
+	cfg := &CryptoConfig{Ciphers: []int{CRYPTO_AES_GCM}}
+
+	//重放
+	server, client := cryptoPair(t, cfg, cfg)
+	client.sock.m_Crypto.Send(cryptoPacket(16, 1))
+	frame := client.take()[0]
+	if ok, _ := server.receive(append([]byte{}, frame...)); !ok {
+		t.Fatal("first packet refused")
+	}
+	if ok, _ := server.receive(frame); ok {
+		t.Fatal("replayed packet accepted")
+	}
+
+	//调换顺序
+	server, client = cryptoPair(t, cfg, cfg)
+	client.sock.m_Crypto.Send(cryptoPacket(16, 1))
+	client.sock.m_Crypto.Send(cryptoPacket(16, 2))
+	frames := client.take()
+	if ok, _ := server.receive(frames[1]); ok {
+		t.Fatal("out of order packet accepted")
+	}
+}
+
+func TestCryptoTamper(t *testing.T) {
+	cfg := &CryptoConfig{Ciphers: []int{CRYPTO_CHACHA20}}
+	for _, offset := range []int{0, CRYPTO_HEADER, CRYPTO_HEADER + 20} { //长度，密文，认证标签
+		server, client := cryptoPair(t, cfg, cfg)
+		client.sock.m_Crypto.Send(cryptoPacket(16, 1))
+		frame := client.take()[0]
+		frame[offset] ^= 0x80
+		if ok, _ := server.receive(frame); ok {
+			t.Fatalf("tampered byte %d accepted", offset)
+		}
+	}
+}
+
+func TestCryptoHandshakeTimeout(t *testing.T) {
+	old := cryptoHandshake
+	cryptoHandshake = 30
+	defer func() { cryptoHandshake = old }()
+	cfg := &CryptoConfig{Ciphers: []int{CRYPTO_AES_GCM}}
+
+	killed := make(chan struct{})
+	newCryptoPeer(cfg, true, func() { close(killed) })
+	select {
+	case <-killed:
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed after handshake timeout")
+	}
+
+	//完成握手后不再关闭
+	done := make(chan struct{}, 1)
+	server := newCryptoPeer(cfg, true, func() { done <- struct{}{} })
+	client := newCryptoPeer(cfg, false, nil)
+	client.sock.m_Crypto.handshake()
+	deliver(t, client, server)
+	select {
+	case <-done:
+		t.Fatal("closed after handshake")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
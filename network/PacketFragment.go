@@ -0,0 +1,132 @@
+package network
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/message"
+	"github.com/snowyyj001/loumiao/util"
+)
+
+//大消息分包
+//压缩后仍然超过发送缓存的消息包拆成多个分包发送，接收方收完后组装成原来的消息包再交给HandlePacket
+//分包：4字节长度+2字节0xFFFD+2字节0+4字节消息序号+4字节原消息包总长度+4字节偏移+数据
+//同一个消息的分包按顺序发送，不同消息的分包可以交错
+//NET_FRAGMENT开启后才分包，没有开启时收到分包断开连接
+//每个连接正在组装的消息最多FRAGMENT_PENDING个，总大小不超过MaxUnpackSize
+
+const (
+	FRAGMENT_MARK    = 0xFFFD //分包的target
+	FRAGMENT_HEADER  = 20     //分包的头
+	FRAGMENT_PENDING = 4      //每个连接同时组装的消息数
+)
+
+//正在组装的消息
+type fragment struct {
+	buff   []byte
+	total  int
+	expire int64
+}
+
+//一个分包的最大大小，对方的接收缓存可能只有NET_BUFFER_SIZE(例如rpc的CHILD_CONNECT)
+func (self *Socket) maxFrame() int {
+	if self.m_MaxSendBufferSize > 0 && self.m_MaxSendBufferSize < config.NET_BUFFER_SIZE {
+		return self.m_MaxSendBufferSize
+	}
+	return config.NET_BUFFER_SIZE
+}
+
+//发送前压缩和分包，返回要发送的消息包，nil代表消息包太大，不能发送
+func (self *Socket) pack(buff []byte) [][]byte {
+	buff = self.compress(buff)
+	max := self.maxFrame()
+	if len(buff) <= max {
+		return [][]byte{buff}
+	}
+	if !config.NET_FRAGMENT {
+		llog.Errorf("Socket.Send: too big packet size: %d, max %d", len(buff), max)
+		return nil
+	}
+	if len(buff) > message.MaxUnpackSize {
+		llog.Errorf("Socket.Send: too big packet size: %d, max %d", len(buff), message.MaxUnpackSize)
+		return nil
+	}
+	id := atomic.AddUint32(&self.m_FragmentSeq, 1)
+	chunk := max - FRAGMENT_HEADER
+	frames := make([][]byte, 0, (len(buff)+chunk-1)/chunk)
+	for off := 0; off < len(buff); off += chunk {
+		end := off + chunk
+		if end > len(buff) {
+			end = len(buff)
+		}
+		frame := make([]byte, FRAGMENT_HEADER+end-off)
+		binary.BigEndian.PutUint32(frame, uint32(len(frame)))
+		binary.BigEndian.PutUint16(frame[4:], FRAGMENT_MARK)
+		binary.BigEndian.PutUint32(frame[8:], id)
+		binary.BigEndian.PutUint32(frame[12:], uint32(len(buff)))
+		binary.BigEndian.PutUint32(frame[16:], uint32(off))
+		copy(frame[FRAGMENT_HEADER:], buff[off:end])
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+//收到一个分包，在接收协程中调用，返回false时断开连接
+func (self *Socket) onFragment(Id int, frame []byte) bool {
+	if !config.NET_FRAGMENT {
+		llog.Errorf("onFragment: fragment is not enabled, from %s", self.m_sAddr)
+		return false
+	}
+	if len(frame) < FRAGMENT_HEADER {
+		llog.Errorf("onFragment: bad fragment from %s", self.m_sAddr)
+		return false
+	}
+	id := binary.BigEndian.Uint32(frame[8:])
+	total := int(binary.BigEndian.Uint32(frame[12:]))
+	off := int(binary.BigEndian.Uint32(frame[16:]))
+	data := frame[FRAGMENT_HEADER:]
+	if total < 8 || total > message.MaxUnpackSize || off+len(data) > total {
+		llog.Errorf("onFragment: bad fragment from %s: total=%d, offset=%d", self.m_sAddr, total, off)
+		return false
+	}
+
+	now := util.TimeStamp()
+	if self.m_Fragments == nil {
+		self.m_Fragments = make(map[uint32]*fragment)
+	}
+	pending := 0
+	for k, f := range self.m_Fragments {
+		if now > f.expire {
+			llog.Warningf("onFragment: message %d from %s timeout, %d/%d", k, self.m_sAddr, len(f.buff), f.total)
+			delete(self.m_Fragments, k)
+		} else if k != id {
+			pending += f.total
+		}
+	}
+	f := self.m_Fragments[id]
+	if off == 0 { //序号相同时是重连前留下的，直接替换
+		if f == nil && len(self.m_Fragments) >= FRAGMENT_PENDING || pending+total > message.MaxUnpackSize {
+			llog.Errorf("onFragment: too many pending messages from %s", self.m_sAddr)
+			return false
+		}
+		f = &fragment{total: total, expire: now + int64(config.NET_FRAGMENT_TIMEOUT)}
+		self.m_Fragments[id] = f
+	} else if f == nil { //已经超时丢弃了
+		return true
+	} else if f.total != total || len(f.buff) != off {
+		llog.Errorf("onFragment: message %d from %s out of order", id, self.m_sAddr)
+		return false
+	}
+	f.buff = append(f.buff, data...)
+	if len(f.buff) < f.total {
+		return true
+	}
+	delete(self.m_Fragments, id)
+	if int(binary.BigEndian.Uint32(f.buff)) != f.total {
+		llog.Errorf("onFragment: message %d from %s bad length", id, self.m_sAddr)
+		return false
+	}
+	return self.dispatch(Id, f.buff, f.total)
+}
@@ -0,0 +1,123 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/message"
+)
+
+func withFragment(on bool, maxUnpack int) func() {
+	oldOn, oldMax := config.NET_FRAGMENT, message.MaxUnpackSize
+	config.NET_FRAGMENT, message.MaxUnpackSize = on, maxUnpack
+	return func() { config.NET_FRAGMENT, message.MaxUnpackSize = oldOn, oldMax }
+}
+
+func testPacket(size int, fill byte) []byte {
+	buff := bytes.Repeat([]byte{fill}, size)
+	binary.BigEndian.PutUint32(buff, uint32(size))
+	binary.BigEndian.PutUint16(buff[4:], 0)
+	binary.BigEndian.PutUint16(buff[6:], 4)
+	copy(buff[8:], "TEST")
+	return buff
+}
+
+//发送缓存64字节的socket，收到的完整消息包放入recv
+func fragmentSocket(recv *[][]byte) *Socket {
+	sock := &Socket{}
+	sock.SetConnectType(CLIENT_CONNECT)
+	sock.m_MaxSendBufferSize = 64
+	sock.BindPacketFunc(func(id int, buff []byte, nlen int) bool {
+		*recv = append(*recv, append([]byte(nil), buff[:nlen]...))
+		return true
+	})
+	return sock
+}
+
+func TestFragmentDisabled(t *testing.T) {
+	defer withFragment(false, 1000)()
+	var recv [][]byte
+	sock := fragmentSocket(&recv)
+	if frames := sock.pack(testPacket(64, 1)); len(frames) != 1 {
+		t.Fatalf("small packet: %d frames", len(frames))
+	}
+	if frames := sock.pack(testPacket(65, 1)); frames != nil {
+		t.Fatalf("big packet fragmented: %d frames", len(frames))
+	}
+
+	config.NET_FRAGMENT = true
+	frames := sock.pack(testPacket(100, 1))
+	config.NET_FRAGMENT = false
+	if sock.onFragment(1, frames[0]) {
+		t.Fatal("fragment accepted while disabled")
+	}
+}
+
+func TestFragmentPack(t *testing.T) {
+	defer withFragment(true, 1000)()
+	var recv [][]byte
+	sock := fragmentSocket(&recv)
+	a, b := testPacket(300, 1), testPacket(150, 2)
+	fa, fb := sock.pack(a), sock.pack(b)
+	if len(fa) != 7 || len(fb) != 4 {
+		t.Fatalf("frames %d %d", len(fa), len(fb))
+	}
+	for _, f := range append(fa, fb...) {
+		if len(f) > 64 || binary.BigEndian.Uint16(f[4:]) != FRAGMENT_MARK {
+			t.Fatalf("bad frame %d", len(f))
+		}
+	}
+	if sock.pack(testPacket(1001, 1)) != nil {
+		t.Fatal("packet over MaxUnpackSize packed")
+	}
+
+	//不同消息的分包交错
+	for i := 0; i < len(fa); i++ {
+		if !sock.onFragment(1, fa[i]) {
+			t.Fatalf("fragment a %d refused", i)
+		}
+		if i < len(fb) && !sock.onFragment(1, fb[i]) {
+			t.Fatalf("fragment b %d refused", i)
+		}
+	}
+	if len(recv) != 2 || !bytes.Equal(recv[0], b) || !bytes.Equal(recv[1], a) {
+		t.Fatalf("reassembled %d packets", len(recv))
+	}
+	if len(sock.m_Fragments) != 0 {
+		t.Fatalf("pending %d", len(sock.m_Fragments))
+	}
+
+	//乱序断开连接
+	fa = sock.pack(a)
+	sock.onFragment(1, fa[0])
+	if sock.onFragment(1, fa[2]) {
+		t.Fatal("out of order fragment accepted")
+	}
+}
+
+func TestFragmentPendingLimit(t *testing.T) {
+	defer withFragment(true, 1000)()
+	var recv [][]byte
+	sock := fragmentSocket(&recv)
+
+	//总大小超过MaxUnpackSize
+	if !sock.onFragment(1, sock.pack(testPacket(600, 1))[0]) {
+		t.Fatal("first message refused")
+	}
+	if sock.onFragment(1, sock.pack(testPacket(500, 2))[0]) {
+		t.Fatal("pending size over MaxUnpackSize accepted")
+	}
+
+	//消息数超过FRAGMENT_PENDING
+	sock = fragmentSocket(&recv)
+	for i := 0; i < FRAGMENT_PENDING; i++ {
+		if !sock.onFragment(1, sock.pack(testPacket(100, 1))[0]) {
+			t.Fatalf("message %d refused", i)
+		}
+	}
+	if sock.onFragment(1, sock.pack(testPacket(100, 1))[0]) {
+		t.Fatal("too many pending messages accepted")
+	}
+}
@@ -0,0 +1,209 @@
+package network
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/message"
+)
+
+//消息序号和确认
+//开启后连接发送的消息包带上序号和累计确认(格式参考message.SetSeq)，心跳、握手等内置消息不带
+//接收方丢弃序号不大于已经收到的最大序号的消息包，序号不连续(断线重连)时继续接收
+//客户端SetSequence后从连接开始就带序号，服务端SetSequence后收到对方带序号的消息包才开始带序号，兼容没有开启的客户端
+//收到SEQ_ACK_WINDOW个消息包还没有发送过消息，或者心跳检查时还有没确认的，单独发送ACK
+//客户端保留最近NET_SEQ_BUFFER个对方没有确认的消息包，断线重连后在登录消息包之后按原来的序号重发，重连的新连接上由对方的会话去重(gate)
+//gate的新会话不能去重，客户端重新完整登录(不是续连)后需要调用DropPending，之前的消息包不再重发
+//ACK：4字节长度+2字节0+2字节(SEQ_FLAG|3)+4字节序号0+4字节确认+"ACK"，由socket自己处理，不交给m_PacketFunc
+
+const (
+	SEQ_ACK        = "ACK"
+	SEQ_ACK_WINDOW = 16 //收到这么多消息包还没有确认时马上发送ACK
+)
+
+//一个连接的序号状态，客户端重连时保留
+type packetSeq struct {
+	lock    sync.Mutex
+	active  int32            //1代表发送的消息包带序号
+	sent    uint32           //最后发送的序号
+	recv    uint32           //收到的对方的最大序号，只在接收协程中修改
+	acked   uint32           //对方确认的最大序号
+	ackSent uint32           //最后一次发送给对方的确认
+	pending [][]byte         //对方还没有确认的消息包，只有客户端保留
+	keep    int              //pending最多保留的消息包数
+	send    func([]byte) int //发送ACK和重发的消息包，不再加序号，重连时替换，用lock保护
+}
+
+func seqAck(ack uint32) []byte {
+	buff := make([]byte, message.HEAD_SIZE+len(SEQ_ACK))
+	binary.BigEndian.PutUint32(buff, uint32(len(buff)))
+	binary.BigEndian.PutUint16(buff[6:], uint16(len(SEQ_ACK)))
+	copy(buff[message.HEAD_SIZE:], SEQ_ACK)
+	return message.SetSeq(buff, 0, ack)
+}
+
+//开启消息序号，必须在连接开始读写之前调用
+//@client: 客户端从连接开始就带序号，重连时保留发送的序号和没有确认的消息包
+//@send: 发送ACK和重发的消息包
+func (self *Socket) EnableSequence(client bool, send func([]byte) int) {
+	if sq := self.m_Seq; client && sq != nil { //重连，对方是新的连接，从头接收
+		atomic.StoreUint32(&sq.recv, 0)
+		atomic.StoreUint32(&sq.ackSent, 0)
+		sq.lock.Lock()
+		sq.send = send
+		sq.lock.Unlock()
+		return
+	}
+	sq := &packetSeq{send: send}
+	if client {
+		sq.active = 1
+		sq.keep = config.NET_SEQ_BUFFER
+	}
+	self.m_Seq = sq
+}
+
+//消息序号的状态，没有开启时返回0
+//@sent: 最后发送的序号
+//@recv: 收到的对方的最大序号
+//@acked: 对方确认的最大序号
+func (self *Socket) SeqState() (sent uint32, recv uint32, acked uint32) {
+	if sq := self.m_Seq; sq != nil {
+		sq.lock.Lock()
+		sent = sq.sent
+		sq.lock.Unlock()
+		recv = atomic.LoadUint32(&sq.recv)
+		acked = atomic.LoadUint32(&sq.acked)
+	}
+	return
+}
+
+//丢弃对方没有确认的消息包，重连后不再重发，例如重新完整登录后，新的会话不能去重
+func (self *Socket) DropPending() {
+	if sq := self.m_Seq; sq != nil {
+		sq.lock.Lock()
+		if len(sq.pending) > 0 {
+			llog.Infof("sequence: %s drop %d pending packets", self.m_sAddr, len(sq.pending))
+		}
+		sq.pending = nil
+		sq.lock.Unlock()
+	}
+}
+
+//发送前加上序号，加序号和发送之间加锁，保证发送的顺序和序号一致
+func (self *Socket) sequence(buff []byte, send func([]byte) int) int {
+	sq := self.m_Seq
+	if sq == nil || atomic.LoadInt32(&sq.active) == 0 {
+		return send(buff)
+	}
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+	sq.sent++
+	ack := atomic.LoadUint32(&sq.recv)
+	atomic.StoreUint32(&sq.ackSent, ack)
+	buff = message.SetSeq(buff, sq.sent, ack)
+	if sq.keep > 0 {
+		if len(sq.pending) >= sq.keep {
+			sq.pending = sq.pending[1:]
+		}
+		sq.pending = append(sq.pending, buff)
+	}
+	return send(buff)
+}
+
+//带序号的消息包先去重，重复的和ACK不交给m_PacketFunc
+func (self *Socket) dispatch(Id int, buff []byte, nLen int) bool {
+	if message.HasSeq(buff) && !self.onSeq(buff) {
+		return true
+	}
+	return self.HandlePacket(Id, buff, nLen)
+}
+
+//收到带序号的消息包，在接收协程中调用，返回false代表丢弃
+func (self *Socket) onSeq(buff []byte) bool {
+	seq, ack := message.GetSeq(buff)
+	sq := self.m_Seq
+	if sq == nil { //没有开启，只是不去重
+		return seq != 0
+	}
+	sq.onAck(ack)
+	if seq == 0 {
+		return false
+	}
+	atomic.StoreInt32(&sq.active, 1)
+	recv := atomic.LoadUint32(&sq.recv)
+	if seq <= recv {
+		llog.Debugf("sequence: %s duplicate packet %d, received %d", self.m_sAddr, seq, recv)
+		return false
+	}
+	if recv != 0 && seq != recv+1 {
+		llog.Infof("sequence: %s packet %d after %d, %d packets missing", self.m_sAddr, seq, recv, seq-recv-1)
+	}
+	atomic.StoreUint32(&sq.recv, seq)
+	if seq-atomic.LoadUint32(&sq.ackSent) >= SEQ_ACK_WINDOW {
+		sq.sendAck()
+	}
+	return true
+}
+
+//对方确认了ack之前的消息包，不再需要重发
+func (self *packetSeq) onAck(ack uint32) {
+	if ack <= atomic.LoadUint32(&self.acked) {
+		return
+	}
+	atomic.StoreUint32(&self.acked, ack)
+	if self.keep == 0 {
+		return
+	}
+	self.lock.Lock()
+	n := 0
+	for n < len(self.pending) {
+		if seq, _ := message.GetSeq(self.pending[n]); seq > ack {
+			break
+		}
+		n++
+	}
+	self.pending = self.pending[n:]
+	self.lock.Unlock()
+}
+
+//有没有确认的消息包
+func (self *packetSeq) unacked() bool {
+	ack := atomic.LoadUint32(&self.recv)
+	return ack != 0 && atomic.LoadUint32(&self.ackSent) != ack
+}
+
+//有没确认的消息包时单独发送ACK
+func (self *packetSeq) sendAck() {
+	ack := atomic.LoadUint32(&self.recv)
+	if ack == 0 || atomic.SwapUint32(&self.ackSent, ack) == ack {
+		return
+	}
+	self.lock.Lock()
+	send := self.send
+	self.lock.Unlock()
+	send(seqAck(ack))
+}
+
+//重连后按原来的序号重发对方没有确认的消息包
+func (self *packetSeq) resend() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.pending) > 0 {
+		llog.Infof("sequence: resend %d packets after %d", len(self.pending), atomic.LoadUint32(&self.acked))
+	}
+	for _, buff := range self.pending {
+		self.send(buff)
+	}
+}
+
+//重连后发送登录消息包，登录消息包不带序号，之后重发对方没有确认的消息包
+func (self *Socket) relogin(buff []byte, send func([]byte) int) int {
+	n := send(buff)
+	if sq := self.m_Seq; sq != nil {
+		sq.resend()
+	}
+	return n
+}
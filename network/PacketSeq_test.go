@@ -0,0 +1,164 @@
+package network
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/message"
+)
+
+//记录发送的消息包
+type seqOutput struct {
+	lock sync.Mutex
+	sent [][]byte
+}
+
+func (self *seqOutput) send(buff []byte) int {
+	self.lock.Lock()
+	self.sent = append(self.sent, buff)
+	self.lock.Unlock()
+	return len(buff)
+}
+
+func seqSocket(client bool, keep int, out *seqOutput, recv *int) *Socket {
+	old := config.NET_SEQ_BUFFER
+	config.NET_SEQ_BUFFER = keep
+	defer func() { config.NET_SEQ_BUFFER = old }()
+	sock := &Socket{}
+	sock.BindPacketFunc(func(int, []byte, int) bool { *recv++; return true })
+	sock.EnableSequence(client, out.send)
+	return sock
+}
+
+func seqOf(buff []byte) uint32 {
+	seq, _ := message.GetSeq(buff)
+	return seq
+}
+
+func TestSequence(t *testing.T) {
+	out, n := &seqOutput{}, 0
+	sock := seqSocket(true, 2, out, &n)
+	for i := 1; i <= 3; i++ {
+		sock.sequence(testPacket(16, byte(i)), out.send)
+	}
+	for i, buff := range out.sent {
+		if seq := seqOf(buff); seq != uint32(i+1) || buff[len(buff)-1] != byte(i+1) {
+			t.Fatalf("packet %d seq %d", i, seq)
+		}
+	}
+	if sent, _, _ := sock.SeqState(); sent != 3 {
+		t.Fatalf("sent %d", sent)
+	}
+	if p := sock.m_Seq.pending; len(p) != 2 || seqOf(p[0]) != 2 || seqOf(p[1]) != 3 {
+		t.Fatalf("pending %d", len(p))
+	}
+
+	//服务端收到带序号的消息包之前不带序号，也不保留
+	server := seqSocket(false, 2, out, &n)
+	if buff := testPacket(16, 1); server.sequence(buff, func(b []byte) int {
+		if message.HasSeq(b) {
+			t.Fatal("server sent seq before peer")
+		}
+		return len(b)
+	}) != len(buff) {
+		t.Fatal("send failed")
+	}
+	server.onSeq(message.SetSeq(testPacket(16, 1), 1, 0))
+	server.sequence(testPacket(16, 1), func(b []byte) int {
+		if seq, ack := message.GetSeq(b); seq != 1 || ack != 1 {
+			t.Fatalf("server seq %d ack %d", seq, ack)
+		}
+		return len(b)
+	})
+	if len(server.m_Seq.pending) != 0 {
+		t.Fatal("server kept pending")
+	}
+}
+
+func TestOnSeq(t *testing.T) {
+	out, n := &seqOutput{}, 0
+	sock := seqSocket(true, 8, out, &n)
+	for i := 1; i <= 3; i++ {
+		sock.sequence(testPacket(16, byte(i)), func(b []byte) int { return len(b) })
+	}
+
+	//去重，序号不连续时继续接收，ACK不交给m_PacketFunc
+	for _, seq := range []uint32{1, 1, 3, 2, 0} {
+		buff := message.SetSeq(testPacket(16, 1), seq, 2)
+		sock.dispatch(1, buff, len(buff))
+	}
+	if _, recv, acked := sock.SeqState(); n != 2 || recv != 3 || acked != 2 {
+		t.Fatalf("dispatched %d, recv %d, acked %d", n, recv, acked)
+	}
+	if p := sock.m_Seq.pending; len(p) != 1 || seqOf(p[0]) != 3 {
+		t.Fatalf("pending %d after ack", len(p))
+	}
+
+	//收到SEQ_ACK_WINDOW个没有确认的消息包时发送ACK
+	for seq := uint32(4); seq < SEQ_ACK_WINDOW; seq++ {
+		sock.onSeq(message.SetSeq(testPacket(16, 1), seq, 0))
+	}
+	if len(out.sent) != 0 || !sock.m_Seq.unacked() {
+		t.Fatalf("ack sent early %d", len(out.sent))
+	}
+	sock.onSeq(message.SetSeq(testPacket(16, 1), SEQ_ACK_WINDOW, 0))
+	if len(out.sent) != 1 || string(out.sent[0][message.HEAD_SIZE+message.SEQ_SIZE:]) != SEQ_ACK {
+		t.Fatalf("ack sent %d", len(out.sent))
+	}
+	if _, ack := message.GetSeq(out.sent[0]); ack != SEQ_ACK_WINDOW || sock.m_Seq.unacked() {
+		t.Fatalf("ack %d", ack)
+	}
+	sock.m_Seq.sendAck()
+	if len(out.sent) != 1 {
+		t.Fatal("duplicate ack")
+	}
+}
+
+//重连后从头接收，登录消息包之后按原来的序号重发，DropPending后不再重发
+func TestEnableSequenceReconnect(t *testing.T) {
+	out, n := &seqOutput{}, 0
+	sock := seqSocket(true, 8, out, &n)
+	for i := 1; i <= 3; i++ {
+		sock.sequence(testPacket(16, byte(i)), out.send)
+	}
+	sock.onSeq(message.SetSeq(testPacket(16, 1), 5, 1))
+
+	relogin := &seqOutput{}
+	sock.EnableSequence(true, relogin.send)
+	if sent, recv, acked := sock.SeqState(); sent != 3 || recv != 0 || acked != 1 {
+		t.Fatalf("sent %d, recv %d, acked %d", sent, recv, acked)
+	}
+	sock.relogin([]byte("login"), relogin.send)
+	if len(relogin.sent) != 3 || string(relogin.sent[0]) != "login" || seqOf(relogin.sent[1]) != 2 || seqOf(relogin.sent[2]) != 3 {
+		t.Fatalf("relogin sent %d", len(relogin.sent))
+	}
+
+	sock.DropPending()
+	relogin.sent = nil
+	sock.relogin([]byte("login"), relogin.send)
+	if len(relogin.sent) != 1 {
+		t.Fatalf("resent %d after DropPending", len(relogin.sent))
+	}
+	if sock.sequence(testPacket(16, 4), out.send); seqOf(out.sent[len(out.sent)-1]) != 4 {
+		t.Fatal("seq restarted after DropPending")
+	}
+}
+
+//重连替换send时接收协程可能在发送ACK，go test -race检查
+func TestEnableSequenceConcurrent(t *testing.T) {
+	out, n := &seqOutput{}, 0
+	sock := seqSocket(true, 8, out, &n)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for seq := uint32(1); seq <= 200; seq++ {
+			sock.onSeq(message.SetSeq(testPacket(16, 1), seq, 0))
+			sock.m_Seq.sendAck()
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		sock.EnableSequence(true, out.send)
+	}
+	<-done
+}
@@ -0,0 +1,198 @@
+package network
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/util"
+)
+
+//断线重连
+//开启重连的客户端连接(ClientSocket，WebClient，KcpClient)断开后不派发C_DISCONNECT，按照退避时间重连
+//重连成功后派发C_CONNECT，先发送登录消息包(SetLoginPacket/SetLoginFunc)，再发送断线期间缓存的消息包
+//放弃重连后和原来一样派发C_DISCONNECT，重连期间调用Stop不派发，由调用Stop的一方清理
+
+const (
+	RECONNECT_MIN_DELAY = 500   //默认第一次重连前的等待时间，毫秒
+	RECONNECT_MAX_DELAY = 30000 //默认最长等待时间，毫秒
+	RECONNECT_BUFFER    = 1024  //默认断线期间最多缓存的消息包数
+)
+
+//重连配置
+type ReconnectConfig struct {
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	MaxRetry int //连续失败这么多次后放弃，0不限制
+	Buffer   int //断线期间最多缓存的消息包数
+}
+
+//按照配置创建，没有配置的项使用默认值
+func NewReconnectConfig(cfg *config.ReconnCfg) *ReconnectConfig {
+	self := &ReconnectConfig{MinDelay: RECONNECT_MIN_DELAY * time.Millisecond, MaxDelay: RECONNECT_MAX_DELAY * time.Millisecond,
+		MaxRetry: cfg.MaxRetry, Buffer: RECONNECT_BUFFER}
+	if cfg.MinDelay > 0 {
+		self.MinDelay = time.Duration(cfg.MinDelay) * time.Millisecond
+	}
+	if cfg.MaxDelay > 0 {
+		self.MaxDelay = time.Duration(cfg.MaxDelay) * time.Millisecond
+	}
+	if self.MaxDelay < self.MinDelay {
+		self.MaxDelay = self.MinDelay
+	}
+	if cfg.Buffer > 0 {
+		self.Buffer = cfg.Buffer
+	}
+	return self
+}
+
+//第n次重连前的等待时间，指数增长，随机减少最多一半，避免同时断开的连接一起重连
+func (self *ReconnectConfig) backoff(n int) time.Duration {
+	d := self.MaxDelay
+	if n < 30 && self.MinDelay<<uint(n) < d {
+		d = self.MinDelay << uint(n)
+	}
+	return d/2 + time.Duration(util.Random64(int64(d/2)+1))
+}
+
+//一个客户端连接的重连状态
+type reconnector struct {
+	cfg         *ReconnectConfig
+	login       func() []byte //生成重连成功后第一个发送的消息包
+	lock        sync.Mutex
+	down        int32    //1代表断线中，Send的消息包放入buffer
+	buffer      [][]byte //断线期间缓存的消息包
+	drops       int      //断线期间丢弃的消息包数
+	stopped     int32    //1代表调用了Stop，不再重连
+	wake        chan struct{}
+	connectType int
+}
+
+func newReconnector(cfg *ReconnectConfig) *reconnector {
+	return &reconnector{cfg: cfg, wake: make(chan struct{}, 1)}
+}
+
+//连接开始，清除Stop的状态
+func (self *reconnector) reset() {
+	atomic.StoreInt32(&self.stopped, 0)
+	select {
+	case <-self.wake:
+	default:
+	}
+}
+
+//停止重连，正在等待的重连马上放弃
+func (self *reconnector) stop() {
+	atomic.StoreInt32(&self.stopped, 1)
+	select {
+	case self.wake <- struct{}{}:
+	default:
+	}
+}
+
+//连接断开，返回false代表不重连
+func (self *reconnector) begin(connectType int) bool {
+	if atomic.LoadInt32(&self.stopped) == 1 {
+		return false
+	}
+	self.lock.Lock()
+	self.connectType = connectType
+	self.drops = 0
+	atomic.StoreInt32(&self.down, 1)
+	self.lock.Unlock()
+	return true
+}
+
+func (self *reconnector) setLogin(login func() []byte) {
+	self.lock.Lock()
+	self.login = login
+	self.lock.Unlock()
+}
+
+func (self *reconnector) isStopped() bool {
+	return atomic.LoadInt32(&self.stopped) == 1
+}
+
+func (self *reconnector) isDown() bool {
+	return atomic.LoadInt32(&self.down) == 1
+}
+
+//断线期间缓存要发送的消息包，返回false代表没有断线，需要直接发送
+func (self *reconnector) hold(buff []byte) (int, bool) {
+	if !self.isDown() {
+		return 0, false
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if !self.isDown() { //刚刚重连成功
+		return 0, false
+	}
+	if len(self.buffer) >= self.cfg.Buffer {
+		if self.drops++; self.drops == 1 {
+			llog.Warningf("reconnect: buffer full, drop packet")
+		}
+		return 0, true
+	}
+	self.buffer = append(self.buffer, buff)
+	return len(buff), true
+}
+
+//按照退避时间重连，在接收协程中调用
+//@connect: 建立连接
+//@login: 连接成功后发送登录消息包
+//@send: 然后发送缓存的消息包
+//返回false代表放弃重连或者调用了Stop
+func (self *reconnector) run(addr string, connect func() bool, login func([]byte) int, send func([]byte) int) bool {
+	for n := 0; self.cfg.MaxRetry <= 0 || n < self.cfg.MaxRetry; n++ {
+		delay := self.cfg.backoff(n)
+		llog.Infof("reconnect: %s retry %d after %v", addr, n+1, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-self.wake:
+			timer.Stop()
+		}
+		if self.isStopped() {
+			break
+		}
+		if connect() {
+			if self.isStopped() { //连接期间调用了Stop，由调用者关闭新的连接
+				break
+			}
+			self.flush(login, send)
+			llog.Infof("reconnect: %s success after %d retries", addr, n+1)
+			return true
+		}
+	}
+	self.lock.Lock()
+	if self.isStopped() {
+		llog.Infof("reconnect: %s stopped, drop %d packets", addr, len(self.buffer)+self.drops)
+	} else {
+		llog.Warningf("reconnect: %s give up, drop %d packets", addr, len(self.buffer)+self.drops)
+	}
+	self.buffer = nil
+	atomic.StoreInt32(&self.down, 0)
+	self.lock.Unlock()
+	return false
+}
+
+//发送登录消息包和缓存的消息包，发送完之前新的消息包继续放入缓存，保证顺序
+func (self *reconnector) flush(login func([]byte) int, send func([]byte) int) {
+	self.lock.Lock()
+	if self.login != nil {
+		if buff := self.login(); buff != nil {
+			login(buff)
+		}
+	}
+	for _, buff := range self.buffer {
+		send(buff)
+	}
+	if self.drops > 0 {
+		llog.Warningf("reconnect: %d packets dropped during reconnecting", self.drops)
+	}
+	self.buffer = nil
+	atomic.StoreInt32(&self.down, 0)
+	self.lock.Unlock()
+}
@@ -0,0 +1,221 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/message"
+)
+
+//重连成功后先发送login生成的消息包，再发送缓存的消息包，login返回nil不发送
+func TestReconnectLoginFunc(t *testing.T) {
+	r := newReconnector(&ReconnectConfig{Buffer: 4})
+	seq := 0
+	r.setLogin(func() []byte {
+		if seq == 0 {
+			return nil
+		}
+		return []byte{byte(seq)}
+	})
+	var sent []byte
+	login := func(buff []byte) int { sent = append(sent, 100+buff[0]); return len(buff) }
+	send := func(buff []byte) int { sent = append(sent, buff[0]); return len(buff) }
+
+	r.begin(CLIENT_CONNECT)
+	r.hold([]byte{1})
+	r.flush(login, send)
+	if string(sent) != string([]byte{1}) {
+		t.Fatalf("nil login sent %v", sent)
+	}
+
+	sent, seq = nil, 5
+	r.begin(CLIENT_CONNECT)
+	r.hold([]byte{1})
+	r.hold([]byte{2})
+	r.flush(login, send)
+	if string(sent) != string([]byte{105, 1, 2}) {
+		t.Fatalf("sent %v", sent)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	cfg := &ReconnectConfig{MinDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for n, base := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		base *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if d := cfg.backoff(n); d < base/2 || d > base {
+				t.Fatalf("backoff(%d) = %v, want [%v, %v]", n, d, base/2, base)
+			}
+		}
+	}
+	if d := cfg.backoff(100); d > time.Second {
+		t.Fatalf("backoff(100) = %v", d)
+	}
+	if cfg = NewReconnectConfig(&config.ReconnCfg{MinDelay: 2000, MaxDelay: 1000}); cfg.MaxDelay != cfg.MinDelay || cfg.Buffer != RECONNECT_BUFFER {
+		t.Fatalf("config %v", cfg)
+	}
+}
+
+//断线期间缓存，超过Buffer丢弃，重连后按顺序发送
+func TestReconnectHold(t *testing.T) {
+	r := newReconnector(&ReconnectConfig{Buffer: 3})
+	if _, held := r.hold([]byte{0}); held {
+		t.Fatal("held while connected")
+	}
+	r.begin(CLIENT_CONNECT)
+	for i := 1; i <= 5; i++ {
+		n, held := r.hold([]byte{byte(i)})
+		if !held || (i <= 3) != (n == 1) {
+			t.Fatalf("hold %d: %d %v", i, n, held)
+		}
+	}
+	if r.drops != 2 {
+		t.Fatalf("drops %d", r.drops)
+	}
+	r.setLogin(func() []byte { return []byte{100} })
+	var sent []byte
+	held := make(chan bool, 1)
+	r.flush(func(buff []byte) int { sent = append(sent, buff[0]); return 1 }, func(buff []byte) int {
+		if buff[0] == 1 { //发送缓存期间其他协程Send的消息包等待发送完，不能插队
+			go func() { _, ok := r.hold([]byte{200}); held <- ok }()
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(held) > 0 {
+			t.Error("hold returned during flush")
+		}
+		sent = append(sent, buff[0])
+		return 1
+	})
+	if string(sent) != string([]byte{100, 1, 2, 3}) {
+		t.Fatalf("sent %v", sent)
+	}
+	if <-held {
+		t.Fatal("held after flush")
+	}
+	if _, held := r.hold([]byte{4}); held || r.isDown() {
+		t.Fatal("held after flush")
+	}
+}
+
+func TestReconnectGiveUp(t *testing.T) {
+	r := newReconnector(&ReconnectConfig{MinDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxRetry: 3, Buffer: 4})
+	r.begin(CLIENT_CONNECT)
+	r.hold([]byte{1})
+	tries := 0
+	if r.run("test", func() bool { tries++; return false }, nil, nil) {
+		t.Fatal("run succeeded")
+	}
+	if tries != 3 || r.isDown() || r.buffer != nil || r.isStopped() {
+		t.Fatalf("tries %d, down %v, buffer %v", tries, r.isDown(), r.buffer)
+	}
+}
+
+//等待重连时调用Stop马上放弃
+func TestReconnectStop(t *testing.T) {
+	r := newReconnector(&ReconnectConfig{MinDelay: time.Hour, MaxDelay: time.Hour, Buffer: 4})
+	r.begin(CLIENT_CONNECT)
+	done := make(chan bool)
+	go func() { done <- r.run("test", func() bool { t.Error("connect after stop"); return true }, nil, nil) }()
+	r.stop()
+	select {
+	case ok := <-done:
+		if ok || !r.isStopped() {
+			t.Fatalf("run %v after stop", ok)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stop did not wake reconnect")
+	}
+	if r.begin(CLIENT_CONNECT) {
+		t.Fatal("reconnect after stop")
+	}
+	r.reset()
+	if r.isStopped() {
+		t.Fatal("stopped after reset")
+	}
+}
+
+//对方断开后重连，放弃时派发C_DISCONNECT，重连期间Stop不派发
+func TestClientSocketReconnect(t *testing.T) {
+	initMessage()
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	newClient := func(cfg *ReconnectConfig, events chan string) *ClientSocket {
+		client := new(ClientSocket)
+		client.Init(ln.Addr().String())
+		client.SetConnectType(CHILD_CONNECT)
+		client.SetReconnect(cfg)
+		client.BindPacketFunc(func(id int, buff []byte, nlen int) bool {
+			_, _, name, _ := message.Decode(0, buff, nlen)
+			events <- name
+			return true
+		})
+		if !client.Start() {
+			t.Fatal("client start failed")
+		}
+		return client
+	}
+	expect := func(events chan string, name string) {
+		select {
+		case got := <-events:
+			if got != name {
+				t.Fatalf("got %s, want %s", got, name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s", name)
+		}
+	}
+
+	//连接和重连成功都派发C_CONNECT，然后放弃重连派发C_DISCONNECT
+	events := make(chan string, 8)
+	client := newClient(&ReconnectConfig{MinDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxRetry: 1, Buffer: 4}, events)
+	expect(events, "C_CONNECT")
+	(<-accepted).Close()
+	expect(events, "C_CONNECT")
+	conn := <-accepted
+	ln.Close()
+	conn.Close()
+	expect(events, "C_DISCONNECT")
+	client.Stop()
+
+	//重连等待期间Stop
+	ln, err = net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	events = make(chan string, 8)
+	client = newClient(&ReconnectConfig{MinDelay: time.Hour, MaxDelay: time.Hour, Buffer: 4}, events)
+	expect(events, "C_CONNECT")
+	(<-accepted).Close()
+	waitFor(t, client.m_Reconnect.isDown)
+	client.Stop()
+	select {
+	case name := <-events:
+		t.Fatalf("%s after Stop", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
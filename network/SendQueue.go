@@ -0,0 +1,231 @@
+package network
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/util"
+	"github.com/xtaci/kcp-go"
+)
+
+//异步发送队列
+//Send只是把消息包放入队列，每个连接一个协程负责写入，一次最多合并MAX_WRITE_CHAN个消息包写入
+//队列满时：对外连接按照NET_SLOW_CLIENT丢弃消息或者断开连接，对内连接等待队列有空位
+//丢弃在消息分片和加密之前决定(admit)，只丢弃整个消息，不会造成分片不完整或者加密序号不连续
+
+const (
+	SEND_BLOCK = iota //等待队列有空位
+	SEND_DROP         //丢弃这个消息
+	SEND_KICK         //断开连接
+
+	SEND_FLUSH_TIMEOUT = 1000 //关闭连接时发送队列中剩余消息包的超时，毫秒
+)
+
+var (
+	SlowPolicyNames = map[string]int{"BLOCK": SEND_BLOCK, "DROP": SEND_DROP, "KICK": SEND_KICK}
+	slowPolicyOnce  sync.Once
+)
+
+//对外连接的慢客户端策略，配置错误时等待
+func slowPolicy() int {
+	policy, ok := SlowPolicyNames[strings.ToUpper(config.NET_SLOW_CLIENT)]
+	if !ok {
+		slowPolicyOnce.Do(func() {
+			llog.Errorf("sendQueue: unknown slow client policy %s, use BLOCK", config.NET_SLOW_CLIENT)
+		})
+		return SEND_BLOCK
+	}
+	return policy
+}
+
+//队列使用的连接
+type queueConn interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+type sendQueue struct {
+	addr   string
+	conn   queueConn
+	writev func([][]byte) error //合并写入多个消息包
+	policy int
+	ch     chan []byte
+	done   chan struct{} //关闭队列
+	once   sync.Once
+	drops  int32 //连续丢弃的消息包数量
+	last   int64 //最后放入消息包的时间，毫秒
+}
+
+//创建发送队列并启动写协程
+func newSendQueue(addr string, conn queueConn, writev func([][]byte) error, connectType int) *sendQueue {
+	self := &sendQueue{addr: addr, conn: conn, writev: writev, policy: SEND_BLOCK}
+	if connectType == CLIENT_CONNECT {
+		self.policy = slowPolicy()
+	}
+	size := config.NET_SEND_QUEUE
+	if size <= 0 {
+		size = MAX_WRITE_CHAN
+	}
+	self.ch = make(chan []byte, size)
+	self.done = make(chan struct{})
+	self.last = util.TimeStamp()
+	go self.run()
+	return self
+}
+
+//连接建立时替换，关闭时置空，发送在任意协程中，都在m_ConnLock中访问
+func (self *Socket) setSendQueue(q *sendQueue) {
+	self.m_ConnLock.Lock()
+	self.m_SendQueue = q
+	self.m_ConnLock.Unlock()
+}
+
+func (self *Socket) getSendQueue() *sendQueue {
+	self.m_ConnLock.Lock()
+	defer self.m_ConnLock.Unlock()
+	return self.m_SendQueue
+}
+
+//tcp连接，使用writev
+func (self *Socket) startTcpQueue(conn net.Conn) {
+	self.setSendQueue(newSendQueue(self.m_sAddr, conn, func(bufs [][]byte) error {
+		b := net.Buffers(bufs)
+		_, err := b.WriteTo(conn)
+		return err
+	}, self.m_nConnectType))
+}
+
+//kcp连接
+func (self *Socket) startKcpQueue(conn *kcp.UDPSession) {
+	self.setSendQueue(newSendQueue(self.m_sAddr, conn, func(bufs [][]byte) error {
+		_, err := conn.WriteBuffers(bufs)
+		return err
+	}, self.m_nConnectType))
+}
+
+//websocket连接，多个消息包合并成一个websocket消息，接收方ReceivePacket会拆开
+func (self *Socket) startWsQueue(conn *websocket.Conn) {
+	self.setSendQueue(newSendQueue(self.m_sAddr, conn, func(bufs [][]byte) error {
+		w, err := conn.NextWriter(websocket.BinaryMessage)
+		if err != nil {
+			return err
+		}
+		for _, b := range bufs {
+			if _, err = w.Write(b); err != nil {
+				return err
+			}
+		}
+		return w.Close()
+	}, self.m_nConnectType))
+}
+
+//消息分片和加密之前调用，SEND_DROP策略队列满时丢弃整个消息，返回false
+func (self *Socket) admit() bool {
+	q := self.getSendQueue()
+	if q == nil || q.policy != SEND_DROP || len(q.ch) < cap(q.ch) {
+		return true
+	}
+	if atomic.AddInt32(&q.drops, 1) == 1 {
+		llog.Warningf("sendQueue: %s send queue full, drop packet", q.addr)
+	}
+	return false
+}
+
+//放入队列，返回0代表没有发送
+//SEND_DROP策略已经在admit中决定，这里等待，保证一个消息的所有分片都能放入
+func (self *sendQueue) push(buff []byte) int {
+	atomic.StoreInt64(&self.last, util.TimeStamp())
+	select {
+	case <-self.done: //已经关闭，不再接收
+		return 0
+	default:
+	}
+	select {
+	case self.ch <- buff:
+		return len(buff)
+	case <-self.done:
+		return 0
+	default:
+	}
+	switch self.policy {
+	case SEND_KICK:
+		llog.Warningf("sendQueue: %s send queue full, kick it", self.addr)
+		self.kill()
+		return 0
+	}
+	select {
+	case self.ch <- buff:
+		return len(buff)
+	case <-self.done:
+		return 0
+	}
+}
+
+//关闭队列，剩余的消息包发送完后关闭连接
+func (self *sendQueue) close() {
+	self.once.Do(func() {
+		close(self.done)
+		self.conn.SetReadDeadline(time.Now()) //读协程马上返回
+	})
+}
+
+//马上关闭连接，丢弃剩余的消息包
+func (self *sendQueue) kill() {
+	self.close()
+	self.conn.Close()
+}
+
+func (self *sendQueue) run() {
+	bufs := make([][]byte, 0, MAX_WRITE_CHAN)
+	for {
+		select {
+		case buff := <-self.ch:
+			bufs = self.collect(append(bufs[:0], buff))
+			if config.NET_WRITE_TIMEOUT > 0 {
+				self.conn.SetWriteDeadline(time.Now().Add(time.Duration(config.NET_WRITE_TIMEOUT) * time.Millisecond))
+			}
+			if err := self.writev(bufs); err != nil {
+				llog.Infof("sendQueue: %s write error: %s", self.addr, err.Error())
+				self.kill()
+				return
+			}
+			if n := atomic.SwapInt32(&self.drops, 0); n > 1 {
+				llog.Warningf("sendQueue: %s dropped %d packets", self.addr, n)
+			}
+		case <-self.done:
+			self.conn.SetWriteDeadline(time.Now().Add(SEND_FLUSH_TIMEOUT * time.Millisecond))
+			for {
+				select {
+				case buff := <-self.ch:
+					if self.writev(self.collect(append(bufs[:0], buff))) != nil {
+						self.conn.Close()
+						return
+					}
+				default:
+					self.conn.Close()
+					return
+				}
+			}
+		}
+	}
+}
+
+//合并队列中已有的消息包
+func (self *sendQueue) collect(bufs [][]byte) [][]byte {
+	for len(bufs) < MAX_WRITE_CHAN {
+		select {
+		case buff := <-self.ch:
+			bufs = append(bufs, buff)
+		default:
+			return bufs
+		}
+	}
+	return bufs
+}
@@ -0,0 +1,214 @@
+package network
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/message"
+)
+
+var messageOnce sync.Once
+
+//只初始化一次，前一个测试的连接协程可能还在编码消息
+func initMessage() {
+	messageOnce.Do(message.DoInit)
+}
+
+//记录写入的消息包，gate打开前写协程阻塞
+type fakeConn struct {
+	lock   sync.Mutex
+	gate   chan struct{}
+	wrote  [][]byte
+	closed bool
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{gate: make(chan struct{})}
+}
+
+func (self *fakeConn) SetReadDeadline(time.Time) error  { return nil }
+func (self *fakeConn) SetWriteDeadline(time.Time) error { return nil }
+func (self *fakeConn) Close() error {
+	self.lock.Lock()
+	self.closed = true
+	self.lock.Unlock()
+	return nil
+}
+
+func (self *fakeConn) writev(bufs [][]byte) error {
+	<-self.gate
+	self.lock.Lock()
+	for _, b := range bufs {
+		self.wrote = append(self.wrote, b)
+	}
+	self.lock.Unlock()
+	return nil
+}
+
+func (self *fakeConn) result() ([][]byte, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.wrote, self.closed
+}
+
+func withSendConfig(size int, slow string) func() {
+	oldSize, oldSlow := config.NET_SEND_QUEUE, config.NET_SLOW_CLIENT
+	config.NET_SEND_QUEUE, config.NET_SLOW_CLIENT = size, slow
+	return func() { config.NET_SEND_QUEUE, config.NET_SLOW_CLIENT = oldSize, oldSlow }
+}
+
+//Stop后等待接收协程派发C_DISCONNECT，避免它在后面的测试修改配置时还在编码消息
+//接收协程读取前就发现已经关闭时不派发
+func waitDisconnect(disconnect chan struct{}) {
+	select {
+	case <-disconnect:
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
+
+//写协程取走第一个消息包后阻塞，队列再放满
+func fillQueue(t *testing.T, q *sendQueue) {
+	q.push([]byte{0})
+	waitFor(t, func() bool { return len(q.ch) == 0 })
+	for len(q.ch) < cap(q.ch) {
+		q.push([]byte{byte(len(q.ch) + 1)})
+	}
+}
+
+//默认和以前一样：队列满时等待，不设置写超时
+func TestSendQueueDefault(t *testing.T) {
+	if config.NET_WRITE_TIMEOUT > 0 || slowPolicy() != SEND_BLOCK {
+		t.Fatalf("default write timeout %d, slow client %s", config.NET_WRITE_TIMEOUT, config.NET_SLOW_CLIENT)
+	}
+	if q := newSendQueue("default", newFakeConn(), nil, CLIENT_CONNECT); q.policy != SEND_BLOCK {
+		t.Fatalf("default policy: %d", q.policy)
+	}
+}
+
+func TestSendQueuePolicy(t *testing.T) {
+	defer withSendConfig(2, "drop")()
+	if p := slowPolicy(); p != SEND_DROP {
+		t.Fatalf("drop policy: %d", p)
+	}
+	config.NET_SLOW_CLIENT = "slow"
+	if p := slowPolicy(); p != SEND_BLOCK {
+		t.Fatalf("unknown policy: %d", p)
+	}
+	config.NET_SLOW_CLIENT = "DROP"
+	if q := newSendQueue("inner", newFakeConn(), nil, SERVER_CONNECT); q.policy != SEND_BLOCK {
+		t.Fatalf("inner policy: %d", q.policy)
+	}
+
+	//DROP：队列满时在分片之前丢弃整个消息
+	conn := newFakeConn()
+	sock := &Socket{}
+	sock.m_SendQueue = newSendQueue("drop", conn, conn.writev, CLIENT_CONNECT)
+	if !sock.admit() {
+		t.Fatal("empty queue refused")
+	}
+	fillQueue(t, sock.m_SendQueue)
+	if sock.admit() || sock.m_SendQueue.drops != 1 {
+		t.Fatalf("full queue admitted, drops %d", sock.m_SendQueue.drops)
+	}
+	close(conn.gate)
+	waitFor(t, func() bool { wrote, _ := conn.result(); return len(wrote) == 3 })
+	if !sock.admit() {
+		t.Fatal("drained queue refused")
+	}
+
+	//KICK：队列满时断开连接
+	config.NET_SLOW_CLIENT = "KICK"
+	conn = newFakeConn()
+	q := newSendQueue("kick", conn, conn.writev, CLIENT_CONNECT)
+	fillQueue(t, q)
+	if n := q.push([]byte{9}); n != 0 {
+		t.Fatalf("kick push %d", n)
+	}
+	if _, closed := conn.result(); !closed {
+		t.Fatal("slow client not kicked")
+	}
+	close(conn.gate)
+
+	//BLOCK：等待队列有空位
+	config.NET_SLOW_CLIENT = "BLOCK"
+	conn = newFakeConn()
+	q = newSendQueue("block", conn, conn.writev, CLIENT_CONNECT)
+	fillQueue(t, q)
+	pushed := make(chan int)
+	go func() { pushed <- q.push([]byte{9}) }()
+	select {
+	case <-pushed:
+		t.Fatal("push did not block")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(conn.gate)
+	if n := <-pushed; n != 1 {
+		t.Fatalf("block push %d", n)
+	}
+}
+
+func TestSendQueueFlushOnClose(t *testing.T) {
+	defer withSendConfig(8, "KICK")()
+	conn := newFakeConn()
+	q := newSendQueue("flush", conn, conn.writev, CLIENT_CONNECT)
+	for i := 0; i < 5; i++ {
+		q.push([]byte{byte(i)})
+	}
+	q.close()
+	if n := q.push([]byte{5}); n != 0 {
+		t.Fatalf("push after close %d", n)
+	}
+	close(conn.gate)
+	waitFor(t, func() bool { _, closed := conn.result(); return closed })
+	wrote, _ := conn.result()
+	if len(wrote) != 5 {
+		t.Fatalf("flushed %d packets", len(wrote))
+	}
+	for i, b := range wrote {
+		if b[0] != byte(i) {
+			t.Fatalf("flush order %v", wrote)
+		}
+	}
+}
+
+//重连替换发送队列和关闭连接时，其他协程可以同时发送
+func TestSendQueueSwap(t *testing.T) {
+	defer withSendConfig(8, "DROP")()
+	newQueue := func() *sendQueue {
+		conn := newFakeConn()
+		close(conn.gate)
+		return newSendQueue("swap", conn, conn.writev, CLIENT_CONNECT)
+	}
+	sock := &ClientSocket{}
+	sock.setSendQueue(newQueue())
+	sock.SetState(SSF_CONNECT)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			sock.output([]byte{0, 0, 0, 8, 0, 0, 0, 0})
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		old := sock.getSendQueue()
+		sock.setSendQueue(newQueue())
+		old.close()
+	}
+	sock.Close()
+	<-done
+	if sock.getSendQueue() != nil || sock.output([]byte{0, 0, 0, 8, 0, 0, 0, 0}) != 0 {
+		t.Fatal("send after close")
+	}
+}
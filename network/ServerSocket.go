@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -14,7 +15,7 @@ type IServerSocket interface {
 	AssignClientId() int
 	GetClientById(int) *ServerSocketClient
 	LoadClient() *ServerSocketClient
-	AddClinet(*net.TCPConn, string, int) *ServerSocketClient
+	AddClinet(net.Conn, string, int) *ServerSocketClient
 	DelClinet(*ServerSocketClient) bool
 	StopClient(int)
 	ClientRemoteAddr(clientid int) string
@@ -22,15 +23,19 @@ type IServerSocket interface {
 
 type ServerSocket struct {
 	Socket
-	m_nClientCount  int
-	m_nMaxClients   int
-	m_nMinClients   int
-	m_nIdSeed       int32
-	m_bShuttingDown bool
-	m_ClientList    map[int]*ServerSocketClient
-	m_ClientLocker  *sync.RWMutex
-	m_Listen        *net.TCPListener
-	m_Lock          sync.Mutex
+	m_nClientCount int32 //原子操作访问
+	m_nMaxClients  int
+	m_nMinClients  int
+	m_nIdSeed      int32
+	m_ClientList   map[int]*ServerSocketClient
+	m_ClientLocker *sync.RWMutex
+	m_Listen       *net.TCPListener
+	m_Lock         sync.Mutex
+	m_Limiter      *ConnLimiter
+	m_TlsConfig    *tls.Config
+	m_CryptoCfg    *CryptoConfig
+	m_CompressCfg  *CompressConfig
+	m_Sequence     bool
 }
 
 func (self *ServerSocket) Init(saddr string) bool {
@@ -44,7 +49,7 @@ func (self *ServerSocket) Start() bool {
 		llog.Error("ServerSocket.Start error : unkonwen socket type")
 		return false
 	}
-	self.m_bShuttingDown = false
+	self.setShuttingDown(false)
 
 	if self.m_sAddr == "" {
 		llog.Error("ServerSocket Start error, saddr is null")
@@ -65,7 +70,7 @@ func (self *ServerSocket) Start() bool {
 	self.m_Listen = ln
 	//延迟，监听关闭
 	//defer ln.Close()
-	self.m_nState = SSF_ACCEPT
+	self.SetState(SSF_ACCEPT)
 	go serverRoutine(self)
 	return true
 }
@@ -88,12 +93,12 @@ func (self *ServerSocket) GetClientById(id int) *ServerSocketClient {
 func (self *ServerSocket) ClientRemoteAddr(clientid int) string {
 	pClinet := self.GetClientById(clientid)
 	if pClinet != nil {
-		return pClinet.m_Conn.RemoteAddr().String()
+		return pClinet.m_sAddr
 	}
 	return ""
 }
 
-func (self *ServerSocket) AddClinet(tcpConn *net.TCPConn, addr string, connectType int) *ServerSocketClient {
+func (self *ServerSocket) AddClinet(tcpConn net.Conn, addr string, connectType int) *ServerSocketClient {
 	pClient := self.LoadClient()
 	if pClient != nil {
 		pClient.Socket.Init(addr)
@@ -101,16 +106,30 @@ func (self *ServerSocket) AddClinet(tcpConn *net.TCPConn, addr string, connectTy
 		pClient.m_ClientId = self.AssignClientId()
 		pClient.SetConnectType(connectType)
 		pClient.SetTcpConn(tcpConn)
+		pClient.startTcpQueue(tcpConn)
 		pClient.BindPacketFunc(self.m_PacketFunc)
+		if self.m_CryptoCfg != nil {
+			pClient.EnableCrypto(self.m_CryptoCfg, true, pClient.write, func() { tcpConn.Close() })
+		}
+		if self.m_CompressCfg != nil {
+			pClient.EnableCompress(self.m_CompressCfg, true, pClient.output)
+		}
+		if self.m_Sequence {
+			pClient.EnableSequence(false, pClient.output)
+		}
+		pClient.startHeartbeat(pClient.output)
 		self.m_ClientLocker.Lock()
 		self.m_ClientList[pClient.m_ClientId] = pClient
 		self.m_ClientLocker.Unlock()
 		pClient.Start()
-		self.m_nClientCount++
+		atomic.AddInt32(&self.m_nClientCount, 1)
 		llog.Debugf("客户端：%s已连接[%d]", tcpConn.RemoteAddr().String(), pClient.m_ClientId)
 		return pClient
 	} else {
 		tcpConn.Close()
+		if self.m_Limiter != nil { //accept时占用的ip名额
+			self.m_Limiter.Release(addr)
+		}
 		llog.Errorf("ServerSocket.AddClinet %s", "无法创建客户端连接对象")
 	}
 	return nil
@@ -119,9 +138,12 @@ func (self *ServerSocket) AddClinet(tcpConn *net.TCPConn, addr string, connectTy
 func (self *ServerSocket) DelClinet(pClient *ServerSocketClient) bool {
 	self.m_ClientLocker.Lock()
 	delete(self.m_ClientList, pClient.m_ClientId)
-	llog.Debugf("客户端：%s已断开连接[%d]", pClient.m_Conn.RemoteAddr().String(), pClient.m_ClientId)
+	llog.Debugf("客户端：%s已断开连接[%d]", pClient.m_sAddr, pClient.m_ClientId)
 	self.m_ClientLocker.Unlock()
-	self.m_nClientCount--
+	atomic.AddInt32(&self.m_nClientCount, -1)
+	if self.m_Limiter != nil {
+		self.m_Limiter.Release(pClient.m_sAddr)
+	}
 	return true
 }
 
@@ -173,6 +195,9 @@ func (self *ServerSocket) OnNetFail(int) {
 }
 
 func (self *ServerSocket) Close() {
+	if atomic.SwapInt32(&self.m_nState, SSF_SHUT_DOWN) == SSF_SHUT_DOWN { //监听协程出错时也会调用
+		return
+	}
 	self.m_Listen.Close()
 	self.Clear()
 
@@ -182,6 +207,39 @@ func (self *ServerSocket) SetMaxClients(maxnum int) {
 	self.m_nMaxClients = maxnum
 }
 
+//设置连接的准入控制，必须在Start之前调用
+func (self *ServerSocket) SetConnLimiter(limiter *ConnLimiter) {
+	self.m_Limiter = limiter
+}
+
+//开启tls，必须在Start之前调用
+func (self *ServerSocket) SetTls(loader *TlsLoader) {
+	self.m_TlsConfig = loader.ServerConfig()
+}
+
+//开启消息加密，客户端连接后需要先握手，必须在Start之前调用
+func (self *ServerSocket) SetCrypto(cfg *CryptoConfig) {
+	self.m_CryptoCfg = cfg
+}
+
+//开启消息压缩，客户端发起协商，必须在Start之前调用
+func (self *ServerSocket) SetCompress(cfg *CompressConfig) {
+	self.m_CompressCfg = cfg
+}
+
+//开启消息序号和确认，客户端发来带序号的消息包后回复的也带序号，必须在Start之前调用
+func (self *ServerSocket) SetSequence(on bool) {
+	self.m_Sequence = on
+}
+
+//是否接受新连接
+func (self *ServerSocket) accept(addr string) bool {
+	if self.m_Limiter != nil {
+		return self.m_Limiter.Accept(addr, int(atomic.LoadInt32(&self.m_nClientCount)), self.m_nMaxClients)
+	}
+	return int(atomic.LoadInt32(&self.m_nClientCount)) < self.m_nMaxClients
+}
+
 func serverRoutine(server *ServerSocket) {
 	for {
 		tcpConn, err := server.m_Listen.AcceptTCP()
@@ -190,13 +248,14 @@ func serverRoutine(server *ServerSocket) {
 			break
 		}
 
-		if server.m_nClientCount >= server.m_nMaxClients {
+		addr := tcpConn.RemoteAddr().String()
+		if !server.accept(addr) {
 			tcpConn.Close()
-			llog.Warning("serverRoutine: too many conns")
+			llog.Warningf("serverRoutine: refuse conn %s", addr)
 			continue
 		}
 
-		handleConn(server, tcpConn, tcpConn.RemoteAddr().String())
+		handleConn(server, tcpConn, addr)
 	}
 	server.Close()
 }
@@ -206,7 +265,12 @@ func handleConn(server *ServerSocket, tcpConn *net.TCPConn, addr string) bool {
 		return false
 	}
 
-	pClient := server.AddClinet(tcpConn, addr, server.m_nConnectType)
+	tcpConn.SetNoDelay(true)
+	var conn net.Conn = tcpConn
+	if server.m_TlsConfig != nil { //握手在读协程中进行，不阻塞accept，超时TLS_HANDSHAKE
+		conn = tls.Server(tcpConn, server.m_TlsConfig)
+	}
+	pClient := server.AddClinet(conn, addr, server.m_nConnectType)
 	if pClient == nil {
 		return false
 	}
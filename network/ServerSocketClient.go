@@ -1,8 +1,8 @@
 package network
 
 import (
+	"crypto/tls"
 	"io"
-	"net"
 	"runtime"
 
 	"github.com/snowyyj001/loumiao/llog"
@@ -23,16 +23,15 @@ func (self *ServerSocketClient) Start() bool {
 		llog.Error("ServerSocketClient.Start error : unkonwen socket type")
 		return false
 	}
-	if self.m_nState != SSF_SHUT_DOWN {
+	if self.GetState() != SSF_SHUT_DOWN {
 		return false
 	}
 
 	if self.m_pServer == nil {
 		return false
 	}
-	self.m_bShuttingDown = false
-	self.m_nState = SSF_CONNECT
-	self.m_Conn.(*net.TCPConn).SetNoDelay(true)
+	self.setShuttingDown(false)
+	self.SetState(SSF_CONNECT)
 	//self.m_Conn.SetKeepAlive(true)
 	//self.m_Conn.SetKeepAlivePeriod(5*time.Second)
 	self.OnNetConn()
@@ -42,12 +41,31 @@ func (self *ServerSocketClient) Start() bool {
 }
 
 func (self *ServerSocketClient) Send(buff []byte) int {
-	n, err := self.m_Conn.Write(buff)
-	handleError(err)
-	if n > 0 {
-		return n
+	return self.sequence(buff, self.output)
+}
+
+//发送一个消息包，不加序号
+func (self *ServerSocketClient) output(buff []byte) int {
+	if !self.admit() {
+		return 0
+	}
+	n := 0
+	for _, frame := range self.pack(buff) {
+		if self.m_Crypto != nil {
+			n += self.m_Crypto.Send(frame)
+		} else {
+			n += self.write(frame)
+		}
+	}
+	return n
+}
+
+func (self *ServerSocketClient) write(buff []byte) int {
+	q := self.getSendQueue()
+	if q == nil {
+		return 0
 	}
-	return 0
+	return q.push(buff)
 }
 
 func (self *ServerSocketClient) OnNetConn() {
@@ -78,18 +96,26 @@ func serverclientRoutine(pClient *ServerSocketClient) bool {
 			llog.Errorf("ServerSocketClient.serverclientRoutine %v: %s", r, buf[:l])
 		}
 	}()
-	if pClient.m_Conn == nil {
+	conn := pClient.conn()
+	if conn == nil {
 		return false
 	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := serverHandshake(tlsConn); err != nil {
+			llog.Infof("远程tls握手错误: %s！ %s", pClient.GetSAddr(), err.Error())
+			pClient.OnNetFail(2)
+			return false
+		}
+	}
 	var buff = make([]byte, pClient.m_MaxReceiveBufferSize)
 	for {
-		if pClient.m_bShuttingDown {
+		if pClient.isShuttingDown() {
 			llog.Infof("远程链接：%s已经被关闭！", pClient.GetSAddr())
 			pClient.OnNetFail(0)
 			break
 		}
 
-		n, err := pClient.m_Conn.Read(buff)
+		n, err := conn.Read(buff)
 		if err == io.EOF {
 			llog.Infof("远程m_Conn：%s已经关闭！", pClient.GetSAddr())
 			pClient.OnNetFail(1)
@@ -0,0 +1,168 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/llog"
+)
+
+const (
+	TLS_RELOAD_CHECK = 1000 //检查证书文件是否更新的最小间隔，毫秒
+	TLS_HANDSHAKE    = 5000 //连接时tls握手的超时，毫秒
+)
+
+//加载tls证书，证书文件更新后自动重新加载，已经建立的连接不受影响
+type TlsLoader struct {
+	cfg config.TlsCfg
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time //证书文件的最后修改时间
+	checked time.Time //上次检查的时间
+}
+
+//加载证书，文件有错误时返回error
+func NewTlsLoader(cfg *config.TlsCfg) (*TlsLoader, error) {
+	self := &TlsLoader{cfg: *cfg}
+	if err := self.Reload(); err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+//重新加载证书，失败时继续使用原来的证书
+func (self *TlsLoader) Reload() error {
+	var cert *tls.Certificate
+	if self.cfg.Cert != "" || self.cfg.Key != "" {
+		c, err := tls.LoadX509KeyPair(self.cfg.Cert, self.cfg.Key)
+		if err != nil {
+			return fmt.Errorf("TlsLoader: %s", err.Error())
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if self.cfg.CA != "" {
+		data, err := ioutil.ReadFile(self.cfg.CA)
+		if err != nil {
+			return fmt.Errorf("TlsLoader: %s", err.Error())
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("TlsLoader: no certificate in %s", self.cfg.CA)
+		}
+	}
+	modTime := self.lastModified()
+	self.lock.Lock()
+	self.cert, self.pool, self.modTime = cert, pool, modTime
+	self.lock.Unlock()
+	return nil
+}
+
+//证书文件中最新的修改时间
+func (self *TlsLoader) lastModified() time.Time {
+	var last time.Time
+	for _, file := range []string{self.cfg.Cert, self.cfg.Key, self.cfg.CA} {
+		if file == "" {
+			continue
+		}
+		if fi, err := os.Stat(file); err == nil && fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	return last
+}
+
+//当前的证书，文件有更新时先重新加载
+func (self *TlsLoader) current() (*tls.Certificate, *x509.CertPool) {
+	now := time.Now()
+	self.lock.Lock()
+	check := now.Sub(self.checked) >= TLS_RELOAD_CHECK*time.Millisecond
+	if check {
+		self.checked = now
+	}
+	modTime := self.modTime
+	self.lock.Unlock()
+
+	if check && self.lastModified().After(modTime) {
+		if err := self.Reload(); err != nil { //可能文件还没有写完，下次再试
+			llog.Errorf("%s", err.Error())
+		} else {
+			llog.Infof("TlsLoader: certificate reloaded: %s", self.cfg.Cert)
+		}
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.cert, self.pool
+}
+
+//监听使用的配置，配置了ca时要求并校验对方的证书
+//GetCertificate给http.Server.ListenAndServeTLS检查使用，go1.22之前只认Certificates和GetCertificate
+func (self *TlsLoader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := self.current()
+			if cert == nil {
+				return nil, errors.New("TlsLoader: no server certificate")
+			}
+			return cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := self.current()
+			if cert == nil {
+				return nil, errors.New("TlsLoader: no server certificate")
+			}
+			cfg := &tls.Config{Certificates: []tls.Certificate{*cert}, MinVersion: tls.VersionTLS12}
+			if pool != nil {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = pool
+			}
+			return cfg, nil
+		},
+	}
+}
+
+//连接使用的配置，每次连接时获取，使用最新的证书
+//@addr: 连接地址，没有配置ServerName时用它的host校验对方证书
+func (self *TlsLoader) ClientConfig(addr string) *tls.Config {
+	cert, pool := self.current()
+	cfg := &tls.Config{RootCAs: pool, ServerName: self.cfg.ServerName, MinVersion: tls.VersionTLS12}
+	if cfg.ServerName == "" {
+		cfg.ServerName = RemoteIp(addr)
+	}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	return cfg
+}
+
+//在conn上完成服务端的tls握手，对方超时没有完成握手时返回错误
+func serverHandshake(conn *tls.Conn) error {
+	conn.SetDeadline(time.Now().Add(TLS_HANDSHAKE * time.Millisecond))
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+//在conn上完成客户端的tls握手
+func (self *TlsLoader) Client(conn net.Conn, addr string) (net.Conn, error) {
+	tlsConn := tls.Client(conn, self.ClientConfig(addr))
+	tlsConn.SetDeadline(time.Now().Add(TLS_HANDSHAKE * time.Millisecond))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
@@ -0,0 +1,158 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/message"
+)
+
+//生成127.0.0.1的自签名证书，返回服务端和客户端(信任这个证书)的TlsLoader
+func testTlsLoaders(t *testing.T) (*TlsLoader, *TlsLoader) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "loumiao"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	server, err := NewTlsLoader(&config.TlsCfg{Cert: certFile, Key: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewTlsLoader(&config.TlsCfg{CA: certFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func testAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+//http.Server.ListenAndServeTLS("", "")需要GetCertificate
+func TestTlsListenAndServe(t *testing.T) {
+	server, client := testTlsLoaders(t)
+	if server.ServerConfig().GetCertificate == nil {
+		t.Fatal("GetCertificate not set")
+	}
+	addr := testAddr(t)
+	srv := &http.Server{Addr: addr, TLSConfig: server.ServerConfig(), Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	errChan := make(chan error, 1)
+	go func() { errChan <- srv.ListenAndServeTLS("", "") }()
+	defer srv.Close()
+
+	hc := &http.Client{Transport: &http.Transport{TLSClientConfig: client.ClientConfig(addr)}}
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if resp, err = hc.Get("https://" + addr); err == nil {
+			break
+		}
+		select {
+		case err = <-errChan:
+			t.Fatalf("ListenAndServeTLS: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestTlsServerSocket(t *testing.T) {
+	initMessage()
+	server, client := testTlsLoaders(t)
+	addr := testAddr(t)
+	ss := new(ServerSocket)
+	ss.Init(addr)
+	ss.SetMaxClients(4)
+	ss.SetConnectType(SERVER_CONNECT)
+	ss.SetTls(server)
+	disconnect := make(chan struct{}, 4)
+	ss.BindPacketFunc(func(id int, buff []byte, nlen int) bool {
+		if _, _, name, _ := message.Decode(0, buff, nlen); name == "DISCONNECT" {
+			disconnect <- struct{}{}
+		}
+		return true
+	})
+	if !ss.Start() {
+		t.Fatal("server start failed")
+	}
+	defer ss.Close()
+
+	cs := new(ClientSocket)
+	cs.Init(addr)
+	cs.SetConnectType(CHILD_CONNECT)
+	cs.SetTls(client)
+	clientDisconnect := make(chan struct{}, 1)
+	cs.BindPacketFunc(func(id int, buff []byte, nlen int) bool {
+		if _, _, name, _ := message.Decode(0, buff, nlen); name == "C_DISCONNECT" {
+			clientDisconnect <- struct{}{}
+		}
+		return true
+	})
+	if !cs.Start() {
+		t.Fatal("tls client start failed")
+	}
+	cs.Stop()
+	<-disconnect
+	waitDisconnect(clientDisconnect)
+
+	if testing.Short() {
+		return
+	}
+	//不握手的连接TLS_HANDSHAKE后断开
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case <-disconnect:
+	case <-time.After((TLS_HANDSHAKE + 1000) * time.Millisecond):
+		t.Fatal("silent tls client not closed")
+	}
+}
@@ -3,8 +3,10 @@ package network
 import (
 	"fmt"
 
+	"net/http"
 	"net/url"
 	"runtime"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/snowyyj001/loumiao/llog"
@@ -15,6 +17,10 @@ type WebClient struct {
 	Socket
 	m_nMaxClients int
 	m_nMinClients int
+	m_Tls         *TlsLoader
+	m_CompressCfg *CompressConfig
+	m_Reconnect   *reconnector
+	m_Sequence    bool
 }
 
 func (self *WebClient) Init(saddr string) bool {
@@ -31,11 +37,15 @@ func (self *WebClient) Start() bool {
 		llog.Error("WebClient.Start error : unkonwen socket type")
 		return false
 	}
-	self.m_bShuttingDown = false
+	self.setShuttingDown(false)
 
 	if self.m_sAddr == "" {
 		return false
 	}
+	if self.m_Reconnect != nil {
+		self.m_Reconnect.reset()
+	}
+	self.m_Seq = nil //重新开始计数
 
 	if self.Connect() {
 		go wsclientRoutine(self)
@@ -46,13 +56,71 @@ func (self *WebClient) Start() bool {
 }
 
 func (self *WebClient) Send(buff []byte) int {
-	if self.m_WsConn == nil {
+	if r := self.m_Reconnect; r != nil {
+		if n, ok := r.hold(buff); ok {
+			return n
+		}
+	}
+	return self.send(buff)
+}
+
+func (self *WebClient) send(buff []byte) int {
+	return self.sequence(buff, self.output)
+}
+
+//发送一个消息包，不加序号
+func (self *WebClient) output(buff []byte) int {
+	q := self.getSendQueue()
+	if q == nil || !self.admit() {
 		return 0
 	}
-	err := self.m_WsConn.WriteMessage(websocket.BinaryMessage, buff)
-	handleError(err)
-	//self.m_Writer.Flush()
-	return 0
+	n := 0
+	for _, frame := range self.pack(buff) {
+		n += q.push(frame)
+	}
+	return n
+}
+
+//开启tls(wss)，每次连接时使用最新的证书
+func (self *WebClient) SetTls(loader *TlsLoader) {
+	self.m_Tls = loader
+}
+
+//开启消息压缩，连接建立后发起协商
+func (self *WebClient) SetCompress(cfg *CompressConfig) {
+	self.m_CompressCfg = cfg
+}
+
+//开启消息序号和确认，重连时保留序号，重发对方没有确认的消息包
+func (self *WebClient) SetSequence(on bool) {
+	self.m_Sequence = on
+}
+
+//开启断线重连
+func (self *WebClient) SetReconnect(cfg *ReconnectConfig) {
+	self.m_Reconnect = newReconnector(cfg)
+}
+
+//重连成功后第一个发送的消息包，需要先调用SetReconnect
+func (self *WebClient) SetLoginPacket(buff []byte) {
+	self.SetLoginFunc(func() []byte { return buff })
+}
+
+//重连成功后调用login生成第一个发送的消息包，例如带续连序号的LouMiaoLoginGate，返回nil不发送，需要先调用SetReconnect
+func (self *WebClient) SetLoginFunc(login func() []byte) {
+	if r := self.m_Reconnect; r != nil {
+		r.setLogin(login)
+	}
+}
+
+//关闭连接，不再重连
+func (self *WebClient) Stop() bool {
+	if self.m_Reconnect != nil {
+		self.m_Reconnect.stop()
+	}
+	self.setShuttingDown(true)
+	self.Close()
+	return true
 }
 
 func (self *WebClient) Restart() bool {
@@ -60,17 +128,36 @@ func (self *WebClient) Restart() bool {
 }
 
 func (self *WebClient) Connect() bool {
-	if self.m_nState == SSF_CONNECT {
+	if self.GetState() == SSF_CONNECT {
 		return false
 	}
 
 	wsAddr := url.URL{Scheme: "ws", Host: self.m_sAddr, Path: "/ws"}
-	conn, _, err := websocket.DefaultDialer.Dial(wsAddr.String(), nil)
+	dialer := websocket.DefaultDialer
+	if self.m_Tls != nil {
+		wsAddr.Scheme = "wss"
+		dialer = &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: TLS_HANDSHAKE * time.Millisecond,
+			TLSClientConfig:  self.m_Tls.ClientConfig(self.m_sAddr),
+		}
+	}
+	conn, _, err := dialer.Dial(wsAddr.String(), nil)
 	if err != nil {
+		llog.Errorf("WebClient Dial %s: %v", wsAddr.String(), err)
 		return false
 	}
-	self.m_nState = SSF_CONNECT
+	self.SetState(SSF_CONNECT)
 	self.SetWsConn(conn)
+	self.startWsQueue(conn)
+	if self.m_CompressCfg != nil {
+		self.EnableCompress(self.m_CompressCfg, false, self.output)
+		self.m_Compress.handshake()
+	}
+	if self.m_Sequence {
+		self.EnableSequence(true, self.output)
+	}
+	self.startHeartbeat(self.output)
 	self.OnNetConn()
 	return true
 }
@@ -84,6 +171,10 @@ func (self *WebClient) OnNetConn() {
 }
 
 func (self *WebClient) OnNetFail(int) {
+	if self.m_Reconnect != nil && self.m_Reconnect.begin(self.m_nConnectType) { //重连，不派发C_DISCONNECT
+		self.Close()
+		return
+	}
 	buff, nLen := message.Encode(0, "C_DISCONNECT", nil)
 	self.HandlePacket(self.m_ClientId, buff, nLen)
 	self.Close()
@@ -97,16 +188,17 @@ func wsclientRoutine(pClient *WebClient) bool {
 			llog.Errorf("WebClient.wsclientRoutine %v: %s", r, buf[:l])
 		}
 	}()
-	if pClient.m_WsConn == nil {
+	conn := pClient.wsConn()
+	if conn == nil {
 		return false
 	}
 
 	for {
-		if pClient.m_bShuttingDown {
+		if pClient.isShuttingDown() {
 			break
 		}
 
-		mt, message, err := pClient.m_WsConn.ReadMessage()
+		mt, message, err := conn.ReadMessage()
 
 		if err != nil {
 			handleError(err)
@@ -126,6 +218,32 @@ func wsclientRoutine(pClient *WebClient) bool {
 
 	}
 
+	if pClient.m_Reconnect != nil && pClient.m_Reconnect.isDown() {
+		pClient.reconnect()
+		return true
+	}
 	pClient.Close()
 	return true
 }
+
+//断线重连，在接收协程中调用，成功后启动新的接收协程
+func (self *WebClient) reconnect() {
+	r := self.m_Reconnect
+	ok := r.run(self.m_sAddr, func() bool {
+		self.SetConnectType(r.connectType)
+		return self.Connect()
+	}, func(buff []byte) int {
+		return self.relogin(buff, self.output)
+	}, self.send)
+	if ok {
+		self.setShuttingDown(false)
+		go wsclientRoutine(self)
+		return
+	}
+	self.Close()
+	if r.isStopped() { //调用了Stop，不再派发C_DISCONNECT
+		return
+	}
+	buff, nLen := message.Encode(0, "C_DISCONNECT", nil)
+	self.HandlePacket(self.m_ClientId, buff, nLen)
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"net/http"
 	"sync"
 	"sync/atomic"
@@ -24,17 +25,20 @@ type IWebSocket interface {
 
 type WebSocket struct {
 	Socket
-	m_nClientCount  int
-	m_nMaxClients   int
-	m_nMinClients   int
-	m_nIdSeed       int32
-	m_bShuttingDown bool
-	m_bCanAccept    bool
-	m_bNagle        bool
-	m_ClientList    map[int]*WebSocketClient
-	m_ClientLocker  *sync.RWMutex
-	m_httpServer    *http.Server
-	m_Lock          sync.Mutex
+	m_nClientCount int32 //原子操作访问
+	m_nMaxClients  int
+	m_nMinClients  int
+	m_nIdSeed      int32
+	m_bCanAccept   bool
+	m_bNagle       bool
+	m_ClientList   map[int]*WebSocketClient
+	m_ClientLocker *sync.RWMutex
+	m_httpServer   *http.Server
+	m_Lock         sync.Mutex
+	m_Limiter      *ConnLimiter
+	m_TlsConfig    *tls.Config
+	m_CompressCfg  *CompressConfig
+	m_Sequence     bool
 }
 
 var upgrader = websocket.Upgrader{
@@ -56,7 +60,7 @@ func (self *WebSocket) Start() bool {
 		llog.Error("WebSocket.Start error : unkonwen socket type")
 		return false
 	}
-	self.m_bShuttingDown = false
+	self.setShuttingDown(false)
 
 	if self.m_sAddr == "" {
 		return false
@@ -64,9 +68,14 @@ func (self *WebSocket) Start() bool {
 
 	http.HandleFunc("/", serveHome)
 	http.HandleFunc("/ws", serveWs)
-	self.m_httpServer = &http.Server{Addr: self.m_sAddr}
+	self.m_httpServer = &http.Server{Addr: self.m_sAddr, TLSConfig: self.m_TlsConfig}
 	go func() {
-		err := self.m_httpServer.ListenAndServe()
+		var err error
+		if self.m_TlsConfig != nil { //wss，证书由TLSConfig提供
+			err = self.m_httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = self.m_httpServer.ListenAndServe()
+		}
 		if err != nil {
 			llog.Errorf("WebSocket ListenAndServe: %v", err)
 			return
@@ -76,7 +85,7 @@ func (self *WebSocket) Start() bool {
 
 	//延迟，监听关闭
 	//defer ln.Close()
-	self.m_nState = SSF_ACCEPT
+	self.SetState(SSF_ACCEPT)
 	return true
 }
 
@@ -98,7 +107,7 @@ func (self *WebSocket) GetClientById(id int) *WebSocketClient {
 func (self *WebSocket) ClientRemoteAddr(clientid int) string {
 	pClinet := self.GetClientById(clientid)
 	if pClinet != nil {
-		return pClinet.m_Conn.RemoteAddr().String()
+		return pClinet.m_sAddr
 	}
 	return ""
 }
@@ -111,15 +120,27 @@ func (self *WebSocket) AddClinet(wConn *websocket.Conn, addr string, connectType
 		pClient.m_ClientId = self.AssignClientId()
 		pClient.SetConnectType(connectType)
 		pClient.SetWsConn(wConn)
+		pClient.startWsQueue(wConn)
 		pClient.BindPacketFunc(self.m_PacketFunc)
+		if self.m_CompressCfg != nil {
+			pClient.EnableCompress(self.m_CompressCfg, true, pClient.output)
+		}
+		if self.m_Sequence {
+			pClient.EnableSequence(false, pClient.output)
+		}
+		pClient.startHeartbeat(pClient.output)
 		self.m_ClientLocker.Lock()
 		self.m_ClientList[pClient.m_ClientId] = pClient
 		self.m_ClientLocker.Unlock()
 		pClient.Start()
-		self.m_nClientCount++
+		atomic.AddInt32(&self.m_nClientCount, 1)
 		llog.Debugf("客户端：%s已连接[%d]！", wConn.RemoteAddr().String(), pClient.m_ClientId)
 		return pClient
 	} else {
+		wConn.Close()
+		if self.m_Limiter != nil { //accept时占用的ip名额
+			self.m_Limiter.Release(addr)
+		}
 		llog.Errorf("WebSocket.AddClinet %s", "无法创建客户端连接对象")
 	}
 	return nil
@@ -128,9 +149,12 @@ func (self *WebSocket) AddClinet(wConn *websocket.Conn, addr string, connectType
 func (self *WebSocket) DelClinet(pClient *WebSocketClient) bool {
 	self.m_ClientLocker.Lock()
 	delete(self.m_ClientList, pClient.m_ClientId)
-	llog.Debugf("客户端：%s已断开连接[%d]！", pClient.m_WsConn.RemoteAddr().String(), pClient.m_ClientId)
+	llog.Debugf("客户端：%s已断开连接[%d]！", pClient.m_sAddr, pClient.m_ClientId)
 	self.m_ClientLocker.Unlock()
-	self.m_nClientCount--
+	atomic.AddInt32(&self.m_nClientCount, -1)
+	if self.m_Limiter != nil {
+		self.m_Limiter.Release(pClient.m_sAddr)
+	}
 	return true
 }
 
@@ -182,6 +206,9 @@ func (self *WebSocket) OnNetFail(int) {
 }
 
 func (self *WebSocket) Close() {
+	if atomic.SwapInt32(&self.m_nState, SSF_SHUT_DOWN) == SSF_SHUT_DOWN { //监听协程出错时也会调用
+		return
+	}
 	self.m_httpServer.Close()
 	self.Clear()
 }
@@ -190,14 +217,49 @@ func (self *WebSocket) SetMaxClients(maxnum int) {
 	self.m_nMaxClients = maxnum
 }
 
+//设置连接的准入控制，必须在Start之前调用
+func (self *WebSocket) SetConnLimiter(limiter *ConnLimiter) {
+	self.m_Limiter = limiter
+}
+
+//开启tls(wss)，必须在Start之前调用
+func (self *WebSocket) SetTls(loader *TlsLoader) {
+	self.m_TlsConfig = loader.ServerConfig()
+}
+
+//开启消息压缩，客户端发起协商，必须在Start之前调用
+func (self *WebSocket) SetCompress(cfg *CompressConfig) {
+	self.m_CompressCfg = cfg
+}
+
+//开启消息序号和确认，客户端发来带序号的消息包后回复的也带序号，必须在Start之前调用
+func (self *WebSocket) SetSequence(on bool) {
+	self.m_Sequence = on
+}
+
+//是否接受新连接
+func (self *WebSocket) accept(addr string) bool {
+	if self.m_Limiter != nil {
+		return self.m_Limiter.Accept(addr, int(atomic.LoadInt32(&self.m_nClientCount)), self.m_nMaxClients)
+	}
+	return int(atomic.LoadInt32(&self.m_nClientCount)) < self.m_nMaxClients
+}
+
 func serveWs(w http.ResponseWriter, r *http.Request) {
+	if !This.accept(r.RemoteAddr) { //升级之前拒绝，不占用websocket资源
+		llog.Warningf("serveWs: refuse conn %s", r.RemoteAddr)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		if This.m_Limiter != nil {
+			This.m_Limiter.Release(r.RemoteAddr)
+		}
 		llog.Errorf("serveWs upgrade: %s", err.Error())
 		return
 	}
-	pClient := This.AddClinet(c, r.RemoteAddr, This.m_nConnectType)
-	pClient.Start()
+	This.AddClinet(c, r.RemoteAddr, This.m_nConnectType)
 }
 
 func serveHome(w http.ResponseWriter, r *http.Request) {
@@ -22,15 +22,15 @@ func (self *WebSocketClient) Start() bool {
 		llog.Error("WebSocketClient.Start error : unkonwen socket type")
 		return false
 	}
-	if self.m_nState != SSF_SHUT_DOWN {
+	if self.GetState() != SSF_SHUT_DOWN {
 		return false
 	}
 
 	if self.m_pServer == nil {
 		return false
 	}
-	self.m_bShuttingDown = false
-	self.m_nState = SSF_ACCEPT
+	self.setShuttingDown(false)
+	self.SetState(SSF_ACCEPT)
 
 	//self.OnNetConn()
 	go wserverclientRoutine(self)
@@ -38,13 +38,20 @@ func (self *WebSocketClient) Start() bool {
 }
 
 func (self *WebSocketClient) Send(buff []byte) int {
-	if self.m_WsConn == nil {
+	return self.sequence(buff, self.output)
+}
+
+//发送一个消息包，不加序号
+func (self *WebSocketClient) output(buff []byte) int {
+	q := self.getSendQueue()
+	if q == nil || !self.admit() {
 		return 0
 	}
-
-	err := self.m_WsConn.WriteMessage(websocket.BinaryMessage, buff)
-	handleError(err)
-	return 0
+	n := 0
+	for _, frame := range self.pack(buff) {
+		n += q.push(frame)
+	}
+	return n
 }
 
 func (self *WebSocketClient) OnNetConn() {
@@ -61,29 +68,27 @@ func (self *WebSocketClient) OnNetFail(error int) {
 }
 
 func (self *WebSocketClient) Close() {
-	if self.m_WsConn != nil {
-		self.m_WsConn.Close()
-	}
-	self.m_WsConn = nil
-	self.Socket.Close()
 	if self.m_pServer != nil {
 		self.m_pServer.DelClinet(self)
+		self.m_pServer = nil
 	}
+	self.Socket.Close()
 }
 
 func wserverclientRoutine(pClient *WebSocketClient) bool {
-	if pClient.m_WsConn == nil {
+	conn := pClient.wsConn()
+	if conn == nil {
 		return false
 	}
 
 	for {
-		if pClient.m_bShuttingDown {
+		if pClient.isShuttingDown() {
 			llog.Debugf("远程链接：%s已经被关闭！", pClient.GetSAddr())
 			pClient.OnNetFail(0)
 			break
 		}
 
-		mt, message, err := pClient.m_WsConn.ReadMessage()
+		mt, message, err := conn.ReadMessage()
 		if err != nil {
 			llog.Debugf("远程链接：%s已经关闭！%v\n", pClient.GetSAddr(), err)
 			pClient.OnNetFail(1)
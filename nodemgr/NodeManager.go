@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/define"
 	"github.com/snowyyj001/loumiao/util"
 )
 
@@ -13,6 +14,7 @@ type NodeInfo struct {
 	config.NetNode      //所有的服务器列表，如果要删除，需要删除etcd里面的内容
 	Number         int  //-1代表服务器未激活,服务器通过ETCD_NODESTATUS上报状态，就算激活，即number >= 0，socket断开或etcd断开都意味着节点不可用，已经关闭
 	SocketActive   bool //服务被主动关闭，节点还可用（因为节点是有状态的，为了不丢失数据），但节点不再被集群主动发现使用，
+	Draining       bool //节点正在排空(ETCD_NODEDRAIN)，不再分配新的登录和rpc，已有的连接和消息继续处理
 }
 
 //负载均衡策略都是挑选number最小的
@@ -23,6 +25,7 @@ var (
 	saddr_uid_Map map[int]string       //saddr -> uid
 	nodeLock      sync.RWMutex
 	SocketActive  bool //本子节点网络状态
+	Draining      bool //本子节点是否正在排空
 )
 
 func init() {
@@ -59,8 +62,7 @@ func GetNodeByAddr(saddr string) *NodeInfo {
 func NodeStatusUpdate(key string, val string, dis bool) {
 	var saddr string
 	//var group string
-	arrStr := strings.Split(key, "/")
-	saddr = arrStr[2]
+	saddr = strings.Trim(strings.TrimPrefix(key, define.ETCD_NODESTATUS), "/")
 	//group = arrStr[2]
 
 	//llog.Debugf("NodeStatusUpdate: key=%s,val=%s,dis=%t", key, val, dis)
@@ -81,9 +83,28 @@ func NodeStatusUpdate(key string, val string, dis bool) {
 
 	} else {
 		node.Number = -1
+		node.Draining = false
 	}
 }
 
+//节点排空状态更新，排空标记和租约一起过期
+func NodeDrainUpdate(key string, val string, put bool) {
+	saddr := strings.Trim(strings.TrimPrefix(key, define.ETCD_NODEDRAIN), "/")
+	nodeLock.RLock()
+	node, _ := node_Map[saddr]
+	nodeLock.RUnlock()
+	if node == nil {
+		return
+	}
+	node.Draining = put
+}
+
+//节点是否正在排空，未知节点视为没有排空
+func IsDraining(uid int) bool {
+	node := GetNode(uid)
+	return node != nil && node.Draining
+}
+
 //pick a gate and world for client
 func GetBalanceServer(group string, onlyworld bool) (string, int) {
 	//pick the gate and the world
@@ -95,12 +116,12 @@ func GetBalanceServer(group string, onlyworld bool) (string, int) {
 
 	nodeLock.RLock()
 	for val, node := range node_Map {
-		if node.SocketActive && node.Number != -1 && node.Number < minNum && node.Type == config.ServerType_Gate {
+		if node.SocketActive && !node.Draining && node.Number != -1 && node.Number < minNum && node.Type == config.ServerType_Gate {
 			saddr = val
 			minNum = node.Number
 		}
 		if onlyworld == false {
-			if node.SocketActive && /* && node.Group == group*/ node.Number != -1 && node.Number < minNum_2 && node.Type == config.ServerType_World {
+			if node.SocketActive && !node.Draining && /* && node.Group == group*/ node.Number != -1 && node.Number < minNum_2 && node.Type == config.ServerType_World {
 				worlduid = node.Uid
 				minNum_2 = node.Number
 			}
@@ -119,7 +140,7 @@ func GetBalanceZone(group string) int {
 
 	nodeLock.RLock()
 	for _, node := range node_Map {
-		if node.SocketActive /* && node.Group == group */ && node.Number != -1 && node.Number < minNum && node.Type == config.ServerType_Zone {
+		if node.SocketActive && !node.Draining /* && node.Group == group */ && node.Number != -1 && node.Number < minNum && node.Type == config.ServerType_Zone {
 			uid = node.Uid
 			minNum = node.Number
 		}
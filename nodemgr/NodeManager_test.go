@@ -0,0 +1,30 @@
+package nodemgr
+
+import (
+	"testing"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/define"
+)
+
+func TestNodeDrainUpdate(t *testing.T) {
+	node := &NodeInfo{NetNode: config.NetNode{Uid: 3001, SAddr: "127.0.0.1:6001"}, Number: -1}
+	AddNode(node)
+	defer RemoveNode(node.SAddr)
+
+	NodeStatusUpdate(define.ETCD_NODESTATUS+node.SAddr, "12", true)
+	if node.Number != 12 || IsDraining(node.Uid) {
+		t.Fatalf("status: number=%d, draining=%v", node.Number, node.Draining)
+	}
+
+	NodeDrainUpdate(define.ETCD_NODEDRAIN+node.SAddr, "1", true)
+	NodeStatusUpdate(define.ETCD_NODESTATUS+node.SAddr, "10", true)
+	if node.Number != 10 || !IsDraining(node.Uid) {
+		t.Fatalf("drain: number=%d, draining=%v", node.Number, node.Draining)
+	}
+
+	NodeDrainUpdate(define.ETCD_NODEDRAIN+node.SAddr, "", false)
+	if IsDraining(node.Uid) {
+		t.Fatal("drain key deleted, still draining")
+	}
+}
@@ -0,0 +1,195 @@
+package loumiao
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/snowyyj001/loumiao/config"
+	"github.com/snowyyj001/loumiao/etcd"
+	"github.com/snowyyj001/loumiao/gorpc"
+	"github.com/snowyyj001/loumiao/llog"
+)
+
+const (
+	SINGLETON_ID = 1 //单例actor在协程池中的id
+)
+
+var singletons []*Singleton
+
+//集群单例actor，同名的单例只在选举出的leader节点上运行
+//leader宕机或者关闭后，新的leader会创建新的actor，需要保留的状态请在DoStart中从数据库恢复
+//例如每日任务只需要一个节点执行，可以在单例actor中添加timer.Scheduler的任务
+type Singleton struct {
+	Name     string
+	sync     bool
+	create   func() gorpc.IGoRoutine
+	pool     *gorpc.GoRoutinePool
+	election *etcd.Election
+
+	lock     sync.Mutex
+	opLock   sync.Mutex //开关actor
+	leader   int        //leader节点uid，0代表还不知道
+	isLeader bool       //是否应该运行
+	running  bool       //actor是否在运行
+	wake     chan struct{}
+	done     chan struct{}
+	actor    etcd.Actor
+	handler  string
+}
+
+//注册集群单例，必须在Run之前调用，Run之后开始参选
+//@name: 单例名，也是选举名和协程池名，actor地址是"leaderUid/name/1"
+//@sync: 同Prepare
+//@create: 创建actor，每次成为leader都会创建新的actor
+func NewSingleton(name string, sync bool, create func() gorpc.IGoRoutine) *Singleton {
+	pool := &gorpc.GoRoutinePool{}
+	pool.Init()
+	gorpc.MGR.RegisterPool(name, pool)
+	self := &Singleton{Name: name, sync: sync, create: create, pool: pool}
+	self.wake = make(chan struct{}, 1)
+	self.done = make(chan struct{})
+	singletons = append(singletons, self)
+	return self
+}
+
+//leader节点uid，0代表还不知道
+func (self *Singleton) Leader() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.leader
+}
+
+//本节点是否是leader
+func (self *Singleton) IsLeader() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.isLeader
+}
+
+//单例actor的地址，用于SendAcotr，CallActor，还不知道leader时为空
+func (self *Singleton) Addr() string {
+	leader := self.Leader()
+	if leader == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%s/%d", leader, self.Name, SINGLETON_ID)
+}
+
+//给单例actor发送消息，leader切换期间会失败
+func (self *Singleton) Send(handler string, data interface{}) error {
+	addr := self.Addr()
+	if addr == "" {
+		return gorpc.ErrTargetNil
+	}
+	actorAddr, err := gorpc.ParseActorAddr(addr)
+	if err != nil {
+		return err
+	}
+	return gorpc.MGR.SendTo(actorAddr, handler, data)
+}
+
+//阻塞调用单例actor
+func (self *Singleton) Call(ctx context.Context, handler string, data interface{}) (interface{}, error) {
+	addr := self.Addr()
+	if addr == "" {
+		return nil, gorpc.ErrTargetNil
+	}
+	return CallActor(ctx, addr, handler, data)
+}
+
+//leader变化时投递*etcd.ElectionEvent给actor，必须在Run之前调用
+func (self *Singleton) Notify(actor etcd.Actor, handler string) {
+	self.actor = actor
+	self.handler = handler
+}
+
+//开始参选
+func (self *Singleton) start() {
+	self.election = etcd.NewElection(nil, "singleton/"+self.Name, strconv.Itoa(config.SERVER_NODE_UID), 0)
+	self.election.OnChange(self.onChange)
+	if self.actor != nil {
+		self.election.Notify(self.actor, self.handler)
+	}
+	go self.loop()
+	self.election.Start()
+}
+
+//退出选举，关闭本节点的actor，其他节点会接替
+func (self *Singleton) stop() {
+	if self.election == nil {
+		return
+	}
+	self.election.Close()
+	self.lock.Lock()
+	self.isLeader = false
+	self.lock.Unlock()
+	close(self.done)
+	self.reconcile()
+}
+
+func (self *Singleton) onChange(ev *etcd.ElectionEvent) {
+	leader, _ := strconv.Atoi(ev.Leader)
+	self.lock.Lock()
+	self.leader, self.isLeader = leader, ev.IsLeader
+	self.lock.Unlock()
+	select {
+	case self.wake <- struct{}{}:
+	default:
+	}
+}
+
+//在自己的协程中开关actor，不阻塞选举
+func (self *Singleton) loop() {
+	for {
+		select {
+		case <-self.wake:
+			self.reconcile()
+		case <-self.done:
+			return
+		}
+	}
+}
+
+//让actor的运行状态和选举结果一致
+func (self *Singleton) reconcile() {
+	self.opLock.Lock()
+	defer self.opLock.Unlock()
+	self.lock.Lock()
+	isLeader, running := self.isLeader, self.running
+	self.lock.Unlock()
+	if isLeader == running {
+		return
+	}
+	if isLeader {
+		llog.Infof("Singleton[%s]: start on this node", self.Name)
+		igo := self.create()
+		igo.SetSync(self.sync)
+		self.pool.DoSingleStart(igo, SINGLETON_ID, true)
+		running = self.pool.GetRunRoutine(SINGLETON_ID) != nil
+		if running == false {
+			llog.Errorf("Singleton[%s]: start failed, resign", self.Name)
+			self.election.Resign()
+		}
+	} else {
+		llog.Infof("Singleton[%s]: leadership lost, close", self.Name)
+		self.pool.CloseRoutine(SINGLETON_ID)
+		running = false
+	}
+	self.lock.Lock()
+	self.running = running
+	self.lock.Unlock()
+}
+
+func startSingletons() {
+	for _, s := range singletons {
+		s.start()
+	}
+}
+
+func stopSingletons() {
+	for _, s := range singletons {
+		s.stop()
+	}
+}
@@ -0,0 +1,31 @@
+package loumiao
+
+import (
+	"sync"
+
+	"github.com/snowyyj001/loumiao/auth"
+	"github.com/snowyyj001/loumiao/llog"
+)
+
+var (
+	tokenOnce   sync.Once
+	tokenSigner auth.Signer
+)
+
+//签发会话令牌，account在玩家登录成功后调用，客户端用令牌登录gate(LouMiaoLoginGate.Token)
+//使用cfg.json中的tokenalg，tokenkey，ED25519需要配置私钥
+//@claims: UserId，TokenId，WorldUid必填，GateUid为0时可以登录任意gate，Expire为0时有效期auth.TOKEN_TTL
+func IssueToken(claims auth.Claims) (string, error) {
+	tokenOnce.Do(func() {
+		signer, _, err := auth.FromConfig()
+		if err != nil {
+			llog.Errorf("IssueToken: %s", err.Error())
+			return
+		}
+		tokenSigner = signer
+	})
+	if tokenSigner == nil {
+		return "", auth.ErrNoSigner
+	}
+	return auth.Issue(tokenSigner, claims)
+}
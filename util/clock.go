@@ -0,0 +1,48 @@
+package util
+
+import (
+	"time"
+)
+
+//时钟，util的时间函数和util/timer的定时器都通过它获取时间，测试时可以替换成虚拟时钟
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) ClockTimer //d之后在其他协程中执行f
+}
+
+//AfterFunc返回的定时器，*time.Timer满足这个接口
+type ClockTimer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+var clock Clock = realClock{}
+
+//替换时钟，返回原来的时钟，必须在创建任何定时器之前调用，nil代表恢复系统时钟
+func SetClock(c Clock) Clock {
+	old := clock
+	if c == nil {
+		c = realClock{}
+	}
+	clock = c
+	return old
+}
+
+//当前时间
+func Now() time.Time {
+	return clock.Now()
+}
+
+//d之后执行f
+func AfterFunc(d time.Duration, f func()) ClockTimer {
+	return clock.AfterFunc(d, f)
+}
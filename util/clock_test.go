@@ -0,0 +1,39 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (self *fixedClock) Now() time.Time {
+	return self.now
+}
+
+func (self *fixedClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+func TestSetClock(t *testing.T) {
+	c := &fixedClock{now: time.Unix(1000, 5*int64(time.Millisecond))}
+	old := SetClock(c)
+	if TimeStamp() != 1000005 || TimeStampSec() != 1000 || !Now().Equal(c.now) {
+		SetClock(old)
+		t.Fatalf("fixed clock: %d %d", TimeStamp(), TimeStampSec())
+	}
+	c.now = c.now.Add(time.Second)
+	if TimeStamp() != 1001005 {
+		SetClock(old)
+		t.Fatalf("advanced clock: %d", TimeStamp())
+	}
+
+	if SetClock(nil) != c {
+		t.Fatal("SetClock did not return the previous clock")
+	}
+	if d := time.Since(Now()); d < 0 || d > time.Minute {
+		t.Fatalf("system clock not restored: %v", Now())
+	}
+}
@@ -0,0 +1,272 @@
+package timer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//cron表达式，实现了Schedule
+//格式：[秒] 分 时 日 月 周，5个字段时秒为0
+//支持 * ? , - / 以及JAN-DEC，SUN-SAT，周日可以写成0或者7
+//日和周都有限制时，满足其一即可，与crontab一致
+//支持@yearly @monthly @weekly @daily @hourly，以及"TZ=Asia/Shanghai "前缀指定时区，默认使用传入时间的时区
+type Cron struct {
+	second  uint64
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool //日是*
+	dowStar bool //周是*
+	loc     *time.Location
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+var cronMonths = map[string]int{"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6, "JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12}
+var cronDays = map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}
+
+//解析cron表达式
+func ParseCron(spec string) (*Cron, error) {
+	self := new(Cron)
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("cron %q: missing fields", spec)
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %s", spec, err.Error())
+		}
+		self.loc = loc
+		spec = strings.TrimSpace(spec[i:])
+	}
+	if desc, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = desc
+	}
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron %q: expected 5 or 6 fields, got %d", spec, len(fields))
+	}
+
+	var err error
+	if self.second, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron %q: second: %s", spec, err.Error())
+	}
+	if self.minute, err = parseCronField(fields[1], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron %q: minute: %s", spec, err.Error())
+	}
+	if self.hour, err = parseCronField(fields[2], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron %q: hour: %s", spec, err.Error())
+	}
+	if self.dom, err = parseCronField(fields[3], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron %q: day of month: %s", spec, err.Error())
+	}
+	if self.month, err = parseCronField(fields[4], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("cron %q: month: %s", spec, err.Error())
+	}
+	if self.dow, err = parseCronField(fields[5], 0, 7, cronDays); err != nil {
+		return nil, fmt.Errorf("cron %q: day of week: %s", spec, err.Error())
+	}
+	if self.dow&(1<<7) != 0 { //7也是周日
+		self.dow |= 1
+	}
+	self.domStar = fields[3] == "*" || fields[3] == "?"
+	self.dowStar = fields[5] == "*" || fields[5] == "?"
+	return self, nil
+}
+
+//解析cron表达式，出错时panic，用于固定的表达式
+func MustCron(spec string) *Cron {
+	c, err := ParseCron(spec)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+			rng, step = part[:i], n
+		}
+		var lo, hi int
+		if rng == "*" || rng == "?" {
+			lo, hi = min, max
+		} else {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = parseCronValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = parseCronValue(bounds[1], names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 { //a/n代表从a到最大值
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range [%d, %d]", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(str string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(str)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", str)
+	}
+	return v, nil
+}
+
+func (self *Cron) dayMatch(t time.Time) bool {
+	domOk := self.dom&(1<<uint(t.Day())) != 0
+	dowOk := self.dow&(1<<uint(t.Weekday())) != 0
+	if self.domStar || self.dowStar {
+		return domOk && dowOk
+	}
+	return domOk || dowOk
+}
+
+//t之后的下一次触发时间，5年内没有时返回零值
+func (self *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	if self.loc != nil {
+		t = t.In(self.loc)
+	}
+	zone := t.Location()
+	t = t.Add(time.Second - time.Duration(t.Nanosecond())) //从下一个整秒开始
+	yearLimit := t.Year() + 5
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+	for self.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, zone)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+	for !self.dayMatch(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, zone)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+	for self.hour&(1<<uint(t.Hour())) == 0 {
+		//按绝对时间加一小时，夏令时切换时不会原地打转，结束时重复的小时可能触发两次
+		next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, zone).Add(time.Hour)
+		if !next.After(t) {
+			next = next.Add(time.Hour)
+		}
+		t = next
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+	for self.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Add(time.Minute - time.Duration(t.Second())*time.Second)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+	for self.second&(1<<uint(t.Second())) == 0 {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+	return t.In(loc)
+}
+
+//每周的固定时间触发，使用传入时间的时区，配合In指定时区，参数超出范围时panic
+func Weekly(weekday time.Weekday, hour, minute, second int) Schedule {
+	if weekday < time.Sunday || weekday > time.Saturday {
+		panic(fmt.Errorf("Weekly: weekday %d out of range [0, 6]", weekday))
+	}
+	checkClock("Weekly", hour, minute, second)
+	return &Cron{
+		second: 1 << uint(second), minute: 1 << uint(minute), hour: 1 << uint(hour),
+		dom: cronAll(1, 31), month: cronAll(1, 12), dow: 1 << uint(weekday),
+		domStar: true,
+	}
+}
+
+//每月的固定时间触发，没有这一天的月份不触发，参数超出范围时panic
+func Monthly(day, hour, minute, second int) Schedule {
+	if day < 1 || day > 31 {
+		panic(fmt.Errorf("Monthly: day %d out of range [1, 31]", day))
+	}
+	checkClock("Monthly", hour, minute, second)
+	return &Cron{
+		second: 1 << uint(second), minute: 1 << uint(minute), hour: 1 << uint(hour),
+		dom: 1 << uint(day), month: cronAll(1, 12), dow: cronAll(0, 6),
+		dowStar: true,
+	}
+}
+
+func checkClock(name string, hour, minute, second int) {
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 || second < 0 || second > 59 {
+		panic(fmt.Errorf("%s: bad time %02d:%02d:%02d", name, hour, minute, second))
+	}
+}
+
+func cronAll(min, max int) uint64 {
+	var bits uint64
+	for v := min; v <= max; v++ {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+type locSchedule struct {
+	loc   *time.Location
+	sched Schedule
+}
+
+func (self *locSchedule) Next(t time.Time) time.Time {
+	next := self.sched.Next(t.In(self.loc))
+	if next.IsZero() {
+		return next
+	}
+	return next.In(t.Location())
+}
+
+//在指定时区计算时间表，例如In(loc, Weekly(time.Monday, 5, 0, 0))
+func In(loc *time.Location, sched Schedule) Schedule {
+	return &locSchedule{loc: loc, sched: sched}
+}
@@ -0,0 +1,132 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+func utc(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04:05", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{
+		"", "* * * *", "* * * * * * *", "60 * * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+		"* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "* * * FOO *", "TZ=Nowhere/City * * * * *", "TZ=UTC",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) no error", spec)
+		}
+	}
+	c := MustCron("0 0,30 9-17/4 * JAN-MAR 1-5")
+	if c.second != 1 || c.minute != 1|1<<30 || c.hour != 1<<9|1<<13|1<<17 || c.month != 0xE || c.dow != 0x3E || !c.domStar || c.dowStar {
+		t.Fatalf("cron %+v", c)
+	}
+	if MustCron("0 0 * * 7").dow&1 == 0 {
+		t.Fatal("7 is not sunday")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MustCron did not panic")
+		}
+	}()
+	MustCron("bad")
+}
+
+func TestCronNext(t *testing.T) {
+	for _, c := range []struct {
+		spec, from, want string
+	}{
+		{"0 5 * * MON", "2024-01-01 06:00:00", "2024-01-08 05:00:00"},
+		{"0 5 * * MON", "2024-01-01 04:59:59", "2024-01-01 05:00:00"},
+		{"*/15 * * * * *", "2024-01-01 10:00:07", "2024-01-01 10:00:15"},
+		{"0 0 13 * FRI", "2024-01-01 00:00:00", "2024-01-05 00:00:00"}, //日和周满足其一
+		{"0 0 29 2 *", "2023-03-01 00:00:00", "2024-02-29 00:00:00"},
+		{"@monthly", "2024-01-31 12:00:00", "2024-02-01 00:00:00"},
+		{"@hourly", "2024-12-31 23:30:00", "2025-01-01 00:00:00"},
+		{"59 59 23 31 12 *", "2024-12-31 23:59:59", "2025-12-31 23:59:59"},
+		{"0 0 30 2 *", "2024-01-01 00:00:00", ""},
+	} {
+		got := MustCron(c.spec).Next(utc(c.from))
+		if c.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q from %s: %s, want never", c.spec, c.from, got)
+			}
+		} else if !got.Equal(utc(c.want)) {
+			t.Errorf("%q from %s: %s, want %s", c.spec, c.from, got, c.want)
+		}
+	}
+
+	//时区前缀，返回传入时间的时区
+	got := MustCron("TZ=Asia/Shanghai 0 0 * * *").Next(utc("2024-01-01 00:00:00"))
+	if !got.Equal(utc("2024-01-01 16:00:00")) || got.Location() != time.UTC {
+		t.Fatalf("TZ: %s", got)
+	}
+}
+
+func TestWeeklyMonthly(t *testing.T) {
+	if got := Weekly(time.Monday, 5, 0, 0).Next(utc("2024-01-01 05:00:00")); !got.Equal(utc("2024-01-08 05:00:00")) {
+		t.Fatalf("Weekly: %s", got)
+	}
+	if got := Monthly(31, 0, 0, 0).Next(utc("2024-01-31 00:00:00")); !got.Equal(utc("2024-03-31 00:00:00")) {
+		t.Fatalf("Monthly: %s", got)
+	}
+	loc := time.FixedZone("UTC+8", 8*3600)
+	if got := In(loc, Weekly(time.Monday, 0, 0, 0)).Next(utc("2024-01-01 00:00:00")); !got.Equal(utc("2024-01-07 16:00:00")) {
+		t.Fatalf("In: %s", got)
+	}
+
+	for name, f := range map[string]func(){
+		"weekday": func() { Weekly(7, 0, 0, 0) },
+		"hour":    func() { Weekly(time.Monday, 24, 0, 0) },
+		"minute":  func() { Monthly(1, 0, -1, 0) },
+		"second":  func() { Monthly(1, 0, 0, 60) },
+		"day":     func() { Monthly(0, 0, 0, 0) },
+		"day32":   func() { Monthly(32, 0, 0, 0) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestMissedEvents(t *testing.T) {
+	last, now := utc("2024-01-01 00:00:00"), utc("2024-01-01 10:30:00")
+	job := &cronJob{name: "hourly", sched: Every(3600 * 1000)}
+	if evs := missedEvents(job, last, now); evs != nil {
+		t.Fatalf("skip: %d", len(evs))
+	}
+	job.catchup = CATCHUP_ONCE
+	if evs := missedEvents(job, last, now); len(evs) != 1 || evs[0].Missed != 10 || !evs[0].Catchup || !evs[0].Planned.Equal(utc("2024-01-01 10:00:00")) {
+		t.Fatalf("once: %d", len(evs))
+	}
+	job.catchup = CATCHUP_ALL
+	evs := missedEvents(job, last, now)
+	if len(evs) != 10 {
+		t.Fatalf("all: %d", len(evs))
+	}
+	for i, ev := range evs {
+		if !ev.Planned.Equal(last.Add(time.Duration(i+1) * time.Hour)) {
+			t.Fatalf("all %d: %s", i, ev.Planned)
+		}
+	}
+
+	//超过CATCHUP_MAX时只保留最近的
+	job.sched = Every(1000)
+	evs = missedEvents(job, last, last.Add(100*time.Second))
+	if len(evs) != CATCHUP_MAX || !evs[len(evs)-1].Planned.Equal(last.Add(100*time.Second)) {
+		t.Fatalf("all max: %d", len(evs))
+	}
+	if evs = missedEvents(job, now, now); len(evs) != 0 {
+		t.Fatalf("none missed: %d", len(evs))
+	}
+}
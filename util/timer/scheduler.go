@@ -0,0 +1,282 @@
+package timer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/snowyyj001/loumiao/llog"
+	"github.com/snowyyj001/loumiao/util"
+)
+
+//重启后对停服期间错过的触发的处理
+const (
+	CATCHUP_SKIP = iota //丢弃
+	CATCHUP_ONCE        //合并成一次，启动后立即执行
+	CATCHUP_ALL         //逐次补上，最多CATCHUP_MAX次，更早的丢弃
+)
+
+const (
+	CATCHUP_MAX  = 64      //CATCHUP_ALL最多补上的次数
+	catchupLimit = 1 << 20 //统计错过次数的上限，防止间隔很短的任务停服很久后遍历过久
+)
+
+//任务投递的目标，gorpc.IGoRoutine满足这个接口
+type Actor interface {
+	SendActor(handler_name string, sdata interface{}) error
+}
+
+//任务的一次触发，投递给actor的数据
+type JobEvent struct {
+	Name    string
+	Planned time.Time //计划触发时间
+	Missed  int       //CATCHUP_ONCE合并的错过次数，正常触发是0
+	Catchup bool      //是否是启动后补上的触发
+}
+
+//保存任务上一次触发的计划时间，重启后用来计算错过的触发
+type JobStore interface {
+	Load(name string) (time.Time, bool)
+	Save(name string, t time.Time) error
+}
+
+//json文件保存的JobStore，每次Save都会重写整个文件
+type FileStore struct {
+	lock sync.Mutex
+	path string
+	last map[string]int64 //毫秒
+}
+
+//打开或者创建JobStore文件
+func NewFileStore(path string) (*FileStore, error) {
+	self := &FileStore{path: path, last: make(map[string]int64)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return self, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &self.last); err != nil {
+			return nil, fmt.Errorf("FileStore %s: %s", path, err.Error())
+		}
+	}
+	return self, nil
+}
+
+func (self *FileStore) Load(name string) (time.Time, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ms, ok := self.last[name]
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+func (self *FileStore) Save(name string, t time.Time) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.last[name] = t.UnixNano() / int64(time.Millisecond)
+	data, err := json.Marshal(self.last)
+	if err != nil {
+		return err
+	}
+	tmp := self.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, self.path)
+}
+
+type cronJob struct {
+	name    string
+	sched   Schedule
+	catchup int
+	deliver func(ev *JobEvent) error
+	handle  *Handle
+	lock    sync.Mutex  //保护queue和running
+	queue   []*JobEvent //等待投递的触发，按计划时间排列
+	running bool        //有协程在投递queue
+}
+
+//定时任务调度，例如每日重置，每周排行榜结算
+//任务按名字区分，触发时把JobEvent投递给actor，错过的触发按照catchup策略处理
+type Scheduler struct {
+	lock  sync.Mutex
+	store JobStore
+	jobs  map[string]*cronJob
+}
+
+//创建调度器
+//@store: 保存触发记录，nil时不处理停服期间错过的触发
+func NewScheduler(store JobStore) *Scheduler {
+	return &Scheduler{store: store, jobs: make(map[string]*cronJob)}
+}
+
+//添加任务，触发时调用actor.SendActor(handler, *JobEvent)
+//请在actor启动后添加，例如DoOpen中，否则补上的触发无法投递
+//@name: 任务名，也是JobStore中的key
+//@sched: 时间表，例如MustCron("0 5 * * MON")，In(loc, Weekly(time.Monday, 5, 0, 0))
+//@catchup: CATCHUP_SKIP, CATCHUP_ONCE, CATCHUP_ALL
+func (self *Scheduler) AddActor(name string, sched Schedule, catchup int, actor Actor, handler string) error {
+	return self.add(name, sched, catchup, func(ev *JobEvent) error {
+		return actor.SendActor(handler, ev)
+	})
+}
+
+//添加cron表达式的任务，参数同AddActor
+func (self *Scheduler) AddCron(name string, spec string, catchup int, actor Actor, handler string) error {
+	sched, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	return self.AddActor(name, sched, catchup, actor, handler)
+}
+
+//添加任务，f在新的协程中执行，需要访问actor数据的请使用AddActor
+func (self *Scheduler) AddFunc(name string, sched Schedule, catchup int, f func(ev *JobEvent)) error {
+	return self.add(name, sched, catchup, func(ev *JobEvent) error {
+		f(ev)
+		return nil
+	})
+}
+
+func (self *Scheduler) add(name string, sched Schedule, catchup int, deliver func(ev *JobEvent) error) error {
+	now := util.Now()
+	first := sched.Next(now)
+	if first.IsZero() {
+		return fmt.Errorf("Scheduler.Add %s: schedule never fires", name)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, ok := self.jobs[name]; ok {
+		return fmt.Errorf("Scheduler.Add %s: job already exists", name)
+	}
+	job := &cronJob{name: name, sched: sched, catchup: catchup, deliver: deliver}
+	self.jobs[name] = job
+
+	if self.store != nil {
+		if last, ok := self.store.Load(name); ok {
+			if evs := missedEvents(job, last, now); len(evs) > 0 {
+				self.fire(job, evs)
+			}
+		} else if err := self.store.Save(name, now); err != nil { //第一次添加，记录起点
+			llog.Warningf("Scheduler.Add %s: save %s", name, err.Error())
+		}
+	}
+
+	ready := make(chan struct{})
+	job.handle = DefaultWheel().Add(first, sched, func() { //在时间轮协程中，不能阻塞
+		<-ready
+		ev := &JobEvent{Name: name, Planned: job.handle.Fired()}
+		self.fire(job, []*JobEvent{ev})
+	})
+	close(ready)
+	return nil
+}
+
+//last之后，now之前错过的触发
+func missedEvents(job *cronJob, last, now time.Time) []*JobEvent {
+	if job.catchup == CATCHUP_SKIP {
+		return nil
+	}
+	var evs []*JobEvent
+	count := 0
+	for t := job.sched.Next(last); !t.IsZero() && !t.After(now) && count < catchupLimit; t = job.sched.Next(t) {
+		count++
+		if job.catchup == CATCHUP_ALL {
+			if len(evs) == CATCHUP_MAX {
+				evs = evs[1:]
+			}
+			evs = append(evs, &JobEvent{Name: job.name, Planned: t, Catchup: true})
+		} else {
+			evs = []*JobEvent{{Name: job.name, Planned: t, Missed: count, Catchup: true}}
+		}
+	}
+	if count > len(evs) && job.catchup == CATCHUP_ALL {
+		llog.Warningf("Scheduler: job %s missed %d runs, only the last %d are delivered", job.name, count, len(evs))
+	}
+	return evs
+}
+
+//触发放入任务的队列，不阻塞，可以在时间轮协程中调用
+//同一个任务只有一个协程按顺序投递，投递慢时后面的触发排队，不会乱序
+func (self *Scheduler) fire(job *cronJob, evs []*JobEvent) {
+	job.lock.Lock()
+	job.queue = append(job.queue, evs...)
+	if job.running {
+		job.lock.Unlock()
+		return
+	}
+	job.running = true
+	job.lock.Unlock()
+	go self.deliver(job)
+}
+
+func (self *Scheduler) deliver(job *cronJob) {
+	for {
+		job.lock.Lock()
+		if len(job.queue) == 0 {
+			job.running = false
+			job.lock.Unlock()
+			return
+		}
+		ev := job.queue[0]
+		job.queue = job.queue[1:]
+		job.lock.Unlock()
+
+		if err := job.deliver(ev); err != nil {
+			llog.Errorf("Scheduler: job %s deliver %s: %s", job.name, ev.Planned.Format("2006-01-02 15:04:05"), err.Error())
+			job.lock.Lock()
+			job.queue = nil //没有投递成功的和排在后面的都不记录，下次启动时再补上
+			job.lock.Unlock()
+			continue
+		}
+		if self.store != nil {
+			if err := self.store.Save(job.name, ev.Planned); err != nil {
+				llog.Warningf("Scheduler: job %s save %s", job.name, err.Error())
+			}
+		}
+	}
+}
+
+//移除任务，JobStore中的记录保留
+func (self *Scheduler) Remove(name string) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	job, ok := self.jobs[name]
+	if !ok {
+		return false
+	}
+	delete(self.jobs, name)
+	job.handle.Cancel()
+	return true
+}
+
+//任务名，按字母排序
+func (self *Scheduler) Jobs() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	names := make([]string, 0, len(self.jobs))
+	for name := range self.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//停止所有任务
+func (self *Scheduler) Stop() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for name, job := range self.jobs {
+		job.handle.Cancel()
+		delete(self.jobs, name)
+	}
+}
@@ -0,0 +1,84 @@
+package timer
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/snowyyj001/loumiao/util"
+)
+
+//投递慢时后面的触发排队，按计划时间依次投递，补上的触发在最前面
+func TestSchedulerOrder(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "jobs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Save("order", util.Now().Add(-200*time.Millisecond))
+
+	var lock sync.Mutex
+	var evs []*JobEvent
+	sched := NewScheduler(store)
+	defer sched.Stop()
+	err = sched.AddFunc("order", Every(50), CATCHUP_ALL, func(ev *JobEvent) {
+		time.Sleep(70 * time.Millisecond) //比间隔慢
+		lock.Lock()
+		evs = append(evs, ev)
+		lock.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sched.AddFunc("order", Every(50), CATCHUP_ALL, func(*JobEvent) {}); err == nil {
+		t.Fatal("duplicate job added")
+	}
+	time.Sleep(700 * time.Millisecond)
+	sched.Stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(evs) < 6 {
+		t.Fatalf("delivered %d", len(evs))
+	}
+	for i, ev := range evs {
+		if ev.Catchup != (i < 4) {
+			t.Fatalf("event %d catchup %v", i, ev.Catchup)
+		}
+		if i > 0 && !ev.Planned.After(evs[i-1].Planned) {
+			t.Fatalf("out of order: %s after %s", ev.Planned.Format("15:04:05.000"), evs[i-1].Planned.Format("15:04:05.000"))
+		}
+	}
+}
+
+//fire在时间轮协程中调用，不能阻塞，投递顺序和fire的顺序一致
+func TestSchedulerFire(t *testing.T) {
+	release := make(chan struct{})
+	delivered := make(chan int, 8)
+	job := &cronJob{name: "fire", deliver: func(ev *JobEvent) error {
+		if ev.Missed == 0 {
+			<-release
+		}
+		delivered <- ev.Missed
+		return nil
+	}}
+	sched := NewScheduler(nil)
+	fired := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			sched.fire(job, []*JobEvent{{Name: "fire", Missed: i}})
+		}
+		close(fired)
+	}()
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("fire blocked by a slow delivery")
+	}
+	close(release)
+	for i := 0; i < 5; i++ {
+		if n := <-delivered; n != i {
+			t.Fatalf("delivered %d, want %d", n, i)
+		}
+	}
+}
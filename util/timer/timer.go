@@ -1,6 +1,8 @@
 package timer
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/snowyyj001/loumiao/util"
@@ -12,139 +14,163 @@ const (
 	MINITE_MILLI int64 = 1000 * 60 //1分钟,毫秒
 )
 
+var defaultWheel atomic.Value
+
+func init() {
+	defaultWheel.Store(NewWheel())
+}
+
+//共用的时间轮
+func DefaultWheel() *Wheel {
+	return defaultWheel.Load().(*Wheel)
+}
+
+//替换共用的时间轮，返回原来的，测试替换时钟后使用
+func SetWheel(w *Wheel) *Wheel {
+	return defaultWheel.Swap(w).(*Wheel)
+}
+
+//dt毫秒后触发一次，回调在时间轮协程中执行
+func AfterFunc(dt int, cb func()) *Handle {
+	w := DefaultWheel()
+	return w.Add(util.Now().Add(time.Duration(dt)*time.Millisecond), nil, cb)
+}
+
+//每隔dt毫秒触发一次，回调在时间轮协程中执行
+func EveryFunc(dt int, cb func()) *Handle {
+	sched := Every(dt)
+	w := DefaultWheel()
+	return w.Add(sched.Next(util.Now()), sched, cb)
+}
+
+//按照时间表触发，回调在时间轮协程中执行，时间表没有下一次时返回nil
+func ScheduleFunc(sched Schedule, cb func()) *Handle {
+	first := sched.Next(util.Now())
+	if first.IsZero() {
+		return nil
+	}
+	return DefaultWheel().Add(first, sched, cb)
+}
+
+//NewTimer和NewTicker的回调在新的协程中执行，可以阻塞，不影响时间轮
+//和以前一样，回调返回后才安排下一次触发，同一个定时器的回调不会同时执行
 type Timer struct {
-	t1   *time.Ticker
-	t2   *time.Timer
-	done chan bool
-	loop bool
-	over bool
+	lock    sync.Mutex
+	handle  *Handle
+	dt      time.Duration
+	repeat  bool
+	ticker  bool      //按固定频率触发
+	due     time.Time //这次触发的计划时间
+	utm     int64     //上一次触发的时间
+	stopped bool
+	cb      func(dt int64) bool
 }
 
 //创建一个定时器
+//回调返回后再过dt毫秒触发下一次，回调的耗时会推迟之后的触发
 //@dt: 时间间隔，毫秒
-//@cb：触发回调
+//@cb：触发回调，返回false停止定时器
 //@repeat：是否循环触发
 func NewTimer(dt int, cb func(dt int64) bool, repeat bool) *Timer {
-	delat := time.Duration(dt) * time.Millisecond
-
-	t := new(Timer)
-	t.t2 = time.NewTimer(delat)
-	t.done = make(chan bool)
-	t.loop = repeat
-	t.over = true
-
-	go func(t *Timer) {
-		defer t.t2.Stop()
-		utm := util.TimeStamp()
-		utmPre := utm
-		for {
-			select {
-			case <-t.t2.C:
-				if !t.over {
-					return
-				}
-				utmPre = util.TimeStamp()
-				t.over = cb(utmPre - utm)
-				if !t.over {
-					return
-				}
-				utm = utmPre
-				if t.loop {
-					t.t2.Reset(delat)
-				}
-
-			case <-t.done:
-				return
-			}
-		}
-	}(t)
-
-	return t
+	return newTimer(dt, cb, repeat, false)
 }
 
 //创建一个定时器
+//和time.Ticker一样按固定频率触发，回调阻塞期间错过的触发合并成一次，在回调返回后马上执行
 //@dt: 时间间隔，毫秒
-//@cb：触发回调
+//@cb：触发回调，返回false停止定时器
 func NewTicker(dt int, cb func(dt int64) bool) *Timer {
+	return newTimer(dt, cb, true, true)
+}
 
-	t := new(Timer)
-	ticker := time.NewTicker(time.Duration(dt) * time.Millisecond)
-
-	t.done = make(chan bool)
-	t.t1 = ticker
-	t.loop = true
-	t.over = true
-
-	go func(timer *Timer) {
-		defer timer.t1.Stop()
-		utm := util.TimeStamp()
-		utmPre := utm
-		for {
-			select {
-			case <-timer.t1.C:
-				if !t.over {
-					return
-				}
-				utmPre = util.TimeStamp()
-				t.over = cb(utmPre - utm)
-				if !t.over {
-					return
-				}
-				utm = utmPre
-			case <-timer.done:
-				return
-			}
-		}
-	}(t)
-
+func newTimer(dt int, cb func(dt int64) bool, repeat bool, ticker bool) *Timer {
+	t := &Timer{dt: time.Duration(dt) * time.Millisecond, repeat: repeat, ticker: ticker, utm: util.TimeStamp(), cb: cb}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	due := util.Now().Add(t.dt)
+	t.schedule(due, due)
 	return t
 }
 
+//持有lock时调用
+//@fire: 时间轮触发的时间
+//@due: 计划时间，NewTicker按照它计算下一次
+func (self *Timer) schedule(fire time.Time, due time.Time) {
+	self.due = due
+	self.handle = DefaultWheel().Add(fire, nil, func() {
+		go self.call()
+	})
+}
+
+func (self *Timer) call() {
+	utmPre := util.TimeStamp()
+	goon := self.cb(utmPre - self.utm)
+	self.utm = utmPre
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if !goon || !self.repeat || self.stopped {
+		self.stopped = true
+		return
+	}
+	now := util.Now()
+	if !self.ticker {
+		self.schedule(now.Add(self.dt), now.Add(self.dt))
+		return
+	}
+	due := self.due.Add(self.dt)
+	if due.After(now) {
+		self.schedule(due, due)
+	} else {
+		due = due.Add(now.Sub(due) / self.dt * self.dt) //错过的最后一次
+		self.schedule(now, due)
+	}
+}
+
+//停止定时器，不会阻塞，定时器已经结束也可以调用
+//正在执行的回调不会被打断，返回后不再触发
 func (self *Timer) Stop() {
-	self.over = false
-	self.done <- true
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.stopped = true
+	self.handle.Cancel()
 }
 
 //延迟dt毫秒，执行一个任务
 //@dt:延迟时间，毫秒
 //@cb:任务
-//@sync:是否同步执行
+//@sync:是否同步执行，false时cb在新的协程中执行
 func DelayJob(dt int64, cb func(), sync bool) {
 	if sync {
-		<-time.After(time.Duration(dt) * time.Millisecond)
+		done := make(chan struct{})
+		AfterFunc(int(dt), func() {
+			close(done)
+		})
+		<-done
 		cb()
 	} else {
-		go func() {
-			<-time.After(time.Duration(dt) * time.Millisecond)
-			cb()
-		}()
+		AfterFunc(int(dt), func() {
+			go cb()
+		})
 	}
 }
 
 //获取当天的0点和24点时间
 //@st：指定那一天，0默认当天
 func GetDayTime(st int64) (int64, int64) {
-	var timeStr string
+	var t time.Time
 	if st == 0 {
-		timeStr = time.Now().Format("2021-01-28")
+		t = util.Now()
 	} else {
-		timeStr = time.Unix(st, 0).Format("2021-01-28")
+		t = time.Unix(st, 0)
 	}
-	t, _ := time.ParseInLocation("2021-01-28", timeStr, time.Local)
-	var beginTimeNum int64 = t.Unix()
-	var endTimeNum int64 = beginTimeNum + DAY_SECONDS
-	return beginTimeNum, endTimeNum
+	begin := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	end := begin.AddDate(0, 0, 1) //夏令时切换的那天不是DAY_SECONDS
+	return begin.Unix(), end.Unix()
 }
 
-//是否是同一天
-//请确保stmp2 > stmp1
+//是否是同一天，秒时间戳
 func IsSameDay(stmp1, stmp2 int64) bool {
-	if stmp2-stmp1 >= DAY_SECONDS {
-		return false
-	}
-	_, end := GetDayTime(stmp1)
-	if stmp2 <= end {
-		return false
-	}
-	return true
+	begin, end := GetDayTime(stmp1)
+	return stmp2 >= begin && stmp2 < end
 }
@@ -0,0 +1,116 @@
+package timer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//NewTicker的回调阻塞时不影响其他定时器，也不会重叠执行
+func TestTickerBlocking(t *testing.T) {
+	var running, calls, overlap int32
+	release := make(chan struct{})
+	tk := NewTicker(10, func(dt int64) bool {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlap, 1)
+		}
+		atomic.AddInt32(&calls, 1)
+		<-release
+		atomic.AddInt32(&running, -1)
+		return true
+	})
+	defer tk.Stop()
+
+	done := make(chan struct{})
+	AfterFunc(100, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("AfterFunc blocked by ticker callback")
+	}
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Fatalf("calls %d", c)
+	}
+	close(release)
+
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&overlap) != 0 {
+		t.Fatal("ticker callbacks overlapped")
+	}
+	if c := atomic.LoadInt32(&calls); c < 2 {
+		t.Fatalf("ticker stopped after blocking, calls %d", c)
+	}
+}
+
+//回调返回false后定时器停止
+func TestTimerStop(t *testing.T) {
+	var calls int32
+	NewTicker(10, func(dt int64) bool {
+		return atomic.AddInt32(&calls, 1) < 3
+	})
+	time.Sleep(200 * time.Millisecond)
+	if c := atomic.LoadInt32(&calls); c != 3 {
+		t.Fatalf("calls %d", c)
+	}
+}
+
+//NewTimer在回调返回后再过dt毫秒触发下一次，回调的耗时推迟之后的触发
+func TestTimerFixedDelay(t *testing.T) {
+	dts := make(chan int64, 4)
+	var calls int32
+	NewTimer(20, func(dt int64) bool {
+		dts <- dt
+		time.Sleep(30 * time.Millisecond)
+		return atomic.AddInt32(&calls, 1) < 3
+	}, true)
+	<-dts
+	for i := 0; i < 2; i++ {
+		select {
+		case dt := <-dts:
+			if dt < 50 {
+				t.Fatalf("next fired %dms after last", dt)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timer stopped")
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if c := atomic.LoadInt32(&calls); c != 3 {
+		t.Fatalf("calls %d", c)
+	}
+
+	//不循环的只触发一次
+	NewTimer(10, func(dt int64) bool { dts <- dt; return true }, false)
+	<-dts
+	select {
+	case <-dts:
+		t.Fatal("fired again without repeat")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+//NewTicker保持频率，阻塞错过的触发合并成一次，回调返回后马上执行
+func TestTickerRate(t *testing.T) {
+	starts := make(chan time.Time, 8)
+	var calls int32
+	tk := NewTicker(100, func(dt int64) bool {
+		starts <- time.Now()
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(250 * time.Millisecond)
+		}
+		return true
+	})
+	first := <-starts
+	second, third := <-starts, <-starts
+	tk.Stop()
+	if d := second.Sub(first); d < 250*time.Millisecond || d > 300*time.Millisecond {
+		t.Fatalf("missed ticks not fired at once: %v", d)
+	}
+	if d := third.Sub(second); d > 90*time.Millisecond {
+		t.Fatalf("ticker lost its rate: %v", d)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if c := atomic.LoadInt32(&calls); c != 3 {
+		t.Fatalf("fired after stop, calls %d", c)
+	}
+}
@@ -0,0 +1,343 @@
+package timer
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/snowyyj001/loumiao/util"
+)
+
+const (
+	WHEEL_TICK   = 10 //时间轮精度，毫秒
+	WHEEL_BITS0  = 8  //第一层2^8格
+	WHEEL_BITS   = 6  //其余每层2^6格
+	WHEEL_LEVELS = 4  //层数，4层可以覆盖WHEEL_TICK*2^26毫秒，约7.7天，更远的定时器到期前会重新放入
+)
+
+const (
+	wheelSize0 = 1 << WHEEL_BITS0
+	wheelSize  = 1 << WHEEL_BITS
+	wheelMax   = int64(1) << (WHEEL_BITS0 + WHEEL_BITS*(WHEEL_LEVELS-1))
+)
+
+//定时器的触发时间表
+type Schedule interface {
+	Next(t time.Time) time.Time //t之后的下一次触发时间，零值代表不再触发
+}
+
+//函数形式的Schedule
+type NextFunc func(t time.Time) time.Time
+
+func (self NextFunc) Next(t time.Time) time.Time {
+	return self(t)
+}
+
+type everySchedule time.Duration
+
+func (self everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(self))
+}
+
+//固定间隔触发
+//@dt: 间隔，毫秒
+func Every(dt int) Schedule {
+	if dt < WHEEL_TICK {
+		dt = WHEEL_TICK
+	}
+	return everySchedule(time.Duration(dt) * time.Millisecond)
+}
+
+//每天的固定时间触发，使用服务器本地时区
+func Daily(hour, minute, second int) Schedule {
+	return NextFunc(func(t time.Time) time.Time {
+		next := time.Date(t.Year(), t.Month(), t.Day(), hour, minute, second, 0, t.Location())
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	})
+}
+
+//时间轮中的定时器，可以在任何协程中取消
+type Handle struct {
+	wheel  *Wheel
+	due    time.Time //下一次触发时间
+	fired  time.Time //最近一次触发的计划时间
+	expire int64     //下一次触发的tick
+	sched  Schedule  //nil代表只触发一次
+	cb     func()
+	slot   *list.List
+	elem   *list.Element
+	active bool
+}
+
+//取消定时器，返回定时器取消前是否还会触发
+func (self *Handle) Cancel() bool {
+	if self == nil {
+		return false
+	}
+	w := self.wheel
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	was := self.active
+	self.active = false
+	w.unlink(self)
+	return was
+}
+
+//定时器是否还会触发
+func (self *Handle) Active() bool {
+	self.wheel.lock.Lock()
+	defer self.wheel.lock.Unlock()
+	return self.active
+}
+
+//最近一次触发的计划时间，和实际执行时间的差就是定时器的延迟
+func (self *Handle) Fired() time.Time {
+	self.wheel.lock.Lock()
+	defer self.wheel.lock.Unlock()
+	return self.fired
+}
+
+//分层时间轮，所有定时器共用一个驱动，只在有定时器到期时唤醒
+//回调在时间轮的协程中依次执行，不能阻塞，需要协程安全的请投递到actor中执行
+type Wheel struct {
+	lock     sync.Mutex
+	fireLock sync.Mutex //保证回调依次执行
+	base     time.Time  //tick 0的时间
+	cur      int64      //下一个要处理的tick
+	levels   [WHEEL_LEVELS][]*list.List
+	count    int
+	driver   util.ClockTimer
+	wake     int64 //driver唤醒的tick，-1代表没有
+	stopped  bool
+}
+
+//创建时间轮，使用util的时钟
+func NewWheel() *Wheel {
+	w := &Wheel{base: util.Now(), wake: -1}
+	for i := range w.levels {
+		n := wheelSize
+		if i == 0 {
+			n = wheelSize0
+		}
+		w.levels[i] = make([]*list.List, n)
+		for j := range w.levels[i] {
+			w.levels[i][j] = list.New()
+		}
+	}
+	return w
+}
+
+//添加定时器
+//@first: 第一次触发时间
+//@sched: 之后的触发时间表，nil代表只触发一次
+//@cb: 回调
+func (self *Wheel) Add(first time.Time, sched Schedule, cb func()) *Handle {
+	h := &Handle{wheel: self, due: first, sched: sched, cb: cb, active: true}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.stopped {
+		h.active = false
+		return h
+	}
+	self.link(h)
+	if self.wake < 0 {
+		self.schedule()
+	} else if h.expire < self.wake { //新定时器在已经安排的唤醒之前到期
+		self.setWake(h.expire)
+	}
+	return h
+}
+
+//停止时间轮，所有定时器不再触发
+func (self *Wheel) Stop() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.stopped = true
+	if self.driver != nil {
+		self.driver.Stop()
+	}
+	for _, level := range self.levels {
+		for _, slot := range level {
+			for e := slot.Front(); e != nil; e = e.Next() {
+				h := e.Value.(*Handle)
+				h.active = false
+				h.slot, h.elem = nil, nil
+			}
+			slot.Init()
+		}
+	}
+	self.count = 0
+}
+
+//定时器数量
+func (self *Wheel) Len() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.count
+}
+
+func (self *Wheel) tickOf(t time.Time) int64 {
+	return int64(t.Sub(self.base) / (WHEEL_TICK * time.Millisecond))
+}
+
+//把定时器放入对应的格子
+func (self *Wheel) link(h *Handle) {
+	d := h.due.Sub(self.base)
+	tick := int64((d + WHEEL_TICK*time.Millisecond - 1) / (WHEEL_TICK * time.Millisecond)) //向上取整，不会提前触发
+	if tick < self.cur {
+		tick = self.cur
+	}
+	h.expire = tick
+	self.insert(h)
+	self.count++
+}
+
+func (self *Wheel) insert(h *Handle) {
+	diff := h.expire - self.cur
+	if diff >= wheelMax { //超出范围，先放在最远的格子，到时重新放入
+		diff = wheelMax - 1
+	}
+	tick := self.cur + diff
+	var slot *list.List
+	if diff < wheelSize0 {
+		slot = self.levels[0][tick&(wheelSize0-1)]
+	} else {
+		level, shift := 1, uint(WHEEL_BITS0)
+		for diff >= int64(1)<<(shift+WHEEL_BITS) {
+			level++
+			shift += WHEEL_BITS
+		}
+		slot = self.levels[level][(tick>>shift)&(wheelSize-1)]
+	}
+	h.slot = slot
+	h.elem = slot.PushBack(h)
+}
+
+func (self *Wheel) unlink(h *Handle) {
+	if h.slot == nil {
+		return
+	}
+	h.slot.Remove(h.elem)
+	h.slot, h.elem = nil, nil
+	self.count--
+}
+
+//把上层格子中的定时器重新放入下层
+func (self *Wheel) cascade(level int) {
+	shift := uint(WHEEL_BITS0 + WHEEL_BITS*(level-1))
+	index := (self.cur >> shift) & (wheelSize - 1)
+	slot := self.levels[level][index]
+	for e := slot.Front(); e != nil; {
+		next := e.Next()
+		h := e.Value.(*Handle)
+		slot.Remove(e)
+		self.insert(h)
+		e = next
+	}
+	if index == 0 && level+1 < WHEEL_LEVELS {
+		self.cascade(level + 1)
+	}
+}
+
+//处理到tick为止的所有格子，返回到期的定时器
+func (self *Wheel) advance(tick int64) []*Handle {
+	var fired []*Handle
+	for self.cur <= tick {
+		if self.count == 0 { //空的时间轮直接跳过
+			self.cur = tick + 1
+			break
+		}
+		index := self.cur & (wheelSize0 - 1)
+		if index == 0 && self.cur > 0 {
+			self.cascade(1)
+		}
+		slot := self.levels[0][index]
+		for e := slot.Front(); e != nil; {
+			next := e.Next()
+			h := e.Value.(*Handle)
+			if h.expire <= self.cur {
+				self.unlink(h)
+				fired = append(fired, h)
+			}
+			e = next
+		}
+		self.cur++
+	}
+	return fired
+}
+
+//下一个可能有定时器到期的tick，第一层没有时是下一次cascade
+//cur本身在边界上时还没有cascade，也需要唤醒
+func (self *Wheel) nextTick() int64 {
+	for t := self.cur; ; t++ {
+		index := t & (wheelSize0 - 1)
+		if index == 0 && t > 0 {
+			return t
+		}
+		if self.levels[0][index].Len() > 0 {
+			return t
+		}
+	}
+}
+
+//安排driver在下一个有定时器的tick唤醒
+func (self *Wheel) schedule() {
+	if self.count == 0 || self.stopped {
+		return
+	}
+	next := self.nextTick()
+	if self.wake >= 0 && self.wake <= next {
+		return
+	}
+	self.setWake(next)
+}
+
+func (self *Wheel) setWake(tick int64) {
+	if self.driver != nil {
+		self.driver.Stop()
+	}
+	self.wake = tick
+	at := self.base.Add(time.Duration(tick) * WHEEL_TICK * time.Millisecond)
+	self.driver = util.AfterFunc(at.Sub(util.Now()), self.run)
+}
+
+func (self *Wheel) run() {
+	self.fireLock.Lock()
+	defer self.fireLock.Unlock()
+
+	self.lock.Lock()
+	now := util.Now()
+	self.wake = -1
+	fired := self.advance(self.tickOf(now))
+	calls := make([]func(), 0, len(fired))
+	for _, h := range fired {
+		if h.active == false {
+			continue
+		}
+		h.fired = h.due
+		calls = append(calls, h.cb)
+		if h.sched == nil {
+			h.active = false
+			continue
+		}
+		next := h.sched.Next(h.due)
+		if !next.IsZero() && next.Before(now) { //落后太多，丢弃错过的触发
+			next = h.sched.Next(now)
+		}
+		if next.IsZero() {
+			h.active = false
+			continue
+		}
+		h.due = next
+		self.link(h)
+	}
+	self.schedule()
+	self.lock.Unlock()
+
+	for _, cb := range calls {
+		cb()
+	}
+}
@@ -0,0 +1,61 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+func tickTime(w *Wheel, tick int64) time.Time {
+	return w.base.Add(time.Duration(tick) * WHEEL_TICK * time.Millisecond)
+}
+
+//从from开始放入定时器，按不同的步长推进，每个定时器在覆盖它到期tick的那一次advance中触发，并且只触发一次
+func checkCascade(t *testing.T, w *Wheel, from int64) {
+	deltas := []int64{0, 1, wheelSize0 - 1, wheelSize0, wheelSize0 + 1, 2*wheelSize0 - 1, 2 * wheelSize0,
+		wheelSize0*wheelSize - 1, wheelSize0 * wheelSize, wheelSize0*wheelSize + 1,
+		wheelSize0*wheelSize*wheelSize - 1, wheelSize0*wheelSize*wheelSize + 3,
+		wheelMax - 1, wheelMax, wheelMax + 10, 2*wheelMax + 300}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	handles := map[*Handle]int64{}
+	for _, d := range deltas {
+		h := &Handle{wheel: w, due: tickTime(w, from+d), active: true}
+		w.link(h)
+		handles[h] = from + d
+	}
+	if w.count != len(deltas) {
+		t.Fatalf("count %d", w.count)
+	}
+	cur, step := w.cur-1, int64(1)
+	for len(handles) > 0 && cur < from+3*wheelMax {
+		next := cur + step
+		for _, h := range w.advance(next) {
+			tick, ok := handles[h]
+			if !ok {
+				t.Fatalf("fired twice at (%d, %d]", cur, next)
+			}
+			if tick <= cur || tick > next {
+				t.Fatalf("tick %d (+%d) fired in (%d, %d]", tick, tick-from, cur, next)
+			}
+			delete(handles, h)
+		}
+		cur = next
+		step = step*7%4093 + 1
+	}
+	for _, tick := range handles {
+		t.Fatalf("tick %d (+%d) never fired", tick, tick-from)
+	}
+	if w.count != 0 {
+		t.Fatalf("count %d after all fired", w.count)
+	}
+}
+
+func TestWheelCascade(t *testing.T) {
+	w := NewWheel()
+	checkCascade(t, w, 0)
+	//从不在格子边界上的位置开始
+	w.lock.Lock()
+	w.advance(w.cur + 1000003)
+	w.lock.Unlock()
+	checkCascade(t, w, w.cur)
+}
@@ -39,22 +39,22 @@ func Randomd64(n1, n2 int64) int64 {
 
 //当前格式化时间字符串
 func TimeStr() string {
-	return time.Now().Format("2006-01-02 15:04:05")
+	return Now().Format("2006-01-02 15:04:05")
 }
 
 //当前格式化时间字符串
 func TimeStrFormat(mat string) string {
-	return time.Now().Format(mat)
+	return Now().Format(mat)
 }
 
 //时间戳秒
 func TimeStampSec() int64 {
-	return time.Now().Unix()
+	return Now().Unix()
 }
 
 //时间戳毫秒
 func TimeStamp() int64 {
-	return time.Now().UnixNano() / int64(time.Millisecond)
+	return Now().UnixNano() / int64(time.Millisecond)
 }
 
 //指定日期的时间戳毫秒